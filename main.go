@@ -2,21 +2,26 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"plugin"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/appbaseio/arc/middleware"
 	"github.com/appbaseio/arc/middleware/logger"
+	"github.com/appbaseio/arc/middleware/methods"
 	"github.com/appbaseio/arc/plugins"
 	"github.com/appbaseio/arc/util"
 	"github.com/gorilla/mux"
@@ -26,7 +31,13 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const logTag = "[cmd]"
+const (
+	logTag = "[cmd]"
+	// time the requests in flight are given to complete on shutdown
+	shutdownTimeout = 30 * time.Second
+	// set to true to answer the unmatched paths with the closest route templates
+	envNotFoundSuggestions = "NOT_FOUND_SUGGESTIONS"
+)
 
 var (
 	envFile     string
@@ -114,7 +125,7 @@ func main() {
 		log.Errorln(logTag, ": reading env file", envFile, ": ", err)
 	}
 
-	router := mux.NewRouter().StrictSlash(true)
+	router := mux.NewRouter().StrictSlash(logger.TrailingSlashes() != logger.TrailingSlashesStrict)
 
 	if PlanRefreshInterval == "" {
 		PlanRefreshInterval = "1"
@@ -266,6 +277,12 @@ func main() {
 	if err != nil {
 		log.Fatal("error loading plugins: ", err)
 	}
+	// the unmatched paths, and the ones the plugins turn away such as the
+	// disabled routes, get the closest route templates instead of a bare 404
+	if os.Getenv(envNotFoundSuggestions) == "true" {
+		router.NotFoundHandler = plugins.NotFoundHandler(router)
+		plugins.SetNotFoundHandler(router.NotFoundHandler)
+	}
 
 	// Execute the migration scripts
 	for _, migration := range util.GetMigrationScripts() {
@@ -300,18 +317,46 @@ func main() {
 		ExposedHeaders: []string{"*"},
 	})
 	handler := c.Handler(router)
+	handler = methods.Allowed(handler)
 	handler = logger.Log(handler)
 
 	// Listen and serve ...
 	addr := fmt.Sprintf("%s:%d", address, port)
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	// let the requests in flight complete, then run the shutdown hooks, e.g.
+	// flush the buffered logs, before exiting
+	stopped := make(chan struct{})
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-shutdown
+		log.Println(logTag, ": received", sig, ", shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorln(logTag, ": requests still in flight after", shutdownTimeout, ":", err)
+		}
+		util.RunShutdownHooks()
+		close(stopped)
+	}()
+
 	log.Println(logTag, ":listening on", addr)
 	if https {
 		httpsCert := os.Getenv("HTTPS_CERT")
 		httpsKey := os.Getenv("HTTPS_KEY")
-		log.Fatal(http.ListenAndServeTLS(addr, httpsCert, httpsKey, handler))
+		server.TLSConfig, err = util.ServerTLSConfig()
+		if err != nil {
+			log.Fatal("error configuring tls: ", err)
+		}
+		err = server.ListenAndServeTLS(httpsCert, httpsKey)
 	} else {
-		log.Fatal(http.ListenAndServe(addr, handler))
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
 	}
+	<-stopped
 }
 
 func LoadPIFromFile(path string) (plugin.Symbol, error) {
@@ -0,0 +1,24 @@
+package classify
+
+import (
+	"net/http"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/model/trace"
+)
+
+// Trace returns a middleware that stores the trace context propagated by the client, if any.
+func Trace() middleware.Middleware {
+	return classifyTrace
+}
+
+func classifyTrace(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if reqTrace, ok := trace.FromHeader(req.Header); ok {
+			ctx := trace.NewContext(req.Context(), reqTrace)
+			req = req.WithContext(ctx)
+		}
+
+		h(w, req)
+	}
+}
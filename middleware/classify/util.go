@@ -1,18 +1,32 @@
 package classify
 
+import "sync"
+
 // IndexAliasCache cache to store index -> alias map
 var IndexAliasCache = make(map[string]string)
 
 // AliasIndexCache cache to store alias -> index map
 var AliasIndexCache = make(map[string]string)
 
-// GetIndexAliasCache get whole cache
+// aliasMu guards the caches, the alias -> index map is refreshed while the
+// requests are being served
+var aliasMu sync.RWMutex
+
+// GetIndexAliasCache get a copy of the whole cache
 func GetIndexAliasCache() map[string]string {
-	return IndexAliasCache
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	cache := make(map[string]string, len(IndexAliasCache))
+	for index, alias := range IndexAliasCache {
+		cache[index] = alias
+	}
+	return cache
 }
 
 // GetIndexAlias get alias for specific index
 func GetIndexAlias(index string) string {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
 	alias, ok := IndexAliasCache[index]
 
 	if !ok {
@@ -23,11 +37,15 @@ func GetIndexAlias(index string) string {
 
 // SetIndexAlias set alias for specific index
 func SetIndexAlias(index, alias string) {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
 	IndexAliasCache[index] = alias
 }
 
 // GetAliasIndex get index for specific alias
 func GetAliasIndex(alias string) string {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
 	index, ok := AliasIndexCache[alias]
 	if !ok {
 		return ""
@@ -37,20 +55,32 @@ func GetAliasIndex(alias string) string {
 
 // SetAliasIndex set index for specific alias
 func SetAliasIndex(alias, index string) {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
 	AliasIndexCache[alias] = index
 }
 
 // SetAliasIndexCache set the whole cache
 func SetAliasIndexCache(data map[string]string) {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
 	AliasIndexCache = data
 }
 
-// GetAliasIndexCache get the whole cache
+// GetAliasIndexCache get a copy of the whole cache
 func GetAliasIndexCache() map[string]string {
-	return AliasIndexCache
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	cache := make(map[string]string, len(AliasIndexCache))
+	for alias, index := range AliasIndexCache {
+		cache[alias] = index
+	}
+	return cache
 }
 
 // RemoveFromIndexAliasCache get the whole cache
 func RemoveFromIndexAliasCache(indexName string) {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
 	delete(IndexAliasCache, indexName)
 }
@@ -2,26 +2,77 @@ package logger
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"net/http"
+	"os"
 	"strings"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logTag             = "[logger]"
+	envTrailingSlashes = "TRAILING_SLASHES"
+)
+
+// Supported ways of handling the trailing slashes of the request paths.
+const (
+	// TrailingSlashesNormalize trims the trailing slashes before the route is matched.
+	TrailingSlashesNormalize = "normalize"
+	// TrailingSlashesRedirect redirects to the path without the trailing slashes.
+	TrailingSlashesRedirect = "redirect"
+	// TrailingSlashesStrict matches the path as is.
+	TrailingSlashesStrict = "strict"
 )
 
-const logTag = "[logger]"
+// TrailingSlashes returns the configured handling of the trailing slashes,
+// defaults to TrailingSlashesNormalize.
+func TrailingSlashes() string {
+	switch mode := os.Getenv(envTrailingSlashes); mode {
+	case TrailingSlashesRedirect, TrailingSlashesStrict:
+		return mode
+	case "", TrailingSlashesNormalize:
+		return TrailingSlashesNormalize
+	default:
+		log.Warnln(logTag, ": unknown", envTrailingSlashes, mode, ", defaulting to", TrailingSlashesNormalize)
+		return TrailingSlashesNormalize
+	}
+}
 
 // Log logs and records time taken by each requests. As a side effect,
-// it trims the trailing slashes from the matched route.
+// it handles the trailing slashes of the path, before the route is matched,
+// as configured by TRAILING_SLASHES.
 func Log(next http.Handler) http.Handler {
+	mode := TrailingSlashes()
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		start := time.Now()
-		req.URL.Path = trimTrailingSlashes(req.URL.Path)
+		switch mode {
+		case TrailingSlashesNormalize:
+			req.URL.Path = trimTrailingSlashes(req.URL.Path)
+		case TrailingSlashesRedirect:
+			if path := trimTrailingSlashes(req.URL.Path); path != req.URL.Path {
+				redirectTo(w, req, path)
+				return
+			}
+		}
 		next.ServeHTTP(w, req)
 		log.Println(fmt.Sprintf("%s: finished %s, took %fs",
 			logTag, fmt.Sprintf("%s %s", req.Method, req.URL.Path), time.Since(start).Seconds()))
 	})
 }
 
+// redirectTo redirects the request to the path, keeping its query. The requests
+// with a body are redirected with a 308 so that the clients resend the body.
+func redirectTo(w http.ResponseWriter, req *http.Request, path string) {
+	url := *req.URL
+	url.Path = path
+	code := http.StatusMovedPermanently
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, req, url.String(), code)
+}
+
 func trimTrailingSlashes(path string) string {
 	for path != "/" && strings.HasSuffix(path, "/") {
 		path = strings.TrimSuffix(path, "/")
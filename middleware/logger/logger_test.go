@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serve routes the request through a router serving /{index}/_search, with
+// the trailing slashes handled as per the given mode.
+func serve(mode, method, target string) *httptest.ResponseRecorder {
+	os.Setenv(envTrailingSlashes, mode)
+	defer os.Unsetenv(envTrailingSlashes)
+	router := mux.NewRouter().StrictSlash(TrailingSlashes() != TrailingSlashesStrict)
+	router.Methods(http.MethodGet, http.MethodPost).Path("/{index}/_search").
+		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(mux.Vars(r)["index"]))
+		})
+	resp := httptest.NewRecorder()
+	Log(router).ServeHTTP(resp, httptest.NewRequest(method, target, nil))
+	return resp
+}
+
+func TestTrailingSlashes(t *testing.T) {
+	Convey("Trailing slashes", t, func() {
+		Convey("normalize", func() {
+			for _, target := range []string{"/foo/_search", "/foo/_search/", "/foo/_search//"} {
+				resp := serve(TrailingSlashesNormalize, http.MethodGet, target)
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Body.String(), ShouldEqual, "foo")
+			}
+		})
+		Convey("normalize is the default", func() {
+			resp := serve("", http.MethodGet, "/foo/_search/")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("redirect", func() {
+			resp := serve(TrailingSlashesRedirect, http.MethodGet, "/foo/_search/?size=1")
+			So(resp.Code, ShouldEqual, http.StatusMovedPermanently)
+			So(resp.Header().Get("Location"), ShouldEqual, "/foo/_search?size=1")
+
+			resp = serve(TrailingSlashesRedirect, http.MethodPost, "/foo/_search/")
+			So(resp.Code, ShouldEqual, http.StatusPermanentRedirect)
+
+			resp = serve(TrailingSlashesRedirect, http.MethodGet, "/foo/_search")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("strict", func() {
+			So(serve(TrailingSlashesStrict, http.MethodGet, "/foo/_search").Code, ShouldEqual, http.StatusOK)
+			So(serve(TrailingSlashesStrict, http.MethodGet, "/foo/_search/").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
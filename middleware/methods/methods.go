@@ -0,0 +1,56 @@
+package methods
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+)
+
+const logTag = "[methods]"
+
+// allowed are the methods arc serves, the op classification, the caching and
+// the acls all key off them.
+var allowed = []string{
+	http.MethodHead,
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// overrideHeaders are the headers some servers and proxies take the method
+// of the request from instead of its request line.
+var overrideHeaders = []string{
+	"X-HTTP-Method-Override",
+	"X-HTTP-Method",
+	"X-Method-Override",
+}
+
+// Allowed rejects the requests made with any other method than the ones arc
+// serves with a 405, before the route is matched. Arc doesn't support
+// overriding the method, the override headers are logged and removed so
+// that nothing downstream serves the request as another method than the one
+// it has been classified by.
+func Allowed(next http.Handler) http.Handler {
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !util.IsExists(req.Method, allowed) {
+			log.Warnln(logTag, ": rejecting a request with the method", req.Method, "to", req.URL.Path)
+			w.Header().Set("Allow", allow)
+			util.WriteBackError(w, "method "+req.Method+" is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		for _, header := range overrideHeaders {
+			if value := req.Header.Get(header); value != "" {
+				log.Warnln(logTag, ": ignoring the", header, "header of a", req.Method, "request to", req.URL.Path, ":", value)
+				req.Header.Del(header)
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,43 @@
+package methods
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAllowed(t *testing.T) {
+	Convey("Allowed methods", t, func() {
+		var served *http.Request
+		handler := Allowed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = r
+		}))
+		serve := func(method string) *httptest.ResponseRecorder {
+			served = nil
+			req := httptest.NewRequest(method, "/foo/_search", nil)
+			req.Header.Set("X-HTTP-Method-Override", "DELETE")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			return resp
+		}
+
+		Convey("Bogus methods are rejected before the route is matched", func() {
+			for _, method := range []string{"FOO", "get", "TRACE", "CONNECT", "PROPFIND"} {
+				resp := serve(method)
+				So(resp.Code, ShouldEqual, http.StatusMethodNotAllowed)
+				So(resp.Header().Get("Allow"), ShouldEqual, "HEAD, GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				So(served, ShouldBeNil)
+			}
+		})
+		Convey("The methods arc serves pass without their override headers", func() {
+			for _, method := range allowed {
+				So(serve(method).Code, ShouldEqual, http.StatusOK)
+				So(served, ShouldNotBeNil)
+				So(served.Method, ShouldEqual, method)
+				So(served.Header.Get("X-HTTP-Method-Override"), ShouldBeEmpty)
+			}
+		})
+	})
+}
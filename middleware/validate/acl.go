@@ -55,7 +55,7 @@ func validateACL(h http.HandlerFunc) http.HandlerFunc {
 		if !ok {
 			msg := fmt.Sprintf(`credentials cannot access "%s" acl`, reqACL.String())
 			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-			util.WriteBackError(w, msg, http.StatusUnauthorized)
+			util.WriteBackDenied(w, req, msg, http.StatusUnauthorized)
 			return
 		}
 
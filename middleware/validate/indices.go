@@ -49,7 +49,7 @@ func indices(h http.HandlerFunc) http.HandlerFunc {
 			}
 			if !ok {
 				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-				util.WriteBackError(w, "credentials cannot access cluster level routes", http.StatusUnauthorized)
+				util.WriteBackDenied(w, req, "credentials cannot access cluster level routes", http.StatusUnauthorized)
 				return
 			}
 		} else {
@@ -63,7 +63,7 @@ func indices(h http.HandlerFunc) http.HandlerFunc {
 			if !ok {
 				msg := fmt.Sprintf("credentials cannot access %v index/indices", reqIndices)
 				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-				util.WriteBackError(w, msg, http.StatusUnauthorized)
+				util.WriteBackDenied(w, req, msg, http.StatusUnauthorized)
 				return
 			}
 		}
@@ -48,7 +48,7 @@ func operation(h http.HandlerFunc) http.HandlerFunc {
 		if !ok {
 			msg := fmt.Sprintf(`credential cannot perform "%v" operation`, reqOp.String())
 			w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-			util.WriteBackError(w, msg, http.StatusUnauthorized)
+			util.WriteBackDenied(w, req, msg, http.StatusUnauthorized)
 			return
 		}
 
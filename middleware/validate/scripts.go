@@ -0,0 +1,103 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/util"
+)
+
+const envDenyInlineScripts = "ES_DENY_INLINE_SCRIPTS"
+
+// Scripts returns a middleware that rejects the update requests carrying inline
+// scripts, unless the credential has been granted the scripts acl. Stored scripts
+// referenced by their id are always allowed. The policy is only enforced when
+// ES_DENY_INLINE_SCRIPTS is set to true.
+func Scripts() middleware.Middleware {
+	return scripts
+}
+
+func scripts(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if os.Getenv(envDenyInlineScripts) != "true" {
+			h(w, req)
+			return
+		}
+		ctx := req.Context()
+
+		errMsg := "an error occurred while validating request scripts"
+		reqACL, err := acl.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		if *reqACL != acl.Update && *reqACL != acl.UpdateByQuery {
+			h(w, req)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if !hasInlineScript(body) {
+			h(w, req)
+			return
+		}
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		scriptsACL := acl.Scripts
+		ok, err := hasACL(ctx, reqCredential, &scriptsACL)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			util.WriteBackError(w, "inline scripts are not allowed for the credential, use a stored script instead", http.StatusForbidden)
+			return
+		}
+
+		h(w, req)
+	}
+}
+
+// hasInlineScript checks whether the body's script is given inline, either
+// as a string or as an object with a source, rather than a stored script id.
+func hasInlineScript(body []byte) bool {
+	var update struct {
+		Script json.RawMessage `json:"script"`
+	}
+	if err := json.Unmarshal(body, &update); err != nil || len(update.Script) == 0 || string(update.Script) == "null" {
+		return false
+	}
+	var source string
+	if err := json.Unmarshal(update.Script, &source); err == nil {
+		return true
+	}
+	var script struct {
+		Source json.RawMessage `json:"source"`
+		Inline json.RawMessage `json:"inline"`
+	}
+	if err := json.Unmarshal(update.Script, &script); err != nil {
+		return false
+	}
+	return len(script.Source) > 0 || len(script.Inline) > 0
+}
@@ -0,0 +1,69 @@
+package validate
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/permission"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// update serves an update request made with a permission holding the given acls.
+func update(body string, acls ...acl.ACL) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest(http.MethodPost, "/foo/_update/1", strings.NewReader(body))
+	reqACL := acl.Update
+	ctx := acl.NewContext(req.Context(), &reqACL)
+	ctx = credential.NewContext(ctx, credential.Permission)
+	ctx = permission.NewContext(ctx, &permission.Permission{ACLs: acls})
+
+	var forwarded string
+	resp := httptest.NewRecorder()
+	scripts(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		forwarded = string(raw)
+	})(resp, req.WithContext(ctx))
+	return resp, forwarded
+}
+
+func TestScripts(t *testing.T) {
+	Convey("Scripts", t, func() {
+		os.Setenv(envDenyInlineScripts, "true")
+		defer os.Unsetenv(envDenyInlineScripts)
+
+		Convey("Inline scripts are blocked", func() {
+			resp, _ := update(`{"script":{"source":"ctx._source.count += 1"}}`, acl.Update)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+
+			resp, _ = update(`{"script":"ctx._source.count += 1","scripted_upsert":true,"upsert":{}}`, acl.Update)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("Stored scripts are allowed", func() {
+			body := `{"script":{"id":"increment","params":{"by":1}}}`
+			resp, forwarded := update(body, acl.Update)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(forwarded, ShouldEqual, body)
+		})
+		Convey("Inline scripts are allowed with the scripts acl", func() {
+			body := `{"script":{"source":"ctx._source.count += 1"}}`
+			resp, forwarded := update(body, acl.Update, acl.Scripts)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(forwarded, ShouldEqual, body)
+		})
+		Convey("Partial document updates are allowed", func() {
+			resp, _ := update(`{"doc":{"count":1}}`, acl.Update)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("The policy is opt-in", func() {
+			os.Unsetenv(envDenyInlineScripts)
+			resp, _ := update(`{"script":{"source":"ctx._source.count += 1"}}`, acl.Update)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
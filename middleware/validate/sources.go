@@ -72,7 +72,7 @@ func sources(h http.HandlerFunc) http.HandlerFunc {
 				msg := fmt.Sprintf(`permission with username %s doesn't have required sources. reqIP = %s, sources = %s`,
 					reqPermission.Username, reqIP, allowedSources)
 				w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
-				util.WriteBackError(w, msg, http.StatusUnauthorized)
+				util.WriteBackDenied(w, req, msg, http.StatusUnauthorized)
 				return
 			}
 		}
@@ -0,0 +1,105 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/util"
+)
+
+const envValidateTemplateParams = "ES_VALIDATE_TEMPLATE_PARAMS"
+
+// matches the _search/template, _msearch/template and _render/template endpoints
+var templatePath = regexp.MustCompile(`/_(search|msearch|render)/template(/|$)`)
+
+// TemplateParams returns a middleware that rejects the search template requests
+// whose params aren't a flat object of scalars, or arrays of scalars, or whose
+// string params contain mustache tags. The params are only validated when
+// ES_VALIDATE_TEMPLATE_PARAMS is set to true.
+func TemplateParams() middleware.Middleware {
+	return templateParams
+}
+
+func templateParams(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if os.Getenv(envValidateTemplateParams) != "true" || !templatePath.MatchString(req.URL.Path) {
+			h(w, req)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// _msearch/template bodies hold a search template per line
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			if err := validateTemplateParams(line); err != nil {
+				util.WriteBackError(w, "invalid template params: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		h(w, req)
+	}
+}
+
+// validateTemplateParams checks the shape of the params of a search template body.
+func validateTemplateParams(body []byte) error {
+	var template struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &template); err != nil {
+		return fmt.Errorf("malformed body: %v", err)
+	}
+	if len(template.Params) == 0 || string(template.Params) == "null" {
+		return nil
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(template.Params, &params); err != nil {
+		return fmt.Errorf("params must be an object")
+	}
+	for name, value := range params {
+		if values, ok := value.([]interface{}); ok {
+			for _, v := range values {
+				if err := validateTemplateParam(name, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := validateTemplateParam(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTemplateParam(name string, value interface{}) error {
+	switch v := value.(type) {
+	case nil, bool, float64:
+		return nil
+	case string:
+		if strings.Contains(v, "{{") || strings.Contains(v, "}}") {
+			return fmt.Errorf("param %q must not contain mustache tags", name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("param %q must be a string, number, boolean or an array of those", name)
+	}
+}
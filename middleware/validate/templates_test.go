@@ -0,0 +1,70 @@
+package validate
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// template serves a search template request to the given path.
+func template(path, body string) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	var forwarded string
+	resp := httptest.NewRecorder()
+	templateParams(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		forwarded = string(raw)
+	})(resp, req)
+	return resp, forwarded
+}
+
+func TestTemplateParams(t *testing.T) {
+	Convey("Template params", t, func() {
+		os.Setenv(envValidateTemplateParams, "true")
+		defer os.Unsetenv(envValidateTemplateParams)
+
+		Convey("Well-formed params are forwarded", func() {
+			body := `{"id":"by_title","params":{"title":"arc","size":10,"exact":true,"tags":["a","b"]}}`
+			resp, forwarded := template("/foo/_search/template", body)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(forwarded, ShouldEqual, body)
+
+			resp, _ = template("/_render/template", `{"source":"{{title}}","params":{"title":"arc"}}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("Malformed params are rejected", func() {
+			for _, body := range []string{
+				`{"id":"by_title","params":"title"}`,
+				`{"id":"by_title","params":{"title":{"match_all":{}}}}`,
+				`{"id":"by_title","params":{"tags":[["a"]]}}`,
+				`{"id":"by_title","params":{"title":"{{#toJson}}query{{/toJson}}"}}`,
+				`{"id":"by_title",`,
+			} {
+				resp, _ := template("/foo/_search/template", body)
+				So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+		Convey("Each search of a multi search template is validated", func() {
+			body := "{\"index\":\"foo\"}\n{\"id\":\"by_title\",\"params\":{\"title\":\"arc\"}}\n"
+			resp, _ := template("/_msearch/template", body)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+
+			body = "{\"index\":\"foo\"}\n{\"id\":\"by_title\",\"params\":{\"title\":{}}}\n"
+			resp, _ = template("/_msearch/template", body)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Other routes and disabled validation are left alone", func() {
+			resp, _ := template("/foo/_search", `{"params":"title"}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+
+			os.Unsetenv(envValidateTemplateParams)
+			resp, _ = template("/foo/_search/template", `{"params":"title"}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
@@ -39,6 +39,7 @@ const (
 	Synonyms
 	SearchGrader
 	Logs
+	IndexTemplates
 )
 
 // String is an implementation of Stringer interface that returns the string representation of category.Categories.
@@ -64,6 +65,7 @@ func (c Category) String() string {
 		"synonyms",
 		"searchgrader",
 		"logs",
+		"indextemplates",
 	}[c]
 }
 
@@ -115,6 +117,8 @@ func (c *Category) UnmarshalJSON(bytes []byte) error {
 		*c = SearchGrader
 	case Logs.String():
 		*c = Logs
+	case IndexTemplates.String():
+		*c = IndexTemplates
 	default:
 		return fmt.Errorf("invalid category encountered: %v", category)
 	}
@@ -165,6 +169,8 @@ func (c Category) MarshalJSON() ([]byte, error) {
 		category = SearchGrader.String()
 	case Logs:
 		category = Logs.String()
+	case IndexTemplates:
+		category = IndexTemplates.String()
 	default:
 		return nil, fmt.Errorf("invalid category encountered: %v" + c.String())
 	}
@@ -172,14 +178,15 @@ func (c Category) MarshalJSON() ([]byte, error) {
 }
 
 // IsFromES checks whether the category is one of the elasticsearch category, i.e.
-// one of [docs, search, indices, cat, clusters, misc]
+// one of [docs, search, indices, cat, clusters, misc, indextemplates]
 func (c Category) IsFromES() bool {
 	return c == Docs ||
 		c == Search ||
 		c == Indices ||
 		c == Cat ||
 		c == Clusters ||
-		c == Misc
+		c == Misc ||
+		c == IndexTemplates
 }
 
 // IsFromRS checks whether the category is of the reactivesearch category.
@@ -236,7 +243,6 @@ func (c Category) ACLs() []acl.ACL {
 			acl.Split,
 			acl.Aliases,
 			acl.Stats,
-			acl.Template,
 			acl.Open,
 			acl.Mapping,
 			acl.Recovery,
@@ -267,6 +273,10 @@ func (c Category) ACLs() []acl.ACL {
 			acl.Ingest,
 			acl.Snapshot,
 		}
+	case IndexTemplates:
+		return []acl.ACL{
+			acl.Template,
+		}
 	default:
 		return []acl.ACL{}
 	}
@@ -44,3 +44,27 @@ func FromContext(ctx context.Context) (Credential, error) {
 	}
 	return reqCredential, nil
 }
+
+// principalKey is a key against which the authenticated principal is stored.
+const principalKey = contextKey("request_principal")
+
+// NewPrincipalContext returns a new context carrying the name of the
+// principal the request authenticated as, i.e. the basic auth username or
+// the subject of the JWT.
+func NewPrincipalContext(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext retrieves the authenticated principal stored in the
+// context.
+func PrincipalFromContext(ctx context.Context) (string, error) {
+	ctxPrincipal := ctx.Value(principalKey)
+	if ctxPrincipal == nil {
+		return "", errors.NewNotFoundInContextError("request principal")
+	}
+	principal, ok := ctxPrincipal.(string)
+	if !ok {
+		return "", errors.NewInvalidCastError("ctxPrincipal", "string")
+	}
+	return principal, nil
+}
@@ -0,0 +1,89 @@
+package feature
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appbaseio/arc/errors"
+)
+
+type contextKey string
+
+// ctxKey is a key against which the feature flags of a request are stored in the context.
+const ctxKey = contextKey("features")
+
+// Header carries the feature flags of a request, e.g. "cache=off,stream=on".
+const Header = "X-Arc-Features"
+
+// Gateway features that can be toggled per request.
+const (
+	// Cache serves the request from, and saves its response to, the response cache.
+	Cache = "cache"
+	// Stream writes the response back in chunks as it is received.
+	Stream = "stream"
+)
+
+// Flags holds whether each of the flagged features is enabled, the features
+// that aren't flagged keep their configured behaviour.
+type Flags map[string]bool
+
+// FromHeader parses the comma separated "feature=on|off" pairs of the header,
+// a bare feature name turns the feature on. Malformed pairs are skipped.
+func FromHeader(value string) Flags {
+	flags := make(Flags)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "" {
+			continue
+		}
+		if len(parts) == 1 {
+			flags[name] = true
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(parts[1])) {
+		case "on", "true", "1":
+			flags[name] = true
+		case "off", "false", "0":
+			flags[name] = false
+		}
+	}
+	return flags
+}
+
+// NewContext returns a new context with the given feature flags.
+func NewContext(ctx context.Context, flags Flags) context.Context {
+	return context.WithValue(ctx, ctxKey, flags)
+}
+
+// FromContext retrieves the feature flags stored against the feature.ctxKey from the context.
+func FromContext(ctx context.Context) (Flags, error) {
+	ctxFlags := ctx.Value(ctxKey)
+	if ctxFlags == nil {
+		return nil, errors.NewNotFoundInContextError("features")
+	}
+	flags, ok := ctxFlags.(Flags)
+	if !ok {
+		return nil, errors.NewInvalidCastError("ctxFlags", "feature.Flags")
+	}
+	return flags, nil
+}
+
+// Lookup returns whether the feature has been flagged on or off for the request.
+func Lookup(ctx context.Context, name string) (enabled, ok bool) {
+	flags, err := FromContext(ctx)
+	if err != nil {
+		return false, false
+	}
+	enabled, ok = flags[name]
+	return enabled, ok
+}
+
+// Enabled returns whether the feature is enabled for the request, def if
+// it hasn't been flagged.
+func Enabled(ctx context.Context, name string, def bool) bool {
+	if enabled, ok := Lookup(ctx, name); ok {
+		return enabled
+	}
+	return def
+}
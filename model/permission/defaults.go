@@ -33,6 +33,7 @@ var (
 		category.Synonyms,
 		category.SearchGrader,
 		category.Logs,
+		category.IndexTemplates,
 	}
 
 	defaultOps = []op.Operation{
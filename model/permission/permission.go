@@ -51,6 +51,7 @@ type Permission struct {
 	Includes    []string            `json:"include_fields"`
 	Excludes    []string            `json:"exclude_fields"`
 	Expired     bool                `json:"expired"`
+	BypassCache *bool               `json:"bypass_cache"`
 }
 
 // Limits defines the rate limits for each category.
@@ -272,6 +273,15 @@ func SetDescription(description string) Options {
 	}
 }
 
+// SetBypassCache defines whether the permission's reads are always served by
+// elasticsearch rather than from the response cache.
+func SetBypassCache(bypass bool) Options {
+	return func(p *Permission) error {
+		p.BypassCache = &bypass
+		return nil
+	}
+}
+
 // SetTTL sets the permission's time-to-live.
 func SetTTL(duration time.Duration) Options {
 	return func(p *Permission) error {
@@ -492,7 +502,7 @@ func (p *Permission) GetLimitFor(c category.Category) (int64, error) {
 		return p.Limits.DocsLimit, nil
 	case category.Search:
 		return p.Limits.SearchLimit, nil
-	case category.Indices:
+	case category.Indices, category.IndexTemplates:
 		return p.Limits.IndicesLimit, nil
 	case category.Cat:
 		return p.Limits.CatLimit, nil
@@ -590,6 +600,9 @@ func (p *Permission) GetPatch(rolePatched bool) (map[string]interface{}, error)
 	if p.TTL.String() != "0s" {
 		patch["ttl"] = p.TTL
 	}
+	if p.BypassCache != nil {
+		patch["bypass_cache"] = *p.BypassCache
+	}
 	// Cannot patch individual limits to 0
 	if p.Limits != nil {
 		limits := make(map[string]interface{})
@@ -0,0 +1,422 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// defaultCacheCapacity is the default maximum number of cached responses.
+const defaultCacheCapacity = 1000
+
+// entryOverhead approximates the memory taken by a cached response besides
+// its variable length fields, i.e. by the struct, its eviction list element
+// and its index slot.
+const entryOverhead = 256
+
+// CachedResponse is an elasticsearch response stored in the response cache.
+type CachedResponse struct {
+	Key    string
+	Code   int
+	Header http.Header
+	Body   []byte
+	// indices the response was read from, patterns allowed, "_all" for the
+	// responses of all the indices; nil if the response isn't invalidated
+	// by the writes to the indices
+	Indices []string
+	// entity tag of the body, empty if the response isn't served
+	// conditionally
+	ETag      string
+	SavedAt   time.Time
+	ExpiresAt time.Time
+	// whether the body is stored gzipped
+	compressed bool
+	// estimated memory taken by the entry, in bytes
+	size int64
+}
+
+// Size returns the estimated memory taken by the cached response, in bytes.
+func (res *CachedResponse) Size() int64 {
+	return res.size
+}
+
+// Cache is an in-memory cache of elasticsearch responses. Each entry lives
+// for its own ttl and the least recently used entries are evicted once the
+// cache holds capacity entries, or once they take more than the memory
+// budget if one is set.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	// max estimated memory taken by the entries, in bytes, zero if unbounded
+	maxBytes int64
+	// estimated memory taken by the entries, in bytes
+	bytes int64
+	// gzip level the bodies are stored with, zero if they aren't compressed
+	level   int
+	entries map[string]*list.Element
+	lru     *list.List
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// CacheStats reports how effective the cache is.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// ratio of the lookups that hit the cache, zero before the first lookup
+	HitRatio float64 `json:"hit_ratio"`
+	// number of entries evicted to fit the capacity or the memory budget,
+	// the expired entries aren't counted
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// NewCache returns an empty cache that holds at most capacity responses.
+func NewCache(capacity int) *Cache {
+	if capacity <= 0 {
+		capacity = defaultCacheCapacity
+	}
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get returns the unexpired response cached against the key.
+func (c *Cache) Get(key string) (*CachedResponse, bool) {
+	res, ok := c.get(key)
+	if !ok || !res.compressed {
+		return res, ok
+	}
+	// the compressed entries are never modified in place, they are
+	// decompressed outside of the lock
+	return decompressed(res)
+}
+
+// Peek returns the unexpired response cached against the key like Get, but
+// neither counts the lookup in the stats nor marks the entry as recently
+// used.
+func (c *Cache) Peek(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	var res *CachedResponse
+	if ok {
+		res = element.Value.(*CachedResponse)
+	}
+	c.mu.Unlock()
+	if !ok || time.Now().After(res.ExpiresAt) {
+		return nil, false
+	}
+	if !res.compressed {
+		return res, true
+	}
+	return decompressed(res)
+}
+
+func (c *Cache) get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	res := element.Value.(*CachedResponse)
+	if time.Now().After(res.ExpiresAt) {
+		c.remove(element)
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.hits++
+	return res, true
+}
+
+// Set caches the response against the key for the given ttl.
+func (c *Cache) Set(key string, res *CachedResponse, ttl time.Duration) {
+	if level := c.CompressionLevel(); level != 0 {
+		if compressed, ok := compress(res, level); ok {
+			res = compressed
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	res.Key = key
+	res.SavedAt = now
+	res.ExpiresAt = now.Add(ttl)
+	res.size = entrySize(res)
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	// a response over the whole budget would evict every other one
+	if c.maxBytes > 0 && res.size > c.maxBytes {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(res)
+	c.bytes += res.size
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits its
+// capacity and memory budget.
+func (c *Cache) evict() {
+	for c.lru.Len() > c.capacity || (c.maxBytes > 0 && c.bytes > c.maxBytes && c.lru.Len() > 0) {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// entrySize estimates the memory taken by the cached response, the body is
+// counted as stored, i.e. compressed if it is.
+func entrySize(res *CachedResponse) int64 {
+	size := entryOverhead + len(res.Key) + len(res.Body) + len(res.ETag)
+	for name, values := range res.Header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	for _, index := range res.Indices {
+		size += len(index)
+	}
+	return int64(size)
+}
+
+// Delete removes the response cached against the key, if any.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// DeleteIndices removes the responses read from any of the given indices,
+// or all the responses read from indices if none is given.
+func (c *Cache) DeleteIndices(indices []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, element := range c.entries {
+		if readFrom(element.Value.(*CachedResponse).Indices, indices) {
+			c.remove(element)
+		}
+	}
+}
+
+// readFrom checks whether any of the indices matches the read indices.
+func readFrom(read, indices []string) bool {
+	if len(indices) == 0 {
+		return len(read) > 0
+	}
+	for _, pattern := range read {
+		if pattern == "_all" {
+			return true
+		}
+		for _, index := range indices {
+			if ok, _ := path.Match(pattern, index); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetCompressionLevel makes the cache store the bodies of the responses
+// cached from now on gzipped with the given level, from gzip.BestSpeed to
+// gzip.BestCompression. Zero stores them as is.
+func (c *Cache) SetCompressionLevel(level int) error {
+	if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("invalid compression level %d, expected %d to %d", level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level = level
+	return nil
+}
+
+// CompressionLevel returns the gzip level the bodies are stored with, zero
+// if they aren't compressed.
+func (c *Cache) CompressionLevel() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.level
+}
+
+// compress returns a copy of the response with its body gzipped.
+func compress(res *CachedResponse, level int) (*CachedResponse, bool) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := w.Write(res.Body); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	copied := *res
+	copied.Body = buf.Bytes()
+	copied.compressed = true
+	return &copied, true
+}
+
+// decompressed returns a copy of the response with its body gunzipped.
+func decompressed(res *CachedResponse) (*CachedResponse, bool) {
+	r, err := gzip.NewReader(bytes.NewReader(res.Body))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	copied := *res
+	copied.Body = body
+	copied.compressed = false
+	return &copied, true
+}
+
+// Capacity returns the maximum number of cached responses.
+func (c *Cache) Capacity() int {
+	return c.capacity
+}
+
+// SetMaxBytes sets the memory budget of the cache, in bytes, evicting the
+// least recently used entries until they fit in it. Zero lifts the budget.
+func (c *Cache) SetMaxBytes(maxBytes int64) error {
+	if maxBytes < 0 {
+		return fmt.Errorf("invalid memory budget %d, expected a positive number of bytes", maxBytes)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = maxBytes
+	c.evict()
+	return nil
+}
+
+// MaxBytes returns the memory budget of the cache, in bytes, zero if it
+// has none.
+func (c *Cache) MaxBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxBytes
+}
+
+// Bytes returns the estimated memory taken by the cached responses, in
+// bytes, including the expired ones that haven't been evicted yet.
+func (c *Cache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// Len returns the number of cached responses, including the expired ones
+// that haven't been evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the lookup and eviction counts of the cache since it was
+// created, along with its current size.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Bytes:     c.bytes,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRatio = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// Check checks that the cache is usable, i.e. that it can be locked before
+// the context is done and that its index and eviction list agree.
+func (c *Cache) Check(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		// release the lock once it is eventually acquired
+		go func() {
+			<-locked
+			c.mu.Unlock()
+		}()
+		return fmt.Errorf("response cache is unresponsive: %v", ctx.Err())
+	}
+	defer c.mu.Unlock()
+	if len(c.entries) != c.lru.Len() {
+		return fmt.Errorf("response cache is inconsistent: %d keys for %d entries", len(c.entries), c.lru.Len())
+	}
+	return nil
+}
+
+func (c *Cache) remove(element *list.Element) {
+	res := element.Value.(*CachedResponse)
+	c.lru.Remove(element)
+	delete(c.entries, res.Key)
+	c.bytes -= res.size
+}
+
+var (
+	cache     *Cache
+	cacheOnce sync.Once
+)
+
+// ResponseCache returns the response cache shared by the plugins.
+func ResponseCache() *Cache {
+	cacheOnce.Do(func() { cache = NewCache(defaultCacheCapacity) })
+	return cache
+}
+
+// SetResponseCache replaces the shared response cache.
+func SetResponseCache(c *Cache) {
+	cacheOnce.Do(func() {})
+	cache = c
+}
+
+// SaveResponse caches the response against the key in the shared response cache.
+func SaveResponse(key string, res *CachedResponse, ttl time.Duration) {
+	ResponseCache().Set(key, res, ttl)
+}
+
+// GetResponse returns the response cached against the key in the shared response cache.
+func GetResponse(key string) (*CachedResponse, bool) {
+	return ResponseCache().Get(key)
+}
+
+// PeekResponse returns the response cached against the key in the shared
+// response cache without counting the lookup or refreshing the entry.
+func PeekResponse(key string) (*CachedResponse, bool) {
+	return ResponseCache().Peek(key)
+}
+
+// InvalidateIndices removes the responses read from any of the given indices
+// from the shared response cache.
+func InvalidateIndices(indices []string) {
+	ResponseCache().DeleteIndices(indices)
+}
@@ -0,0 +1,245 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// searchResponse returns a search response body with the given number of hits.
+func searchResponse(hits int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"took":3,"timed_out":false,"hits":{"total":{"value":` + fmt.Sprint(hits) + `,"relation":"eq"},"hits":[`)
+	for i := 0; i < hits; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"_index":"products","_id":"%d","_score":1.0,"_source":{"name":"product %d","price":%d,"tags":["new","sale"],"description":"a product that is sold in the store"}}`, i, i, i*10)
+	}
+	buf.WriteString(`]}}`)
+	return buf.Bytes()
+}
+
+func TestCacheCompression(t *testing.T) {
+	Convey("Cache compression", t, func() {
+		body := searchResponse(100)
+		So(NewCache(10).SetCompressionLevel(gzip.DefaultCompression), ShouldNotBeNil)
+		So(NewCache(10).SetCompressionLevel(gzip.BestCompression+1), ShouldNotBeNil)
+
+		for _, level := range []int{0, gzip.BestSpeed, gzip.BestCompression} {
+			cache := NewCache(10)
+			So(cache.SetCompressionLevel(level), ShouldBeNil)
+			header := http.Header{"Content-Type": []string{"application/json"}}
+			cache.Set("key", &CachedResponse{Code: http.StatusOK, Header: header, Body: body, Indices: []string{"products"}}, time.Minute)
+
+			stored := cache.entries["key"].Value.(*CachedResponse)
+			So(stored.compressed, ShouldEqual, level != 0)
+			if level != 0 {
+				So(len(stored.Body), ShouldBeLessThan, len(body))
+			}
+
+			res, ok := cache.Get("key")
+			So(ok, ShouldBeTrue)
+			So(res.Body, ShouldResemble, body)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(res.Header, ShouldResemble, header)
+			So(res.SavedAt, ShouldEqual, stored.SavedAt)
+
+			// the stored entry stays compressed across the reads
+			again, _ := cache.Get("key")
+			So(again.Body, ShouldResemble, body)
+
+			cache.DeleteIndices([]string{"products"})
+			_, ok = cache.Get("key")
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
+
+func benchmarkCacheLevel(b *testing.B, level int) {
+	body := searchResponse(100)
+	cache := NewCache(10)
+	if err := cache.SetCompressionLevel(level); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		cache.Set("key", &CachedResponse{Code: http.StatusOK, Body: body}, time.Minute)
+		if _, ok := cache.Get("key"); !ok {
+			b.Fatal("cache miss")
+		}
+	}
+	stored := cache.entries["key"].Value.(*CachedResponse)
+	b.ReportMetric(float64(len(body))/float64(len(stored.Body)), "ratio")
+}
+
+func BenchmarkCacheUncompressed(b *testing.B)    { benchmarkCacheLevel(b, 0) }
+func BenchmarkCacheBestSpeed(b *testing.B)       { benchmarkCacheLevel(b, gzip.BestSpeed) }
+func BenchmarkCacheBestCompression(b *testing.B) { benchmarkCacheLevel(b, gzip.BestCompression) }
+
+func TestCacheCheck(t *testing.T) {
+	Convey("Cache check", t, func() {
+		cache := NewCache(10)
+		cache.Set("key", &CachedResponse{Code: http.StatusOK, Body: []byte(`{}`)}, time.Minute)
+		So(cache.Check(context.Background()), ShouldBeNil)
+
+		Convey("fails while the cache is locked", func() {
+			cache.mu.Lock()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			So(cache.Check(ctx), ShouldNotBeNil)
+			cache.mu.Unlock()
+
+			// the check releases the lock it acquires late
+			So(cache.Check(context.Background()), ShouldBeNil)
+		})
+		Convey("fails once the index and the entries disagree", func() {
+			delete(cache.entries, "key")
+			So(cache.Check(context.Background()), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCacheMemoryBudget(t *testing.T) {
+	Convey("Cache memory budget", t, func() {
+		cache := NewCache(100)
+		So(cache.SetMaxBytes(-1), ShouldNotBeNil)
+		body := searchResponse(10)
+		entry := func() *CachedResponse {
+			return &CachedResponse{Code: http.StatusOK, Body: body, Indices: []string{"products"}}
+		}
+		cache.Set("key-0", entry(), time.Minute)
+		size := cache.Bytes()
+		So(size, ShouldBeGreaterThan, len(body))
+
+		// room for three entries
+		So(cache.SetMaxBytes(3*size+size/2), ShouldBeNil)
+		for i := 1; i < 3; i++ {
+			cache.Set(fmt.Sprintf("key-%d", i), entry(), time.Minute)
+		}
+		So(cache.Len(), ShouldEqual, 3)
+		So(cache.Bytes(), ShouldEqual, 3*size)
+
+		// the least recently used entry is evicted first
+		_, ok := cache.Get("key-0")
+		So(ok, ShouldBeTrue)
+		cache.Set("key-3", entry(), time.Minute)
+		So(cache.Len(), ShouldEqual, 3)
+		So(cache.Bytes(), ShouldBeLessThanOrEqualTo, cache.MaxBytes())
+		_, ok = cache.Get("key-1")
+		So(ok, ShouldBeFalse)
+		_, ok = cache.Get("key-0")
+		So(ok, ShouldBeTrue)
+
+		// replacing an entry accounts for its new size only
+		cache.Set("key-3", entry(), time.Minute)
+		So(cache.Bytes(), ShouldEqual, 3*size)
+
+		// a larger entry takes the room of several
+		cache.Set("key-large", &CachedResponse{Code: http.StatusOK, Body: bytes.Repeat([]byte(" "), int(2*size))}, time.Minute)
+		So(cache.Len(), ShouldEqual, 2)
+		So(cache.Bytes(), ShouldBeLessThanOrEqualTo, cache.MaxBytes())
+		_, ok = cache.Get("key-0")
+		So(ok, ShouldBeFalse)
+
+		// an entry over the whole budget isn't cached
+		cache.Set("key-huge", &CachedResponse{Code: http.StatusOK, Body: bytes.Repeat([]byte(" "), int(4*size))}, time.Minute)
+		_, ok = cache.Get("key-huge")
+		So(ok, ShouldBeFalse)
+		_, ok = cache.Get("key-large")
+		So(ok, ShouldBeTrue)
+
+		// lowering the budget evicts right away
+		So(cache.SetMaxBytes(size), ShouldBeNil)
+		So(cache.Len(), ShouldEqual, 0)
+		So(cache.Bytes(), ShouldEqual, 0)
+		So(cache.Check(context.Background()), ShouldBeNil)
+	})
+}
+
+func TestCacheStats(t *testing.T) {
+	Convey("Cache stats", t, func() {
+		cache := NewCache(2)
+		So(cache.Stats(), ShouldResemble, CacheStats{})
+		entry := func() *CachedResponse {
+			return &CachedResponse{Code: http.StatusOK, Body: []byte(`{"took":1}`)}
+		}
+
+		cache.Set("a", entry(), time.Minute)
+		cache.Get("a")
+		cache.Get("a")
+		cache.Get("b")
+		stats := cache.Stats()
+		So(stats.Hits, ShouldEqual, 2)
+		So(stats.Misses, ShouldEqual, 1)
+		So(stats.HitRatio, ShouldAlmostEqual, 2.0/3.0)
+		So(stats.Evictions, ShouldEqual, 0)
+		So(stats.Entries, ShouldEqual, 1)
+		So(stats.Bytes, ShouldEqual, cache.Bytes())
+
+		// the entries over the capacity are evicted
+		cache.Set("b", entry(), time.Minute)
+		cache.Set("c", entry(), time.Minute)
+		cache.Set("d", entry(), time.Minute)
+		stats = cache.Stats()
+		So(stats.Evictions, ShouldEqual, 2)
+		So(stats.Entries, ShouldEqual, 2)
+
+		// the expired entries are misses, not evictions
+		cache.Set("e", entry(), -time.Second)
+		_, ok := cache.Get("e")
+		So(ok, ShouldBeFalse)
+		stats = cache.Stats()
+		So(stats.Misses, ShouldEqual, 2)
+		So(stats.Evictions, ShouldEqual, 3)
+		So(stats.Entries, ShouldEqual, 1)
+	})
+}
+
+func TestCachePeek(t *testing.T) {
+	Convey("Cache peek", t, func() {
+		cache := NewCache(2)
+		entry := func() *CachedResponse {
+			return &CachedResponse{Code: http.StatusOK, Body: []byte(`{"took":1}`)}
+		}
+		cache.Set("a", entry(), time.Minute)
+		cache.Set("b", entry(), time.Minute)
+
+		Convey("doesn't count the lookups", func() {
+			res, ok := cache.Peek("a")
+			So(ok, ShouldBeTrue)
+			So(string(res.Body), ShouldEqual, `{"took":1}`)
+			_, ok = cache.Peek("z")
+			So(ok, ShouldBeFalse)
+			So(cache.Stats().Hits, ShouldEqual, 0)
+			So(cache.Stats().Misses, ShouldEqual, 0)
+		})
+		Convey("doesn't mark the entries as recently used", func() {
+			cache.Peek("a")
+			cache.Set("c", entry(), time.Minute)
+			_, ok := cache.Peek("a")
+			So(ok, ShouldBeFalse)
+			_, ok = cache.Peek("b")
+			So(ok, ShouldBeTrue)
+		})
+		Convey("skips the expired entries", func() {
+			cache.Set("a", entry(), -time.Second)
+			_, ok := cache.Peek("a")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("decompresses the bodies", func() {
+			So(cache.SetCompressionLevel(gzip.BestSpeed), ShouldBeNil)
+			cache.Set("a", entry(), time.Minute)
+			res, ok := cache.Peek("a")
+			So(ok, ShouldBeTrue)
+			So(string(res.Body), ShouldEqual, `{"took":1}`)
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/appbaseio/arc/errors"
+)
+
+type contextKey string
+
+// ctxKey is a key against which the trace context of a request is stored in the context.
+const ctxKey = contextKey("trace")
+
+// Trace identifies the trace and the span a request belongs to.
+type Trace struct {
+	TraceID string
+	SpanID  string
+}
+
+// FromHeader extracts the trace context propagated by the client, either as a
+// W3C "traceparent" header or as a Jaeger "uber-trace-id" header.
+func FromHeader(header http.Header) (*Trace, bool) {
+	// version-traceid-spanid-flags
+	if value := header.Get("traceparent"); value != "" {
+		parts := strings.Split(strings.TrimSpace(value), "-")
+		if len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+			return &Trace{TraceID: parts[1], SpanID: parts[2]}, true
+		}
+	}
+	// traceid:spanid:parentid:flags
+	if value := header.Get("uber-trace-id"); value != "" {
+		parts := strings.Split(strings.TrimSpace(value), ":")
+		if len(parts) == 4 && parts[0] != "" && parts[1] != "" {
+			return &Trace{TraceID: parts[0], SpanID: parts[1]}, true
+		}
+	}
+	return nil, false
+}
+
+// NewContext returns a new context with the given trace.
+func NewContext(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, ctxKey, t)
+}
+
+// FromContext retrieves the trace stored against the trace.ctxKey from the context.
+func FromContext(ctx context.Context) (*Trace, error) {
+	ctxTrace := ctx.Value(ctxKey)
+	if ctxTrace == nil {
+		return nil, errors.NewNotFoundInContextError("trace")
+	}
+	reqTrace, ok := ctxTrace.(*Trace)
+	if !ok {
+		return nil, errors.NewInvalidCastError("ctxTrace", "*trace.Trace")
+	}
+	return reqTrace, nil
+}
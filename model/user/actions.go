@@ -99,6 +99,7 @@ var developCategories = []category.Category{
 	category.Misc,
 	category.Streams,
 	category.Logs,
+	category.IndexTemplates,
 }
 
 var ActionToCategories = map[UserAction][]category.Category{
@@ -35,6 +35,7 @@ type User struct {
 	ACLs             []acl.ACL           `json:"acls"`
 	Email            string              `json:"email"`
 	Indices          []string            `json:"indices"`
+	BypassCache      *bool               `json:"bypass_cache"`
 	CreatedAt        string              `json:"created_at"`
 }
 
@@ -115,6 +116,15 @@ func SetIndices(indices []string) Options {
 	}
 }
 
+// SetBypassCache defines whether the user's reads are always served by
+// elasticsearch rather than from the response cache.
+func SetBypassCache(bypass bool) Options {
+	return func(u *User) error {
+		u.BypassCache = &bypass
+		return nil
+	}
+}
+
 // New creates a new user by running the Options on it. It returns a default user
 // in case no Options are provided.
 func New(username, password string, opts ...Options) (*User, error) {
@@ -317,6 +327,9 @@ func (u *User) GetPatch() (map[string]interface{}, error) {
 	if u.Indices != nil {
 		patch["indices"] = u.Indices
 	}
+	if u.BypassCache != nil {
+		patch["bypass_cache"] = *u.BypassCache
+	}
 	if u.CreatedAt != "" {
 		return nil, errors.NewUnsupportedPatchError("user", "created_at")
 	}
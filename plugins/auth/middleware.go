@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"os"
@@ -242,6 +244,13 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// the clients sharing a JWT role are told apart by the token's subject
+		principal := username
+		if !hasBasicAuth {
+			principal = jwtPrincipal(jwtToken)
+		}
+		req = req.WithContext(credential.NewPrincipalContext(req.Context(), principal))
+
 		// remove user/permission from cache on write operation
 		if *reqOp == op.Write || *reqOp == op.Delete {
 			username := mux.Vars(req)["username"]
@@ -252,6 +261,18 @@ func (a *Auth) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// jwtPrincipal returns the principal the JWT identifies, its subject, or the
+// token itself if it has none.
+func jwtPrincipal(token *jwt.Token) string {
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if sub, ok := claims["sub"].(string); ok && sub != "" {
+			return "jwt:" + sub
+		}
+	}
+	hash := sha256.Sum256([]byte(token.Raw))
+	return "jwt-token:" + hex.EncodeToString(hash[:])
+}
+
 func (a *Auth) getCredential(ctx context.Context, username string) (credential.AuthCredential, error) {
 	c, ok := GetCachedCredential(username)
 	if ok {
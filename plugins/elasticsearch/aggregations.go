@@ -0,0 +1,210 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/appbaseio/arc/model/category"
+)
+
+// aggregationLimits bound the cost of the searches' aggregations, zero
+// means unlimited.
+type aggregationLimits struct {
+	// maximum number of documents collected per shard
+	terminateAfter int64
+	// maximum number of buckets of each bucket aggregation
+	size int64
+	// maximum nesting depth of the aggregations
+	depth int
+}
+
+// stricter returns the lowest of the two limits.
+func stricter(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (l aggregationLimits) merge(o aggregationLimits) aggregationLimits {
+	return aggregationLimits{
+		terminateAfter: stricter(l.terminateAfter, o.terminateAfter),
+		size:           stricter(l.size, o.size),
+		depth:          int(stricter(int64(l.depth), int64(o.depth))),
+	}
+}
+
+// aggregationLimiter holds the limits configured by category and by index
+// pattern.
+type aggregationLimiter struct {
+	categories map[category.Category]aggregationLimits
+	patterns   map[string]aggregationLimits
+}
+
+func (es *elasticsearch) initAggregationLimits() error {
+	entries := envList(envAggregationLimits)
+	if len(entries) == 0 {
+		return nil
+	}
+	limiter := &aggregationLimiter{
+		categories: make(map[category.Category]aggregationLimits),
+		patterns:   make(map[string]aggregationLimits),
+	}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf(`invalid aggregation limit %q, expected "category:limit=value" or "index:limit=value"`, entry)
+		}
+		limits, err := parseAggregationLimit(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid aggregation limit %q: %v", entry, err)
+		}
+		if c, err := parseCategory(parts[0]); err == nil {
+			limiter.categories[c] = limiter.categories[c].merge(limits)
+			continue
+		}
+		if _, err := path.Match(parts[0], ""); err != nil {
+			return fmt.Errorf("invalid aggregation limit %q: invalid pattern: %v", entry, err)
+		}
+		limiter.patterns[parts[0]] = limiter.patterns[parts[0]].merge(limits)
+	}
+	es.aggregationLimiter = limiter
+	return nil
+}
+
+// parseAggregationLimit parses a single limit=value pair.
+func parseAggregationLimit(pair string) (aggregationLimits, error) {
+	var limits aggregationLimits
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 {
+		return limits, fmt.Errorf(`expected "limit=value"`)
+	}
+	value, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || value <= 0 {
+		return limits, fmt.Errorf("%q isn't a positive integer", parts[1])
+	}
+	switch parts[0] {
+	case "terminate_after":
+		limits.terminateAfter = value
+	case "size":
+		limits.size = value
+	case "depth":
+		limits.depth = int(value)
+	default:
+		return limits, fmt.Errorf(`unknown limit %q, expected "terminate_after", "size" or "depth"`, parts[0])
+	}
+	return limits, nil
+}
+
+// limitsFor returns the limits of the category and of the indices merged,
+// false if none apply. The searches of all the indices, e.g. a pathless
+// /_search, or of wildcard ones may reach any of the limited indices and get
+// the limits of all the patterns.
+func (l *aggregationLimiter) limitsFor(c category.Category, indices []string) (aggregationLimits, bool) {
+	limits, ok := l.categories[c]
+	_, wildcard := wildcardTarget(indices)
+	for pattern, patternLimits := range l.patterns {
+		if _, matched := matchIndex([]string{pattern}, indices); matched || wildcard || len(indices) == 0 {
+			limits = limits.merge(patternLimits)
+			ok = true
+		}
+	}
+	return limits, ok
+}
+
+// limitedSearch checks whether the request is a plain search, i.e. not a
+// scroll, a search template or an sql query, whose bodies aren't search
+// bodies.
+func limitedSearch(r *http.Request) bool {
+	return strings.HasSuffix(routeTemplate(r), "/_search")
+}
+
+// aggregationDepthError reports the aggregations nested deeper than allowed.
+type aggregationDepthError struct {
+	name  string
+	depth int
+}
+
+func (e *aggregationDepthError) Error() string {
+	return fmt.Sprintf(`aggregation "%s" is nested deeper than the %d levels allowed`, e.name, e.depth)
+}
+
+// limit rewrites the search body so that it abides by the limits, keeping
+// the client's values that are stricter. The body is returned unchanged if
+// it already does, or if it isn't a json object es would reject anyway.
+func (l aggregationLimits) limit(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var search map[string]interface{}
+	if err := decoder.Decode(&search); err != nil {
+		return body, nil
+	}
+	changed := false
+	if l.terminateAfter > 0 {
+		if value, ok := search["terminate_after"].(json.Number); !ok || exceeds(value, l.terminateAfter) {
+			search["terminate_after"] = l.terminateAfter
+			changed = true
+		}
+	}
+	for _, key := range []string{"aggs", "aggregations"} {
+		if aggs, ok := search[key].(map[string]interface{}); ok {
+			limited, err := l.limitAggregations(aggs, 1)
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || limited
+		}
+	}
+	if !changed {
+		return body, nil
+	}
+	return json.Marshal(search)
+}
+
+// limitAggregations caps the sizes of the aggregations at the given depth
+// and of their sub-aggregations, reporting whether any was changed.
+func (l aggregationLimits) limitAggregations(aggs map[string]interface{}, depth int) (bool, error) {
+	changed := false
+	for name, agg := range aggs {
+		if l.depth > 0 && depth > l.depth {
+			return false, &aggregationDepthError{name: name, depth: l.depth}
+		}
+		body, ok := agg.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range body {
+			params, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if key == "aggs" || key == "aggregations" {
+				limited, err := l.limitAggregations(params, depth+1)
+				if err != nil {
+					return false, err
+				}
+				changed = changed || limited
+				continue
+			}
+			// the size of a bucket aggregation, e.g. terms, unset sizes
+			// default to es's
+			if size, ok := params["size"].(json.Number); ok && l.size > 0 && exceeds(size, l.size) {
+				params["size"] = l.size
+				changed = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// exceeds checks whether the client's value is above the limit, the values
+// that aren't integers are replaced by the limit as well.
+func exceeds(value json.Number, limit int64) bool {
+	n, err := value.Int64()
+	return err != nil || n > limit
+}
@@ -0,0 +1,103 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregationLimits(t *testing.T) {
+	Convey("Aggregation limits by category and index", t, func() {
+		os.Setenv(envAggregationLimits, "search:terminate_after=100000,logs-*:terminate_after=5000,logs-*:size=100,logs-*:depth=2")
+		defer os.Unsetenv(envAggregationLimits)
+		es := &elasticsearch{}
+		So(es.initAggregationLimits(), ShouldBeNil)
+
+		var forwarded map[string]interface{}
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			forwarded = nil
+			json.Unmarshal(body, &forwarded)
+			w.Write([]byte(`{"hits":{"hits":[]}}`))
+		})
+		defer upstream.Close()
+
+		search := func(index, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/"+index+"/_search", strings.NewReader(body))
+			return route(http.MethodPost, "/{index}/_search", func(w http.ResponseWriter, r *http.Request) {
+				es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+			}, req)
+		}
+		terms := func(agg map[string]interface{}) map[string]interface{} {
+			return agg["terms"].(map[string]interface{})
+		}
+
+		Convey("should inject the stricter limits in the forwarded body", func() {
+			resp := search("logs-2026", `{"size":0,"aggs":{"hosts":{"terms":{"field":"host","size":10000},`+
+				`"aggs":{"paths":{"terms":{"field":"path","size":50}}}}}}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(forwarded["terminate_after"], ShouldEqual, 5000)
+			hosts := forwarded["aggs"].(map[string]interface{})["hosts"].(map[string]interface{})
+			So(terms(hosts)["size"], ShouldEqual, 100)
+			paths := hosts["aggs"].(map[string]interface{})["paths"].(map[string]interface{})
+			// the client's stricter size is kept
+			So(terms(paths)["size"], ShouldEqual, 50)
+			So(forwarded["size"], ShouldEqual, 0)
+		})
+		Convey("should keep the client's stricter terminate_after", func() {
+			So(search("logs-2026", `{"terminate_after":10}`).Code, ShouldEqual, http.StatusOK)
+			So(forwarded["terminate_after"], ShouldEqual, 10)
+		})
+		Convey("should apply the category limits to the other indices", func() {
+			So(search("orders", `{"aggs":{"statuses":{"terms":{"field":"status","size":10000}}}}`).Code, ShouldEqual, http.StatusOK)
+			So(forwarded["terminate_after"], ShouldEqual, 100000)
+			statuses := forwarded["aggs"].(map[string]interface{})["statuses"].(map[string]interface{})
+			So(terms(statuses)["size"], ShouldEqual, 10000)
+		})
+		Convey("should apply the limits of all the patterns to the searches of all the indices", func() {
+			searchAll := func(path, template string) {
+				req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"terminate_after":1000000}`))
+				So(route(http.MethodPost, template, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+				}, req).Code, ShouldEqual, http.StatusOK)
+			}
+			searchAll("/_search", "/_search")
+			So(forwarded["terminate_after"], ShouldEqual, 5000)
+			searchAll("/log*/_search", "/{index}/_search")
+			So(forwarded["terminate_after"], ShouldEqual, 5000)
+		})
+		Convey("should leave the bodies of the other search routes alone", func() {
+			for _, template := range []string{"/_search/scroll", "/_search/template", "/_sql"} {
+				req := httptest.NewRequest(http.MethodPost, template, strings.NewReader(`{"scroll_id":"abc","size":10000}`))
+				So(route(http.MethodPost, template, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+				}, req).Code, ShouldEqual, http.StatusOK)
+				So(forwarded, ShouldNotContainKey, "terminate_after")
+			}
+		})
+		Convey("should reject the aggregations nested too deep", func() {
+			resp := search("logs-2026", `{"aggs":{"a":{"terms":{"field":"a"},"aggs":{"b":{"terms":{"field":"b"},`+
+				`"aggs":{"c":{"terms":{"field":"c"}}}}}}}}`)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldContainSubstring, `aggregation \"c\" is nested deeper than the 2 levels allowed`)
+		})
+	})
+
+	Convey("Invalid aggregation limits", t, func() {
+		for _, value := range []string{"logs-*", "logs-*:size", "logs-*:size=0", "logs-*:buckets=10", ":size=10"} {
+			os.Setenv(envAggregationLimits, value)
+			So((&elasticsearch{}).initAggregationLimits(), ShouldNotBeNil)
+		}
+		os.Unsetenv(envAggregationLimits)
+	})
+}
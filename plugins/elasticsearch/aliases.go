@@ -0,0 +1,168 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// aliasCache keeps the alias -> index map the requests' indices are resolved
+// against up to date, it is fetched from es at most once every ttl rather
+// than for every request and refetched as soon as arc forwards an alias change.
+type aliasCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	fetchedAt time.Time
+	// whether an alias change has made the map stale
+	stale     bool
+	hits      int64
+	refreshes int64
+	errors    int64
+}
+
+func (es *elasticsearch) initAliasCache() error {
+	value := os.Getenv(envAliasCacheTTL)
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	es.aliasCache = &aliasCache{ttl: ttl}
+	return nil
+}
+
+// resolve refreshes the alias -> index map if it is older than the ttl.
+func (c *aliasCache) resolve(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stale && !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.ttl {
+		c.hits++
+		return nil
+	}
+	aliases, err := fetchAliases(ctx)
+	if err != nil {
+		c.errors++
+		return err
+	}
+	classify.SetAliasIndexCache(aliases)
+	c.fetchedAt = time.Now()
+	c.stale = false
+	c.refreshes++
+	return nil
+}
+
+// invalidate makes the next request refetch the alias -> index map.
+func (c *aliasCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stale = true
+}
+
+func (c *aliasCache) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := map[string]interface{}{
+		"ttl":       c.ttl.String(),
+		"aliases":   len(classify.GetAliasIndexCache()),
+		"hits":      c.hits,
+		"refreshes": c.refreshes,
+		"errors":    c.errors,
+	}
+	if !c.fetchedAt.IsZero() {
+		stats["fetched_at"] = c.fetchedAt.Format(time.RFC3339)
+	}
+	return stats
+}
+
+// fetchAliases returns the cluster's aliases mapped to their index.
+func fetchAliases(ctx context.Context) (map[string]string, error) {
+	res, err := util.GetReadClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodGet,
+		Path:   "/_cat/aliases",
+		Params: map[string][]string{"format": {"json"}, "h": {"alias,index"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Alias string `json:"alias"`
+		Index string `json:"index"`
+	}
+	if err := json.Unmarshal(res.Body, &rows); err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string)
+	for _, row := range rows {
+		aliases[row.Alias] = row.Index
+	}
+	return aliases, nil
+}
+
+// changesAliases checks whether the request may add or remove aliases.
+func changesAliases(r *http.Request, o op.Operation) bool {
+	return o != op.Read && strings.Contains(routeTemplate(r), "/_alias")
+}
+
+// resolveAliases brings the alias -> index map the indices of the request are
+// classified against up to date, and marks it stale once an alias change has
+// succeeded, the rejected or failed ones having changed nothing.
+func (es *elasticsearch) resolveAliases(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if es.aliasCache == nil {
+			h(w, req)
+			return
+		}
+		if err := es.aliasCache.resolve(req.Context()); err != nil {
+			// keep serving against the aliases fetched last
+			log.Errorln(logTag, ": unable to refresh the aliases:", err)
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, req)
+		if sw.code < 200 || sw.code > 299 {
+			return
+		}
+		if reqOp, err := op.FromContext(req.Context()); err == nil && changesAliases(req, *reqOp) {
+			es.aliasCache.invalidate()
+		}
+	}
+}
+
+// statusWriter records the status code of the response it writes, zero
+// until the response is written.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
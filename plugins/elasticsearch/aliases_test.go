@@ -0,0 +1,108 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAliasCache(t *testing.T) {
+	Convey("Alias resolution cache", t, func() {
+		saved := classify.GetAliasIndexCache()
+		defer classify.SetAliasIndexCache(saved)
+
+		var fetches int
+		var rejected bool
+		aliases := `[{"alias":"catalog","index":"products_v1"}]`
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			if r.URL.Path == "/_cat/aliases" {
+				fetches++
+				w.Write([]byte(aliases))
+				return
+			}
+			if rejected && r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"type":"aliases_not_found_exception"},"status":404}`))
+				return
+			}
+			w.Write([]byte(`{"acknowledged":true}`))
+		})
+		defer upstream.Close()
+		es := &elasticsearch{aliasCache: &aliasCache{ttl: time.Minute}}
+
+		serve := func(method, template, path string, o op.Operation) *httptest.ResponseRecorder {
+			return route(method, template, func(w http.ResponseWriter, r *http.Request) {
+				es.resolveAliases(es.handler())(w, classified(r, category.Indices, acl.Indices, o))
+			}, httptest.NewRequest(method, path, nil))
+		}
+		search := func() {
+			So(serve(http.MethodGet, "/{index}/_search", "/catalog/_search", op.Read).Code, ShouldEqual, http.StatusOK)
+		}
+
+		Convey("Aliases are resolved once per ttl", func() {
+			search()
+			search()
+			So(fetches, ShouldEqual, 1)
+			So(classify.GetAliasIndex("catalog"), ShouldEqual, "products_v1")
+
+			// the map is refreshed once it is older than the ttl
+			aliases = `[{"alias":"catalog","index":"products_v2"}]`
+			es.aliasCache.fetchedAt = es.aliasCache.fetchedAt.Add(-time.Minute)
+			search()
+			So(fetches, ShouldEqual, 2)
+			So(classify.GetAliasIndex("catalog"), ShouldEqual, "products_v2")
+			search()
+			So(fetches, ShouldEqual, 2)
+		})
+		Convey("Alias changes make the next request refetch them", func() {
+			search()
+			aliases = `[{"alias":"catalog","index":"products_v3"}]`
+			So(serve(http.MethodPut, "/{index}/_alias/{name}", "/products_v3/_alias/catalog", op.Write).Code, ShouldEqual, http.StatusOK)
+			So(fetches, ShouldEqual, 1)
+			search()
+			So(fetches, ShouldEqual, 2)
+			So(classify.GetAliasIndex("catalog"), ShouldEqual, "products_v3")
+
+			// the other writes leave the map alone
+			So(serve(http.MethodPut, "/{index}/_settings", "/products_v3/_settings", op.Write).Code, ShouldEqual, http.StatusOK)
+			search()
+			So(fetches, ShouldEqual, 2)
+
+			// and so do the alias changes that failed
+			rejected = true
+			So(serve(http.MethodDelete, "/{index}/_alias/{name}", "/products_v3/_alias/catalog", op.Delete).Code, ShouldEqual, http.StatusNotFound)
+			search()
+			So(fetches, ShouldEqual, 2)
+		})
+		Convey("The cache is reported to the admins on the health endpoint", func() {
+			search()
+			search()
+			var health struct {
+				AliasCache map[string]interface{} `json:"alias_cache"`
+			}
+			resp := httptest.NewRecorder()
+			es.healthHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/health", nil))
+			So(json.Unmarshal(resp.Body.Bytes(), &health), ShouldBeNil)
+			So(health.AliasCache, ShouldBeNil)
+
+			resp = httptest.NewRecorder()
+			es.healthDetailsHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/health/details", nil))
+			So(json.Unmarshal(resp.Body.Bytes(), &health), ShouldBeNil)
+			So(health.AliasCache["ttl"], ShouldEqual, "1m0s")
+			So(health.AliasCache["aliases"], ShouldEqual, 1)
+			So(health.AliasCache["hits"], ShouldEqual, 1)
+			So(health.AliasCache["refreshes"], ShouldEqual, 1)
+			So(health.AliasCache["fetched_at"], ShouldNotBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// autoCreateBlock rejects the writes that would make es create an index on
+// the fly, e.g. because of a typo in the index name, unless the index
+// matches one of the allowed patterns. The indices are created explicitly
+// otherwise, with PUT /{index}.
+type autoCreateBlock struct {
+	// path.Match patterns of the indices that may still be auto-created
+	allowed []string
+	ttl     time.Duration
+
+	mu sync.Mutex
+	// when the indices and aliases were last seen to exist
+	existing map[string]time.Time
+}
+
+func (es *elasticsearch) initAutoCreateBlock() error {
+	if os.Getenv(envBlockAutoCreate) != "true" {
+		return nil
+	}
+	allowed := envList(envAutoCreateAllowlist)
+	for _, pattern := range allowed {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %v", envAutoCreateAllowlist, pattern, err)
+		}
+	}
+	ttl := defaultIndexCheckTTL
+	if value := os.Getenv(envIndexCheckTTL); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		ttl = parsed
+	}
+	es.autoCreateBlock = &autoCreateBlock{
+		allowed:  allowed,
+		ttl:      ttl,
+		existing: make(map[string]time.Time),
+	}
+	return nil
+}
+
+// allows checks whether the index may be auto-created.
+func (b *autoCreateBlock) allows(index string) bool {
+	for _, pattern := range b.allowed {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exists checks whether the index or alias exists, the existing ones are
+// remembered for the ttl so that the writes to them aren't checked each time.
+func (b *autoCreateBlock) exists(ctx context.Context, index string) (bool, error) {
+	b.mu.Lock()
+	seenAt, ok := b.existing[index]
+	b.mu.Unlock()
+	if ok && time.Since(seenAt) < b.ttl {
+		return true, nil
+	}
+	res, err := util.GetWriteClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method:       http.MethodHead,
+		Path:         "/" + index,
+		IgnoreErrors: []int{http.StatusNotFound},
+	})
+	if err != nil {
+		return false, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, nil
+	}
+	b.mu.Lock()
+	b.existing[index] = time.Now()
+	b.mu.Unlock()
+	return true, nil
+}
+
+// blockAutoCreate rejects the writes to the missing indices that aren't
+// allowed to be auto-created. The explicit creations of the indices, as
+// well as the writes that don't name their indices in the path, e.g. the
+// bulk requests to /_bulk, aren't checked.
+func (es *elasticsearch) blockAutoCreate(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if es.autoCreateBlock == nil {
+			h(w, req)
+			return
+		}
+		reqOp, err := op.FromContext(req.Context())
+		if err != nil || *reqOp != op.Write || (req.Method == http.MethodPut && routeTemplate(req) == "/{index}") {
+			h(w, req)
+			return
+		}
+		// the concrete indices, the patterns never auto-create an index
+		for _, index := range missingIndices(util.IndicesFromRequest(req), nil) {
+			if es.autoCreateBlock.allows(index) {
+				continue
+			}
+			exists, err := es.autoCreateBlock.exists(req.Context(), index)
+			if err != nil {
+				// let elasticsearch answer if the index can't be checked
+				log.Errorln(logTag, ": unable to check whether index", index, "exists:", err)
+				continue
+			}
+			if !exists {
+				msg := fmt.Sprintf(`index "%s" does not exist and isn't allowed to be created automatically, `+
+					`create it with PUT /%s or add it to %s`, index, index, envAutoCreateAllowlist)
+				util.WriteBackError(w, msg, http.StatusNotFound)
+				return
+			}
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,54 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// headerBulkErrors reports the number of items of a _bulk request that es
+// failed to execute.
+const headerBulkErrors = "X-Arc-Bulk-Errors"
+
+type bulkItem struct {
+	Error *struct {
+		Type string `json:"type"`
+	} `json:"error"`
+}
+
+// bulkErrors returns the number of failed items of the _bulk response, and a
+// summary of their error types, zero if es reports no errors.
+func bulkErrors(body []byte) (int, string) {
+	// the items are only decoded if es reports errors, whatever the
+	// formatting of the body, e.g. with ?pretty
+	var status struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil || !status.Errors {
+		return 0, ""
+	}
+	var res struct {
+		// each item is keyed on its action, e.g. index or delete
+		Items []map[string]bulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return 0, ""
+	}
+	var failed int
+	types := make(map[string]int)
+	for _, item := range res.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				failed++
+				types[result.Error.Type]++
+			}
+		}
+	}
+	var summary []string
+	for t, n := range types {
+		summary = append(summary, fmt.Sprintf("%s (%d)", t, n))
+	}
+	sort.Strings(summary)
+	return failed, fmt.Sprintf("%d of %d items failed: %s", failed, len(res.Items), strings.Join(summary, ", "))
+}
@@ -0,0 +1,364 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	es7 "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBulkQueueDir      = "/var/lib/arc/bulk"
+	defaultBulkQueueInterval = time.Second
+	defaultBulkQueueMaxDepth = 1000
+	// longest pause of the draining while elasticsearch pushes back
+	maxBulkQueueBackoff = time.Minute
+	// finished jobs are kept around for the clients to poll their status
+	bulkJobRetention = 24 * time.Hour
+)
+
+// errBulkQueueFull is returned for the bulks queued once the queue is full.
+var errBulkQueueFull = errors.New("the bulk queue is full")
+
+// Status of a queued bulk request.
+const (
+	bulkQueued = "queued"
+	bulkDone   = "done"
+	bulkFailed = "failed"
+)
+
+type bulkJob struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Params     url.Values      `json:"params,omitempty"`
+	Body       string          `json:"body,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	QueuedAt   time.Time       `json:"queued_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	// number of times elasticsearch pushed back on the bulk
+	Attempts int `json:"attempts,omitempty"`
+	// indices the bulk writes to, whose cached responses are invalidated
+	// once it is drained
+	Indices []string `json:"indices,omitempty"`
+	// principal that queued the bulk, the only one besides the admin users
+	// its status is reported to
+	Principal string `json:"principal,omitempty"`
+}
+
+// bulkQueue accepts the bulk requests made to the opted-in routes and drains
+// them to elasticsearch one at a time at a fixed interval. Each job is persisted
+// as a json file in dir so that the accepted requests survive restarts. The
+// draining pauses, for longer each time, while elasticsearch pushes back
+// with a 429 or a 503, the job being retried afterwards.
+type bulkQueue struct {
+	mu       sync.Mutex
+	dir      string
+	routes   map[string]bool
+	jobs     map[string]*bulkJob
+	pending  []string
+	interval time.Duration
+	// max number of pending jobs, the bulks queued beyond are rejected
+	maxDepth int
+	// current pause of the draining, zero unless elasticsearch pushed back
+	backoff  time.Duration
+	resumeAt time.Time
+	// called with each job successfully drained
+	drained func(job *bulkJob)
+}
+
+func newBulkQueue(dir string, routes []string) (*bulkQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	q := &bulkQueue{
+		dir:      dir,
+		routes:   make(map[string]bool),
+		jobs:     make(map[string]*bulkJob),
+		interval: defaultBulkQueueInterval,
+		maxDepth: defaultBulkQueueMaxDepth,
+	}
+	for _, route := range routes {
+		q.routes[route] = true
+	}
+	return q, q.load()
+}
+
+// load restores the persisted jobs, queued jobs are drained in the order they were accepted.
+func (q *bulkQueue) load() error {
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var job bulkJob
+		if err := json.Unmarshal(content, &job); err != nil {
+			log.Errorln(logTag, ": skipping corrupt bulk job", file, ":", err)
+			continue
+		}
+		q.jobs[job.ID] = &job
+		if job.Status == bulkQueued {
+			q.pending = append(q.pending, job.ID)
+		}
+	}
+	sort.Slice(q.pending, func(i, j int) bool {
+		return q.jobs[q.pending[i]].QueuedAt.Before(q.jobs[q.pending[j]].QueuedAt)
+	})
+	log.Println(logTag, ":", len(q.pending), "queued bulk requests restored")
+	return nil
+}
+
+// handles checks whether the request's route has opted in for queueing.
+func (q *bulkQueue) handles(r *http.Request) bool {
+	return q.routes[routeTemplate(r)]
+}
+
+// enqueue queues the bulk request, it returns errBulkQueueFull once maxDepth
+// jobs are pending.
+func (q *bulkQueue) enqueue(r *http.Request, options es7.PerformRequestOptions) (*bulkJob, error) {
+	body, _ := options.Body.(string)
+	indices, _ := index.FromContext(r.Context())
+	principal, _ := credential.PrincipalFromContext(r.Context())
+	job := &bulkJob{
+		ID:        uuid.New().String(),
+		Status:    bulkQueued,
+		Method:    options.Method,
+		Path:      options.Path,
+		Params:    options.Params,
+		Body:      body,
+		Indices:   indices,
+		Principal: principal,
+		QueuedAt:  time.Now(),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxDepth > 0 && len(q.pending) >= q.maxDepth {
+		return nil, errBulkQueueFull
+	}
+	if err := q.persist(job); err != nil {
+		return nil, err
+	}
+	q.jobs[job.ID] = job
+	q.pending = append(q.pending, job.ID)
+	return job, nil
+}
+
+// status returns a copy of the job, without the request body.
+func (q *bulkQueue) status(id string) (*bulkJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	status := *job
+	status.Body = ""
+	return &status, true
+}
+
+// retryAfter returns the delay, in seconds, after which a job can be queued
+// again once the queue is full, i.e. until the next job is drained.
+func (q *bulkQueue) retryAfter() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wait := q.interval
+	if paused := time.Until(q.resumeAt); paused > 0 {
+		wait += paused
+	}
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// pushesBack checks whether elasticsearch answered with a 429 or a 503, i.e.
+// is too busy to index the bulk right now.
+func pushesBack(response *es7.Response, err error) bool {
+	var code int
+	if response != nil {
+		code = response.StatusCode
+	} else if e, ok := err.(*es7.Error); ok {
+		code = e.Status
+	}
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// drain forwards the oldest queued job to elasticsearch. It returns false if
+// there was nothing to drain, or if the draining is paused because
+// elasticsearch pushed back.
+func (q *bulkQueue) drain(ctx context.Context) bool {
+	q.mu.Lock()
+	if len(q.pending) == 0 || time.Now().Before(q.resumeAt) {
+		q.mu.Unlock()
+		return false
+	}
+	job := q.jobs[q.pending[0]]
+	q.pending = q.pending[1:]
+	q.mu.Unlock()
+
+	response, err := util.GetWriteClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: job.Method,
+		Path:   job.Path,
+		Params: job.Params,
+		Body:   job.Body,
+	})
+
+	q.mu.Lock()
+	now := time.Now()
+	if pushesBack(response, err) {
+		// the job is retried first once the draining resumes, each push
+		// back doubling the pause
+		q.backoff *= 2
+		if q.backoff < q.interval {
+			q.backoff = q.interval
+		}
+		if q.backoff > maxBulkQueueBackoff {
+			q.backoff = maxBulkQueueBackoff
+		}
+		q.resumeAt = now.Add(q.backoff)
+		q.pending = append([]string{job.ID}, q.pending...)
+		job.Attempts++
+		log.Warnln(logTag, ": elasticsearch pushed back on bulk request", job.ID, ", draining paused for", q.backoff)
+		if err := q.persist(job); err != nil {
+			log.Errorln(logTag, ": error persisting bulk request", job.ID, ":", err)
+		}
+		q.mu.Unlock()
+		return true
+	}
+	q.backoff = 0
+	job.Status = bulkDone
+	job.FinishedAt = &now
+	job.Body = ""
+	if response != nil {
+		job.StatusCode = response.StatusCode
+		job.Response = response.Body
+	}
+	if err != nil {
+		log.Errorln(logTag, ": error draining bulk request", job.ID, ":", err)
+		job.Status = bulkFailed
+		job.Error = err.Error()
+	}
+	if err := q.persist(job); err != nil {
+		log.Errorln(logTag, ": error persisting bulk request", job.ID, ":", err)
+	}
+	drained := q.drained
+	q.mu.Unlock()
+	if err == nil && drained != nil {
+		drained(job)
+	}
+	return true
+}
+
+// cleanup forgets the finished jobs older than the retention period.
+func (q *bulkQueue) cleanup() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > bulkJobRetention {
+			delete(q.jobs, id)
+			os.Remove(q.file(id))
+		}
+	}
+}
+
+// run drains a queued job every interval.
+func (q *bulkQueue) run() {
+	ticker := time.NewTicker(q.interval)
+	for range ticker.C {
+		q.drain(context.Background())
+		q.cleanup()
+	}
+}
+
+func (q *bulkQueue) persist(job *bulkJob) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	// the jobs hold the documents of the bulks
+	return ioutil.WriteFile(q.file(job.ID), raw, 0600)
+}
+
+func (q *bulkQueue) file(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+func (q *bulkQueue) accept(w http.ResponseWriter, r *http.Request, options es7.PerformRequestOptions) {
+	job, err := q.enqueue(r, options)
+	if err == errBulkQueueFull {
+		w.Header().Set("Retry-After", q.retryAfter())
+		util.WriteBackError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Errorln(logTag, ": error queueing bulk request:", err)
+		util.WriteBackError(w, "error queueing bulk request", http.StatusInternalServerError)
+		return
+	}
+	raw, _ := json.Marshal(map[string]string{
+		"id":     job.ID,
+		"status": job.Status,
+	})
+	util.WriteBackRaw(w, raw, http.StatusAccepted)
+}
+
+func (es *elasticsearch) bulkStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if es.bulkQueue == nil {
+			util.WriteBackError(w, "bulk queueing is not enabled", http.StatusNotFound)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		job, ok := es.bulkQueue.status(id)
+		// the jobs of the other principals are reported as missing
+		if ok && !isAdminRequest(r) {
+			principal, err := credential.PrincipalFromContext(r.Context())
+			ok = err == nil && job.Principal != "" && principal == job.Principal
+		}
+		if !ok {
+			util.WriteBackError(w, "bulk request "+id+" not found", http.StatusNotFound)
+			return
+		}
+		raw, err := json.Marshal(job)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+// invalidateDrained invalidates the responses made stale by the drained
+// bulk, as invalidateWritten does for the bulks forwarded right away.
+func (es *elasticsearch) invalidateDrained(job *bulkJob) {
+	r, err := http.NewRequest(job.Method, job.Path, nil)
+	if err != nil {
+		log.Errorln(logTag, ": error invalidating the responses of bulk request", job.ID, ":", err)
+		return
+	}
+	es.invalidateWritten(r.WithContext(index.NewContext(r.Context(), job.Indices)), job.Params)
+}
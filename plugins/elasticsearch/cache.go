@@ -0,0 +1,732 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/response"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// headers telling whether a cacheable response has been served from the cache
+// and, for the cache hits, the number of seconds since it was cached
+const (
+	headerCache    = "X-Arc-Cache"
+	headerCacheAge = "X-Arc-Cache-Age"
+	cacheHit       = "HIT"
+	cacheMiss      = "MISS"
+	headerETag     = "ETag"
+	// header of the reads that skip the cached responses, e.g. to read the
+	// writes of the client
+	headerBypassCache = "X-Arc-Bypass-Cache"
+)
+
+var defaultCacheCategories = []string{category.Search.String()}
+
+// cacheConfig holds the settings of the response cache, the cache is
+// disabled unless a ttl is configured.
+type cacheConfig struct {
+	ttl        time.Duration
+	categories map[category.Category]bool
+	// ttl of the cached _count responses, zero unless they are cached
+	countTTL time.Duration
+	// whether the Cache-Control directives of the es responses decide
+	// whether and for how long they are cached
+	honorCacheControl bool
+	// max ttl of the cached searches without hits, nil if they are cached
+	// like the other responses, zero if they aren't cached at all
+	emptyTTL *time.Duration
+	// whether the clients can skip the cached responses with the
+	// X-Arc-Bypass-Cache header
+	bypassHeader bool
+	// request headers the responses of each category vary by, which are
+	// part of their cache keys
+	keyHeaders map[category.Category][]string
+	// open indices the reads of all the indices are resolved to
+	openIndices openIndices
+}
+
+func (es *elasticsearch) initCache() error {
+	value := os.Getenv(envResponseCacheTTL)
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	capacity := 0
+	if value := os.Getenv(envResponseCacheSize); value != "" {
+		capacity, err = strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+	}
+	names := envList(envResponseCacheCategories)
+	if len(names) == 0 {
+		names = defaultCacheCategories
+	}
+	categories := make(map[category.Category]bool)
+	for _, name := range names {
+		c, err := parseCategory(name)
+		if err != nil {
+			return err
+		}
+		categories[c] = true
+	}
+	cache := response.NewCache(capacity)
+	if value := os.Getenv(envResponseCacheLevel); value != "" {
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if err := cache.SetCompressionLevel(level); err != nil {
+			return err
+		}
+	}
+	if value := os.Getenv(envResponseCacheMaxBytes); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := cache.SetMaxBytes(maxBytes); err != nil {
+			return err
+		}
+	}
+	var countTTL time.Duration
+	if value := os.Getenv(envCountCacheTTL); value != "" {
+		countTTL, err = time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+	}
+	var emptyTTL *time.Duration
+	if value := os.Getenv(envEmptyResultsCacheTTL); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		emptyTTL = &parsed
+	}
+	keyHeaders, err := categoryHeaders(os.Getenv(envCacheKeyHeaders))
+	if err != nil {
+		return err
+	}
+	var statsInterval time.Duration
+	if value := os.Getenv(envCacheStatsInterval); value != "" {
+		statsInterval, err = time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if statsInterval <= 0 {
+			return fmt.Errorf("%s: expected a positive duration, got %q", envCacheStatsInterval, value)
+		}
+	}
+	response.SetResponseCache(cache)
+	es.cache = &cacheConfig{
+		ttl:               ttl,
+		categories:        categories,
+		countTTL:          countTTL,
+		honorCacheControl: os.Getenv(envCacheControl) == "true",
+		emptyTTL:          emptyTTL,
+		bypassHeader:      os.Getenv(envCacheBypassHeader) == "true",
+		keyHeaders:        keyHeaders,
+	}
+
+	if path := os.Getenv(envCacheWarmUpFile); path != "" {
+		queries, err := readWarmUpQueries(path)
+		if err != nil {
+			log.Errorln(logTag, ": unable to read cache warm-up queries from", path, ":", err)
+			return nil
+		}
+		go es.warmUp(context.Background(), queries)
+	}
+	if statsInterval > 0 {
+		go logCacheStats(statsInterval)
+	}
+	return nil
+}
+
+// logCacheStats periodically logs the stats of the response cache.
+func logCacheStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		stats := response.ResponseCache().Stats()
+		log.Println(logTag, ": response cache hits=", stats.Hits, ", misses=", stats.Misses,
+			", hit_ratio=", fmt.Sprintf("%.3f", stats.HitRatio), ", evictions=", stats.Evictions,
+			", entries=", stats.Entries, ", bytes=", stats.Bytes)
+	}
+}
+
+func (es *elasticsearch) cacheStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if es.cache == nil {
+			util.WriteBackError(w, fmt.Sprintf("the response cache is disabled, set %s to enable it", envResponseCacheTTL), http.StatusNotFound)
+			return
+		}
+		raw, err := json.Marshal(response.ResponseCache().Stats())
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling cache stats:", err)
+			util.WriteBackError(w, "error reporting cache stats", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+// cacheEntryHandler dumps the response cached against the request's key,
+// along with its metadata, to troubleshoot what is served from the cache.
+func (es *elasticsearch) cacheEntryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if es.cache == nil {
+			util.WriteBackError(w, fmt.Sprintf("the response cache is disabled, set %s to enable it", envResponseCacheTTL), http.StatusNotFound)
+			return
+		}
+		key := mux.Vars(r)["requestID"]
+		cached, ok := response.PeekResponse(key)
+		if !ok {
+			util.WriteBackError(w, fmt.Sprintf(`no response is cached for request "%s"`, key), http.StatusNotFound)
+			return
+		}
+		// the body is dumped as is if it is json, as a string otherwise
+		var body interface{} = string(cached.Body)
+		if json.Valid(cached.Body) {
+			body = json.RawMessage(cached.Body)
+		}
+		raw, err := json.Marshal(map[string]interface{}{
+			"key":        cached.Key,
+			"code":       cached.Code,
+			"header":     cached.Header,
+			"body":       body,
+			"indices":    cached.Indices,
+			"etag":       cached.ETag,
+			"saved_at":   cached.SavedAt,
+			"expires_at": cached.ExpiresAt,
+			"ttl":        cached.ExpiresAt.Sub(cached.SavedAt).String(),
+			"size":       cached.Size(),
+		})
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling cache entry:", err)
+			util.WriteBackError(w, "error reporting cache entry", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
+
+// cacheable checks whether the responses of the classified request can be
+// cached. The analysis of a text is deterministic, the _analyze responses are
+// cached whatever the cached categories.
+func (es *elasticsearch) cacheable(c category.Category, a acl.ACL, o op.Operation) bool {
+	return es.cache != nil && o == op.Read && (es.cache.categories[c] || a == acl.Analyze)
+}
+
+// cacheControlTTL returns the ttl the response is cached with as per its
+// Cache-Control header, the given ttl if it has none, and false if it must
+// not be cached, i.e. with no-store, no-cache or a max-age of zero. The
+// s-maxage meant for the shared caches takes precedence over the max-age.
+func cacheControlTTL(header http.Header, ttl time.Duration) (time.Duration, bool) {
+	var maxAge, sharedMaxAge string
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			switch name {
+			case "no-store", "no-cache":
+				return 0, false
+			case "max-age":
+				maxAge = arg
+			case "s-maxage":
+				sharedMaxAge = arg
+			}
+		}
+	}
+	if sharedMaxAge != "" {
+		maxAge = sharedMaxAge
+	}
+	if maxAge == "" {
+		return ttl, true
+	}
+	// an invalid max-age means the response is stale
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// emptyResultsTTL returns the ttl the search response is cached with if it has no
+// hits, as they may only mean that the index isn't populated yet, and
+// false if it must not be cached.
+func (c *cacheConfig) emptyResultsTTL(body []byte, ttl time.Duration) (time.Duration, bool) {
+	if c.emptyTTL == nil || !emptyHits(body) {
+		return ttl, true
+	}
+	if *c.emptyTTL <= 0 {
+		return 0, false
+	}
+	if *c.emptyTTL < ttl {
+		return *c.emptyTTL, true
+	}
+	return ttl, true
+}
+
+// emptyHits checks whether the body is a search response without hits, as
+// per its hits.total, a number before es 7, or the number of returned hits
+// if the total isn't tracked.
+func emptyHits(body []byte) bool {
+	var res struct {
+		Hits *struct {
+			Total json.RawMessage   `json:"total"`
+			Hits  []json.RawMessage `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.Hits == nil {
+		return false
+	}
+	total := res.Hits.Total
+	if len(total) == 0 || string(total) == "null" {
+		return len(res.Hits.Hits) == 0
+	}
+	var value int64
+	if err := json.Unmarshal(total, &value); err == nil {
+		return value == 0
+	}
+	var tracked struct {
+		Value int64 `json:"value"`
+	}
+	if err := json.Unmarshal(total, &tracked); err != nil {
+		return false
+	}
+	return tracked.Value == 0
+}
+
+// skipsCache checks whether the read skips the cached responses, to read the
+// writes of the client, its fresh response is cached in their place.
+func (es *elasticsearch) skipsCache(r *http.Request) bool {
+	return es.cache != nil && es.cache.bypassHeader && r.Header.Get(headerBypassCache) == "true"
+}
+
+// cachesCount checks whether the request is a _count whose response can be
+// cached, the counts are cached with their own, usually shorter, ttl.
+func (es *elasticsearch) cachesCount(a acl.ACL, o op.Operation) bool {
+	return es.cache != nil && es.cache.countTTL > 0 && a == acl.Count && o == op.Read
+}
+
+// the index every cached count is also stored against, so that the writes
+// without indices, e.g. a _bulk, invalidate all of them
+const countMarker = "_count"
+
+// countIndices suffixes the indices so that the cached counts of an index
+// only match the writes to it, which all invalidate its counts, and not the
+// refreshed writes that invalidate the other cached reads.
+func countIndices(indices []string) []string {
+	if len(indices) == 0 {
+		return []string{countMarker}
+	}
+	counted := make([]string, 0, len(indices))
+	for _, index := range indices {
+		counted = append(counted, index+"/"+countMarker)
+	}
+	return counted
+}
+
+// bypassesCache checks whether the principal of the request, the user or the
+// permission it authenticated with, has opted out of the response cache.
+func bypassesCache(ctx context.Context) bool {
+	reqCredential, err := credential.FromContext(ctx)
+	if err != nil {
+		return false
+	}
+	switch reqCredential {
+	case credential.User:
+		reqUser, err := user.FromContext(ctx)
+		return err == nil && reqUser.BypassCache != nil && *reqUser.BypassCache
+	case credential.Permission:
+		reqPermission, err := permission.FromContext(ctx)
+		return err == nil && reqPermission.BypassCache != nil && *reqPermission.BypassCache
+	}
+	return false
+}
+
+// CacheKeyFunc computes the key against which the response of a request is
+// cached. It can be replaced, e.g. to namespace the entries by tenant so that
+// the tenants never get each other's cached responses.
+var CacheKeyFunc = DefaultCacheKey
+
+// responseKey returns the cache key of the request, i.e. the one computed by
+// CacheKeyFunc combined with the values of the headers its category varies by.
+// The requests without any of these headers keep the key of CacheKeyFunc, so
+// that they share the responses cached by the warm-up.
+func (es *elasticsearch) responseKey(r *http.Request, c category.Category, body []byte) string {
+	key := CacheKeyFunc(r, body)
+	if es.cache == nil {
+		return key
+	}
+	var vary strings.Builder
+	for _, name := range es.cache.keyHeaders[c] {
+		if values, ok := r.Header[name]; ok {
+			vary.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+		}
+	}
+	if vary.Len() == 0 {
+		return key
+	}
+	h := sha256.New()
+	h.Write([]byte(key + "\n" + vary.String()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// maxAge returns the maximum age of the cached responses the request accepts,
+// as per its max_age query param, and whether the param is set. The age is
+// given in seconds, like the Cache-Control max-age, or as a duration.
+func maxAge(r *http.Request) (time.Duration, bool, error) {
+	value := r.URL.Query().Get(gatewayMaxAgeParam)
+	if value == "" {
+		return 0, false, nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false, errors.New("expected a number of seconds or a duration")
+		}
+		age = time.Duration(seconds) * time.Second
+	}
+	if age < 0 {
+		return 0, false, errors.New("expected a positive age")
+	}
+	return age, true, nil
+}
+
+// fresh checks whether the cached response is recent enough for the request.
+func fresh(r *http.Request, cached *response.CachedResponse) bool {
+	age, ok, err := maxAge(r)
+	if err != nil || !ok {
+		return true
+	}
+	return time.Since(cached.SavedAt) <= age
+}
+
+// bodyETag returns the strong entity tag of the cached response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified responds with 304 Not Modified, and no body, if the request
+// carries the entity tag of the cached response in its If-None-Match header.
+// The tag is set on the response either way.
+func notModified(w http.ResponseWriter, r *http.Request, cached *response.CachedResponse) bool {
+	if cached.ETag == "" {
+		return false
+	}
+	w.Header().Set(headerETag, cached.ETag)
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == cached.ETag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultCacheKey hashes the request method, path, query params and body,
+// ignoring the query params consumed by arc itself.
+func DefaultCacheKey(r *http.Request, body []byte) string {
+	params := r.URL.Query()
+	for _, param := range gatewayParams {
+		params.Del(param)
+	}
+	// the sql results format is negotiated with the Accept header
+	if isSQLPath(r.URL.Path) && params.Get("format") == "" {
+		if format := sqlFormat(r.Header.Get("Accept")); format != "" {
+			params.Set("format", format)
+		}
+	}
+	return cacheKey(r.Method, r.URL.Path, params, body)
+}
+
+// the index the cached reads of all the indices are also stored against,
+// so that the writes to the indices that weren't listed when they were
+// cached, e.g. created since, invalidate them
+const unlistedMarker = "_unlisted"
+
+// openIndices lists the indices of the cluster, the open ones being those
+// the reads of all the indices read from, refreshed every ttl.
+type openIndices struct {
+	mu sync.Mutex
+	// whether each index is open
+	names     map[string]bool
+	fetchedAt time.Time
+}
+
+// list returns whether each of the cluster's indices is open, listed again if
+// they are older than the ttl. The lock isn't held while elasticsearch lists
+// them.
+func (o *openIndices) list(ctx context.Context, ttl time.Duration) (map[string]bool, error) {
+	o.mu.Lock()
+	names, fetchedAt := o.names, o.fetchedAt
+	o.mu.Unlock()
+	if names != nil && time.Since(fetchedAt) < ttl {
+		return names, nil
+	}
+	res, err := util.GetReadClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodGet,
+		Path:   "/_cat/indices",
+		Params: map[string][]string{"format": {"json"}, "h": {"index,status"}, "expand_wildcards": {"all"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Index  string `json:"index"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(res.Body, &rows); err != nil {
+		return nil, err
+	}
+	names = make(map[string]bool, len(rows))
+	for _, row := range rows {
+		names[row.Index] = row.Status != "close"
+	}
+	o.mu.Lock()
+	o.names = names
+	o.fetchedAt = time.Now()
+	o.mu.Unlock()
+	return names, nil
+}
+
+// listed returns whether each of the indices listed last is open, nil if
+// they haven't been listed.
+func (o *openIndices) listed() map[string]bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.names
+}
+
+// readsAll checks whether the request's indices are all the indices.
+func readsAll(indices []string) bool {
+	return len(indices) == 0 || (len(indices) == 1 && indices[0] == "_all")
+}
+
+// cachedIndices returns the indices the request reads from. The reads of all
+// the indices are resolved to the open indices, along with the
+// unlistedMarker, so that only the writes to those invalidate them. They are
+// kept as "_all", invalidated by any write, if the indices can't be listed.
+func (es *elasticsearch) cachedIndices(ctx context.Context) []string {
+	indices, _ := index.FromContext(ctx)
+	if !readsAll(indices) {
+		return indices
+	}
+	names, err := es.cache.openIndices.list(ctx, es.cache.ttl)
+	if err != nil {
+		log.Warnln(logTag, ": unable to list the indices read by the cached reads of all the indices:", err)
+		return []string{"_all"}
+	}
+	resolved := make([]string, 0, len(names)+1)
+	for name, open := range names {
+		if open {
+			resolved = append(resolved, name)
+		}
+	}
+	sort.Strings(resolved)
+	return append(resolved, unlistedMarker)
+}
+
+// writtenIndices returns the indices the write invalidates the cached reads
+// of, along with the unlistedMarker if any of them wasn't listed when the
+// reads of all the indices were resolved. The writes without indices, or to
+// patterns, return none and invalidate all the cached reads.
+func (es *elasticsearch) writtenIndices(ctx context.Context) []string {
+	indices, _ := index.FromContext(ctx)
+	if readsAll(indices) {
+		return nil
+	}
+	names := es.cache.openIndices.listed()
+	written := append([]string(nil), indices...)
+	for _, name := range indices {
+		if strings.ContainsAny(name, "*?[") || strings.HasPrefix(name, "-") {
+			return nil
+		}
+		if _, ok := names[name]; !ok {
+			return append(written, unlistedMarker)
+		}
+	}
+	return written
+}
+
+// refreshes checks whether the write makes its changes visible right away,
+// i.e. it has a refresh param other than false.
+func refreshes(params url.Values) bool {
+	values, ok := params["refresh"]
+	return ok && (len(values) == 0 || values[0] != "false")
+}
+
+// cacheKey returns the key against which the response of the request is
+// cached. Requests that only differ in the order of their query params or
+// in the formatting of their json body share the same key.
+func cacheKey(method, path string, params url.Values, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + "\n" + path + "\n"))
+	h.Write([]byte(normalizeParams(params) + "\n"))
+	h.Write(canonicalBody(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeParams encodes the query params sorted by key, with the
+// surrounding whitespace of the values trimmed.
+func normalizeParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf strings.Builder
+	for _, key := range keys {
+		for _, value := range params[key] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(url.QueryEscape(key))
+			buf.WriteByte('=')
+			buf.WriteString(url.QueryEscape(strings.TrimSpace(value)))
+		}
+	}
+	return buf.String()
+}
+
+// canonicalBody re-encodes the json body, or each line of an ndjson body,
+// with sorted object keys and no insignificant whitespace. Lines that
+// aren't valid json are kept as is.
+func canonicalBody(body []byte) []byte {
+	if canonical, ok := canonicalJSON(body); ok {
+		return canonical
+	}
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if canonical, ok := canonicalJSON(line); ok {
+			line = canonical
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func canonicalJSON(raw []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	return canonical, true
+}
+
+// warmUpQuery is a request whose response is cached on startup.
+type warmUpQuery struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Params url.Values      `json:"params,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+func readWarmUpQueries(path string) ([]warmUpQuery, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var queries []warmUpQuery
+	if err := json.Unmarshal(content, &queries); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// warmUp pre-populates the response cache with the responses of the queries,
+// failed queries are logged and skipped.
+func (es *elasticsearch) warmUp(ctx context.Context, queries []warmUpQuery) {
+	for _, query := range queries {
+		method := query.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		options := es7.PerformRequestOptions{
+			Method: method,
+			Path:   query.Path,
+			Params: query.Params,
+		}
+		if len(query.Body) > 0 {
+			options.Body = string(query.Body)
+		}
+		res, err := util.GetReadClient7().PerformRequest(ctx, options)
+		if err != nil {
+			log.Errorln(logTag, ": error warming up the cache with", method, query.Path, ":", err)
+			continue
+		}
+		req := &http.Request{
+			Method: method,
+			URL:    &url.URL{Path: query.Path, RawQuery: query.Params.Encode()},
+			Header: make(http.Header),
+		}
+		key := CacheKeyFunc(req, query.Body)
+		indices := []string{"_all"}
+		if names, err := es.cache.openIndices.list(ctx, es.cache.ttl); err == nil {
+			indices = []string{unlistedMarker}
+			for name, open := range names {
+				if open {
+					indices = append(indices, name)
+				}
+			}
+		}
+		if target := strings.Split(strings.TrimPrefix(query.Path, "/"), "/")[0]; target != "" && !strings.HasPrefix(target, "_") {
+			indices = strings.Split(target, ",")
+		}
+		response.SaveResponse(key, &response.CachedResponse{
+			Code:    res.StatusCode,
+			Header:  res.Header,
+			Body:    res.Body,
+			Indices: indices,
+			ETag:    bodyETag(res.Body),
+		}, es.cache.ttl)
+	}
+	log.Println(logTag, ": response cache warmed up with", len(queries), "queries")
+}
@@ -0,0 +1,903 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/response"
+	"github.com/appbaseio/arc/model/user"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// withCache returns a plugin instance that caches the search responses for a
+// minute, the cluster has no indices to resolve the reads of all the indices
+// to.
+func withCache() *elasticsearch {
+	response.SetResponseCache(response.NewCache(10))
+	return &elasticsearch{cache: &cacheConfig{
+		ttl:         time.Minute,
+		categories:  map[category.Category]bool{category.Search: true},
+		openIndices: openIndices{names: map[string]bool{}, fetchedAt: time.Now()},
+	}}
+}
+
+func TestCache(t *testing.T) {
+	Convey("Cache", t, func() {
+		Convey("Keys ignore param order and body formatting", func() {
+			a := httptest.NewRequest(http.MethodGet, "/foo/_search?size=10&from=0", nil)
+			b := httptest.NewRequest(http.MethodGet, "/foo/_search?from=0&size=%2010", nil)
+			So(cacheKey(a.Method, a.URL.Path, a.URL.Query(), []byte(`{"query":{"match_all":{}},"size":1}`)),
+				ShouldEqual,
+				cacheKey(b.Method, b.URL.Path, b.URL.Query(), []byte("{ \"size\": 1,\n \"query\": { \"match_all\": {} } }")))
+
+			So(cacheKey(a.Method, a.URL.Path, a.URL.Query(), []byte(`{"size":1}`)),
+				ShouldNotEqual,
+				cacheKey(a.Method, a.URL.Path, a.URL.Query(), []byte(`{"size":2}`)))
+			So(cacheKey(a.Method, "/bar/_search", a.URL.Query(), nil),
+				ShouldNotEqual,
+				cacheKey(a.Method, a.URL.Path, a.URL.Query(), nil))
+		})
+		Convey("Multi-line bodies are normalized per line", func() {
+			So(string(canonicalBody([]byte("{\"index\": \"foo\"}\n{ \"size\" : 1, \"from\": 0 }\n"))),
+				ShouldEqual, "{\"index\":\"foo\"}\n{\"from\":0,\"size\":1}\n")
+		})
+		Convey("Reordered params hit the same entry", func() {
+			var hits int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.Write([]byte(`{"took":1,"hits":{"total":0}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			search := func(url, body string, c category.Category) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, c, acl.Search, op.Read))
+				return resp
+			}
+			first := search("/foo/_search?size=10&from=0", `{"query":{"match_all":{}}}`, category.Search)
+			second := search("/foo/_search?from=0&size=10", `{ "query": { "match_all": {} } }`, category.Search)
+			So(hits, ShouldEqual, 1)
+			So(second.Code, ShouldEqual, first.Code)
+			So(second.Body.String(), ShouldEqual, first.Body.String())
+			So(second.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+
+			search("/foo/_search?from=0&size=20", `{"query":{"match_all":{}}}`, category.Search)
+			So(hits, ShouldEqual, 2)
+
+			// categories that haven't opted in are never cached
+			search("/foo/_count", "", category.Docs)
+			search("/foo/_count", "", category.Docs)
+			So(hits, ShouldEqual, 4)
+		})
+		Convey("Error responses aren't cached", func() {
+			var hits int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"bad"}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			}
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Warmed up queries are cache hits", func() {
+			var hits int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				if r.URL.Path == "/missing/_search" {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error":"index_not_found_exception"}`))
+					return
+				}
+				w.Write([]byte(`{"took":1,"hits":{"total":42}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			file, err := ioutil.TempFile("", "warmup")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			file.WriteString(`[
+				{"method": "POST", "path": "/foo/_search", "params": {"size": ["10"]}, "body": {"query": {"match_all": {}}}},
+				{"method": "POST", "path": "/missing/_search"}
+			]`)
+			file.Close()
+			queries, err := readWarmUpQueries(file.Name())
+			So(err, ShouldBeNil)
+			es.warmUp(context.Background(), queries)
+			So(hits, ShouldEqual, 2)
+			So(response.ResponseCache().Len(), ShouldEqual, 1)
+
+			req := httptest.NewRequest(http.MethodPost, "/foo/_search?size=10", strings.NewReader(`{ "query": { "match_all": {} } }`))
+			resp := httptest.NewRecorder()
+			es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+			So(hits, ShouldEqual, 2)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, `{"took":1,"hits":{"total":42}}`)
+		})
+		Convey("Custom key funcs can namespace the entries", func() {
+			var hits int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				w.Write([]byte(`{"tenant":"` + r.Header.Get("X-Tenant") + `"}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+			CacheKeyFunc = func(r *http.Request, body []byte) string {
+				return r.Header.Get("X-Tenant") + ":" + DefaultCacheKey(r, body)
+			}
+			defer func() { CacheKeyFunc = DefaultCacheKey }()
+
+			search := func(tenant string) string {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				req.Header.Set("X-Tenant", tenant)
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp.Body.String()
+			}
+			So(search("acme"), ShouldEqual, `{"tenant":"acme"}`)
+			So(search("globex"), ShouldEqual, `{"tenant":"globex"}`)
+			So(search("acme"), ShouldEqual, `{"tenant":"acme"}`)
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Responses tell whether they were cached and how long ago", func() {
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"took":1}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			search := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp
+			}
+			miss := search()
+			So(miss.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(miss.Header().Get(headerCacheAge), ShouldBeEmpty)
+
+			// age the cached entry
+			key := DefaultCacheKey(httptest.NewRequest(http.MethodGet, "/foo/_search", nil), nil)
+			cached, ok := response.GetResponse(key)
+			So(ok, ShouldBeTrue)
+			cached.SavedAt = cached.SavedAt.Add(-5 * time.Second)
+
+			hit := search()
+			So(hit.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hit.Header().Get(headerCacheAge), ShouldEqual, "5")
+			So(hit.Body.String(), ShouldEqual, miss.Body.String())
+
+			// requests that aren't cacheable carry no cache headers
+			req := httptest.NewRequest(http.MethodGet, "/foo/_count", nil)
+			resp := httptest.NewRecorder()
+			es.handler()(resp, classified(req, category.Docs, acl.Count, op.Read))
+			So(resp.Header().Get(headerCache), ShouldBeEmpty)
+		})
+		Convey("Cached responses are served conditionally", func() {
+			var hits int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				w.Write([]byte(`{"took":1}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			search := func(etag string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				if etag != "" {
+					req.Header.Set("If-None-Match", etag)
+				}
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp
+			}
+			first := search("")
+			So(first.Code, ShouldEqual, http.StatusOK)
+			etag := first.Header().Get(headerETag)
+			So(etag, ShouldEqual, bodyETag([]byte(`{"took":1}`)))
+
+			notModified := search(etag)
+			So(notModified.Code, ShouldEqual, http.StatusNotModified)
+			So(notModified.Body.Len(), ShouldEqual, 0)
+			So(notModified.Header().Get(headerETag), ShouldEqual, etag)
+			So(notModified.Header().Get(headerCache), ShouldEqual, cacheHit)
+
+			So(search(`"stale", W/`+etag).Code, ShouldEqual, http.StatusNotModified)
+
+			changed := search(`"stale"`)
+			So(changed.Code, ShouldEqual, http.StatusOK)
+			So(changed.Body.String(), ShouldEqual, `{"took":1}`)
+			So(changed.Header().Get(headerETag), ShouldEqual, etag)
+			So(hits, ShouldEqual, 1)
+		})
+		Convey("Entries older than the requested max_age are refetched", func() {
+			var hits int
+			var forwarded []string
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				forwarded = append(forwarded, r.URL.RawQuery)
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.Write([]byte(`{"took":1,"hits":{"total":` + strconv.Itoa(hits) + `}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			search := func(path string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp
+			}
+			So(search("/foo/_search").Header().Get(headerCache), ShouldEqual, cacheMiss)
+
+			key := DefaultCacheKey(httptest.NewRequest(http.MethodGet, "/foo/_search", nil), nil)
+			cached, ok := response.GetResponse(key)
+			So(ok, ShouldBeTrue)
+			cached.SavedAt = cached.SavedAt.Add(-5 * time.Second)
+
+			// the entry is recent enough for a larger max_age
+			So(search("/foo/_search?max_age=10").Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hits, ShouldEqual, 1)
+
+			resp := search("/foo/_search?max_age=2")
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(resp.Body.String(), ShouldContainSubstring, `"total":2`)
+			So(hits, ShouldEqual, 2)
+			So(forwarded[1], ShouldNotContainSubstring, gatewayMaxAgeParam)
+
+			// the refetched response replaces the stale entry
+			So(search("/foo/_search?max_age=2s").Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(search("/foo/_search").Body.String(), ShouldContainSubstring, `"total":2`)
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Invalid max_age values are rejected", func() {
+			for _, value := range []string{"soon", "-5", "-1s"} {
+				_, _, err := maxAge(httptest.NewRequest(http.MethodGet, "/foo/_search?max_age="+value, nil))
+				So(err, ShouldNotBeNil)
+			}
+			age, ok, err := maxAge(httptest.NewRequest(http.MethodGet, "/foo/_search?max_age=0", nil))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(age, ShouldEqual, 0)
+		})
+		Convey("Counts are cached until their indices are written", func() {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				for _, path := range []string{"/_count", "/{index}/_count"} {
+					spec := specFor(method, path)
+					So(spec.acl, ShouldEqual, acl.Count)
+					So(spec.op, ShouldEqual, op.Read)
+				}
+			}
+
+			var counts int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/_count") {
+					counts++
+					w.Write([]byte(`{"count":` + strconv.Itoa(counts) + `}`))
+					return
+				}
+				w.Write([]byte(`{"took":1,"result":"created"}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+			es.cache.countTTL = time.Second
+
+			count := func(index string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/"+index+"/_count", nil)
+				return route(http.MethodGet, "/{index}/_count", func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Docs, acl.Count, op.Read))
+				}, req)
+			}
+			write := func(template, url string, a acl.ACL) {
+				req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"title":"arc"}`+"\n"))
+				resp := route(http.MethodPost, template, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Docs, a, op.Write))
+				}, req)
+				So(resp.Code, ShouldEqual, http.StatusOK)
+			}
+
+			So(count("foo").Header().Get(headerCache), ShouldEqual, cacheMiss)
+			hit := count("foo")
+			So(hit.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hit.Body.String(), ShouldEqual, `{"count":1}`)
+			So(count("bar").Body.String(), ShouldEqual, `{"count":2}`)
+
+			// the writes to other indices leave the count cached
+			write("/{index}/_doc", "/baz/_doc", acl.Index)
+			So(count("foo").Header().Get(headerCache), ShouldEqual, cacheHit)
+
+			// an unrefreshed write recomputes the counts of its index
+			write("/{index}/_doc", "/foo/_doc", acl.Index)
+			recomputed := count("foo")
+			So(recomputed.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(recomputed.Body.String(), ShouldEqual, `{"count":3}`)
+			So(count("bar").Header().Get(headerCache), ShouldEqual, cacheHit)
+
+			// the writes without indices recompute all of them
+			write("/_bulk", "/_bulk", acl.Bulk)
+			So(count("foo").Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(count("bar").Header().Get(headerCache), ShouldEqual, cacheMiss)
+
+			// the counts expire after their own ttl
+			key := DefaultCacheKey(httptest.NewRequest(http.MethodGet, "/foo/_count", nil), nil)
+			cached, ok := response.GetResponse(key)
+			So(ok, ShouldBeTrue)
+			So(cached.ExpiresAt.Sub(cached.SavedAt), ShouldEqual, time.Second)
+		})
+		Convey("The upstream Cache-Control directives can be honored", func() {
+			cacheControl := map[string]string{
+				"/nostore/_search": "no-store",
+				"/nocache/_search": "private, no-cache",
+				"/maxage/_search":  "max-age=60",
+				"/shared/_search":  "max-age=60, s-maxage=5",
+				"/stale/_search":   "max-age=0",
+			}
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				if value := cacheControl[r.URL.Path]; value != "" {
+					w.Header().Set("Cache-Control", value)
+				}
+				w.Write([]byte(`{"took":1}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+			es.cache.honorCacheControl = true
+			es.cache.ttl = 30 * time.Second
+
+			search := func(path string) (*response.CachedResponse, bool) {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				es.handler()(httptest.NewRecorder(), classified(req, category.Search, acl.Search, op.Read))
+				return response.GetResponse(DefaultCacheKey(httptest.NewRequest(http.MethodGet, path, nil), nil))
+			}
+			ttl := func(cached *response.CachedResponse) time.Duration {
+				return cached.ExpiresAt.Sub(cached.SavedAt)
+			}
+
+			_, ok := search("/nostore/_search")
+			So(ok, ShouldBeFalse)
+			_, ok = search("/nocache/_search")
+			So(ok, ShouldBeFalse)
+			_, ok = search("/stale/_search")
+			So(ok, ShouldBeFalse)
+
+			cached, ok := search("/maxage/_search")
+			So(ok, ShouldBeTrue)
+			So(ttl(cached), ShouldEqual, 60*time.Second)
+			cached, ok = search("/shared/_search")
+			So(ok, ShouldBeTrue)
+			So(ttl(cached), ShouldEqual, 5*time.Second)
+			// the responses without directives get the configured ttl
+			cached, ok = search("/plain/_search")
+			So(ok, ShouldBeTrue)
+			So(ttl(cached), ShouldEqual, 30*time.Second)
+
+			// the directives are ignored unless they are honored
+			es.cache.honorCacheControl = false
+			_, ok = search("/nostore/_search")
+			So(ok, ShouldBeTrue)
+		})
+		Convey("Empty search results are cached for a shorter ttl", func() {
+			hits := `{"took":1,"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+			var mu sync.Mutex
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				w.Write([]byte(hits))
+			})
+			defer upstream.Close()
+			es := withCache()
+			emptyTTL := 50 * time.Millisecond
+			es.cache.emptyTTL = &emptyTTL
+
+			search := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp
+			}
+			So(search().Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(search().Header().Get(headerCache), ShouldEqual, cacheHit)
+
+			// the index gets populated
+			mu.Lock()
+			hits = `{"took":1,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"1"}]}}`
+			mu.Unlock()
+			So(search().Body.String(), ShouldContainSubstring, `"value":0`)
+
+			time.Sleep(emptyTTL)
+			resp := search()
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(resp.Body.String(), ShouldContainSubstring, `"_id":"1"`)
+			// the results are cached with the full ttl
+			time.Sleep(emptyTTL)
+			So(search().Header().Get(headerCache), ShouldEqual, cacheHit)
+
+			Convey("or not cached at all", func() {
+				response.SetResponseCache(response.NewCache(10))
+				mu.Lock()
+				hits = `{"took":1,"hits":{"total":0,"hits":[]}}`
+				mu.Unlock()
+				emptyTTL = 0
+				So(search().Header().Get(headerCache), ShouldEqual, cacheMiss)
+				So(search().Header().Get(headerCache), ShouldEqual, cacheMiss)
+			})
+		})
+		Convey("Empty search results are detected from their total", func() {
+			So(emptyHits([]byte(`{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`)), ShouldBeTrue)
+			So(emptyHits([]byte(`{"hits":{"total":0,"hits":[]}}`)), ShouldBeTrue)
+			So(emptyHits([]byte(`{"hits":{"hits":[]}}`)), ShouldBeTrue)
+			So(emptyHits([]byte(`{"hits":{"total":{"value":3,"relation":"eq"},"hits":[]}}`)), ShouldBeFalse)
+			So(emptyHits([]byte(`{"hits":{"hits":[{"_id":"1"}]}}`)), ShouldBeFalse)
+			So(emptyHits([]byte(`{"count":0}`)), ShouldBeFalse)
+		})
+		Convey("Analyze responses are cached whatever the cached categories", func() {
+			var analyzed int32
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&analyzed, 1)
+				body, _ := ioutil.ReadAll(r.Body)
+				w.Write([]byte(`{"tokens":[{"token":"` + strings.ToLower(string(body[9:12])) + `"}]}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			analyze := func(text string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/foo/_analyze", strings.NewReader(`{"text":"`+text+`"}`))
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Indices, acl.Analyze, op.Read))
+				return resp
+			}
+			resp := analyze("Arc")
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(resp.Body.String(), ShouldEqual, `{"tokens":[{"token":"arc"}]}`)
+			resp = analyze("Arc")
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.String(), ShouldEqual, `{"tokens":[{"token":"arc"}]}`)
+			So(atomic.LoadInt32(&analyzed), ShouldEqual, 1)
+
+			// the analyzed text is part of the key
+			So(analyze("ES!").Body.String(), ShouldEqual, `{"tokens":[{"token":"es!"}]}`)
+			So(atomic.LoadInt32(&analyzed), ShouldEqual, 2)
+		})
+		Convey("Responses vary by the configured headers of their category", func() {
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"language":"` + r.Header.Get("Accept-Language") + `"}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+			var err error
+			es.cache.keyHeaders, err = categoryHeaders("search:accept-language, docs:X-Tenant")
+			So(err, ShouldBeNil)
+			So(es.cache.keyHeaders[category.Search], ShouldResemble, []string{"Accept-Language"})
+
+			search := func(language string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				if language != "" {
+					req.Header.Set("Accept-Language", language)
+				}
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp
+			}
+			So(search("en").Body.String(), ShouldEqual, `{"language":"en"}`)
+			resp := search("de")
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(resp.Body.String(), ShouldEqual, `{"language":"de"}`)
+			resp = search("en")
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.String(), ShouldEqual, `{"language":"en"}`)
+			So(search("").Body.String(), ShouldEqual, `{"language":""}`)
+
+			// the other headers are left out of the key
+			es.cache.keyHeaders = nil
+			resp = search("fr")
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.String(), ShouldEqual, `{"language":""}`)
+
+			_, err = categoryHeaders("search")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Cache stats", func() {
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"took":1}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+			for _, path := range []string{"/foo/_search", "/foo/_search", "/bar/_search", "/foo/_search"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				es.handler()(httptest.NewRecorder(), classified(req, category.Search, acl.Search, op.Read))
+			}
+
+			resp := httptest.NewRecorder()
+			es.cacheStatsHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/cache/stats", nil))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			var stats response.CacheStats
+			So(json.Unmarshal(resp.Body.Bytes(), &stats), ShouldBeNil)
+			So(stats.Hits, ShouldEqual, 2)
+			So(stats.Misses, ShouldEqual, 2)
+			So(stats.HitRatio, ShouldEqual, 0.5)
+			So(stats.Entries, ShouldEqual, 2)
+
+			resp = httptest.NewRecorder()
+			(&elasticsearch{}).cacheStatsHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/cache/stats", nil))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Cache entries can be dumped", func() {
+			es := withCache()
+			response.SaveResponse("abc123", &response.CachedResponse{
+				Code:    http.StatusOK,
+				Header:  http.Header{"Content-Type": []string{"application/json"}},
+				Body:    []byte(`{"took":1,"hits":{"hits":[]}}`),
+				Indices: []string{"foo"},
+				ETag:    `"etag"`,
+			}, time.Minute)
+			dump := func(key string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/_arc/cache/"+key, nil)
+				return route(http.MethodGet, "/_arc/cache/{requestID}", es.cacheEntryHandler(), req)
+			}
+
+			resp := dump("abc123")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			var entry struct {
+				Key     string                 `json:"key"`
+				Code    int                    `json:"code"`
+				Body    map[string]interface{} `json:"body"`
+				Indices []string               `json:"indices"`
+				ETag    string                 `json:"etag"`
+				SavedAt time.Time              `json:"saved_at"`
+				TTL     string                 `json:"ttl"`
+				Size    int64                  `json:"size"`
+			}
+			So(json.Unmarshal(resp.Body.Bytes(), &entry), ShouldBeNil)
+			So(entry.Key, ShouldEqual, "abc123")
+			So(entry.Code, ShouldEqual, http.StatusOK)
+			So(entry.Body["took"], ShouldEqual, 1)
+			So(entry.Indices, ShouldResemble, []string{"foo"})
+			So(entry.ETag, ShouldEqual, `"etag"`)
+			So(entry.SavedAt, ShouldHappenWithin, time.Second, time.Now())
+			So(entry.TTL, ShouldEqual, "1m0s")
+			So(entry.Size, ShouldBeGreaterThan, 0)
+
+			So(dump("missing").Code, ShouldEqual, http.StatusNotFound)
+			// the dumps aren't counted as cache lookups
+			stats := response.ResponseCache().Stats()
+			So(stats.Hits, ShouldEqual, 0)
+			So(stats.Misses, ShouldEqual, 0)
+		})
+		Convey("Clients can skip the cache to read their writes", func() {
+			var mu sync.Mutex
+			docs := 0
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Method == http.MethodPut {
+					docs++
+					w.Write([]byte(`{"result":"created"}`))
+					return
+				}
+				w.Write([]byte(`{"took":1,"hits":{"total":{"value":` + strconv.Itoa(docs) + `}}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+			es.cache.bypassHeader = true
+
+			search := func(bypass bool) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				if bypass {
+					req.Header.Set(headerBypassCache, "true")
+				}
+				resp := httptest.NewRecorder()
+				es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+				return resp
+			}
+			So(search(false).Body.String(), ShouldContainSubstring, `"value":0`)
+
+			// the write isn't refreshed, the cached response stays
+			req := httptest.NewRequest(http.MethodPut, "/foo/_doc/1", strings.NewReader(`{"a":1}`))
+			es.handler()(httptest.NewRecorder(), classified(req, category.Docs, acl.Doc, op.Write))
+			resp := search(false)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.String(), ShouldContainSubstring, `"value":0`)
+
+			resp = search(true)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(resp.Body.String(), ShouldContainSubstring, `"value":1`)
+			// the fresh response is cached for the other reads
+			resp = search(false)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.String(), ShouldContainSubstring, `"value":1`)
+
+			// the header is ignored unless enabled
+			es.cache.bypassHeader = false
+			mu.Lock()
+			docs++
+			mu.Unlock()
+			resp = search(true)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.String(), ShouldContainSubstring, `"value":1`)
+		})
+		Convey("Principals that opted out of the cache always read from es", func() {
+			var hits int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				w.Write([]byte(`{"took":1,"hits":{"total":` + strconv.Itoa(hits) + `}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			bypass := true
+			search := func(ctx func(context.Context) context.Context) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+				req = classified(req, category.Search, acl.Search, op.Read)
+				resp := httptest.NewRecorder()
+				es.handler()(resp, req.WithContext(ctx(req.Context())))
+				return resp
+			}
+			normal := func(ctx context.Context) context.Context {
+				ctx = credential.NewContext(ctx, credential.User)
+				return user.NewContext(ctx, &user.User{Username: "foo"})
+			}
+			optedOutUser := func(ctx context.Context) context.Context {
+				ctx = credential.NewContext(ctx, credential.User)
+				return user.NewContext(ctx, &user.User{Username: "bar", BypassCache: &bypass})
+			}
+			optedOutPermission := func(ctx context.Context) context.Context {
+				ctx = credential.NewContext(ctx, credential.Permission)
+				return permission.NewContext(ctx, &permission.Permission{Username: "baz", BypassCache: &bypass})
+			}
+
+			miss := search(normal)
+			So(miss.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(hits, ShouldEqual, 1)
+
+			// the cache is populated, yet the opted out principals go to es
+			resp := search(optedOutUser)
+			So(resp.Header().Get(headerCache), ShouldBeEmpty)
+			So(resp.Body.String(), ShouldContainSubstring, `"total":2`)
+			resp = search(optedOutPermission)
+			So(resp.Header().Get(headerCache), ShouldBeEmpty)
+			So(resp.Body.String(), ShouldContainSubstring, `"total":3`)
+			So(hits, ShouldEqual, 3)
+
+			// while the others get the cached response
+			hit := search(normal)
+			So(hit.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hit.Body.String(), ShouldEqual, miss.Body.String())
+			So(hits, ShouldEqual, 3)
+		})
+		Convey("Refreshed writes invalidate the cached reads of their indices", func() {
+			docs := map[string]int{"foo": 1, "bar": 1}
+			var refresh []string
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				target := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+				if r.Method == http.MethodPut {
+					refresh = r.URL.Query()["refresh"]
+					docs[target]++
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"result":"created"}`))
+					return
+				}
+				w.Write([]byte(`{"hits":{"total":` + strconv.Itoa(docs[target]) + `}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			serve := func(method, template, url string, c category.Category, a acl.ACL, o op.Operation) string {
+				req := httptest.NewRequest(method, url, strings.NewReader(`{"title":"arc"}`))
+				return route(method, template, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, c, a, o))
+				}, req).Body.String()
+			}
+			search := func(index string) string {
+				return serve(http.MethodGet, "/{index}/_search", "/"+index+"/_search", category.Search, acl.Search, op.Read)
+			}
+			write := func(url string) {
+				serve(http.MethodPut, "/{index}/_doc/{id}", url, category.Docs, acl.Index, op.Write)
+			}
+			So(search("foo"), ShouldEqual, `{"hits":{"total":1}}`)
+			So(search("bar"), ShouldEqual, `{"hits":{"total":1}}`)
+
+			// writes without refresh leave the cache alone
+			write("/foo/_doc/1")
+			So(search("foo"), ShouldEqual, `{"hits":{"total":1}}`)
+
+			write("/foo/_doc/2?refresh=wait_for")
+			So(refresh, ShouldResemble, []string{"wait_for"})
+			So(search("foo"), ShouldEqual, `{"hits":{"total":3}}`)
+			// the reads of the other indices stay cached
+			So(search("bar"), ShouldEqual, `{"hits":{"total":1}}`)
+		})
+		Convey("Writes only invalidate the cached reads of all the indices they overlap", func() {
+			var searches, counts int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/_cat/indices":
+					w.Write([]byte(`[{"index":"foo","status":"open"},{"index":"archive","status":"close"}]`))
+				case r.URL.Path == "/_search":
+					searches++
+					w.Write([]byte(`{"hits":{"total":` + strconv.Itoa(searches) + `}}`))
+				case r.URL.Path == "/_count":
+					counts++
+					w.Write([]byte(`{"count":` + strconv.Itoa(counts) + `}`))
+				default:
+					w.Write([]byte(`{"result":"created"}`))
+				}
+			})
+			defer upstream.Close()
+			es := withCache()
+			es.cache.countTTL = time.Minute
+			es.cache.openIndices.names = nil
+
+			read := func(path string, a acl.ACL) string {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				return route(http.MethodGet, path, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Search, a, op.Read))
+				}, req).Header().Get(headerCache)
+			}
+			write := func(url string) {
+				req := httptest.NewRequest(http.MethodPut, url, strings.NewReader(`{"title":"arc"}`))
+				So(route(http.MethodPut, "/{index}/_doc/{id}", func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Docs, acl.Index, op.Write))
+				}, req).Code, ShouldEqual, http.StatusOK)
+			}
+			So(read("/_search", acl.Search), ShouldEqual, cacheMiss)
+			So(read("/_count", acl.Count), ShouldEqual, cacheMiss)
+
+			// the unrefreshed writes only recompute the counts
+			write("/foo/_doc/1")
+			So(read("/_search", acl.Search), ShouldEqual, cacheHit)
+			So(read("/_count", acl.Count), ShouldEqual, cacheMiss)
+
+			// the closed indices aren't read
+			write("/archive/_doc/1?refresh=true")
+			So(read("/_search", acl.Search), ShouldEqual, cacheHit)
+			So(read("/_count", acl.Count), ShouldEqual, cacheHit)
+
+			write("/foo/_doc/2?refresh=true")
+			So(read("/_search", acl.Search), ShouldEqual, cacheMiss)
+
+			// the indices created since the indices were listed are read
+			write("/bar/_doc/1?refresh=true")
+			So(read("/_search", acl.Search), ShouldEqual, cacheMiss)
+			So(searches, ShouldEqual, 3)
+		})
+		Convey("Missing documents are cached until they are written", func() {
+			docs := make(map[string]bool)
+			var gets int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				if r.Method == http.MethodPut {
+					docs[r.URL.Path] = true
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"result":"created"}`))
+					return
+				}
+				gets++
+				if !docs[r.URL.Path] {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"_index":"foo","_id":"1","found":false}`))
+					return
+				}
+				w.Write([]byte(`{"_index":"foo","_id":"1","found":true}`))
+			})
+			defer upstream.Close()
+			es := &elasticsearch{negativeCache: &negativeCache{ttl: time.Minute, entries: response.NewCache(10)}}
+
+			serve := func(method string, c category.Category, a acl.ACL, o op.Operation) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, "/foo/_doc/1", strings.NewReader(`{"title":"arc"}`))
+				return route(method, "/{index}/_doc/{id}", func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, c, a, o))
+				}, req)
+			}
+			get := func() *httptest.ResponseRecorder {
+				return serve(http.MethodGet, category.Docs, acl.Get, op.Read)
+			}
+
+			miss := get()
+			So(miss.Code, ShouldEqual, http.StatusNotFound)
+			So(miss.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(gets, ShouldEqual, 1)
+
+			hit := get()
+			So(hit.Code, ShouldEqual, http.StatusNotFound)
+			So(hit.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hit.Body.String(), ShouldEqual, miss.Body.String())
+			So(gets, ShouldEqual, 1)
+
+			So(serve(http.MethodPut, category.Docs, acl.Index, op.Write).Code, ShouldEqual, http.StatusCreated)
+			fresh := get()
+			So(fresh.Code, ShouldEqual, http.StatusOK)
+			So(fresh.Body.String(), ShouldContainSubstring, `"found":true`)
+			So(gets, ShouldEqual, 2)
+
+			// found documents are never cached
+			get()
+			So(gets, ShouldEqual, 3)
+		})
+		Convey("Existence checks are cached until the document is written", func() {
+			docs := map[string]bool{}
+			heads := 0
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodPut:
+					docs[r.URL.Path] = true
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"result":"created"}`))
+				case http.MethodDelete:
+					delete(docs, r.URL.Path)
+					w.Write([]byte(`{"result":"deleted"}`))
+				default:
+					heads++
+					if !docs[r.URL.Path] {
+						w.WriteHeader(http.StatusNotFound)
+					}
+				}
+			})
+			defer upstream.Close()
+			es := &elasticsearch{existsCache: &existsCache{ttl: time.Minute, entries: response.NewCache(10)}}
+
+			serve := func(method string, c category.Category, a acl.ACL, o op.Operation) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, "/foo/_doc/1", strings.NewReader(`{"title":"arc"}`))
+				return route(method, "/{index}/_doc/{id}", func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, c, a, o))
+				}, req)
+			}
+			head := func() *httptest.ResponseRecorder {
+				return serve(http.MethodHead, category.Docs, acl.Get, op.Read)
+			}
+
+			resp := head()
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheMiss)
+			resp = head()
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(resp.Body.Len(), ShouldEqual, 0)
+			So(heads, ShouldEqual, 1)
+
+			// the write invalidates the cached status, the found one is cached too
+			So(serve(http.MethodPut, category.Docs, acl.Index, op.Write).Code, ShouldEqual, http.StatusCreated)
+			So(head().Code, ShouldEqual, http.StatusOK)
+			resp = head()
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(heads, ShouldEqual, 2)
+
+			So(serve(http.MethodDelete, category.Docs, acl.Doc, op.Delete).Code, ShouldEqual, http.StatusOK)
+			So(head().Code, ShouldEqual, http.StatusNotFound)
+			So(heads, ShouldEqual, 3)
+
+			// the lookups of the documents aren't cached
+			So(serve(http.MethodGet, category.Docs, acl.Get, op.Read).Header().Get(headerCache), ShouldBeEmpty)
+		})
+		Convey("Pattern characters in the document ids are escaped", func() {
+			So(escapePattern(`a*b?[c]\d`), ShouldEqual, `a\*b\?\[c\]\\d`)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+)
+
+const defaultCaptureSampleRate = 1.0
+
+// headers whose values are never captured
+var sensitiveHeaders = headerSet([]string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+})
+
+type capture struct {
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Query      string      `json:"query,omitempty"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body,omitempty"`
+	CapturedAt time.Time   `json:"captured_at"`
+}
+
+// captures is a fixed size ring buffer of a sample of the requests received,
+// an in-memory debugging aid that only keeps the most recent requests.
+type captures struct {
+	mu         sync.Mutex
+	buf        []capture
+	next       int
+	full       bool
+	sampleRate float64
+}
+
+func newCaptures(size int, sampleRate float64) *captures {
+	return &captures{
+		buf:        make([]capture, size),
+		sampleRate: sampleRate,
+	}
+}
+
+func (es *elasticsearch) initCaptures() error {
+	value := os.Getenv(envCaptureSize)
+	if value == "" {
+		return nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return nil
+	}
+	sampleRate := defaultCaptureSampleRate
+	if value := os.Getenv(envCaptureSampleRate); value != "" {
+		sampleRate, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+	}
+	es.captures = newCaptures(size, sampleRate)
+	return nil
+}
+
+// add records the request, with the sensitive headers redacted, if it gets sampled.
+func (c *captures) add(r *http.Request, body []byte) {
+	if c.sampleRate < 1 && rand.Float64() >= c.sampleRate {
+		return
+	}
+	headers := make(http.Header)
+	for k, v := range r.Header {
+		if sensitiveHeaders[k] {
+			headers.Set(k, redacted)
+			continue
+		}
+		headers[k] = v
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf[c.next] = capture{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		Headers:    headers,
+		Body:       string(body),
+		CapturedAt: time.Now(),
+	}
+	c.next = (c.next + 1) % len(c.buf)
+	if c.next == 0 {
+		c.full = true
+	}
+}
+
+// list returns the captured requests, oldest first.
+func (c *captures) list() []capture {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.full {
+		return append([]capture{}, c.buf[:c.next]...)
+	}
+	return append(append([]capture{}, c.buf[c.next:]...), c.buf[:c.next]...)
+}
+
+func (es *elasticsearch) capturesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if es.captures == nil {
+			util.WriteBackError(w, "request capture is not enabled", http.StatusNotFound)
+			return
+		}
+		raw, err := json.Marshal(es.captures.list())
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling captures:", err)
+			util.WriteBackError(w, "error reporting captures", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
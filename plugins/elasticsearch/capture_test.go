@@ -0,0 +1,68 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCaptures(t *testing.T) {
+	Convey("Captures", t, func() {
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		})
+		defer upstream.Close()
+		es := &elasticsearch{captures: newCaptures(2, 1)}
+
+		list := func() []capture {
+			resp := httptest.NewRecorder()
+			es.capturesHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/captures", nil))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			var captured []capture
+			So(json.Unmarshal(resp.Body.Bytes(), &captured), ShouldBeNil)
+			return captured
+		}
+		search := func(i int) {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/foo/_search?from=%d", i), strings.NewReader(`{"query":{"match_all":{}}}`))
+			req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+			req.Header.Set("X-Opaque-Id", "trace")
+			es.handler()(httptest.NewRecorder(), classified(req, category.Search, acl.Search, op.Read))
+		}
+
+		Convey("Requests are captured with the sensitive headers redacted", func() {
+			So(list(), ShouldBeEmpty)
+			search(0)
+			captured := list()
+			So(len(captured), ShouldEqual, 1)
+			So(captured[0].Method, ShouldEqual, http.MethodPost)
+			So(captured[0].Path, ShouldEqual, "/foo/_search")
+			So(captured[0].Query, ShouldEqual, "from=0")
+			So(captured[0].Body, ShouldEqual, `{"query":{"match_all":{}}}`)
+			So(captured[0].Headers.Get("Authorization"), ShouldEqual, redacted)
+			So(captured[0].Headers.Get("X-Opaque-Id"), ShouldEqual, "trace")
+		})
+		Convey("Only the most recent requests are kept", func() {
+			for i := 0; i < 3; i++ {
+				search(i)
+			}
+			captured := list()
+			So(len(captured), ShouldEqual, 2)
+			So(captured[0].Query, ShouldEqual, "from=1")
+			So(captured[1].Query, ShouldEqual, "from=2")
+		})
+		Convey("Capture is opt-in", func() {
+			resp := httptest.NewRecorder()
+			Instance().capturesHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/captures", nil))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,84 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+)
+
+// initConditionalUpdates reads the patterns of the indices whose documents
+// may only be updated conditionally.
+func (es *elasticsearch) initConditionalUpdates() error {
+	patterns := envList(envConditionalUpdates)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %v", envConditionalUpdates, pattern, err)
+		}
+	}
+	es.conditionalUpdates = patterns
+	return nil
+}
+
+// matchIndex returns the first of the indices that matches one of the
+// path.Match patterns.
+func matchIndex(patterns, indices []string) (string, bool) {
+	for _, index := range indices {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, index); ok {
+				return index, true
+			}
+		}
+	}
+	return "", false
+}
+
+// conditional checks whether the write to a document only applies to the
+// version of it the client has read, or only creates the document.
+func conditional(req *http.Request) bool {
+	params := req.URL.Query()
+	if params.Get("if_seq_no") != "" && params.Get("if_primary_term") != "" {
+		return true
+	}
+	if params.Get("version") != "" || params.Get("op_type") == "create" {
+		return true
+	}
+	return strings.Contains(routeTemplate(req), "/_create/")
+}
+
+// requireConditionalUpdates rejects the unconditional writes to the
+// documents of the guarded indices, whose concurrent updates would be lost
+// otherwise. The documents are written with the if_seq_no and
+// if_primary_term, or version, of the read they are based on instead. The
+// writes of several documents, e.g. the bulk requests, aren't checked.
+func (es *elasticsearch) requireConditionalUpdates(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(es.conditionalUpdates) == 0 {
+			h(w, req)
+			return
+		}
+		reqOp, err := op.FromContext(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating request op", http.StatusInternalServerError)
+			return
+		}
+		if *reqOp != op.Write || mux.Vars(req)["id"] == "" || conditional(req) {
+			h(w, req)
+			return
+		}
+		if index, ok := matchIndex(es.conditionalUpdates, util.IndicesFromRequest(req)); ok {
+			msg := fmt.Sprintf(`the documents of index "%s" may only be updated conditionally, `+
+				`set the "if_seq_no" and "if_primary_term" query params, or "version"`, index)
+			util.WriteBackError(w, msg, http.StatusConflict)
+			return
+		}
+		h(w, req)
+	}
+}
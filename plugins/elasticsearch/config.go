@@ -0,0 +1,166 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/response"
+	"github.com/appbaseio/arc/plugins/logs"
+	"github.com/appbaseio/arc/util"
+)
+
+const redacted = "[REDACTED]"
+
+// config returns the effective runtime configuration, with the credentials
+// and the tls keys redacted.
+func (es *elasticsearch) config() map[string]interface{} {
+	timeouts := make(map[string]string)
+	for c, timeout := range es.timeouts {
+		timeouts[c.String()] = timeout.String()
+	}
+	cache := map[string]interface{}{
+		"enabled": es.cache != nil,
+	}
+	if es.cache != nil {
+		var categories []string
+		for c := range es.cache.categories {
+			categories = append(categories, c.String())
+		}
+		sort.Strings(categories)
+		cache["ttl"] = es.cache.ttl.String()
+		cache["categories"] = categories
+		cache["size"] = response.ResponseCache().Capacity()
+		cache["max_bytes"] = response.ResponseCache().MaxBytes()
+		cache["compression_level"] = response.ResponseCache().CompressionLevel()
+		cache["count_ttl"] = es.cache.countTTL.String()
+		cache["honor_cache_control"] = es.cache.honorCacheControl
+		cache["bypass_header"] = es.cache.bypassHeader
+		keyHeaders := make(map[string][]string)
+		for c, headers := range es.cache.keyHeaders {
+			keyHeaders[c.String()] = headers
+		}
+		cache["key_headers"] = keyHeaders
+		if es.cache.emptyTTL != nil {
+			cache["empty_results_ttl"] = es.cache.emptyTTL.String()
+		}
+	}
+	negativeCache := map[string]interface{}{
+		"enabled": es.negativeCache != nil,
+	}
+	if es.negativeCache != nil {
+		negativeCache["ttl"] = es.negativeCache.ttl.String()
+		negativeCache["size"] = es.negativeCache.entries.Capacity()
+	}
+	existsCache := map[string]interface{}{
+		"enabled": es.existsCache != nil,
+	}
+	if es.existsCache != nil {
+		existsCache["ttl"] = es.existsCache.ttl.String()
+		existsCache["size"] = es.existsCache.entries.Capacity()
+	}
+	bulkQueue := map[string]interface{}{
+		"enabled": es.bulkQueue != nil,
+	}
+	if es.bulkQueue != nil {
+		var routes []string
+		for route := range es.bulkQueue.routes {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		bulkQueue["dir"] = es.bulkQueue.dir
+		bulkQueue["routes"] = routes
+		bulkQueue["interval"] = es.bulkQueue.interval.String()
+		bulkQueue["max_depth"] = es.bulkQueue.maxDepth
+	}
+	tls := map[string]string{
+		"cert":          os.Getenv("HTTPS_CERT"),
+		"min_version":   os.Getenv("HTTPS_MIN_TLS_VERSION"),
+		"cipher_suites": os.Getenv("HTTPS_CIPHER_SUITES"),
+	}
+	if os.Getenv("HTTPS_KEY") != "" {
+		tls["key"] = redacted
+	}
+	clusters := map[string]string{
+		"default": redactURL(os.Getenv("ES_CLUSTER_URL")),
+		"read":    redactURL(os.Getenv("ES_READ_CLUSTER_URL")),
+		"write":   redactURL(os.Getenv("ES_WRITE_CLUSTER_URL")),
+		"mirror":  redactURL(os.Getenv("ES_MIRROR_CLUSTER_URL")),
+	}
+	// the named clusters are listed as comma separated name=url pairs
+	for _, pair := range strings.Split(os.Getenv("ES_NAMED_CLUSTER_URLS"), ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		clusters[strings.TrimSpace(parts[0])] = redactURL(strings.TrimSpace(parts[1]))
+	}
+	return map[string]interface{}{
+		"clusters": clusters,
+		"timeouts": map[string]interface{}{
+			"default":    es.defaultTimeout.String(),
+			"categories": timeouts,
+		},
+		"cache":          cache,
+		"negative_cache": negativeCache,
+		"exists_cache":   existsCache,
+		"bulk_queue":     bulkQueue,
+		"params_allowlist": map[string]interface{}{
+			"enabled": es.paramsAllowlist != nil,
+			"params":  headerNames(es.paramsAllowlist),
+		},
+		"headers_denylist": map[string][]string{
+			"request":  headerNames(es.requestHeaderDenylist),
+			"response": headerNames(es.responseHeaderDenylist),
+		},
+		"logs": map[string]interface{}{
+			"sample_rate": logs.Instance().SampleRate(),
+		},
+		"disabled_routes": es.disabledRoutes,
+		"privileged_categories": map[string]interface{}{
+			"enabled": es.enabledPrivilegedCategories(),
+		},
+		"captures": map[string]interface{}{
+			"enabled": es.captures != nil,
+		},
+		"tls": tls,
+	}
+}
+
+// redactURL replaces the credentials present in the url, if any.
+func redactURL(rawURL string) string {
+	at := strings.LastIndex(rawURL, "@")
+	if at < 0 {
+		return rawURL
+	}
+	scheme := ""
+	if i := strings.Index(rawURL[:at], "://"); i >= 0 {
+		scheme = rawURL[:i+3]
+	}
+	return scheme + redacted + "@" + rawURL[at+1:]
+}
+
+func headerNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (es *elasticsearch) configHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw, err := json.Marshal(es.config())
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling config:", err)
+			util.WriteBackError(w, "error reporting config", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
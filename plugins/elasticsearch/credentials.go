@@ -0,0 +1,70 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+)
+
+// esCredentials are the basic-auth credentials arc authenticates to es with.
+type esCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// readCredentialsFile reads the "username:password" credentials from the file.
+func readCredentialsFile(path string) (esCredentials, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return esCredentials{}, err
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(content)), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return esCredentials{}, errors.New(`credentials file must contain "username:password"`)
+	}
+	return esCredentials{Username: parts[0], Password: parts[1]}, nil
+}
+
+// reloadCredentialsHandler swaps the es credentials for the ones in the request
+// body or, if the body is empty, for the ones in the credentials file.
+func (es *elasticsearch) reloadCredentialsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds esCredentials
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &creds); err != nil || creds.Username == "" {
+				util.WriteBackError(w, `request body must contain the "username" and "password"`, http.StatusBadRequest)
+				return
+			}
+		} else {
+			path := os.Getenv(envCredentialsFile)
+			if path == "" {
+				util.WriteBackError(w, "no credentials in the request body and "+envCredentialsFile+" isn't set", http.StatusBadRequest)
+				return
+			}
+			creds, err = readCredentialsFile(path)
+			if err != nil {
+				log.Errorln(logTag, ": error reading the credentials file:", err)
+				util.WriteBackError(w, "error reading the credentials file", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := util.ReloadCredentials(r.Context(), creds.Username, creds.Password); err != nil {
+			log.Errorln(logTag, ": error reloading the es credentials:", err)
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		util.WriteBackMessage(w, "es credentials reloaded", http.StatusOK)
+	}
+}
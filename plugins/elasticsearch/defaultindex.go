@@ -0,0 +1,78 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/plugins"
+	"github.com/gorilla/mux"
+)
+
+// defaultIndexRoutes returns the index-less variants of the document routes,
+// e.g. /_doc/{id} for /{index}/_doc/{id}, whose requests are served against
+// the default index. Variants that are already routes of their own, or that
+// would be ambiguous such as the ones with a {type}, are left out.
+func (es *elasticsearch) defaultIndexRoutes(specRoutes []plugins.Route, mw []middleware.Middleware) []plugins.Route {
+	middlewareFunction := (&chain{}).Wrap
+
+	var indexless []plugins.Route
+	for _, r := range specRoutes {
+		if !strings.HasPrefix(r.Path, "/{index}/") || strings.Contains(r.Path, "{type}") {
+			continue
+		}
+		if !strings.Contains(r.Path, "{id}") && !strings.HasSuffix(r.Path, "/_doc") {
+			continue
+		}
+		path := strings.TrimPrefix(r.Path, "/{index}")
+		if es.routeDisabled(r.Name, path) {
+			continue
+		}
+		var methods []string
+		for _, method := range r.Methods {
+			spec, ok := routeSpecs[fmt.Sprintf("%s:%s", method, r.Path)]
+			if !ok || spec.category != category.Docs {
+				continue
+			}
+			key := fmt.Sprintf("%s:%s", method, path)
+			if _, ok := routeSpecs[key]; ok {
+				continue
+			}
+			routeSpecs[key] = spec
+			methods = append(methods, method)
+		}
+		if len(methods) == 0 {
+			continue
+		}
+		log.Debugln(logTag, ": serving", methods, path, "against the default index", es.defaultIndex)
+		indexless = append(indexless, plugins.Route{
+			Name:        r.Name,
+			Methods:     methods,
+			Path:        path,
+			HandlerFunc: es.rejectDisabled(withDefaultIndex(es.defaultIndex, middlewareFunction(mw, es.handler()))),
+			Description: r.Description,
+		})
+	}
+	return indexless
+}
+
+// withDefaultIndex serves the index-less request against the index, as if
+// the index had been part of the request path.
+func withDefaultIndex(index string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := map[string]string{"index": index}
+		for k, v := range mux.Vars(r) {
+			vars[k] = v
+		}
+		r = mux.SetURLVars(r, vars)
+		u := *r.URL
+		u.Path = "/" + index + r.URL.Path
+		u.RawPath = ""
+		r.URL = &u
+		h(w, r)
+	}
+}
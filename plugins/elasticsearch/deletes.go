@@ -0,0 +1,48 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+)
+
+// wildcardTarget returns the first of the indices that expands to indices
+// the client may not know about, i.e. _all or a wildcard pattern.
+func wildcardTarget(indices []string) (string, bool) {
+	for _, index := range indices {
+		if index == "_all" || strings.Contains(index, "*") {
+			return index, true
+		}
+	}
+	return "", false
+}
+
+// blockWildcardDeletes rejects the deletes of _all or of wildcard indices,
+// e.g. DELETE /* which wipes the whole cluster, unless they are confirmed
+// with the i_am_sure param or made by an admin user.
+func blockWildcardDeletes(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqOp, err := op.FromContext(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating request op", http.StatusInternalServerError)
+			return
+		}
+		if *reqOp != op.Delete || req.URL.Query().Get(gatewayConfirmParam) == "true" || isAdminRequest(req) {
+			h(w, req)
+			return
+		}
+		if target, ok := wildcardTarget(util.IndicesFromRequest(req)); ok {
+			msg := fmt.Sprintf(`deleting "%s" may delete every index, set the "%s=true" query param to confirm`,
+				target, gatewayConfirmParam)
+			util.WriteBackDenied(w, req, msg, http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -1,13 +1,100 @@
 package elasticsearch
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/appbaseio/arc/middleware"
+	"github.com/appbaseio/arc/model/category"
 	"github.com/appbaseio/arc/plugins"
 )
 
-const logTag = "[elasticsearch]"
+const (
+	logTag                     = "[elasticsearch]"
+	envResponseHeaderDenylist  = "ES_RESPONSE_HEADERS_DENYLIST"
+	envRequestHeaderDenylist   = "ES_REQUEST_HEADERS_DENYLIST"
+	envSpecSelfCheck           = "ES_SPEC_SELF_CHECK"
+	envExpectedEndpoints       = "ES_EXPECTED_ENDPOINTS"
+	envRouteOverridesFile      = "ES_ROUTE_OVERRIDES_FILE"
+	envBulkQueueRoutes         = "ES_BULK_QUEUE_ROUTES"
+	envBulkQueueDir            = "ES_BULK_QUEUE_DIR"
+	envBulkQueueInterval       = "ES_BULK_QUEUE_INTERVAL"
+	envBulkQueueMaxDepth       = "ES_BULK_QUEUE_MAX_DEPTH"
+	envResponseCacheTTL        = "ES_RESPONSE_CACHE_TTL"
+	envResponseCacheSize       = "ES_RESPONSE_CACHE_SIZE"
+	envResponseCacheMaxBytes   = "ES_RESPONSE_CACHE_MAX_BYTES"
+	envResponseCacheCategories = "ES_RESPONSE_CACHE_CATEGORIES"
+	envResponseCacheLevel      = "ES_RESPONSE_CACHE_COMPRESSION_LEVEL"
+	envCountCacheTTL           = "ES_COUNT_CACHE_TTL"
+	envCacheControl            = "ES_RESPONSE_CACHE_HONOR_CACHE_CONTROL"
+	envEmptyResultsCacheTTL    = "ES_EMPTY_RESULTS_CACHE_TTL"
+	envCacheBypassHeader       = "ES_RESPONSE_CACHE_BYPASS_HEADER"
+	envCacheKeyHeaders         = "ES_RESPONSE_CACHE_KEY_HEADERS"
+	envCacheStatsInterval      = "ES_RESPONSE_CACHE_STATS_INTERVAL"
+	envRequestTimeout          = "ES_REQUEST_TIMEOUT"
+	envCategoryTimeouts        = "ES_CATEGORY_TIMEOUTS"
+	envCaptureSize             = "ES_CAPTURE_SIZE"
+	envCaptureSampleRate       = "ES_CAPTURE_SAMPLE_RATE"
+	envMaxTrackedIndices       = "ES_STATS_MAX_INDICES"
+	envDisabledRoutes          = "ES_DISABLED_ROUTES"
+	envCacheWarmUpFile         = "ES_RESPONSE_CACHE_WARMUP_FILE"
+	envIndexCheck              = "ES_INDEX_EXISTENCE_CHECK"
+	envIndexCheckTTL           = "ES_INDEX_EXISTENCE_CHECK_TTL"
+	envBlockAutoCreate         = "ES_BLOCK_AUTO_CREATE_INDEX"
+	envAutoCreateAllowlist     = "ES_AUTO_CREATE_INDEX_ALLOWLIST"
+	envSpecFallback            = "ES_SPEC_FALLBACK"
+	envMaxRoutes               = "ES_MAX_ROUTES"
+	envIdempotencyTTL          = "ES_IDEMPOTENCY_TTL"
+	envParamsAllowlist         = "ES_PARAMS_ALLOWLIST"
+	envStreamedRoutes          = "ES_STREAMED_ROUTES"
+	envCredentialsFile         = "ES_CREDENTIALS_FILE"
+	envNegativeCacheTTL        = "ES_NEGATIVE_CACHE_TTL"
+	envNegativeCacheSize       = "ES_NEGATIVE_CACHE_SIZE"
+	envExistsCacheTTL          = "ES_EXISTS_CACHE_TTL"
+	envExistsCacheSize         = "ES_EXISTS_CACHE_SIZE"
+	envSpecVersions            = "ES_SPEC_VERSIONS"
+	envDefaultIndex            = "ES_DEFAULT_INDEX"
+	envSelfURLs                = "SELF_URLS"
+	envEncryptedFields         = "ES_ENCRYPTED_FIELDS"
+	envEncryptionKey           = "ES_ENCRYPTION_KEY"
+	envSpecDecoders            = "ES_SPEC_DECODE_CONCURRENCY"
+	envErrorBodyPreview        = "ES_ERROR_BODY_PREVIEW_SIZE"
+	envAliasCacheTTL           = "ES_ALIAS_CACHE_TTL"
+	envWrapNonJSONErrors       = "ES_WRAP_NON_JSON_ERRORS"
+	envStreamBulk              = "ES_STREAM_BULK_RESPONSES"
+	envShardFailures           = "ES_REPORT_SHARD_FAILURES"
+	envBulkErrors              = "ES_SUMMARIZE_BULK_ERRORS"
+	envPrivilegedCategories    = "ES_ENABLED_PRIVILEGED_CATEGORIES"
+	envStreamBulkThreshold     = "ES_STREAM_BULK_THRESHOLD"
+	envHealthChecks            = "ES_HEALTH_CHECKS"
+	envHealthCheckTimeout      = "ES_HEALTH_CHECK_TIMEOUT"
+	envHealthCheckInterval     = "ES_HEALTH_CHECK_INTERVAL"
+	envLoadSheddingInterval    = "ES_LOAD_SHEDDING_INTERVAL"
+	envLoadSheddingCPU         = "ES_LOAD_SHEDDING_CPU_PERCENT"
+	envLoadSheddingQueue       = "ES_LOAD_SHEDDING_QUEUE_SIZE"
+	envLoadSheddingCategories  = "ES_LOAD_SHEDDING_CATEGORIES"
+	envResponseTransforms      = "ES_RESPONSE_TRANSFORMS"
+	envScrollCleanup           = "ES_SCROLL_CLEANUP"
+	envSlowSpecDecode          = "ES_SLOW_SPEC_DECODE_THRESHOLD"
+	envRequestConcurrency      = "ES_REQUEST_CONCURRENCY"
+	envRequestQueueSize        = "ES_REQUEST_QUEUE_SIZE"
+	envRequestPriorities       = "ES_REQUEST_PRIORITIES"
+	envInjectedHeaders         = "ES_REQUEST_HEADERS_INJECTED"
+	envVersionCheckInterval    = "ES_VERSION_CHECK_INTERVAL"
+	envVersionCheckSwitch      = "ES_VERSION_CHECK_SWITCH"
+	envMirrorSampleRate        = "ES_MIRROR_SAMPLE_RATE"
+	envMirrorCompareDepth      = "ES_MIRROR_COMPARE_DEPTH"
+	envExplainTooLarge         = "ES_EXPLAIN_TOO_LARGE_ERRORS"
+	envMaxContentLength        = "ES_MAX_CONTENT_LENGTH"
+	envConditionalUpdates      = "ES_CONDITIONAL_UPDATE_INDICES"
+	envRoutedIndices           = "ES_ROUTED_INDICES"
+	envAggregationLimits       = "ES_AGGREGATION_LIMITS"
+)
 
 var (
 	singleton *elasticsearch
@@ -16,6 +103,113 @@ var (
 
 type elasticsearch struct {
 	specs []api
+	// headers that are never copied from the es response to the client
+	responseHeaderDenylist map[string]bool
+	// headers that are never forwarded from the client request to es
+	requestHeaderDenylist map[string]bool
+	// static headers set on every request forwarded to es, overriding the
+	// client's values
+	injectedHeaders map[string]string
+	// query params that are forwarded to es, nil if all of them are
+	paramsAllowlist map[string]bool
+	// route templates whose responses are streamed
+	streamedRoutes map[string]bool
+	// whether the responses of all the _bulk routes are streamed
+	streamBulk bool
+	// body size in bytes from which the _bulk responses are streamed, the
+	// smaller bulks are buffered, zero if the size doesn't matter
+	streamBulkThreshold int64
+	// queue for the bulk requests of the opted-in routes, nil if disabled
+	bulkQueue *bulkQueue
+	// response cache settings, nil if caching is disabled
+	cache *cacheConfig
+	// cache of the missing documents' 404s, nil if disabled
+	negativeCache *negativeCache
+	existsCache   *existsCache
+	// upstream request timeouts, by category and for the rest of the
+	// categories, zero means no timeout
+	timeouts       map[category.Category]time.Duration
+	defaultTimeout time.Duration
+	// sample of the recent requests for debugging, nil if disabled
+	captures *captures
+	// operation counters by index
+	indexStats *indexStats
+	// route names or templates, glob patterns allowed, that aren't registered
+	disabledRoutes []string
+	// matcher of the requests to the disabled routes, nil unless routes
+	// are disabled
+	disabledMatcher *disabledMatcher
+	// pre-check of the existence of the read indices, nil if disabled
+	indexCheck *indexCheck
+	// block of the writes auto-creating the indices, nil if disabled
+	autoCreateBlock *autoCreateBlock
+	// path.Match patterns of the indices whose documents may only be
+	// updated conditionally
+	conditionalUpdates []string
+	// path.Match patterns of the custom-routed indices, whose documents
+	// are written with a routing value
+	routedIndices []string
+	// periodically refreshed alias -> index map, nil if it is only loaded
+	// on startup
+	aliasCache *aliasCache
+	// index the index-less document requests are served against, empty if
+	// they aren't routed
+	defaultIndex string
+	// encryption of the configured document fields, nil if disabled
+	encryption *fieldEncryption
+	// whether an es url points at arc itself
+	selfProxied bool
+	// number of spec files decoded concurrently, zero means GOMAXPROCS
+	specDecoders int
+	// decode time from which a spec file is flagged as slow, zero means
+	// the default
+	slowSpecDecode time.Duration
+	// maximum number of spec routes to register, zero means unlimited
+	maxRoutes int
+	// duration for which the responses of the keyed writes are replayed,
+	// zero if idempotency keys aren't supported
+	idempotencyTTL time.Duration
+	// idempotency keys of the writes being forwarded
+	idempotentWrites inFlightKeys
+	// maximum size of the request body preview added to the errors of the
+	// admin users' requests, zero if it isn't added
+	errorPreviewSize int
+	// whether the error responses es, or a proxy in front of it, sends
+	// back in a format other than json are wrapped in a json error
+	wrapNonJSONErrors bool
+	// whether the searches some shards failed to execute are logged and
+	// flagged with a header
+	reportShardFailures bool
+	// whether the _bulk responses with failed items are summarized in the
+	// logs and a header
+	summarizeBulkErrors bool
+	// privileged categories whose requests are let through
+	enabledCategories map[category.Category]bool
+	// dependencies checked by the health endpoint, the time they are given
+	// to respond and for which their outcome is reused
+	healthChecks        []string
+	healthCheckTimeout  time.Duration
+	healthCheckInterval time.Duration
+	healthResults       healthResults
+	// shedding of the low priority reads while the cluster is under
+	// pressure, nil if disabled
+	loadShedder *loadShedder
+	// limits of the searches' aggregations by category and index pattern,
+	// nil if disabled
+	aggregationLimiter *aggregationLimiter
+	// response transform pipelines by index pattern, nil if disabled
+	transforms *transformPipelines
+	// clearing of the scrolls of the disconnected clients, nil if disabled
+	scrollCleanup *scrollCleanup
+	// limit of the requests forwarded to es at once, the requests over it
+	// are queued by priority, nil if unlimited
+	requestQueue *requestQueue
+	// periodic detection of the cluster version changes, nil if disabled
+	versionCheck *versionCheck
+	// mirror of the read requests to a secondary cluster, nil if disabled
+	mirror *requestMirror
+	// explanation of the 413 responses of es, nil if they are passed through
+	tooLargeHint *tooLargeHint
 }
 
 func Instance() *elasticsearch {
@@ -28,9 +222,206 @@ func (es *elasticsearch) Name() string {
 }
 
 func (es *elasticsearch) InitFunc(mw []middleware.Middleware) error {
+	es.responseHeaderDenylist = headerSet(envList(envResponseHeaderDenylist))
+	es.requestHeaderDenylist = headerSet(envList(envRequestHeaderDenylist))
+	if err := es.initInjectedHeaders(); err != nil {
+		return err
+	}
+	es.disabledRoutes = envList(envDisabledRoutes)
+	es.defaultIndex = os.Getenv(envDefaultIndex)
+	es.wrapNonJSONErrors = os.Getenv(envWrapNonJSONErrors) == "true"
+	es.reportShardFailures = os.Getenv(envShardFailures) == "true"
+	es.summarizeBulkErrors = os.Getenv(envBulkErrors) == "true"
+	es.initLoopCheck()
+	es.initScrollCleanup()
+	es.initTooLargeHint()
+	es.streamBulk = os.Getenv(envStreamBulk) == "true"
+	es.streamedRoutes = make(map[string]bool)
+	for _, route := range envList(envStreamedRoutes) {
+		es.streamedRoutes[route] = true
+	}
+	if params := envList(envParamsAllowlist); len(params) > 0 {
+		es.paramsAllowlist = make(map[string]bool)
+		for _, param := range params {
+			es.paramsAllowlist[param] = true
+		}
+	}
+	if value := os.Getenv(envStreamBulkThreshold); value != "" {
+		threshold, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		es.streamBulkThreshold = threshold
+	}
+	if value := os.Getenv(envMaxRoutes); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		es.maxRoutes = max
+	}
+	if value := os.Getenv(envSpecDecoders); value != "" {
+		decoders, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		es.specDecoders = decoders
+	}
+	if value := os.Getenv(envSlowSpecDecode); value != "" {
+		slow, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		es.slowSpecDecode = slow
+	}
+	if err := es.initBulkQueue(); err != nil {
+		return err
+	}
+	if err := es.initCache(); err != nil {
+		return err
+	}
+	if err := es.initNegativeCache(); err != nil {
+		return err
+	}
+	if err := es.initExistsCache(); err != nil {
+		return err
+	}
+	if err := es.initTimeouts(); err != nil {
+		return err
+	}
+	if err := es.initCaptures(); err != nil {
+		return err
+	}
+	if err := es.initIndexStats(); err != nil {
+		return err
+	}
+	if err := es.initIndexCheck(); err != nil {
+		return err
+	}
+	if err := es.initAutoCreateBlock(); err != nil {
+		return err
+	}
+	if err := es.initConditionalUpdates(); err != nil {
+		return err
+	}
+	if err := es.initRoutedIndices(); err != nil {
+		return err
+	}
+	if err := es.initAliasCache(); err != nil {
+		return err
+	}
+	if err := es.initEncryption(); err != nil {
+		return err
+	}
+	if err := es.initIdempotency(); err != nil {
+		return err
+	}
+	if err := es.initErrorPreview(); err != nil {
+		return err
+	}
+	if err := es.initPrivilegedCategories(); err != nil {
+		return err
+	}
+	if err := es.initHealthChecks(); err != nil {
+		return err
+	}
+	if err := es.initLoadShedding(); err != nil {
+		return err
+	}
+	if err := es.initAggregationLimits(); err != nil {
+		return err
+	}
+	if err := es.initResponseTransforms(); err != nil {
+		return err
+	}
+	if err := es.initRequestQueue(); err != nil {
+		return err
+	}
+	if err := es.initVersionCheck(); err != nil {
+		return err
+	}
+	if err := es.initRequestMirror(); err != nil {
+		return err
+	}
 	return es.preprocess(mw)
 }
 
+func (es *elasticsearch) initBulkQueue() error {
+	routes := envList(envBulkQueueRoutes)
+	if len(routes) == 0 {
+		return nil
+	}
+	dir := os.Getenv(envBulkQueueDir)
+	if dir == "" {
+		dir = defaultBulkQueueDir
+	}
+	interval := defaultBulkQueueInterval
+	if value := os.Getenv(envBulkQueueInterval); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		interval = parsed
+	}
+	queue, err := newBulkQueue(dir, routes)
+	if err != nil {
+		return err
+	}
+	queue.interval = interval
+	if value := os.Getenv(envBulkQueueMaxDepth); value != "" {
+		if queue.maxDepth, err = strconv.Atoi(value); err != nil {
+			return err
+		}
+		if queue.maxDepth <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", envBulkQueueMaxDepth, queue.maxDepth)
+		}
+	}
+	queue.drained = es.invalidateDrained
+	es.bulkQueue = queue
+	go queue.run()
+	return nil
+}
+
+func (es *elasticsearch) initInjectedHeaders() error {
+	value := os.Getenv(envInjectedHeaders)
+	if value == "" {
+		return nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(value), &headers); err != nil {
+		return fmt.Errorf("invalid %s: %v", envInjectedHeaders, err)
+	}
+	es.injectedHeaders = make(map[string]string)
+	for k, v := range headers {
+		es.injectedHeaders[http.CanonicalHeaderKey(k)] = v
+	}
+	return nil
+}
+
+func (es *elasticsearch) initTimeouts() error {
+	if value := os.Getenv(envRequestTimeout); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		es.defaultTimeout = timeout
+	}
+	timeouts, err := categoryDurations(os.Getenv(envCategoryTimeouts))
+	if err != nil {
+		return err
+	}
+	es.timeouts = timeouts
+	return nil
+}
+
+// timeout returns the upstream request timeout for the category.
+func (es *elasticsearch) timeout(c category.Category) time.Duration {
+	if timeout, ok := es.timeouts[c]; ok {
+		return timeout
+	}
+	return es.defaultTimeout
+}
+
 func (es *elasticsearch) Routes() []plugins.Route {
 	return es.routes()
 }
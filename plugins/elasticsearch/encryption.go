@@ -0,0 +1,422 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/util"
+)
+
+// prefix of the encrypted field values, the values without it are passed
+// through so that the fields encrypted after the fact still read back
+const encryptedPrefix = "arc:enc:"
+
+// fieldEncryption encrypts the configured _source fields of the documents
+// written to the matching indices and decrypts them in the responses.
+type fieldEncryption struct {
+	aead cipher.AEAD
+	// dotted field paths by index pattern
+	fields map[string][]string
+}
+
+func (es *elasticsearch) initEncryption() error {
+	pairs := envList(envEncryptedFields)
+	if len(pairs) == 0 {
+		return nil
+	}
+	key, err := util.DecodeBase64Key(os.Getenv(envEncryptionKey))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", envEncryptionKey, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", envEncryptionKey, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string][]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf(`invalid encrypted field %q, expected "index:field"`, pair)
+		}
+		fields[parts[0]] = append(fields[parts[0]], parts[1])
+	}
+	es.encryption = &fieldEncryption{aead: aead, fields: fields}
+	return nil
+}
+
+// redact replaces the values of the encrypted fields of all the indices
+// wherever they are found in the body, e.g. in the queries naming their
+// plain values, so that the request can be kept around.
+func (e *fieldEncryption) redact(body []byte) []byte {
+	var fields []string
+	for _, patternFields := range e.fields {
+		fields = append(fields, patternFields...)
+	}
+	return util.MaskNestedFields(body, fields, redacted)
+}
+
+// fieldsOf returns the encrypted fields of the index, or of the index the
+// alias points to along with the ones of the alias itself.
+func (e *fieldEncryption) fieldsOf(index string) []string {
+	names := []string{index}
+	if aliased, ok := classify.GetAliasIndexCache()[index]; ok {
+		names = append(names, aliased)
+	}
+	var fields []string
+	seen := make(map[string]bool)
+	for pattern, patternFields := range e.fields {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); !ok {
+				continue
+			}
+			for _, field := range patternFields {
+				if !seen[field] {
+					seen[field] = true
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// unencryptableError reports a write to an index with encrypted fields whose
+// documents can't be encrypted, which is rejected rather than letting the
+// plain values through.
+type unencryptableError struct {
+	index  string
+	reason string
+}
+
+func (e *unencryptableError) Error() string {
+	if e.index == "" {
+		return "can't encrypt the fields of the written documents: " + e.reason
+	}
+	return fmt.Sprintf("can't encrypt the fields of the documents written to %q: %s", e.index, e.reason)
+}
+
+func (e *fieldEncryption) encrypt(value interface{}) (string, error) {
+	plain, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := e.aead.Seal(nonce, nonce, plain, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *fieldEncryption) decrypt(value string) (interface{}, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < e.aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+	plain, err := e.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(plain)
+}
+
+// transformFields applies fn to the values found at the dotted field paths.
+func transformFields(source map[string]interface{}, fields []string, fn func(interface{}) (interface{}, error)) error {
+	for _, field := range fields {
+		parent := source
+		keys := strings.Split(field, ".")
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				parent = nil
+				break
+			}
+			parent = child
+		}
+		if parent == nil {
+			continue
+		}
+		leaf := keys[len(keys)-1]
+		value, ok := parent[leaf]
+		if !ok || value == nil {
+			continue
+		}
+		transformed, err := fn(value)
+		if err != nil {
+			return fmt.Errorf("field %q: %v", field, err)
+		}
+		parent[leaf] = transformed
+	}
+	return nil
+}
+
+func (e *fieldEncryption) encryptSource(source map[string]interface{}, fields []string) error {
+	return transformFields(source, fields, func(value interface{}) (interface{}, error) {
+		if s, ok := value.(string); ok && strings.HasPrefix(s, encryptedPrefix) {
+			return value, nil
+		}
+		return e.encrypt(value)
+	})
+}
+
+func (e *fieldEncryption) decryptSource(source map[string]interface{}, fields []string) error {
+	return transformFields(source, fields, func(value interface{}) (interface{}, error) {
+		if s, ok := value.(string); ok && strings.HasPrefix(s, encryptedPrefix) {
+			return e.decrypt(s)
+		}
+		return value, nil
+	})
+}
+
+// encryptRequest encrypts the fields of the documents in the body of the
+// document index, create, update and bulk requests, the typed ones of es6
+// included. The writes to the indices with encrypted fields whose documents
+// can't be encrypted, i.e. whose body can't be decoded or that run scripts
+// or copy the documents of indices without these fields, are rejected with
+// an *unencryptableError.
+func (e *fieldEncryption) encryptRequest(urlPath, index string, body []byte) ([]byte, error) {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	last := segments[len(segments)-1]
+	switch {
+	case last == "_bulk":
+		return e.encryptBulk(index, body)
+	case last == "_update" || (len(segments) == 3 && segments[1] == "_update"):
+		return e.encryptDocument(index, body, "doc", "upsert")
+	case len(segments) >= 2 && (segments[1] == "_doc" || segments[1] == "_create"):
+		return e.encryptDocument(index, body)
+	case len(segments) == 4 && last == "_create":
+		// /{index}/{type}/{id}/_create
+		return e.encryptDocument(index, body)
+	case typedDocumentPath(segments):
+		return e.encryptDocument(index, body)
+	case last == "_update_by_query":
+		return body, e.checkUpdateByQuery(index, body)
+	case last == "_reindex":
+		return body, e.checkReindex(body)
+	}
+	return body, nil
+}
+
+// typedDocumentPath checks whether the path segments are the ones of the
+// typed document writes of es6, i.e. /{index}/{type} or /{index}/{type}/{id}.
+func typedDocumentPath(segments []string) bool {
+	if len(segments) != 2 && len(segments) != 3 {
+		return false
+	}
+	for _, segment := range segments {
+		if segment == "" || strings.HasPrefix(segment, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUpdateByQuery rejects the updates by query of the indices with
+// encrypted fields that run a script, which may write plain values.
+func (e *fieldEncryption) checkUpdateByQuery(index string, body []byte) error {
+	if len(e.fieldsOf(index)) == 0 {
+		return nil
+	}
+	var update struct {
+		Script json.RawMessage `json:"script"`
+	}
+	if err := json.Unmarshal(body, &update); err != nil {
+		return &unencryptableError{index: index, reason: "the body isn't valid json"}
+	}
+	if len(update.Script) > 0 {
+		return &unencryptableError{index: index, reason: "the scripts aren't supported"}
+	}
+	return nil
+}
+
+// checkReindex rejects the reindexes into an index with encrypted fields
+// that run a script or copy the documents of an index without all of these
+// fields, whose values would be written plain.
+func (e *fieldEncryption) checkReindex(body []byte) error {
+	var reindex struct {
+		Source struct {
+			Index interface{} `json:"index"`
+		} `json:"source"`
+		Dest struct {
+			Index string `json:"index"`
+		} `json:"dest"`
+		Script json.RawMessage `json:"script"`
+	}
+	if err := json.Unmarshal(body, &reindex); err != nil {
+		return &unencryptableError{reason: "the body isn't valid json"}
+	}
+	fields := e.fieldsOf(reindex.Dest.Index)
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(reindex.Script) > 0 {
+		return &unencryptableError{index: reindex.Dest.Index, reason: "the scripts aren't supported"}
+	}
+	var sources []string
+	switch source := reindex.Source.Index.(type) {
+	case string:
+		sources = strings.Split(source, ",")
+	case []interface{}:
+		for _, index := range source {
+			name, _ := index.(string)
+			sources = append(sources, name)
+		}
+	}
+	if len(sources) == 0 {
+		return &unencryptableError{index: reindex.Dest.Index, reason: "the source index is missing"}
+	}
+	for _, source := range sources {
+		encrypted := make(map[string]bool)
+		for _, field := range e.fieldsOf(strings.TrimSpace(source)) {
+			encrypted[field] = true
+		}
+		for _, field := range fields {
+			if !encrypted[field] {
+				return &unencryptableError{index: reindex.Dest.Index, reason: fmt.Sprintf("the %q field of the source index %q isn't encrypted", field, source)}
+			}
+		}
+	}
+	return nil
+}
+
+// encryptDocument encrypts the fields of the index in the document, or in
+// the documents found under the given keys of the body, e.g. "doc" for the
+// updates.
+func (e *fieldEncryption) encryptDocument(index string, body []byte, keys ...string) ([]byte, error) {
+	fields := e.fieldsOf(index)
+	if len(fields) == 0 {
+		return body, nil
+	}
+	decoded, err := decodeJSON(body)
+	if err != nil {
+		return nil, &unencryptableError{index: index, reason: "the body isn't valid json"}
+	}
+	document, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, &unencryptableError{index: index, reason: "the document isn't a json object"}
+	}
+	sources := []map[string]interface{}{document}
+	if len(keys) > 0 {
+		if _, ok := document["script"]; ok {
+			return nil, &unencryptableError{index: index, reason: "the scripts aren't supported"}
+		}
+		sources = nil
+		for _, key := range keys {
+			if source, ok := document[key].(map[string]interface{}); ok {
+				sources = append(sources, source)
+			}
+		}
+	}
+	for _, source := range sources {
+		if err := e.encryptSource(source, fields); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(document)
+}
+
+// encryptBulk encrypts the documents of the index, create and update actions
+// of the bulk body, the index of each action defaults to the one in the path.
+func (e *fieldEncryption) encryptBulk(index string, body []byte) ([]byte, error) {
+	lines := bytes.Split(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		if len(bytes.TrimSpace(lines[i])) == 0 {
+			continue
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(lines[i], &action); err != nil {
+			return nil, &unencryptableError{reason: "the bulk actions aren't valid json"}
+		}
+		for name, meta := range action {
+			if name == "delete" || i+1 >= len(lines) {
+				continue
+			}
+			actionIndex := meta.Index
+			if actionIndex == "" {
+				actionIndex = index
+			}
+			var keys []string
+			if name == "update" {
+				keys = []string{"doc", "upsert"}
+			}
+			encrypted, err := e.encryptDocument(actionIndex, lines[i+1], keys...)
+			if err != nil {
+				return nil, err
+			}
+			lines[i+1] = encrypted
+			i++
+		}
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// decryptResponse decrypts the fields of every document, i.e. every object
+// with an "_index" and a "_source", found in the response body.
+func (e *fieldEncryption) decryptResponse(body []byte) ([]byte, error) {
+	if !bytes.Contains(body, []byte(encryptedPrefix)) {
+		return body, nil
+	}
+	decoded, err := decodeJSON(body)
+	if err != nil {
+		return body, nil
+	}
+	if err := e.decryptDocuments(decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
+
+func (e *fieldEncryption) decryptDocuments(value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		index, _ := v["_index"].(string)
+		if source, ok := v["_source"].(map[string]interface{}); ok && index != "" {
+			if err := e.decryptSource(source, e.fieldsOf(index)); err != nil {
+				return err
+			}
+		}
+		for _, child := range v {
+			if err := e.decryptDocuments(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if err := e.decryptDocuments(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeJSON(raw []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,148 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryption(t *testing.T) {
+	Convey("Field encryption", t, func() {
+		os.Setenv(envEncryptedFields, "patients:ssn,patients:address.zip,pat*:notes")
+		os.Setenv(envEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer os.Unsetenv(envEncryptedFields)
+		defer os.Unsetenv(envEncryptionKey)
+		es := &elasticsearch{}
+		So(es.initEncryption(), ShouldBeNil)
+
+		stored := make(map[string]json.RawMessage)
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			switch {
+			case r.URL.Path == "/_bulk":
+				lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+				stored["/patients/_doc/2"] = json.RawMessage(lines[1])
+				stored["/visits/_doc/1"] = json.RawMessage(lines[3])
+				w.Write([]byte(`{"errors":false}`))
+			case r.Method == http.MethodPut:
+				stored[r.URL.Path] = body
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"result":"created"}`))
+			case strings.HasSuffix(r.URL.Path, "/_search"):
+				w.Write([]byte(`{"hits":{"hits":[{"_index":"patients","_id":"1","_source":` + string(stored["/patients/_doc/1"]) + `}]}}`))
+			default:
+				w.Write([]byte(`{"_index":"patients","_id":"1","found":true,"_source":` + string(stored[r.URL.Path]) + `}`))
+			}
+		})
+		defer upstream.Close()
+
+		serve := func(method, template, url, body string, a acl.ACL, o op.Operation) string {
+			req := httptest.NewRequest(method, url, strings.NewReader(body))
+			return route(method, template, func(w http.ResponseWriter, r *http.Request) {
+				es.handler()(w, classified(r, category.Docs, a, o))
+			}, req).Body.String()
+		}
+		document := `{"name":"Jane","ssn":"123-45-6789","address":{"city":"Berlin","zip":10115},"notes":["allergic"]}`
+		serve(http.MethodPut, "/{index}/_doc/{id}", "/patients/_doc/1", document, acl.Doc, op.Write)
+
+		Convey("should store the configured fields encrypted", func() {
+			var source map[string]interface{}
+			So(json.Unmarshal(stored["/patients/_doc/1"], &source), ShouldBeNil)
+			So(source["name"], ShouldEqual, "Jane")
+			So(source["ssn"], ShouldStartWith, encryptedPrefix)
+			So(source["notes"], ShouldStartWith, encryptedPrefix)
+			address := source["address"].(map[string]interface{})
+			So(address["city"], ShouldEqual, "Berlin")
+			So(address["zip"], ShouldStartWith, encryptedPrefix)
+			So(string(stored["/patients/_doc/1"]), ShouldNotContainSubstring, "123-45-6789")
+		})
+		Convey("should decrypt the fields of the read documents", func() {
+			var res struct {
+				Source json.RawMessage `json:"_source"`
+			}
+			So(json.Unmarshal([]byte(serve(http.MethodGet, "/{index}/_doc/{id}", "/patients/_doc/1", "", acl.Doc, op.Read)), &res), ShouldBeNil)
+			So(string(res.Source), ShouldEqual, string(canonicalBody([]byte(document))))
+
+			search := serve(http.MethodPost, "/{index}/_search", "/patients/_search", "", acl.Search, op.Read)
+			So(search, ShouldContainSubstring, `"ssn":"123-45-6789"`)
+			So(search, ShouldContainSubstring, `"zip":10115`)
+		})
+		Convey("should decrypt the fields of the streamed documents", func() {
+			os.Setenv("ES_CLUSTER_URL", upstream.URL)
+			defer os.Unsetenv("ES_CLUSTER_URL")
+			es.streamedRoutes = map[string]bool{"/{index}/_search": true}
+			defer func() { es.streamedRoutes = nil }()
+			search := serve(http.MethodPost, "/{index}/_search", "/patients/_search", "", acl.Search, op.Read)
+			So(search, ShouldContainSubstring, `"ssn":"123-45-6789"`)
+		})
+		Convey("should keep the plain values out of the captures", func() {
+			es.captures = newCaptures(2, 1)
+			defer func() { es.captures = nil }()
+			serve(http.MethodPost, "/{index}/_search", "/patients/_search", `{"query":{"term":{"ssn":"123-45-6789"}}}`, acl.Search, op.Read)
+			serve(http.MethodPut, "/{index}/_doc/{id}", "/patients/_doc/3", document, acl.Doc, op.Write)
+			captured := es.captures.list()
+			So(captured, ShouldHaveLength, 2)
+			for _, c := range captured {
+				So(c.Body, ShouldNotContainSubstring, "123-45-6789")
+				So(c.Body, ShouldContainSubstring, `"ssn":"`+redacted+`"`)
+			}
+		})
+		Convey("should encrypt the documents of the bulk actions by index", func() {
+			bulk := `{"index":{"_index":"patients","_id":"2"}}` + "\n" +
+				`{"ssn":"987-65-4321"}` + "\n" +
+				`{"index":{"_index":"visits","_id":"1"}}` + "\n" +
+				`{"ssn":"987-65-4321"}` + "\n"
+			serve(http.MethodPost, "/_bulk", "/_bulk", bulk, acl.Bulk, op.Write)
+			So(string(stored["/patients/_doc/2"]), ShouldNotContainSubstring, "987-65-4321")
+			So(string(stored["/visits/_doc/1"]), ShouldEqual, `{"ssn":"987-65-4321"}`)
+		})
+		Convey("should encrypt the typed documents of es6", func() {
+			serve(http.MethodPut, "/{index}/{type}/{id}", "/patients/doc/4", `{"ssn":"111-22-3333"}`, acl.Doc, op.Write)
+			So(stored["/patients/doc/4"], ShouldNotBeEmpty)
+			So(string(stored["/patients/doc/4"]), ShouldNotContainSubstring, "111-22-3333")
+		})
+		Convey("should encrypt the documents written to an alias", func() {
+			saved := classify.GetAliasIndexCache()
+			classify.SetAliasIndexCache(map[string]string{"records": "patients"})
+			defer classify.SetAliasIndexCache(saved)
+			serve(http.MethodPut, "/{index}/_doc/{id}", "/records/_doc/5", `{"ssn":"111-22-3333"}`, acl.Doc, op.Write)
+			So(stored["/records/_doc/5"], ShouldNotBeEmpty)
+			So(string(stored["/records/_doc/5"]), ShouldNotContainSubstring, "111-22-3333")
+		})
+		Convey("should reject the writes whose documents can't be encrypted", func() {
+			write := func(method, template, url, body string) int {
+				req := httptest.NewRequest(method, url, strings.NewReader(body))
+				return route(method, template, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Docs, acl.Doc, op.Write))
+				}, req).Code
+			}
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/patients/_doc/6", `{"ssn":"111-22-3333"`), ShouldEqual, http.StatusBadRequest)
+			So(write(http.MethodPost, "/{index}/_update/{id}", "/patients/_update/1", `{"script":"ctx._source.ssn = '111-22-3333'"}`), ShouldEqual, http.StatusBadRequest)
+			So(write(http.MethodPost, "/{index}/_update_by_query", "/patients/_update_by_query", `{"script":"ctx._source.ssn = '111-22-3333'"}`), ShouldEqual, http.StatusBadRequest)
+			So(write(http.MethodPost, "/_reindex", "/_reindex", `{"source":{"index":"visits"},"dest":{"index":"patients"}}`), ShouldEqual, http.StatusBadRequest)
+			So(write(http.MethodPost, "/_bulk", "/_bulk", `{"index":`+"\n"+`{"ssn":"111-22-3333"}`+"\n"), ShouldEqual, http.StatusBadRequest)
+			So(stored, ShouldNotContainKey, "/patients/_doc/6")
+
+			// the writes to the other indices, or that write no plain value,
+			// are forwarded
+			So(write(http.MethodPost, "/{index}/_update_by_query", "/visits/_update_by_query", `{"script":"ctx._source.ssn = '111-22-3333'"}`), ShouldEqual, http.StatusOK)
+			So(write(http.MethodPost, "/{index}/_update_by_query", "/patients/_update_by_query", `{"query":{"match_all":{}}}`), ShouldEqual, http.StatusOK)
+			So(write(http.MethodPost, "/_reindex", "/_reindex", `{"source":{"index":"patients"},"dest":{"index":"patients-v2"}}`), ShouldEqual, http.StatusOK)
+		})
+		Convey("should reject the invalid keys", func() {
+			os.Setenv(envEncryptionKey, "c2hvcnQ=")
+			So((&elasticsearch{}).initEncryption(), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/response"
+	"github.com/gorilla/mux"
+)
+
+// existsCache caches the status of the HEAD requests checking whether a
+// document exists, found or not, which the apps often make before writing
+// the document. The checks are cheap for es but frequent, they are cached
+// for a short ttl and until the document is written.
+type existsCache struct {
+	ttl     time.Duration
+	entries *response.Cache
+}
+
+func (es *elasticsearch) initExistsCache() error {
+	value := os.Getenv(envExistsCacheTTL)
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	capacity := 0
+	if value := os.Getenv(envExistsCacheSize); value != "" {
+		capacity, err = strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+	}
+	es.existsCache = &existsCache{ttl: ttl, entries: response.NewCache(capacity)}
+	return nil
+}
+
+// existsCacheable checks whether the request checks the existence of a single
+// document, i.e. it is a HEAD of the docs category whose route has an {id}.
+func (es *elasticsearch) existsCacheable(r *http.Request, c category.Category, o op.Operation) bool {
+	return es.existsCache != nil && r.Method == http.MethodHead && o == op.Read && c == category.Docs &&
+		mux.Vars(r)["id"] != ""
+}
+
+// get returns the status cached against the key.
+func (e *existsCache) get(key string) (*response.CachedResponse, bool) {
+	return e.entries.Get(key)
+}
+
+// save caches the status of the existence check, the responses other than
+// found and not found, e.g. the errors, aren't cached.
+func (e *existsCache) save(r *http.Request, key string, code int, header http.Header) {
+	if code != http.StatusOK && code != http.StatusNotFound {
+		return
+	}
+	e.entries.Set(key, &response.CachedResponse{
+		Code:    code,
+		Header:  header,
+		Indices: documentTags(r),
+	}, e.ttl)
+}
+
+// invalidate removes the statuses of the documents the write may have
+// created or deleted.
+func (e *existsCache) invalidate(r *http.Request, indices []string) {
+	invalidateDocuments(e.entries, r, indices)
+}
@@ -0,0 +1,118 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// extractStep is a step of an extraction path, either the key of an object
+// or the index of an array.
+type extractStep struct {
+	key   string
+	index int
+}
+
+// parseExtractPath parses the path of the value to extract from a response,
+// made of dotted keys and array indices, e.g. "hits.hits[0]._source", with
+// an optional leading "$.".
+func parseExtractPath(path string) ([]extractStep, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, fmt.Errorf("expected a path such as hits.hits[0]._source")
+	}
+	var steps []extractStep
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		var indices []string
+		if open := strings.Index(part, "["); open >= 0 {
+			key = part[:open]
+			for rest := part[open:]; rest != ""; {
+				end := strings.Index(rest, "]")
+				if !strings.HasPrefix(rest, "[") || end < 0 {
+					return nil, fmt.Errorf("invalid array index in %q", part)
+				}
+				indices = append(indices, rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" && (len(steps) > 0 || len(indices) == 0) {
+			return nil, fmt.Errorf("empty key in %q", path)
+		}
+		if key != "" {
+			steps = append(steps, extractStep{key: key, index: -1})
+		}
+		for _, value := range indices {
+			index, err := strconv.Atoi(value)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid array index %q in %q", value, part)
+			}
+			steps = append(steps, extractStep{index: index})
+		}
+	}
+	return steps, nil
+}
+
+// extractPathError reports the path of the value to extract that isn't
+// found in the response.
+type extractPathError struct {
+	path string
+}
+
+func (e *extractPathError) Error() string {
+	return fmt.Sprintf(`path "%s" not found in the response`, e.path)
+}
+
+// extractDecodeError reports the response the value can't be extracted from,
+// which isn't valid json.
+type extractDecodeError struct {
+	err error
+}
+
+func (e *extractDecodeError) Error() string {
+	return fmt.Sprintf("the elasticsearch response isn't valid json: %v", e.err)
+}
+
+// extractJSON returns the value at the path of the json body, as json.
+func extractJSON(body []byte, path string) ([]byte, error) {
+	steps, err := parseExtractPath(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// the numbers are extracted as they were sent
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, &extractDecodeError{err: err}
+	}
+	var at string
+	for _, step := range steps {
+		if step.key != "" {
+			at = strings.TrimPrefix(at+"."+step.key, ".")
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, &extractPathError{path: at}
+			}
+			if value, ok = object[step.key]; !ok {
+				return nil, &extractPathError{path: at}
+			}
+			continue
+		}
+		at += "[" + strconv.Itoa(step.index) + "]"
+		array, ok := value.([]interface{})
+		if !ok || step.index >= len(array) {
+			return nil, &extractPathError{path: at}
+		}
+		value = array[step.index]
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -2,16 +2,26 @@ package elasticsearch
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/model/acl"
 	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/feature"
+	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/response"
+	"github.com/appbaseio/arc/plugins/logs"
 	"github.com/appbaseio/arc/util"
 	es7 "github.com/olivere/elastic/v7"
 )
@@ -41,57 +51,523 @@ func (es *elasticsearch) handler() http.HandlerFunc {
 			return
 		}
 		log.Println(logTag, ": category=", *reqCategory, ", acl=", *reqACL, ", op=", *reqOp)
-		// Forward the request to elasticsearch
-		esClient := util.GetClient7()
+		if es.indexStats != nil {
+			indices, _ := index.FromContext(ctx)
+			es.indexStats.record(indices, *reqOp)
+		}
+		// Forward the request to elasticsearch, reads and writes may be
+		// served by different clusters
+		esClient := util.GetWriteClient7()
+		if *reqOp == op.Read {
+			esClient = util.GetReadClient7()
+		}
+		// the admin users may route the request to a named cluster, whose
+		// responses are neither cached nor mirrored
+		upstream, code, err := namedUpstream(r)
+		if err != nil {
+			util.WriteBackError(w, err.Error(), code)
+			return
+		}
+		if upstream != nil {
+			esClient = upstream
+		}
 
 		// remove content-type header from r.Headers as that is internally managed my oliver
 		// and can give following error if passed `{"error":{"code":500,"message":"elastic: Error 400 (Bad Request): java.lang.IllegalArgumentException: only one Content-Type header should be provided [type=content_type_header_exception]","status":"Internal Server Error"}}`
+		// the sql results format is negotiated with the format param instead,
+		// the client always asks for json
+		sqlRequest := isSQLPath(r.URL.Path)
 		headers := http.Header{}
 		for k, v := range r.Header {
-			if k != "Content-Type" {
+			if sqlRequest && k == "Accept" {
+				continue
+			}
+			if k != "Content-Type" && !es.requestHeaderDenylist[k] {
 				headers.Set(k, v[0])
 			}
 		}
+		for k, v := range es.injectedHeaders {
+			headers.Set(k, v)
+		}
+		headers.Set(headerArcInstance, instanceID)
+		headers.Del(headerUpstream)
 
 		params := r.URL.Query()
+		for _, param := range gatewayParams {
+			params.Del(param)
+		}
+		if es.paramsAllowlist != nil {
+			for param := range params {
+				if !es.paramsAllowlist[param] {
+					log.Debugln(logTag, ": dropping query param", param, "missing from the allowlist")
+					params.Del(param)
+				}
+			}
+		}
 		formatParam := params.Get("format")
 		// need to add check for `strings.Contains(r.URL.Path, "_cat")` because
 		// ACL for root route `/` is also `Cat`.
 		if *reqACL == acl.Cat && strings.Contains(r.URL.Path, "_cat") && formatParam == "" {
 			params.Add("format", "text")
 		}
+		if sqlRequest && formatParam == "" {
+			if format := sqlFormat(r.Header.Get("Accept")); format != "" {
+				params.Set("format", format)
+			}
+		}
 
 		requestOptions := es7.PerformRequestOptions{
 			Method:  r.Method,
 			Path:    r.URL.Path,
 			Params:  params,
 			Headers: headers,
+			// the client consumes the body of the error responses, which
+			// must be passed through to the client as is
+			IgnoreErrors: errorStatusCodes,
 		}
 
 		// convert body to string string as oliver Perform request can accept io.Reader, String, interface
-		body, err := ioutil.ReadAll(r.Body)
+		var body []byte
+		if !util.IsBodyless(r) {
+			body, err = ioutil.ReadAll(r.Body)
+			if err != nil {
+				log.Errorln(logTag, ": error reading request body:", err)
+				util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+				return
+			}
+		}
+		if es.encryption != nil && *reqOp == op.Write && len(body) > 0 {
+			var writeIndex string
+			if indices := util.IndicesFromRequest(r); len(indices) == 1 {
+				writeIndex = indices[0]
+			}
+			body, err = es.encryption.encryptRequest(r.URL.Path, writeIndex, body)
+			if _, ok := err.(*unencryptableError); ok {
+				util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Errorln(logTag, ": error encrypting the request fields:", err)
+				util.WriteBackError(w, "error encrypting the document fields", http.StatusInternalServerError)
+				return
+			}
+		}
+		// the searches of the limited indices are bounded before anything
+		// else sees them, the cache included
+		if es.aggregationLimiter != nil && limitedSearch(r) && len(body) > 0 {
+			if limits, ok := es.aggregationLimiter.limitsFor(*reqCategory, util.IndicesFromRequest(r)); ok {
+				body, err = limits.limit(body)
+				if err != nil {
+					util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		}
 		if len(body) > 0 {
 			requestOptions.Body = string(body)
 		}
 
-		response, err := esClient.PerformRequest(ctx, requestOptions)
+		if es.captures != nil {
+			captured := body
+			if es.encryption != nil {
+				captured = es.encryption.redact(body)
+			}
+			es.captures.add(r, captured)
+		}
+
+		if es.bulkQueue != nil && upstream == nil && es.bulkQueue.handles(r) {
+			es.bulkQueue.accept(w, r, requestOptions)
+			return
+		}
+
+		// streamed responses are neither cached nor replayed
+		if upstream == nil && es.streams(r) {
+			release, ok := es.queued(ctx, w, *reqCategory, es.deadline(*reqCategory))
+			if !ok {
+				return
+			}
+			defer release()
+			code := es.stream(ctx, w, r, *reqOp, requestOptions)
+			if code >= 200 && code <= 299 && *reqOp != op.Read {
+				es.invalidateWritten(r, params)
+			}
+			return
+		}
+
+		idempotencyKey := es.idempotencyKey(r, *reqOp)
+		if idempotencyKey != "" {
+			if replayed, ok := response.PeekResponse(idempotencyKey); ok {
+				w.Header().Set(headerIdempotentReplayed, "true")
+				es.writeResponse(w, r, replayed.Code, replayed.Header, replayed.Body)
+				return
+			}
+			// the concurrent retries are told to retry once the write is done
+			if !es.idempotentWrites.begin(idempotencyKey) {
+				w.Header().Set("Retry-After", defaultRetryAfter)
+				util.WriteBackError(w, "a request with the same idempotency key is in progress", http.StatusConflict)
+				return
+			}
+			defer es.idempotentWrites.end(idempotencyKey)
+			// the write may have completed since the lookup
+			if replayed, ok := response.PeekResponse(idempotencyKey); ok {
+				w.Header().Set(headerIdempotentReplayed, "true")
+				es.writeResponse(w, r, replayed.Code, replayed.Header, replayed.Body)
+				return
+			}
+		}
 
-		// Copy the headers
-		for k, v := range response.Header {
-			if k != "Content-Length" {
-				w.Header().Set(k, v[0])
+		var key string
+		useCache := feature.Enabled(ctx, feature.Cache, true) && !bypassesCache(ctx) && upstream == nil
+		countCacheable := es.cachesCount(*reqACL, *reqOp) && useCache
+		cacheable := (es.cacheable(*reqCategory, *reqACL, *reqOp) || countCacheable) && useCache
+		// the reads that skip the cached responses still cache theirs
+		skipsCache := es.skipsCache(r)
+		if cacheable {
+			key = es.responseKey(r, *reqCategory, body)
+		}
+		if cacheable && !skipsCache {
+			if cached, ok := response.GetResponse(key); ok && fresh(r, cached) {
+				w.Header().Set(headerCache, cacheHit)
+				w.Header().Set(headerCacheAge, strconv.Itoa(int(time.Since(cached.SavedAt).Seconds())))
+				if notModified(w, r, cached) {
+					return
+				}
+				es.writeResponse(w, r, cached.Code, cached.Header, cached.Body)
+				return
+			}
+		}
+		// the existence checks are cached apart, found or not
+		existsCacheable := es.existsCacheable(r, *reqCategory, *reqOp) && useCache
+		negativeCacheable := es.negativeCacheable(r, *reqCategory, *reqOp) && useCache && !existsCacheable
+		if (negativeCacheable || existsCacheable) && key == "" {
+			key = es.responseKey(r, *reqCategory, body)
+		}
+		if existsCacheable && !skipsCache {
+			if cached, ok := es.existsCache.get(key); ok && fresh(r, cached) {
+				w.Header().Set(headerCache, cacheHit)
+				w.Header().Set(headerCacheAge, strconv.Itoa(int(time.Since(cached.SavedAt).Seconds())))
+				es.writeResponse(w, r, cached.Code, cached.Header, nil)
+				return
+			}
+		}
+		if negativeCacheable && !skipsCache {
+			if cached, ok := es.negativeCache.get(key); ok && fresh(r, cached) {
+				w.Header().Set(headerCache, cacheHit)
+				w.Header().Set(headerCacheAge, strconv.Itoa(int(time.Since(cached.SavedAt).Seconds())))
+				es.writeResponse(w, r, cached.Code, cached.Header, cached.Body)
+				return
 			}
 		}
-		w.Header().Set("X-Origin", "ES")
-		// Copy the status code
-		w.WriteHeader(response.StatusCode)
 
-		// Copy the body
-		io.Copy(w, bytes.NewReader(response.Body))
+		// the wait for a slot of the queue counts against the timeout
+		deadline := es.deadline(*reqCategory)
+		release, ok := es.queued(ctx, w, *reqCategory, deadline)
+		if !ok {
+			return
+		}
+
+		// the scrolls complete even if the client disconnects, to be cleared
+		var scrolled func(body []byte)
+		if es.scrollCleanup != nil && isScroll(r) {
+			ctx, scrolled = es.scrollCleanup.track(ctx, r)
+		}
+
+		if !deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+
+		esResponse, err := esClient.PerformRequest(ctx, requestOptions)
+		release()
+		if scrolled != nil {
+			var body []byte
+			if esResponse != nil {
+				body = esResponse.Body
+			}
+			scrolled(body)
+		}
 		if err != nil {
 			log.Errorln(logTag, ": error fetching response for", r.URL.Path, err)
-			util.WriteBackError(w, err.Error(), response.StatusCode)
+			// error responses from elasticsearch are passed through as is,
+			// we only need to bail out when there is no response at all
+			if esResponse == nil {
+				if ctx.Err() == context.DeadlineExceeded {
+					util.WriteBackError(w, "elasticsearch didn't respond in time", http.StatusGatewayTimeout)
+					return
+				}
+				util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// the scroll ids are only known to the primary cluster
+		if es.mirror != nil && upstream == nil && *reqOp == op.Read && !isScroll(r) {
+			es.mirror.send(requestOptions, esResponse.StatusCode, esResponse.Body)
+		}
+
+		// surface the backpressure, e.g. bulk rejections, to the client
+		if esResponse.StatusCode == http.StatusTooManyRequests {
+			retryAfter(esResponse.Header)
+		}
+		if es.tooLargeHint != nil && esResponse.StatusCode == http.StatusRequestEntityTooLarge {
+			esResponse.Body = es.tooLargeHint.explain(len(body), esResponse.Header.Get("Content-Type"), esResponse.Body)
+			esResponse.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+
+		success := esResponse.StatusCode >= 200 && esResponse.StatusCode <= 299
+		// partial results are successful responses, flag the degraded searches
+		if es.reportShardFailures && success && (*reqACL == acl.Search || *reqACL == acl.Msearch) {
+			if failed := shardFailures(esResponse.Body); failed > 0 {
+				log.Warnln(logTag, ":", failed, "shards failed to execute the search", r.URL.Path)
+				esResponse.Header.Set(headerShardFailures, strconv.Itoa(failed))
+			}
+		}
+		// so are the bulks some items failed of, es responds with a 200
+		if es.summarizeBulkErrors && success && *reqACL == acl.Bulk {
+			if failed, summary := bulkErrors(esResponse.Body); failed > 0 {
+				log.Warnln(logTag, ": bulk request", r.URL.Path, summary)
+				esResponse.Header.Set(headerBulkErrors, strconv.Itoa(failed))
+			}
+		}
+		if cacheable && success {
+			cached := &response.CachedResponse{
+				Code:    esResponse.StatusCode,
+				Header:  esResponse.Header,
+				Body:    esResponse.Body,
+				Indices: es.cachedIndices(ctx),
+				ETag:    bodyETag(esResponse.Body),
+			}
+			ttl := es.cache.ttl
+			if countCacheable {
+				if cached.Indices[0] != "_all" {
+					cached.Indices = append(countIndices(cached.Indices), countMarker)
+				}
+				ttl = es.cache.countTTL
+			}
+			store := true
+			if es.cache.honorCacheControl {
+				ttl, store = cacheControlTTL(esResponse.Header, ttl)
+			}
+			if store && *reqACL == acl.Search {
+				ttl, store = es.cache.emptyResultsTTL(esResponse.Body, ttl)
+			}
+			if store {
+				response.SaveResponse(key, cached, ttl)
+				w.Header().Set(headerETag, cached.ETag)
+			}
+		}
+		if success && *reqOp != op.Read {
+			es.invalidateWritten(r, params)
+		}
+		if negativeCacheable && esResponse.StatusCode == http.StatusNotFound {
+			es.negativeCache.save(r, key, &response.CachedResponse{
+				Code:   esResponse.StatusCode,
+				Header: esResponse.Header,
+				Body:   esResponse.Body,
+			})
+		}
+		if existsCacheable {
+			es.existsCache.save(r, key, esResponse.StatusCode, esResponse.Header)
+		}
+		if cacheable || negativeCacheable || existsCacheable {
+			w.Header().Set(headerCache, cacheMiss)
+		}
+		// server errors aren't replayed so that the write can be retried
+		if idempotencyKey != "" && esResponse.StatusCode < http.StatusInternalServerError {
+			response.SaveResponse(idempotencyKey, &response.CachedResponse{
+				Code:   esResponse.StatusCode,
+				Header: esResponse.Header,
+				Body:   esResponse.Body,
+			}, es.idempotencyTTL)
+		}
+
+		// Copy the body, partial results (e.g. "timed_out": true) are
+		// successful responses and get forwarded unchanged
+		es.writeResponse(w, r, esResponse.StatusCode, esResponse.Header, esResponse.Body)
+	}
+}
+
+// invalidateWritten removes the cached responses the successful write has
+// made stale.
+func (es *elasticsearch) invalidateWritten(r *http.Request, params url.Values) {
+	indices, _ := index.FromContext(r.Context())
+	var written []string
+	if es.cache != nil {
+		written = es.writtenIndices(r.Context())
+	}
+	// the refreshed writes are visible to the reads right away, the
+	// responses cached for the written indices are stale
+	if es.cache != nil && refreshes(params) {
+		response.InvalidateIndices(written)
+	}
+	// any write changes the counts of its indices, refreshed or not
+	if es.cache != nil && es.cache.countTTL > 0 {
+		response.InvalidateIndices(countIndices(written))
+	}
+	// the write may have created a document known to be missing
+	if es.negativeCache != nil {
+		es.negativeCache.invalidate(r, indices)
+	}
+	// or created or deleted a document whose existence was checked
+	if es.existsCache != nil {
+		es.existsCache.invalidate(r, indices)
+	}
+}
+
+// writeResponse writes back the elasticsearch response, minus the denylisted
+// headers, transformed by the pipeline of the request's indices.
+func (es *elasticsearch) writeResponse(w http.ResponseWriter, r *http.Request, code int, header http.Header, body []byte) {
+	// the documents are transformed once decrypted
+	if es.encryption != nil {
+		decrypted, err := es.encryption.decryptResponse(body)
+		if err != nil {
+			log.Errorln(logTag, ": error decrypting the response fields:", err)
+		} else {
+			body = decrypted
+		}
+	}
+	if es.transforms != nil && code >= 200 && code <= 299 {
+		transformed, err := es.transforms.transform(util.IndicesFromRequest(r), body)
+		if err != nil {
+			// the untransformed response may expose redacted fields
+			log.Errorln(logTag, ": error transforming the response:", err)
+			util.WriteBackError(w, "error transforming the response", http.StatusInternalServerError)
+			return
+		}
+		body = transformed
+	}
+	// Copy the headers
+	for k, v := range header {
+		if k != "Content-Length" && !es.responseHeaderDenylist[k] {
+			w.Header().Set(k, v[0])
+		}
+	}
+	if es.wrapNonJSONErrors && code >= http.StatusBadRequest && len(body) > 0 &&
+		!util.IsJSONContentType(header.Get("Content-Type")) {
+		body = wrapError(code, header.Get("Content-Type"), body)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.Header().Set("X-Origin", "ES")
+	// Copy the status code
+	w.WriteHeader(code)
+	io.Copy(w, bytes.NewReader(body))
+}
+
+// wrapError wraps the non-json error body in arc's error envelope, the
+// original body is kept as a string.
+func wrapError(code int, contentType string, body []byte) []byte {
+	errBody := util.ErrorBody("elasticsearch responded with a non-json error", code)
+	if errObject, ok := errBody["error"].(map[string]interface{}); ok {
+		errObject["upstream_content_type"] = contentType
+		errObject["upstream_body"] = string(body)
+	}
+	raw, err := json.Marshal(errBody)
+	if err != nil {
+		return body
+	}
+	return raw
+}
+
+func (es *elasticsearch) healthHandler() http.HandlerFunc {
+	return es.health(false)
+}
+
+// healthDetailsHandler reports the outcome of each health check, errors
+// included, and the stats of the enabled features, which the anonymous
+// callers of the health endpoint don't get.
+func (es *elasticsearch) healthDetailsHandler() http.HandlerFunc {
+	return es.health(true)
+}
+
+func (es *elasticsearch) health(details bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := map[string]interface{}{
+			"status": "ok",
+			"logs": map[string]interface{}{
+				"sample_rate": logs.Instance().SampleRate(),
+			},
+		}
+		// the internal stats are only reported to the admins
+		if details {
+			es.healthStats(health)
+		}
+		code := http.StatusOK
+		var failing []string
+		if len(es.healthChecks) > 0 {
+			var checks map[string]dependencyHealth
+			checks, failing = es.cachedHealth()
+			if details {
+				health["checks"] = checks
+			}
+		}
+		if es.versionCheck != nil {
+			if es.versionCheck.mismatched() {
+				failing = append(failing, "elasticsearch_version")
+			}
+		}
+		if len(failing) > 0 {
+			log.Errorln(logTag, ": unhealthy dependencies:", failing)
+			health["status"] = "degraded"
+			if details {
+				health["failing"] = failing
+				health["message"] = "unhealthy dependencies: " + strings.Join(failing, ", ")
+			}
+			code = http.StatusServiceUnavailable
+		}
+		raw, err := json.Marshal(health)
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling health:", err)
+			util.WriteBackError(w, "error reporting health", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, code)
+	}
+}
+
+// healthStats adds the stats of the enabled features to the health report.
+func (es *elasticsearch) healthStats(health map[string]interface{}) {
+	if es.aliasCache != nil {
+		health["alias_cache"] = es.aliasCache.stats()
+	}
+	if es.loadShedder != nil {
+		health["load_shedding"] = es.loadShedder.stats()
+	}
+	if es.scrollCleanup != nil {
+		health["scroll_cleanup"] = es.scrollCleanup.stats()
+	}
+	if es.requestQueue != nil {
+		health["request_queue"] = es.requestQueue.stats()
+	}
+	if es.mirror != nil {
+		health["request_mirror"] = es.mirror.stats()
+	}
+	if es.cache != nil {
+		cache := response.ResponseCache()
+		health["response_cache"] = map[string]interface{}{
+			"entries":   cache.Len(),
+			"size":      cache.Capacity(),
+			"bytes":     cache.Bytes(),
+			"max_bytes": cache.MaxBytes(),
+		}
+	}
+	if es.versionCheck != nil {
+		health["version_check"] = es.versionCheck.stats()
+	}
+}
+
+func (es *elasticsearch) versionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw, err := json.Marshal(map[string]string{
+			"arc_version": util.Version,
+			"go_version":  runtime.Version(),
+			"es_version":  util.CachedSemanticVersion(),
+		})
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling version:", err)
+			util.WriteBackError(w, "error reporting version", http.StatusInternalServerError)
 			return
 		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
 	}
 }
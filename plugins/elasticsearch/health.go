@@ -0,0 +1,148 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/response"
+	"github.com/appbaseio/arc/plugins/logs"
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+const (
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+)
+
+// the dependencies whose health can be checked, by name
+var healthChecks = map[string]func(ctx context.Context) error{
+	"elasticsearch": checkElasticsearch,
+	"cache":         checkCache,
+	"logs":          logs.Instance().CheckWrite,
+}
+
+func (es *elasticsearch) initHealthChecks() error {
+	for _, name := range envList(envHealthChecks) {
+		if _, ok := healthChecks[name]; !ok {
+			return fmt.Errorf("%s: unknown health check %q", envHealthChecks, name)
+		}
+		es.healthChecks = append(es.healthChecks, name)
+	}
+	es.healthCheckTimeout = defaultHealthCheckTimeout
+	if value := os.Getenv(envHealthCheckTimeout); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		es.healthCheckTimeout = timeout
+	}
+	es.healthCheckInterval = defaultHealthCheckInterval
+	if value := os.Getenv(envHealthCheckInterval); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		es.healthCheckInterval = interval
+	}
+	return nil
+}
+
+// checkElasticsearch pings the clusters the requests are forwarded to.
+func checkElasticsearch(ctx context.Context) error {
+	read, write := util.GetReadClient7(), util.GetWriteClient7()
+	clients := []*es7.Client{read}
+	if write != read {
+		clients = append(clients, write)
+	}
+	for _, client := range clients {
+		if _, err := client.PerformRequest(ctx, es7.PerformRequestOptions{
+			Method: http.MethodHead,
+			Path:   "/",
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCache checks that the response cache is usable.
+func checkCache(ctx context.Context) error {
+	cache := response.ResponseCache()
+	if cache == nil {
+		return errors.New("response cache isn't set up")
+	}
+	return cache.Check(ctx)
+}
+
+// dependencyHealth is the outcome of a health check.
+type dependencyHealth struct {
+	Status string `json:"status"`
+	Took   int64  `json:"took_ms"`
+	Error  string `json:"error,omitempty"`
+}
+
+// checkHealth runs the configured health checks concurrently and returns
+// their outcome by dependency, along with the names of the failing ones.
+func (es *elasticsearch) checkHealth(ctx context.Context) (map[string]dependencyHealth, []string) {
+	ctx, cancel := context.WithTimeout(ctx, es.healthCheckTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]dependencyHealth)
+	failing := []string{}
+	for _, name := range es.healthChecks {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			start := time.Now()
+			err := healthChecks[name](ctx)
+			result := dependencyHealth{Status: "ok", Took: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if err != nil {
+				failing = append(failing, name)
+			}
+		}(name)
+	}
+	wg.Wait()
+	sort.Strings(failing)
+	return results, failing
+}
+
+// healthResults caches the outcome of the health checks, so that the
+// unauthenticated health requests don't each reach the dependencies.
+type healthResults struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	checks    map[string]dependencyHealth
+	failing   []string
+}
+
+// cachedHealth returns the outcome of the health checks, which are run again
+// once older than the interval. The concurrent requests wait for the same
+// run, which is bounded by the health check timeout.
+func (es *elasticsearch) cachedHealth() (map[string]dependencyHealth, []string) {
+	results := &es.healthResults
+	results.mu.Lock()
+	defer results.mu.Unlock()
+	if results.checks == nil || time.Since(results.checkedAt) >= es.healthCheckInterval {
+		// not bound to the request, whose client may go away before the
+		// checks complete
+		results.checks, results.failing = es.checkHealth(context.Background())
+		results.checkedAt = time.Now()
+	}
+	return results.checks, append([]string{}, results.failing...)
+}
@@ -0,0 +1,121 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/response"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHealthChecks(t *testing.T) {
+	Convey("Health checks", t, func() {
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`{}`))
+		})
+		defer upstream.Close()
+		response.SetResponseCache(response.NewCache(10))
+
+		type health struct {
+			Status  string                      `json:"status"`
+			Checks  map[string]dependencyHealth `json:"checks"`
+			Failing []string                    `json:"failing"`
+			Message string                      `json:"message"`
+		}
+		get := func(h http.HandlerFunc) (int, health) {
+			resp := httptest.NewRecorder()
+			h(resp, httptest.NewRequest(http.MethodGet, "/_arc/health", nil))
+			var body health
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			return resp.Code, body
+		}
+		check := func(checks ...string) (int, health) {
+			es := &elasticsearch{healthChecks: checks, healthCheckTimeout: time.Second}
+			return get(es.healthDetailsHandler())
+		}
+
+		Convey("Healthy dependencies", func() {
+			code, body := check("elasticsearch", "cache")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body.Status, ShouldEqual, "ok")
+			So(body.Checks["elasticsearch"].Status, ShouldEqual, "ok")
+			So(body.Checks["cache"].Status, ShouldEqual, "ok")
+			So(body.Failing, ShouldBeEmpty)
+		})
+		Convey("Elasticsearch unreachable", func() {
+			upstream.Close()
+			code, body := check("elasticsearch", "cache")
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(body.Status, ShouldEqual, "degraded")
+			So(body.Failing, ShouldResemble, []string{"elasticsearch"})
+			So(body.Message, ShouldContainSubstring, "elasticsearch")
+			So(body.Checks["elasticsearch"].Error, ShouldNotBeEmpty)
+			So(body.Checks["cache"].Status, ShouldEqual, "ok")
+		})
+		Convey("Cache unusable", func() {
+			response.SetResponseCache(nil)
+			defer response.SetResponseCache(response.NewCache(10))
+			code, body := check("elasticsearch", "cache")
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(body.Failing, ShouldResemble, []string{"cache"})
+			So(body.Checks["elasticsearch"].Status, ShouldEqual, "ok")
+		})
+		Convey("Logs index unwritable", func() {
+			// the logs plugin isn't initialized, so its index can't be written
+			code, body := check("elasticsearch", "logs")
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(body.Failing, ShouldResemble, []string{"logs"})
+			So(body.Message, ShouldEqual, "unhealthy dependencies: logs")
+			So(body.Checks["elasticsearch"].Status, ShouldEqual, "ok")
+		})
+		Convey("Anonymous callers only get the status", func() {
+			upstream.Close()
+			es := &elasticsearch{healthChecks: []string{"elasticsearch"}, healthCheckTimeout: time.Second,
+				cache: &cacheConfig{ttl: time.Minute}}
+			code, body := get(es.healthHandler())
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(body.Status, ShouldEqual, "degraded")
+			So(body.Checks, ShouldBeNil)
+			So(body.Failing, ShouldBeEmpty)
+			So(body.Message, ShouldBeEmpty)
+
+			// nor the stats of the features
+			resp := httptest.NewRecorder()
+			es.healthHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/health", nil))
+			var fields map[string]interface{}
+			So(json.Unmarshal(resp.Body.Bytes(), &fields), ShouldBeNil)
+			So(fields, ShouldResemble, map[string]interface{}{
+				"status": "degraded",
+				// the effective log sampling rate isn't internal
+				"logs": map[string]interface{}{"sample_rate": float64(0)},
+			})
+			resp = httptest.NewRecorder()
+			es.healthDetailsHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/health/details", nil))
+			So(json.Unmarshal(resp.Body.Bytes(), &fields), ShouldBeNil)
+			So(fields, ShouldContainKey, "response_cache")
+		})
+		Convey("The outcome is reused for the interval", func() {
+			es := &elasticsearch{healthChecks: []string{"elasticsearch"}, healthCheckTimeout: time.Second,
+				healthCheckInterval: time.Minute}
+			code, _ := get(es.healthHandler())
+			So(code, ShouldEqual, http.StatusOK)
+			upstream.Close()
+			code, _ = get(es.healthHandler())
+			So(code, ShouldEqual, http.StatusOK)
+			es.healthCheckInterval = 0
+			code, _ = get(es.healthHandler())
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+		Convey("No checks configured", func() {
+			upstream.Close()
+			code, body := check()
+			So(code, ShouldEqual, http.StatusOK)
+			So(body.Checks, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,87 @@
+package elasticsearch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/op"
+)
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	// set on the responses replayed for a known idempotency key
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	// prefix of the idempotency entries in the shared response cache
+	idempotencyKeyPrefix = "idempotency:"
+)
+
+func (es *elasticsearch) initIdempotency() error {
+	value := os.Getenv(envIdempotencyTTL)
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	es.idempotencyTTL = ttl
+	return nil
+}
+
+// idempotencyKey returns the key against which the response of the write is
+// cached, or an empty string if the request isn't idempotent. The client's key
+// is scoped to the authenticated principal, method and path so that it can't
+// be used to replay the response of another client or of a different request.
+// The requests whose principal isn't known are never replayed.
+func (es *elasticsearch) idempotencyKey(r *http.Request, o op.Operation) string {
+	if es.idempotencyTTL == 0 || (o != op.Write && o != op.Delete) {
+		return ""
+	}
+	key := r.Header.Get(headerIdempotencyKey)
+	if key == "" {
+		return ""
+	}
+	principal, err := credential.PrincipalFromContext(r.Context())
+	if err != nil || principal == "" {
+		return ""
+	}
+	hash := sha256.New()
+	for _, part := range []string{principal, r.Method, r.URL.Path, key} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return idempotencyKeyPrefix + hex.EncodeToString(hash.Sum(nil))
+}
+
+// inFlightKeys are the idempotency keys of the writes being forwarded, so
+// that a concurrent retry isn't forwarded a second time.
+type inFlightKeys struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// begin marks the key in flight, false if it already is.
+func (k *inFlightKeys) begin(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys[key] {
+		return false
+	}
+	if k.keys == nil {
+		k.keys = make(map[string]bool)
+	}
+	k.keys[key] = true
+	return true
+}
+
+// end marks the write of the key as complete.
+func (k *inFlightKeys) end(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, key)
+}
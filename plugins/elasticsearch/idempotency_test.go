@@ -0,0 +1,107 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/response"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdempotency(t *testing.T) {
+	Convey("Idempotency", t, func() {
+		var hits int
+		var release chan struct{}
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			hits++
+			if release != nil {
+				<-release
+			}
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"_id":"abc","result":"created"}`))
+		})
+		defer upstream.Close()
+		response.SetResponseCache(response.NewCache(10))
+		es := &elasticsearch{idempotencyTTL: time.Minute}
+
+		index := func(key, principal string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/foo/_doc", strings.NewReader(`{"title":"arc"}`))
+			if key != "" {
+				req.Header.Set(headerIdempotencyKey, key)
+			}
+			req = classified(req, category.Docs, acl.Index, op.Write)
+			if principal != "" {
+				req = req.WithContext(credential.NewPrincipalContext(req.Context(), principal))
+			}
+			resp := httptest.NewRecorder()
+			es.handler()(resp, req)
+			return resp
+		}
+
+		Convey("Replayed writes are forwarded once", func() {
+			first := index("8e03978e", "alice")
+			second := index("8e03978e", "alice")
+			So(hits, ShouldEqual, 1)
+			So(second.Code, ShouldEqual, http.StatusCreated)
+			So(second.Body.String(), ShouldEqual, first.Body.String())
+			So(second.Header().Get(headerIdempotentReplayed), ShouldEqual, "true")
+			So(first.Header().Get(headerIdempotentReplayed), ShouldBeEmpty)
+		})
+		Convey("Keys are scoped to the principal", func() {
+			index("8e03978e", "alice")
+			index("8e03978e", "bob")
+			// e.g. the subjects of JWTs sharing a role
+			index("8e03978e", "jwt:carol")
+			index("8e03978e", "jwt:dave")
+			So(hits, ShouldEqual, 4)
+		})
+		Convey("Writes without a principal are always forwarded", func() {
+			index("8e03978e", "")
+			index("8e03978e", "")
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Concurrent retries aren't forwarded", func() {
+			release = make(chan struct{})
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- index("8e03978e", "alice") }()
+			for {
+				es.idempotentWrites.mu.Lock()
+				inFlight := len(es.idempotentWrites.keys)
+				es.idempotentWrites.mu.Unlock()
+				if inFlight == 1 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			retry := index("8e03978e", "alice")
+			So(retry.Code, ShouldEqual, http.StatusConflict)
+			So(retry.Header().Get("Retry-After"), ShouldNotBeEmpty)
+			close(release)
+			So((<-done).Code, ShouldEqual, http.StatusCreated)
+			So(hits, ShouldEqual, 1)
+			// the retry is replayed once the write is done
+			So(index("8e03978e", "alice").Header().Get(headerIdempotentReplayed), ShouldEqual, "true")
+			So(hits, ShouldEqual, 1)
+		})
+		Convey("Writes without a key are always forwarded", func() {
+			index("", "alice")
+			index("", "alice")
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Keys are ignored when idempotency is disabled", func() {
+			es.idempotencyTTL = 0
+			index("8e03978e", "alice")
+			index("8e03978e", "alice")
+			So(hits, ShouldEqual, 2)
+		})
+	})
+}
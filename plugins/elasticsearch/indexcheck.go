@@ -0,0 +1,205 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+const (
+	defaultIndexCheckTTL = 30 * time.Second
+	maxIndexSuggestions  = 3
+)
+
+// indexCheck verifies that the indices targeted by the read requests exist,
+// against a list of the cluster's indices and aliases refreshed every ttl.
+type indexCheck struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	names     map[string]bool
+	fetchedAt time.Time
+}
+
+func (es *elasticsearch) initIndexCheck() error {
+	if os.Getenv(envIndexCheck) != "true" {
+		return nil
+	}
+	ttl := defaultIndexCheckTTL
+	if value := os.Getenv(envIndexCheckTTL); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		ttl = parsed
+	}
+	es.indexCheck = &indexCheck{ttl: ttl}
+	return nil
+}
+
+// indices returns the names of the cluster's indices and aliases, listed
+// again if they are older than the ttl or if refresh is set. The lock isn't
+// held while elasticsearch lists them.
+func (c *indexCheck) indices(ctx context.Context, refresh bool) (map[string]bool, error) {
+	c.mu.Lock()
+	names, fetchedAt := c.names, c.fetchedAt
+	c.mu.Unlock()
+	if !refresh && names != nil && time.Since(fetchedAt) < c.ttl {
+		return names, nil
+	}
+	names = make(map[string]bool)
+	for path, column := range map[string]string{"/_cat/indices": "index", "/_cat/aliases": "alias"} {
+		res, err := util.GetReadClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+			Method: http.MethodGet,
+			Path:   path,
+			Params: map[string][]string{"format": {"json"}, "h": {column}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var rows []map[string]string
+		if err := json.Unmarshal(res.Body, &rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			for _, name := range row {
+				names[name] = true
+			}
+		}
+	}
+	c.mu.Lock()
+	c.names = names
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+	return names, nil
+}
+
+// missingIndices returns the concrete index names, i.e. not patterns or exclusions,
+// that don't exist in the cluster.
+func missingIndices(targets []string, names map[string]bool) []string {
+	var missing []string
+	for _, target := range targets {
+		if target == "" || target == "_all" || strings.Contains(target, "*") ||
+			strings.HasPrefix(target, "-") || strings.HasPrefix(target, "<") {
+			continue
+		}
+		if !names[target] {
+			missing = append(missing, target)
+		}
+	}
+	return missing
+}
+
+// accessibleIndices returns whether the credential of the request may
+// access an index, none may without a credential.
+func accessibleIndices(ctx context.Context) func(index string) bool {
+	reqCredential, err := credential.FromContext(ctx)
+	if err != nil {
+		return func(string) bool { return false }
+	}
+	return func(index string) bool {
+		switch reqCredential {
+		case credential.User:
+			reqUser, err := user.FromContext(ctx)
+			if err != nil {
+				return false
+			}
+			ok, err := reqUser.CanAccessIndex(index)
+			return ok && err == nil
+		case credential.Permission:
+			reqPermission, err := permission.FromContext(ctx)
+			if err != nil {
+				return false
+			}
+			ok, err := reqPermission.CanAccessIndex(index)
+			return ok && err == nil
+		}
+		return false
+	}
+}
+
+// suggestIndices returns the names closest to the missing index, among the
+// accessible ones so that the other indices aren't disclosed.
+func suggestIndices(missing string, names map[string]bool, accessible func(index string) bool) []string {
+	maxDistance := len(missing) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	distances := make(map[string]int)
+	var suggestions []string
+	for name := range names {
+		if strings.HasPrefix(name, ".") || !accessible(name) {
+			continue
+		}
+		if d := util.Levenshtein(missing, name); d <= maxDistance {
+			distances[name] = d
+			suggestions = append(suggestions, name)
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if distances[suggestions[i]] == distances[suggestions[j]] {
+			return suggestions[i] < suggestions[j]
+		}
+		return distances[suggestions[i]] < distances[suggestions[j]]
+	})
+	if len(suggestions) > maxIndexSuggestions {
+		suggestions = suggestions[:maxIndexSuggestions]
+	}
+	return suggestions
+}
+
+// checkIndices rejects the read requests targeting a missing index with a 404
+// that names the index and suggests the closest existing ones.
+func (es *elasticsearch) checkIndices(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if es.indexCheck == nil {
+			h(w, req)
+			return
+		}
+		reqOp, err := op.FromContext(req.Context())
+		if err != nil || *reqOp != op.Read {
+			h(w, req)
+			return
+		}
+		targets := util.IndicesFromRequest(req)
+		if len(targets) == 0 {
+			h(w, req)
+			return
+		}
+		names, err := es.indexCheck.indices(req.Context(), false)
+		if err == nil && len(missingIndices(targets, names)) > 0 {
+			// the indices may have been created since they were listed
+			names, err = es.indexCheck.indices(req.Context(), true)
+		}
+		if err != nil {
+			// let elasticsearch answer if the indices can't be listed
+			log.Errorln(logTag, ": unable to list the indices for the existence check:", err)
+			h(w, req)
+			return
+		}
+		missing := missingIndices(targets, names)
+		if len(missing) == 0 {
+			h(w, req)
+			return
+		}
+		msg := fmt.Sprintf(`index "%s" does not exist`, missing[0])
+		if suggestions := suggestIndices(missing[0], names, accessibleIndices(req.Context())); len(suggestions) > 0 {
+			msg += fmt.Sprintf(`, did you mean "%s"?`, strings.Join(suggestions, `", "`))
+		}
+		util.WriteBackError(w, msg, http.StatusNotFound)
+	}
+}
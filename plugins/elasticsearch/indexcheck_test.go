@@ -0,0 +1,128 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/user"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexCheck(t *testing.T) {
+	Convey("Index existence check", t, func() {
+		var listings int
+		indices := `[{"index":"products"},{"index":"orders"},{"index":".logs"}]`
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			switch r.URL.Path {
+			case "/_cat/indices":
+				listings++
+				w.Write([]byte(indices))
+			case "/_cat/aliases":
+				w.Write([]byte(`[{"alias":"catalog"}]`))
+			default:
+				w.Write([]byte(`{}`))
+			}
+		})
+		defer upstream.Close()
+		es := &elasticsearch{indexCheck: &indexCheck{ttl: time.Minute}}
+
+		patterns := []string{"*"}
+		search := func(path string, o op.Operation) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			ctx := credential.NewContext(req.Context(), credential.User)
+			req = req.WithContext(user.NewContext(ctx, &user.User{Username: "foo", Indices: patterns}))
+			return route(http.MethodGet, "/{index}/_search", func(w http.ResponseWriter, r *http.Request) {
+				es.checkIndices(es.handler())(w, classified(r, category.Search, acl.Search, o))
+			}, req)
+		}
+
+		Convey("Missing indices get a friendly 404", func() {
+			resp := search("/prodcts/_search", op.Read)
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldContainSubstring, `index \"prodcts\" does not exist`)
+			So(resp.Body.String(), ShouldContainSubstring, `did you mean \"products\"?`)
+		})
+		Convey("Only the accessible indices are suggested", func() {
+			patterns = []string{"orders"}
+			resp := search("/prodcts/_search", op.Read)
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldNotContainSubstring, "did you mean")
+		})
+		Convey("The indices are listed again before a miss is rejected", func() {
+			So(search("/products/_search", op.Read).Code, ShouldEqual, http.StatusOK)
+			indices = `[{"index":"products"},{"index":"invoices"}]`
+			So(search("/invoices/_search", op.Read).Code, ShouldEqual, http.StatusOK)
+			So(listings, ShouldEqual, 2)
+		})
+		Convey("Existing indices, aliases and patterns pass", func() {
+			So(search("/products/_search", op.Read).Code, ShouldEqual, http.StatusOK)
+			So(search("/catalog,orders/_search", op.Read).Code, ShouldEqual, http.StatusOK)
+			So(search("/prod*/_search", op.Read).Code, ShouldEqual, http.StatusOK)
+			// the index list is cached
+			So(listings, ShouldEqual, 1)
+		})
+		Convey("Writes are not checked", func() {
+			So(search("/new-index/_search", op.Write).Code, ShouldEqual, http.StatusOK)
+			So(listings, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Auto-created indices", t, func() {
+		var checks, writes int
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				checks++
+				if r.URL.Path != "/products" {
+					w.WriteHeader(http.StatusNotFound)
+				}
+				return
+			}
+			writes++
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"result":"created"}`))
+		})
+		defer upstream.Close()
+		es := &elasticsearch{autoCreateBlock: &autoCreateBlock{
+			allowed:  []string{"logs-*"},
+			ttl:      time.Minute,
+			existing: make(map[string]time.Time),
+		}}
+		serve := func(method, template, path string) *httptest.ResponseRecorder {
+			return route(method, template, func(w http.ResponseWriter, r *http.Request) {
+				es.blockAutoCreate(es.handler())(w, classified(r, category.Docs, acl.Index, op.Write))
+			}, httptest.NewRequest(method, path, strings.NewReader(`{"title":"arc"}`)))
+		}
+		index := func(path string) *httptest.ResponseRecorder {
+			return serve(http.MethodPut, "/{index}/_doc/{id}", path)
+		}
+
+		Convey("the writes to a missing index are blocked", func() {
+			resp := index("/prodcts/_doc/1")
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldContainSubstring, `index \"prodcts\" does not exist`)
+			So(writes, ShouldEqual, 0)
+		})
+		Convey("the writes to the allowed or existing indices pass", func() {
+			So(index("/logs-2026.10.15/_doc/1").Code, ShouldEqual, http.StatusCreated)
+			So(checks, ShouldEqual, 0)
+			So(index("/products/_doc/1").Code, ShouldEqual, http.StatusCreated)
+			So(index("/products/_doc/2").Code, ShouldEqual, http.StatusCreated)
+			// the existing index is remembered
+			So(checks, ShouldEqual, 1)
+			So(writes, ShouldEqual, 3)
+		})
+		Convey("the indices can be created explicitly", func() {
+			So(serve(http.MethodPut, "/{index}", "/orders").Code, ShouldEqual, http.StatusCreated)
+			So(checks, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,116 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+)
+
+const (
+	defaultMaxTrackedIndices = 1000
+	// requests that don't target specific indices
+	allIndices = "_all"
+	// requests to the indices beyond the tracked ones
+	otherIndices = "_other"
+)
+
+type opCounters struct {
+	Reads   int64 `json:"reads"`
+	Writes  int64 `json:"writes"`
+	Deletes int64 `json:"deletes"`
+}
+
+// indexStats counts the operations performed on each index. At most max
+// indices are tracked, the operations on the rest of the indices are
+// accumulated under otherIndices.
+type indexStats struct {
+	mu      sync.Mutex
+	max     int
+	indices map[string]*opCounters
+}
+
+func newIndexStats(max int) *indexStats {
+	if max <= 0 {
+		max = defaultMaxTrackedIndices
+	}
+	return &indexStats{
+		max:     max,
+		indices: make(map[string]*opCounters),
+	}
+}
+
+func (es *elasticsearch) initIndexStats() error {
+	max := 0
+	if value := os.Getenv(envMaxTrackedIndices); value != "" {
+		var err error
+		max, err = strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+	}
+	es.indexStats = newIndexStats(max)
+	return nil
+}
+
+// record counts the operation against each of the indices.
+func (s *indexStats) record(indices []string, o op.Operation) {
+	if len(indices) == 0 {
+		indices = []string{allIndices}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, index := range indices {
+		counters, ok := s.indices[index]
+		if !ok {
+			if len(s.indices) >= s.max {
+				index = otherIndices
+			}
+			if counters, ok = s.indices[index]; !ok {
+				counters = &opCounters{}
+				s.indices[index] = counters
+			}
+		}
+		switch o {
+		case op.Read:
+			counters.Reads++
+		case op.Write:
+			counters.Writes++
+		case op.Delete:
+			counters.Deletes++
+		}
+	}
+}
+
+// snapshot returns a copy of the counters.
+func (s *indexStats) snapshot() map[string]opCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]opCounters, len(s.indices))
+	for index, counters := range s.indices {
+		snapshot[index] = *counters
+	}
+	return snapshot
+}
+
+func (es *elasticsearch) indexStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := make(map[string]opCounters)
+		if es.indexStats != nil {
+			stats = es.indexStats.snapshot()
+		}
+		raw, err := json.Marshal(stats)
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling index stats:", err)
+			util.WriteBackError(w, "error reporting index stats", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
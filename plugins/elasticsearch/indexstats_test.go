@@ -0,0 +1,65 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexStats(t *testing.T) {
+	Convey("Index stats", t, func() {
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		})
+		defer upstream.Close()
+		es := &elasticsearch{indexStats: newIndexStats(2)}
+
+		serve := func(method, template, path string, c category.Category, a acl.ACL, o op.Operation) {
+			route(method, template, func(w http.ResponseWriter, r *http.Request) {
+				es.handler()(w, classified(r, c, a, o))
+			}, httptest.NewRequest(method, path, nil))
+		}
+		stats := func() map[string]opCounters {
+			resp := httptest.NewRecorder()
+			es.indexStatsHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/stats/indices", nil))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			var counters map[string]opCounters
+			So(json.Unmarshal(resp.Body.Bytes(), &counters), ShouldBeNil)
+			return counters
+		}
+
+		Convey("Operations are counted by index", func() {
+			es.indexStats = newIndexStats(10)
+			serve(http.MethodGet, "/{index}/_search", "/foo/_search", category.Search, acl.Search, op.Read)
+			serve(http.MethodGet, "/{index}/_search", "/foo,bar/_search", category.Search, acl.Search, op.Read)
+			serve(http.MethodPut, "/{index}/_doc/{id}", "/foo/_doc/1", category.Docs, acl.Index, op.Write)
+			serve(http.MethodDelete, "/{index}/_doc/{id}", "/bar/_doc/1", category.Docs, acl.Delete, op.Delete)
+
+			serve(http.MethodGet, "/_search", "/_search", category.Search, acl.Search, op.Read)
+
+			So(stats(), ShouldResemble, map[string]opCounters{
+				allIndices: {Reads: 1},
+				"foo":      {Reads: 2, Writes: 1},
+				"bar":      {Reads: 1, Deletes: 1},
+			})
+		})
+		Convey("Untracked indices are accumulated together", func() {
+			serve(http.MethodGet, "/{index}/_search", "/foo/_search", category.Search, acl.Search, op.Read)
+			serve(http.MethodGet, "/{index}/_search", "/bar/_search", category.Search, acl.Search, op.Read)
+			serve(http.MethodGet, "/{index}/_search", "/baz/_search", category.Search, acl.Search, op.Read)
+			serve(http.MethodGet, "/{index}/_search", "/qux/_search", category.Search, acl.Search, op.Read)
+
+			counters := stats()
+			So(counters, ShouldContainKey, "foo")
+			So(counters, ShouldContainKey, "bar")
+			So(counters[otherIndices], ShouldResemble, opCounters{Reads: 2})
+		})
+	})
+}
@@ -0,0 +1,222 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+const (
+	defaultShedCPU   = 90
+	defaultShedQueue = 1000
+)
+
+// categories whose reads are shed first when the cluster is under pressure
+var defaultShedCategories = []string{"cat", "clusters", "misc", "indices"}
+
+// clusterLoad is the load of the busiest node of the cluster.
+type clusterLoad struct {
+	// cpu usage in percent
+	CPU int `json:"cpu_percent"`
+	// number of requests waiting in the search and write thread pools
+	Queue int `json:"queue"`
+}
+
+// loadShedder sheds the low priority reads while the last polled load of
+// the cluster exceeds the thresholds, to relieve it before it starts
+// rejecting the requests itself.
+type loadShedder struct {
+	interval   time.Duration
+	cpu        int
+	queue      int
+	categories map[category.Category]bool
+	// source of the cluster load, the node stats unless replaced
+	source func(ctx context.Context) (clusterLoad, error)
+
+	mu       sync.RWMutex
+	load     clusterLoad
+	shedding bool
+	polledAt time.Time
+	shed     int64
+}
+
+func (es *elasticsearch) initLoadShedding() error {
+	value := os.Getenv(envLoadSheddingInterval)
+	if value == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	shedder := &loadShedder{
+		interval:   interval,
+		cpu:        defaultShedCPU,
+		queue:      defaultShedQueue,
+		categories: make(map[category.Category]bool),
+		source:     nodesLoad,
+	}
+	if value := os.Getenv(envLoadSheddingCPU); value != "" {
+		if shedder.cpu, err = strconv.Atoi(value); err != nil {
+			return err
+		}
+	}
+	if value := os.Getenv(envLoadSheddingQueue); value != "" {
+		if shedder.queue, err = strconv.Atoi(value); err != nil {
+			return err
+		}
+	}
+	names := envList(envLoadSheddingCategories)
+	if len(names) == 0 {
+		names = defaultShedCategories
+	}
+	for _, name := range names {
+		c, err := parseCategory(name)
+		if err != nil {
+			return err
+		}
+		shedder.categories[c] = true
+	}
+	es.loadShedder = shedder
+	go shedder.run()
+	return nil
+}
+
+func (s *loadShedder) run() {
+	ticker := time.NewTicker(s.interval)
+	for range ticker.C {
+		s.poll(context.Background())
+	}
+}
+
+// poll fetches the load of the cluster and starts or stops the shedding
+// accordingly. The shedding stops if the load can't be fetched, arc doesn't
+// shed requests on stale data.
+func (s *loadShedder) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
+	load, err := s.source(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		log.Errorln(logTag, ": unable to fetch the cluster load:", err)
+		s.shedding = false
+		return
+	}
+	shedding := load.CPU >= s.cpu || load.Queue >= s.queue
+	if shedding != s.shedding {
+		log.Warnln(logTag, ": cluster load at", load.CPU, "% cpu and", load.Queue, "queued requests, shedding:", shedding)
+	}
+	s.load = load
+	s.shedding = shedding
+	s.polledAt = time.Now()
+}
+
+// sheds checks whether the request is to be shed.
+func (s *loadShedder) sheds(c category.Category, o op.Operation) bool {
+	if o != op.Read || !s.categories[c] {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shedding {
+		s.shed++
+	}
+	return s.shedding
+}
+
+func (s *loadShedder) stats() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := map[string]interface{}{
+		"shedding": s.shedding,
+		"load":     s.load,
+		"shed":     s.shed,
+	}
+	if !s.polledAt.IsZero() {
+		stats["polled_at"] = s.polledAt.Format(time.RFC3339)
+	}
+	return stats
+}
+
+// nodesLoad returns the highest cpu usage and thread pool queue among the
+// nodes of the cluster.
+func nodesLoad(ctx context.Context) (clusterLoad, error) {
+	res, err := util.GetClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodGet,
+		Path:   "/_nodes/stats/os,thread_pool",
+		Params: map[string][]string{"filter_path": {"nodes.*.os.cpu.percent,nodes.*.thread_pool.search.queue,nodes.*.thread_pool.write.queue"}},
+	})
+	if err != nil {
+		return clusterLoad{}, err
+	}
+	var stats struct {
+		Nodes map[string]struct {
+			OS struct {
+				CPU struct {
+					Percent int `json:"percent"`
+				} `json:"cpu"`
+			} `json:"os"`
+			ThreadPool map[string]struct {
+				Queue int `json:"queue"`
+			} `json:"thread_pool"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(res.Body, &stats); err != nil {
+		return clusterLoad{}, fmt.Errorf("error parsing the node stats: %v", err)
+	}
+	var load clusterLoad
+	for _, node := range stats.Nodes {
+		if node.OS.CPU.Percent > load.CPU {
+			load.CPU = node.OS.CPU.Percent
+		}
+		for _, pool := range node.ThreadPool {
+			if pool.Queue > load.Queue {
+				load.Queue = pool.Queue
+			}
+		}
+	}
+	return load, nil
+}
+
+// shedLoad rejects the low priority reads with 503 while the cluster is
+// under pressure.
+func (es *elasticsearch) shedLoad(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if es.loadShedder == nil {
+			h(w, req)
+			return
+		}
+		reqCategory, err := category.FromContext(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			h(w, req)
+			return
+		}
+		reqOp, err := op.FromContext(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			h(w, req)
+			return
+		}
+		if es.loadShedder.sheds(*reqCategory, *reqOp) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(es.loadShedder.interval.Seconds()))))
+			util.WriteBackError(w, "elasticsearch is under pressure, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,89 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadShedding(t *testing.T) {
+	Convey("Load shedding", t, func() {
+		var load clusterLoad
+		var sourceErr error
+		shedder := &loadShedder{
+			interval:   2 * time.Second,
+			cpu:        80,
+			queue:      100,
+			categories: map[category.Category]bool{category.Cat: true, category.Clusters: true},
+			source: func(ctx context.Context) (clusterLoad, error) {
+				return load, sourceErr
+			},
+		}
+		es := &elasticsearch{loadShedder: shedder}
+		serve := func(c category.Category, a acl.ACL, o op.Operation) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/_cat/indices", nil)
+			es.shedLoad(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(resp, classified(req, c, a, o))
+			return resp
+		}
+
+		Convey("Nothing is shed while the cluster is healthy", func() {
+			load = clusterLoad{CPU: 40, Queue: 3}
+			shedder.poll(context.Background())
+			So(serve(category.Cat, acl.Cat, op.Read).Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("The low priority reads are shed under pressure", func() {
+			for _, pressure := range []clusterLoad{{CPU: 95}, {Queue: 500}} {
+				load = pressure
+				shedder.poll(context.Background())
+
+				resp := serve(category.Cat, acl.Cat, op.Read)
+				So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(resp.Header().Get("Retry-After"), ShouldEqual, "2")
+				So(serve(category.Clusters, acl.Nodes, op.Read).Code, ShouldEqual, http.StatusServiceUnavailable)
+
+				// the other categories and the writes go through
+				So(serve(category.Search, acl.Search, op.Read).Code, ShouldEqual, http.StatusOK)
+				So(serve(category.Clusters, acl.Cluster, op.Write).Code, ShouldEqual, http.StatusOK)
+			}
+			So(shedder.stats()["shed"], ShouldEqual, 4)
+
+			// the shedding stops once the load drops
+			load = clusterLoad{CPU: 50}
+			shedder.poll(context.Background())
+			So(serve(category.Cat, acl.Cat, op.Read).Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("The shedding stops when the load can't be fetched", func() {
+			load = clusterLoad{CPU: 99}
+			shedder.poll(context.Background())
+			So(serve(category.Cat, acl.Cat, op.Read).Code, ShouldEqual, http.StatusServiceUnavailable)
+			sourceErr = errors.New("timeout")
+			shedder.poll(context.Background())
+			So(serve(category.Cat, acl.Cat, op.Read).Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("The load is the one of the busiest node", func() {
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.Write([]byte(`{"nodes":{
+					"a":{"os":{"cpu":{"percent":35}},"thread_pool":{"search":{"queue":12},"write":{"queue":0}}},
+					"b":{"os":{"cpu":{"percent":88}},"thread_pool":{"search":{"queue":2},"write":{"queue":40}}}
+				}}`))
+			})
+			defer upstream.Close()
+			nodes, err := nodesLoad(context.Background())
+			So(err, ShouldBeNil)
+			So(nodes, ShouldResemble, clusterLoad{CPU: 88, Queue: 40})
+		})
+	})
+}
@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+)
+
+// headerArcInstance marks the requests forwarded by an arc instance, so that
+// the instance recognises the requests it has forwarded to itself.
+const headerArcInstance = "X-Arc-Instance"
+
+// instanceID identifies this arc instance in the forwarded requests.
+var instanceID = util.RandStr()
+
+// initLoopCheck compares the es urls against the urls arc is reachable at,
+// a match means every request would be forwarded back to arc.
+func (es *elasticsearch) initLoopCheck() {
+	selfURLs := envList(envSelfURLs)
+	if len(selfURLs) == 0 {
+		return
+	}
+	for _, env := range []string{"ES_CLUSTER_URL", "ES_READ_CLUSTER_URL", "ES_WRITE_CLUSTER_URL"} {
+		esURL := os.Getenv(env)
+		if esURL == "" {
+			continue
+		}
+		for _, selfURL := range selfURLs {
+			if sameHost(esURL, selfURL) {
+				log.Errorln(logTag, ":", env, "points at arc itself, requests will be rejected until it points at elasticsearch")
+				es.selfProxied = true
+			}
+		}
+	}
+}
+
+// sameHost checks whether the urls share their scheme, host and port.
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// detectLoops rejects the requests that arc has forwarded to itself, which
+// would otherwise be forwarded over and over.
+func (es *elasticsearch) detectLoops(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case es.selfProxied:
+			util.WriteBackError(w, "arc is configured to forward the requests to itself, check ES_CLUSTER_URL", http.StatusLoopDetected)
+		case req.Header.Get(headerArcInstance) == instanceID:
+			util.WriteBackError(w, "request forwarded back to arc, check that ES_CLUSTER_URL doesn't point at arc", http.StatusLoopDetected)
+		case req.Header.Get("X-Origin") == "ES":
+			// arc marks the es responses, never the requests
+			util.WriteBackError(w, "request carries the es response marker, it may have been forwarded back to arc", http.StatusLoopDetected)
+		default:
+			h(w, req)
+		}
+	}
+}
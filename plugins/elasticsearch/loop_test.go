@@ -0,0 +1,62 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoopDetection(t *testing.T) {
+	Convey("Loop detection", t, func() {
+		var forwarded string
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r.Header.Get(headerArcInstance)
+			w.Write([]byte(`{"hits":{"total":0}}`))
+		})
+		defer upstream.Close()
+		es := &elasticsearch{}
+		h := es.detectLoops(func(w http.ResponseWriter, r *http.Request) {
+			es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+		})
+		serve := func(header, value string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			resp := httptest.NewRecorder()
+			h(resp, req)
+			return resp
+		}
+
+		Convey("should mark the forwarded requests", func() {
+			So(serve("", "").Code, ShouldEqual, http.StatusOK)
+			So(forwarded, ShouldEqual, instanceID)
+		})
+		Convey("should reject the requests it has forwarded", func() {
+			So(serve(headerArcInstance, instanceID).Code, ShouldEqual, http.StatusLoopDetected)
+			// requests forwarded by another arc instance are served
+			So(serve(headerArcInstance, "other").Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("should reject the requests carrying the es origin marker", func() {
+			resp := serve("X-Origin", "ES")
+			So(resp.Code, ShouldEqual, http.StatusLoopDetected)
+			So(resp.Body.String(), ShouldContainSubstring, "forwarded back to arc")
+		})
+		Convey("should reject every request if es points at arc", func() {
+			os.Setenv("ES_CLUSTER_URL", "http://localhost:8000")
+			os.Setenv(envSelfURLs, "https://arc.example.com,http://LOCALHOST:8000/")
+			defer os.Unsetenv("ES_CLUSTER_URL")
+			defer os.Unsetenv(envSelfURLs)
+			es.initLoopCheck()
+			So(es.selfProxied, ShouldBeTrue)
+			So(serve("", "").Code, ShouldEqual, http.StatusLoopDetected)
+		})
+	})
+}
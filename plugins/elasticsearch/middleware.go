@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
@@ -19,9 +20,12 @@ import (
 	"github.com/appbaseio/arc/middleware/validate"
 	"github.com/appbaseio/arc/model/acl"
 	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/feature"
 	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/op"
 	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
 	"github.com/appbaseio/arc/plugins/auth"
 	"github.com/appbaseio/arc/plugins/logs"
 	"github.com/appbaseio/arc/util"
@@ -36,14 +40,64 @@ func (c *chain) Wrap(mw []middleware.Middleware, h http.HandlerFunc) http.Handle
 	return c.Adapt(h, append(append(list(), mw...), interceptor.Redirect())...)
 }
 
+// MiddlewarePosition is where the custom middleware run in the chain of the
+// es routes.
+type MiddlewarePosition int
+
+const (
+	// BeforeAuth runs the middleware once the request is classified, i.e.
+	// its category, acl, op and indices are in its context, and recorded
+	// by the logs, but before it is authenticated.
+	BeforeAuth MiddlewarePosition = iota
+	// BeforeForward runs the middleware once the request is authenticated
+	// and has passed all the built-in checks, right before it is forwarded
+	// to elasticsearch.
+	BeforeForward
+)
+
+var (
+	customMiddlewareMu sync.Mutex
+	customMiddleware   = make(map[MiddlewarePosition][]middleware.Middleware)
+)
+
+// RegisterMiddleware adds the middleware to the chain of the es routes at
+// the given position, e.g. to validate a custom header. The middleware
+// registered at the same position run in their registration order. The
+// routes are built when the plugin is initialized, so the middleware must
+// be registered before that.
+func RegisterMiddleware(position MiddlewarePosition, mw ...middleware.Middleware) {
+	customMiddlewareMu.Lock()
+	defer customMiddlewareMu.Unlock()
+	customMiddleware[position] = append(customMiddleware[position], mw...)
+}
+
+// registeredMiddleware returns the custom middleware registered at the position.
+func registeredMiddleware(position MiddlewarePosition) []middleware.Middleware {
+	customMiddlewareMu.Lock()
+	defer customMiddlewareMu.Unlock()
+	return append([]middleware.Middleware(nil), customMiddleware[position]...)
+}
+
 func list() []middleware.Middleware {
-	return []middleware.Middleware{
+	mw := []middleware.Middleware{
+		timeRequest,
+		Instance().detectLoops,
 		classifyCategory,
 		classifyACL,
 		classifyOp,
+		Instance().shedLoad,
+		Instance().resolveAliases,
 		classify.Indices(),
+		classify.Trace(),
 		logs.Recorder(),
+	}
+	mw = append(mw, registeredMiddleware(BeforeAuth)...)
+	mw = append(mw,
 		auth.BasicAuth(),
+		Instance().blockPrivileged,
+		blockWildcardDeletes,
+		Instance().previewErrors,
+		classifyFeatures,
 		ratelimiter.Limit(),
 		validate.Sources(),
 		validate.Referers(),
@@ -52,7 +106,89 @@ func list() []middleware.Middleware {
 		validate.ACL(),
 		validate.Operation(),
 		validate.PermissionExpiry(),
-		intercept,
+		validate.Scripts(),
+		validate.TemplateParams(),
+		Instance().checkIndices,
+		Instance().blockAutoCreate,
+		Instance().requireConditionalUpdates,
+		Instance().requireRouting,
+	)
+	mw = append(mw, registeredMiddleware(BeforeForward)...)
+	return append(mw, intercept)
+}
+
+// adminChain guards the arc routes that are only meant for the admin users.
+type adminChain struct {
+	middleware.Fifo
+}
+
+func (c *adminChain) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return c.Adapt(h, classifyAdmin, auth.BasicAuth(), isAdmin)
+}
+
+// bulkStatusChain authenticates the requests for the status of the queued
+// bulks, which the handler only reports to the principal that queued the bulk
+// or to the admin users.
+type bulkStatusChain struct {
+	middleware.Fifo
+}
+
+func (c *bulkStatusChain) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return c.Adapt(h, classifyBulkStatus, auth.BasicAuth())
+}
+
+// classifyBulkStatus classifies the status requests like the reads of the
+// documents, so that the credentials allowed to queue bulks may read them.
+func classifyBulkStatus(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		docsCategory := category.Docs
+		readOp := op.Read
+
+		ctx := category.NewContext(req.Context(), &docsCategory)
+		ctx = op.NewContext(ctx, &readOp)
+		req = req.WithContext(ctx)
+
+		h(w, req)
+	}
+}
+
+func classifyAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		adminCategory := category.Misc
+		adminOp := op.Read
+
+		ctx := category.NewContext(req.Context(), &adminCategory)
+		ctx = op.NewContext(ctx, &adminOp)
+		req = req.WithContext(ctx)
+
+		h(w, req)
+	}
+}
+
+func isAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		reqCredential, err := credential.FromContext(ctx)
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating user admin", http.StatusInternalServerError)
+			return
+		}
+		if reqCredential == credential.User {
+			reqUser, err := user.FromContext(ctx)
+			if err != nil {
+				log.Errorln(logTag, ":", err)
+				util.WriteBackError(w, "an error occurred while validating user admin", http.StatusInternalServerError)
+				return
+			}
+			if *reqUser.IsAdmin {
+				h(w, req)
+				return
+			}
+		}
+		w.Header().Set("www-authenticate", "Basic realm=\"Authentication Required\"")
+		util.WriteBackError(w, "only admin users can access the route", http.StatusUnauthorized)
 	}
 }
 
@@ -126,9 +262,59 @@ func classifyOp(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// classifyFeatures stores the feature flags of the request in its context.
+// Only the admin users may toggle the gateway features, the flags of the
+// other requests are ignored. The header is never forwarded to es.
+func classifyFeatures(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		value := req.Header.Get(feature.Header)
+		if value == "" {
+			h(w, req)
+			return
+		}
+		req.Header.Del(feature.Header)
+
+		if !isAdminRequest(req) {
+			log.Debugln(logTag, ": ignoring the feature flags of a request without an admin user")
+			h(w, req)
+			return
+		}
+		flags := feature.FromHeader(value)
+		log.Debugln(logTag, ": request feature flags", flags)
+		h(w, req.WithContext(feature.NewContext(req.Context(), flags)))
+	}
+}
+
+// isAdminRequest checks whether the request has been made by an admin user.
+func isAdminRequest(req *http.Request) bool {
+	reqCredential, err := credential.FromContext(req.Context())
+	if err != nil || reqCredential != credential.User {
+		return false
+	}
+	reqUser, err := user.FromContext(req.Context())
+	return err == nil && reqUser.IsAdmin != nil && *reqUser.IsAdmin
+}
+
 func intercept(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
+		format := req.URL.Query().Get(gatewayFormatParam)
+		if !isValidFormat(format) {
+			msg := fmt.Sprintf(`invalid value "%s" for query param "%s"`, format, gatewayFormatParam)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+		extract := req.URL.Query().Get(gatewayExtractParam)
+		if _, err := parseExtractPath(extract); extract != "" && err != nil {
+			msg := fmt.Sprintf(`invalid value "%s" for query param "%s": %v`, extract, gatewayExtractParam, err)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+		if _, _, err := maxAge(req); err != nil {
+			msg := fmt.Sprintf(`invalid value "%s" for query param "%s": %v`, req.URL.Query().Get(gatewayMaxAgeParam), gatewayMaxAgeParam, err)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
 		reqACL, err := acl.FromContext(ctx)
 		if err != nil {
 			log.Errorln(logTag, ":", err)
@@ -192,6 +378,8 @@ func intercept(h http.HandlerFunc) http.HandlerFunc {
 						}
 						modifiedBody := []byte(modifiedBodyString)
 						req.Body = ioutil.NopCloser(bytes.NewReader(modifiedBody))
+						// the GETs without a body are told apart by their length
+						req.ContentLength = int64(len(modifiedBody))
 					} else {
 						reqBody := make(map[string]interface{})
 						err := json.NewDecoder(req.Body).Decode(&reqBody)
@@ -203,21 +391,23 @@ func intercept(h http.HandlerFunc) http.HandlerFunc {
 						reqBody["_source"] = sources
 						modifiedBody, _ := json.Marshal(reqBody)
 						req.Body = ioutil.NopCloser(bytes.NewReader(modifiedBody))
+						req.ContentLength = int64(len(modifiedBody))
 					}
 				}
 			}
 
 		}
 
+		// streamed responses are written back as they are received
+		if Instance().streams(req) {
+			h(w, req)
+			return
+		}
+
 		resp := httptest.NewRecorder()
 		indices, err := index.FromContext(req.Context())
 		h(resp, req)
 
-		// Copy the response to writer
-		for k, v := range resp.Header() {
-			w.Header()[k] = v
-		}
-		w.WriteHeader(resp.Code)
 		result := resp.Result()
 		body, err2 := ioutil.ReadAll(result.Body)
 		if err2 != nil {
@@ -225,6 +415,17 @@ func intercept(h http.HandlerFunc) http.HandlerFunc {
 			util.WriteBackError(w, "error reading response body", http.StatusInternalServerError)
 			return
 		}
+		// Copy the response to writer
+		for k, v := range resp.Header() {
+			w.Header()[k] = v
+		}
+		// non-json responses, e.g. _cat in text format, are passed through untouched
+		if !util.IsJSONContentType(result.Header.Get("Content-Type")) {
+			w.WriteHeader(resp.Code)
+			w.Write(body)
+			return
+		}
+		setTimingHeaders(w.Header(), req.Context(), body)
 		for _, index := range indices {
 			alias := classify.GetIndexAlias(index)
 			if alias != "" {
@@ -237,6 +438,26 @@ func intercept(h http.HandlerFunc) http.HandlerFunc {
 				body = bytes.Replace(body, []byte(`"`+indexName+`"`), []byte(`"`+index+`"`), -1)
 			}
 		}
-		util.WriteBackRaw(w, body, http.StatusOK)
+		if extract != "" && resp.Code >= 200 && resp.Code <= 299 {
+			extracted, err := extractJSON(body, extract)
+			if err != nil {
+				code := http.StatusInternalServerError
+				switch err.(type) {
+				case *extractPathError:
+					code = http.StatusNotFound
+				case *extractDecodeError:
+					code = http.StatusBadGateway
+				default:
+					log.Errorln(logTag, ": error extracting", extract, "from the response:", err)
+				}
+				util.WriteBackError(w, err.Error(), code)
+				return
+			}
+			body = extracted
+			// the entity tag is the one of the whole response
+			w.Header().Del(headerETag)
+		}
+		w.WriteHeader(resp.Code)
+		util.WriteBackRaw(w, formatJSON(body, format), http.StatusOK)
 	}
 }
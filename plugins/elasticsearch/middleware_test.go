@@ -0,0 +1,304 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/credential"
+	"github.com/appbaseio/arc/model/feature"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/permission"
+	"github.com/appbaseio/arc/model/user"
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// route serves the request through a router that only knows the given
+// route template, so that the classifiers can look up the route spec.
+func route(method, template string, h http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.Methods(method).Path(template).HandlerFunc(h)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestMiddleware(t *testing.T) {
+	Convey("Middleware", t, func() {
+		Convey("Route overrides change the classification", func() {
+			key := "POST:/{index}/_custom"
+			routeSpecs[key] = api{category: category.Docs, acl: acl.Index, op: op.Write}
+			defer delete(routeSpecs, key)
+
+			file, err := ioutil.TempFile("", "overrides")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			file.WriteString(`{"POST:/{index}/_custom": {"category": "search", "acl": "search", "op": "read"}}`)
+			file.Close()
+
+			overrides, err := readRouteOverrides(file.Name())
+			So(err, ShouldBeNil)
+			applyRouteOverrides(routeSpecs, overrides)
+
+			var reqCategory *category.Category
+			var reqACL *acl.ACL
+			var reqOp *op.Operation
+			h := classifyCategory(classifyACL(classifyOp(func(w http.ResponseWriter, r *http.Request) {
+				reqCategory, _ = category.FromContext(r.Context())
+				reqACL, _ = acl.FromContext(r.Context())
+				reqOp, _ = op.FromContext(r.Context())
+			})))
+			route(http.MethodPost, "/{index}/_custom", h, httptest.NewRequest(http.MethodPost, "/foo/_custom", nil))
+
+			So(*reqCategory, ShouldEqual, category.Search)
+			So(*reqACL, ShouldEqual, acl.Search)
+			So(*reqOp, ShouldEqual, op.Read)
+		})
+		Convey("Admin users can toggle the gateway features per request", func() {
+			var hits int
+			var forwarded string
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				forwarded = r.Header.Get(feature.Header)
+				w.Write([]byte(`{"hits":{"total":0}}`))
+			})
+			defer upstream.Close()
+			es := withCache()
+
+			search := func(admin bool, features string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/foo/_search", nil)
+				if features != "" {
+					req.Header.Set(feature.Header, features)
+				}
+				ctx := credential.NewContext(req.Context(), credential.User)
+				ctx = user.NewContext(ctx, &user.User{IsAdmin: &admin})
+				resp := httptest.NewRecorder()
+				classifyFeatures(func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+				})(resp, req.WithContext(ctx))
+				return resp
+			}
+			So(search(true, "").Header().Get(headerCache), ShouldEqual, cacheMiss)
+			So(search(true, "").Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hits, ShouldEqual, 1)
+
+			// the cache is bypassed for the flagged request only
+			resp := search(true, "cache=off")
+			So(resp.Header().Get(headerCache), ShouldBeEmpty)
+			So(hits, ShouldEqual, 2)
+			So(forwarded, ShouldBeEmpty)
+			So(search(true, "").Header().Get(headerCache), ShouldEqual, cacheHit)
+
+			// the flags of the other users are ignored
+			So(search(false, "cache=off").Header().Get(headerCache), ShouldEqual, cacheHit)
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Custom middleware run in the chain of the es routes", func() {
+			search := specFor(http.MethodPost, "/{index}/_search")
+			So(search.name, ShouldNotBeEmpty)
+			var classifiedAs *category.Category
+			var authenticated bool
+			RegisterMiddleware(BeforeAuth, func(h http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					classifiedAs, _ = category.FromContext(r.Context())
+					_, err := credential.FromContext(r.Context())
+					authenticated = err == nil
+					w.Header().Set("X-Custom", "checked")
+					h(w, r)
+				}
+			})
+			defer delete(customMiddleware, BeforeAuth)
+
+			forwarded := false
+			h := (&chain{}).Wrap(nil, func(w http.ResponseWriter, r *http.Request) { forwarded = true })
+			resp := route(http.MethodPost, "/{index}/_search", h, httptest.NewRequest(http.MethodPost, "/foo/_search", nil))
+			So(resp.Header().Get("X-Custom"), ShouldEqual, "checked")
+			So(*classifiedAs, ShouldEqual, category.Search)
+			So(authenticated, ShouldBeFalse)
+			// the request without credentials is then rejected by the auth
+			So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+			So(forwarded, ShouldBeFalse)
+		})
+		Convey("Wildcard deletes need a confirmation", func() {
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"acknowledged":true,"query":"` + r.URL.RawQuery + `"}`))
+			})
+			defer upstream.Close()
+			es := &elasticsearch{}
+			del := func(path string, admin bool) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodDelete, path, nil)
+				ctx := credential.NewContext(req.Context(), credential.User)
+				ctx = user.NewContext(ctx, &user.User{IsAdmin: &admin})
+				h := func(w http.ResponseWriter, r *http.Request) {
+					blockWildcardDeletes(es.handler())(w, classified(r, category.Indices, acl.Indices, op.Delete))
+				}
+				return route(http.MethodDelete, "/{index}", h, req.WithContext(ctx))
+			}
+
+			for _, path := range []string{"/*", "/_all", "/foo,logs-*"} {
+				resp := del(path, false)
+				So(resp.Code, ShouldEqual, http.StatusForbidden)
+				So(resp.Body.String(), ShouldContainSubstring, "i_am_sure=true")
+			}
+			resp := del("/*?i_am_sure=true", false)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			// the confirmation isn't forwarded
+			So(resp.Body.String(), ShouldEqual, `{"acknowledged":true,"query":""}`)
+			So(del("/_all", true).Code, ShouldEqual, http.StatusOK)
+			So(del("/foo", false).Code, ShouldEqual, http.StatusOK)
+			So(del("/*?i_am_sure=false", false).Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("The source filters of the permission apply to the bodyless searches", func() {
+			var forwarded string
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				forwarded = string(body)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"hits":{"hits":[]}}`))
+			})
+			defer upstream.Close()
+			es := &elasticsearch{}
+			h := func(w http.ResponseWriter, r *http.Request) {
+				r = classified(r, category.Search, acl.Search, op.Read)
+				ctx := permission.NewContext(r.Context(), &permission.Permission{Excludes: []string{"secret"}})
+				intercept(es.handler())(w, r.WithContext(ctx))
+			}
+			resp := route(http.MethodGet, "/{index}/_search", h, httptest.NewRequest(http.MethodGet, "/foo/_search", nil))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(forwarded, ShouldEqual, `{"_source":{"excludes":["secret"]}}`)
+		})
+		Convey("Updates to the guarded indices must be conditional", func() {
+			var writes int
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				writes++
+				w.Write([]byte(`{"result":"updated","_seq_no":4,"_primary_term":1}`))
+			})
+			defer upstream.Close()
+			es := &elasticsearch{conditionalUpdates: []string{"orders", "accounts-*"}}
+			write := func(method, template, path string) *httptest.ResponseRecorder {
+				h := func(w http.ResponseWriter, r *http.Request) {
+					es.requireConditionalUpdates(es.handler())(w, classified(r, category.Docs, acl.Index, op.Write))
+				}
+				return route(method, template, h, httptest.NewRequest(method, path, strings.NewReader(`{"total":42}`)))
+			}
+
+			for _, path := range []string{"/orders/_doc/1", "/accounts-eu/_doc/1"} {
+				resp := write(http.MethodPut, "/{index}/_doc/{id}", path)
+				So(resp.Code, ShouldEqual, http.StatusConflict)
+				So(resp.Body.String(), ShouldContainSubstring, "may only be updated conditionally")
+			}
+			resp := write(http.MethodPost, "/{index}/_update/{id}", "/orders/_update/1")
+			So(resp.Code, ShouldEqual, http.StatusConflict)
+			// the seq_no and primary_term are both needed
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/orders/_doc/1?if_seq_no=3").Code, ShouldEqual, http.StatusConflict)
+			So(writes, ShouldEqual, 0)
+
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/orders/_doc/1?if_seq_no=3&if_primary_term=1").Code, ShouldEqual, http.StatusOK)
+			So(write(http.MethodPost, "/{index}/_update/{id}", "/orders/_update/1?if_seq_no=3&if_primary_term=1").Code, ShouldEqual, http.StatusOK)
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/orders/_doc/1?version=5&version_type=external").Code, ShouldEqual, http.StatusOK)
+			// the creations can't overwrite a document
+			So(write(http.MethodPut, "/{index}/_create/{id}", "/orders/_create/2").Code, ShouldEqual, http.StatusOK)
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/orders/_doc/2?op_type=create").Code, ShouldEqual, http.StatusOK)
+			// nor are the other indices or the new documents without an id checked
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/products/_doc/1").Code, ShouldEqual, http.StatusOK)
+			So(write(http.MethodPost, "/{index}/_doc", "/orders/_doc").Code, ShouldEqual, http.StatusOK)
+			So(writes, ShouldEqual, 7)
+		})
+		Convey("Writes to the custom-routed indices need a routing", func() {
+			var routings []string
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				routings = append(routings, r.URL.Query().Get("routing"))
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"result":"created"}`))
+			})
+			defer upstream.Close()
+			es := &elasticsearch{routedIndices: []string{"tenants-*"}}
+			write := func(method, template, path string, o op.Operation) *httptest.ResponseRecorder {
+				h := func(w http.ResponseWriter, r *http.Request) {
+					es.requireRouting(es.handler())(w, classified(r, category.Docs, acl.Index, o))
+				}
+				return route(method, template, h, httptest.NewRequest(method, path, strings.NewReader(`{"name":"arc"}`)))
+			}
+
+			resp := write(http.MethodPut, "/{index}/_doc/{id}", "/tenants-eu/_doc/1", op.Write)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldContainSubstring, `set the \"routing\" query param`)
+			So(write(http.MethodPost, "/{index}/_doc", "/tenants-eu/_doc", op.Write).Code, ShouldEqual, http.StatusBadRequest)
+			So(write(http.MethodDelete, "/{index}/_doc/{id}", "/tenants-eu/_doc/1", op.Delete).Code, ShouldEqual, http.StatusBadRequest)
+			So(routings, ShouldBeEmpty)
+
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/tenants-eu/_doc/1?routing=acme", op.Write).Code, ShouldEqual, http.StatusCreated)
+			So(routings, ShouldResemble, []string{"acme"})
+			// the other indices aren't checked
+			So(write(http.MethodPut, "/{index}/_doc/{id}", "/products/_doc/1", op.Write).Code, ShouldEqual, http.StatusCreated)
+			// nor are the reads
+			So(write(http.MethodGet, "/{index}/_doc/{id}", "/tenants-eu/_doc/1", op.Read).Code, ShouldEqual, http.StatusCreated)
+		})
+		Convey("Admin users get a preview of the request body in the gateway errors", func() {
+			es := &elasticsearch{errorPreviewSize: 40}
+			reject := func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				util.WriteBackError(w, "script not allowed", http.StatusBadRequest)
+			}
+			serve := func(admin bool, body string, h http.HandlerFunc) map[string]interface{} {
+				req := httptest.NewRequest(http.MethodPost, "/foo/_search", strings.NewReader(body))
+				ctx := credential.NewContext(req.Context(), credential.User)
+				ctx = user.NewContext(ctx, &user.User{IsAdmin: &admin})
+				resp := httptest.NewRecorder()
+				es.previewErrors(h)(resp, req.WithContext(ctx))
+				So(resp.Code, ShouldEqual, http.StatusBadRequest)
+				var errBody struct {
+					Error map[string]interface{} `json:"error"`
+				}
+				So(json.Unmarshal(resp.Body.Bytes(), &errBody), ShouldBeNil)
+				return errBody.Error
+			}
+
+			errObject := serve(true, `{"query":{"match_all":{}}}`, reject)
+			So(errObject["message"], ShouldEqual, "script not allowed")
+			So(errObject["request_preview"], ShouldEqual, `{"query":{"match_all":{}}}`)
+			So(errObject["request_preview_truncated"], ShouldBeFalse)
+
+			// the secrets are redacted and the preview is capped
+			errObject = serve(true, `{"password":"hunter2","script":{"source":"ctx._source.views++"}}`, reject)
+			So(errObject["request_preview"], ShouldEqual, `{"password":"[REDACTED]","script":{"sour`)
+			So(errObject["request_preview_truncated"], ShouldBeTrue)
+
+			// characters aren't cut in half
+			errObject = serve(true, strings.Repeat("a", 39)+"é", reject)
+			So(errObject["request_preview"], ShouldEqual, strings.Repeat("a", 39))
+
+			// the other users never get it
+			errObject = serve(false, `{"query":{"match_all":{}}}`, reject)
+			So(errObject, ShouldNotContainKey, "request_preview")
+
+			// nor do the errors passed through from es
+			errObject = serve(true, `{"query":{"match_all":{}}}`, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Origin", "ES")
+				util.WriteBackError(w, "parsing_exception", http.StatusBadRequest)
+			})
+			So(errObject, ShouldNotContainKey, "request_preview")
+
+			// and it is off by default
+			es.errorPreviewSize = 0
+			errObject = serve(true, `{"query":{"match_all":{}}}`, reject)
+			So(errObject, ShouldNotContainKey, "request_preview")
+		})
+		Convey("Feature flags are parsed from the header", func() {
+			So(feature.FromHeader(" Cache=off, stream ,bogus=maybe,,idempotency=on"), ShouldResemble, feature.Flags{
+				"cache":       false,
+				"stream":      true,
+				"idempotency": true,
+			})
+		})
+	})
+}
@@ -0,0 +1,212 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+const (
+	defaultMirrorSampleRate = 0.1
+	defaultMirrorDepth      = 3
+	// max number of mirrored requests in flight, the requests sampled over
+	// it are skipped rather than piling up against a slow cluster
+	maxMirroredRequests = 100
+	mirrorTimeout       = 30 * time.Second
+	// max number of divergences logged for a request
+	maxLoggedDivergences = 10
+)
+
+// requestMirror sends a sample of the read requests to a secondary cluster,
+// e.g. a new cluster being validated before the cutover, and logs how its
+// responses diverge from the ones of the primary cluster. The clients only
+// ever get the responses of the primary cluster.
+type requestMirror struct {
+	sampleRate float64
+	// depth down to which the shapes of the json responses are compared
+	depth    int
+	inflight chan struct{}
+
+	sent     int64
+	skipped  int64
+	failed   int64
+	diverged int64
+}
+
+func (es *elasticsearch) initRequestMirror() error {
+	if util.GetMirrorClient7() == nil {
+		return nil
+	}
+	var err error
+	sampleRate := defaultMirrorSampleRate
+	if value := os.Getenv(envMirrorSampleRate); value != "" {
+		sampleRate, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+	}
+	depth := defaultMirrorDepth
+	if value := os.Getenv(envMirrorCompareDepth); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+	}
+	es.mirror = newRequestMirror(sampleRate, depth)
+	return nil
+}
+
+func newRequestMirror(sampleRate float64, depth int) *requestMirror {
+	return &requestMirror{
+		sampleRate: sampleRate,
+		depth:      depth,
+		inflight:   make(chan struct{}, maxMirroredRequests),
+	}
+}
+
+func (m *requestMirror) sampled() bool {
+	return m.sampleRate >= 1 || rand.Float64() < m.sampleRate
+}
+
+// send mirrors the request, if sampled, in the background and compares the
+// response of the secondary cluster with the given response of the primary.
+func (m *requestMirror) send(options es7.PerformRequestOptions, code int, body []byte) {
+	if !m.sampled() {
+		return
+	}
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		atomic.AddInt64(&m.skipped, 1)
+		return
+	}
+	atomic.AddInt64(&m.sent, 1)
+	go func() {
+		defer func() { <-m.inflight }()
+		m.compare(options, code, body)
+	}()
+}
+
+func (m *requestMirror) compare(options es7.PerformRequestOptions, code int, body []byte) {
+	client := util.GetMirrorClient7()
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	options.IgnoreErrors = errorStatusCodes
+	res, err := client.PerformRequest(ctx, options)
+	if res == nil {
+		atomic.AddInt64(&m.failed, 1)
+		log.Warnln(logTag, ": error mirroring", options.Method, options.Path, ":", err)
+		return
+	}
+	var divergences []string
+	if res.StatusCode != code {
+		divergences = append(divergences, fmt.Sprintf("status %d instead of %d", res.StatusCode, code))
+	}
+	var primary, secondary interface{}
+	if json.Unmarshal(body, &primary) == nil && json.Unmarshal(res.Body, &secondary) == nil {
+		divergences = append(divergences, shapeDiff("", primary, secondary, m.depth)...)
+	}
+	if len(divergences) == 0 {
+		return
+	}
+	if len(divergences) > maxLoggedDivergences {
+		divergences = append(divergences[:maxLoggedDivergences], fmt.Sprintf("and %d more", len(divergences)-maxLoggedDivergences))
+	}
+	log.Warnln(logTag, ": mirrored", options.Method, options.Path, "diverged:", strings.Join(divergences, "; "))
+	atomic.AddInt64(&m.diverged, 1)
+}
+
+// shapeDiff compares the shapes of the json values down to the given depth,
+// i.e. the keys of the objects, the lengths of the arrays and the types of
+// the values, not the values themselves.
+func shapeDiff(path string, primary, secondary interface{}, depth int) []string {
+	at := path
+	if at == "" {
+		at = "."
+	}
+	if jsonType(primary) != jsonType(secondary) {
+		return []string{fmt.Sprintf("%s: %s instead of %s", at, jsonType(secondary), jsonType(primary))}
+	}
+	if depth <= 0 {
+		return nil
+	}
+	var diffs []string
+	switch primary := primary.(type) {
+	case map[string]interface{}:
+		secondary := secondary.(map[string]interface{})
+		keys := make([]string, 0, len(primary)+len(secondary))
+		for key := range primary {
+			keys = append(keys, key)
+		}
+		for key := range secondary {
+			if _, ok := primary[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := strings.TrimPrefix(path+"."+key, ".")
+			primaryValue, inPrimary := primary[key]
+			secondaryValue, inSecondary := secondary[key]
+			switch {
+			case !inSecondary:
+				diffs = append(diffs, field+": missing")
+			case !inPrimary:
+				diffs = append(diffs, field+": unexpected")
+			default:
+				diffs = append(diffs, shapeDiff(field, primaryValue, secondaryValue, depth-1)...)
+			}
+		}
+	case []interface{}:
+		secondary := secondary.([]interface{})
+		if len(primary) != len(secondary) {
+			diffs = append(diffs, fmt.Sprintf("%s: %d items instead of %d", at, len(secondary), len(primary)))
+		}
+		for i := 0; i < len(primary) && i < len(secondary); i++ {
+			diffs = append(diffs, shapeDiff(fmt.Sprintf("%s[%d]", path, i), primary[i], secondary[i], depth-1)...)
+		}
+	}
+	return diffs
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+func (m *requestMirror) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"sample_rate": m.sampleRate,
+		"sent":        atomic.LoadInt64(&m.sent),
+		"skipped":     atomic.LoadInt64(&m.skipped),
+		"failed":      atomic.LoadInt64(&m.failed),
+		"diverged":    atomic.LoadInt64(&m.diverged),
+	}
+}
@@ -0,0 +1,104 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestMirror(t *testing.T) {
+	Convey("Request mirror", t, func() {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		primaryBody := `{"took":1,"hits":{"total":{"value":2},"hits":[{"_id":"1"},{"_id":"2"}]}}`
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(primaryBody))
+		})
+		defer upstream.Close()
+
+		var mirrored int32
+		respond := make(chan struct{})
+		secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&mirrored, 1)
+			<-respond
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"took":"1","hits":{"hits":[{"_id":"1"}]}}`))
+		}))
+		defer secondary.Close()
+		util.SetMirrorClient7(newTestClient(secondary.URL))
+		defer util.SetMirrorClient7(nil)
+
+		es := &elasticsearch{mirror: newRequestMirror(1, 3)}
+		search := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+			resp := httptest.NewRecorder()
+			es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+			return resp
+		}
+		// waitFor waits for the condition for up to a second
+		waitFor := func(condition func() bool) bool {
+			deadline := time.Now().Add(time.Second)
+			for !condition() && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			return condition()
+		}
+
+		Convey("the client gets the primary response without waiting for the mirror", func() {
+			resp := search()
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, primaryBody)
+			So(waitFor(func() bool { return atomic.LoadInt32(&mirrored) == 1 }), ShouldBeTrue)
+
+			// the divergences are logged once the mirror responds
+			close(respond)
+			var entry *log.Entry
+			So(waitFor(func() bool {
+				for _, e := range hook.AllEntries() {
+					if e.Level == log.WarnLevel {
+						entry = e
+					}
+				}
+				return entry != nil
+			}), ShouldBeTrue)
+			So(es.mirror.stats()["diverged"], ShouldEqual, int64(1))
+			So(entry.Message, ShouldContainSubstring, "/foo/_search diverged")
+			So(entry.Message, ShouldContainSubstring, "took: string instead of number")
+			So(entry.Message, ShouldContainSubstring, "hits.total: missing")
+			So(entry.Message, ShouldContainSubstring, "hits.hits: 1 items instead of 2")
+		})
+		Convey("the unsampled requests aren't mirrored", func() {
+			close(respond)
+			es.mirror.sampleRate = 0
+			So(search().Code, ShouldEqual, http.StatusOK)
+			So(es.mirror.stats()["sent"], ShouldEqual, int64(0))
+		})
+	})
+
+	Convey("Response shapes", t, func() {
+		So(shapeDiff("", map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 2.0}, 3), ShouldBeEmpty)
+		So(shapeDiff("", map[string]interface{}{"a": 1.0}, map[string]interface{}{"b": 1.0}, 3), ShouldResemble,
+			[]string{"a: missing", "b: unexpected"})
+		nested := func(value interface{}) interface{} {
+			return map[string]interface{}{"a": map[string]interface{}{"b": value}}
+		}
+		So(shapeDiff("", nested(true), nested("true"), 2), ShouldResemble, []string{"a.b: string instead of boolean"})
+		// the values below the depth aren't compared
+		So(shapeDiff("", nested(true), nested("true"), 1), ShouldBeEmpty)
+		So(shapeDiff("", []interface{}{nil}, map[string]interface{}{}, 3), ShouldResemble, []string{".: object instead of array"})
+	})
+}
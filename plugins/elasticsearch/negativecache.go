@@ -0,0 +1,102 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/response"
+	"github.com/gorilla/mux"
+)
+
+// negativeCache caches the 404s of the lookups of missing documents, so that
+// the repeated lookups of a missing document aren't forwarded to es. It is
+// kept apart from the response cache so that the not found responses never
+// evict the cached search results.
+type negativeCache struct {
+	ttl     time.Duration
+	entries *response.Cache
+}
+
+func (es *elasticsearch) initNegativeCache() error {
+	value := os.Getenv(envNegativeCacheTTL)
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	capacity := 0
+	if value := os.Getenv(envNegativeCacheSize); value != "" {
+		capacity, err = strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+	}
+	es.negativeCache = &negativeCache{ttl: ttl, entries: response.NewCache(capacity)}
+	return nil
+}
+
+// negativeCacheable checks whether the request looks up a single document,
+// i.e. it is a read of the docs category whose route has an {id}.
+func (es *elasticsearch) negativeCacheable(r *http.Request, c category.Category, o op.Operation) bool {
+	return es.negativeCache != nil && o == op.Read && c == category.Docs && mux.Vars(r)["id"] != ""
+}
+
+// get returns the not found response cached against the key.
+func (n *negativeCache) get(key string) (*response.CachedResponse, bool) {
+	return n.entries.Get(key)
+}
+
+// save caches the not found response of the document lookup.
+func (n *negativeCache) save(r *http.Request, key string, res *response.CachedResponse) {
+	res.Indices = documentTags(r)
+	n.entries.Set(key, res, n.ttl)
+}
+
+// invalidate removes the not found responses of the documents the write may
+// have created.
+func (n *negativeCache) invalidate(r *http.Request, indices []string) {
+	invalidateDocuments(n.entries, r, indices)
+}
+
+// documentTags returns the tags of the response of a single document lookup,
+// its index and its "index/id" reference, so that it can be invalidated by
+// the writes to either.
+func documentTags(r *http.Request) []string {
+	vars := mux.Vars(r)
+	return []string{
+		escapePattern(vars["index"]),
+		escapePattern(vars["index"]) + "/" + escapePattern(vars["id"]),
+	}
+}
+
+// invalidateDocuments removes the cached responses of the documents the write
+// may have changed: the written document if the route has an {id}, the
+// documents of the written indices otherwise, e.g. for the bulk writes.
+func invalidateDocuments(entries *response.Cache, r *http.Request, indices []string) {
+	vars := mux.Vars(r)
+	if id := vars["id"]; id != "" && vars["index"] != "" {
+		entries.DeleteIndices([]string{vars["index"] + "/" + id})
+		return
+	}
+	entries.DeleteIndices(indices)
+}
+
+// escapePattern escapes the characters that are special to path.Match.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
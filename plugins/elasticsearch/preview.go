@@ -0,0 +1,135 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+)
+
+// the json string values of the keys that look like they hold secrets
+var secretValues = regexp.MustCompile(`(?i)("[^"]*(?:password|passwd|secret|token|api_?key|authorization)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+func (es *elasticsearch) initErrorPreview() error {
+	value := os.Getenv(envErrorBodyPreview)
+	if value == "" {
+		return nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	es.errorPreviewSize = size
+	return nil
+}
+
+// previewErrors adds a preview of the request body to the errors arc
+// responds to the admin users with, e.g. the validation errors, to help
+// debugging the clients. The errors passed through from es are left as is.
+func (es *elasticsearch) previewErrors(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if es.errorPreviewSize <= 0 || util.IsBodyless(req) || req.Body == nil || !isAdminRequest(req) {
+			h(w, req)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Errorln(logTag, ": error reading request body:", err)
+			util.WriteBackError(w, "can't read request body", http.StatusBadRequest)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		pw := &previewWriter{ResponseWriter: w}
+		h(pw, req)
+		pw.finish(bodyPreview(body, es.errorPreviewSize))
+	}
+}
+
+// bodyPreview returns the body with the secrets redacted and the control
+// characters replaced, truncated to at most size bytes, and whether it has
+// been truncated.
+func bodyPreview(body []byte, size int) (string, bool) {
+	preview := secretValues.ReplaceAllString(string(body), `$1"`+redacted+`"`)
+	preview = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n') {
+			return ' '
+		}
+		return r
+	}, preview)
+	if len(preview) <= size {
+		return preview, false
+	}
+	// don't cut a character in half
+	cut := size
+	for cut > 0 && !utf8.RuneStart(preview[cut]) {
+		cut--
+	}
+	return preview[:cut], true
+}
+
+// previewWriter holds back the json errors that don't come from es, so that
+// the preview can be added to them once they have been written in full.
+type previewWriter struct {
+	http.ResponseWriter
+	code int
+	// the held back error, nil unless the response is one
+	buf *bytes.Buffer
+}
+
+func (pw *previewWriter) WriteHeader(code int) {
+	if pw.buf != nil {
+		return
+	}
+	header := pw.Header()
+	if code >= http.StatusBadRequest && header.Get("X-Origin") != "ES" && util.IsJSONContentType(header.Get("Content-Type")) {
+		pw.code = code
+		pw.buf = &bytes.Buffer{}
+		return
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *previewWriter) Write(b []byte) (int, error) {
+	if pw.buf != nil {
+		return pw.buf.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *previewWriter) Flush() {
+	if flusher, ok := pw.ResponseWriter.(http.Flusher); ok && pw.buf == nil {
+		flusher.Flush()
+	}
+}
+
+// finish writes the held back error with the preview, if any.
+func (pw *previewWriter) finish(preview string, truncated bool) {
+	if pw.buf == nil {
+		return
+	}
+	body := pw.buf.Bytes()
+	var errBody map[string]interface{}
+	if err := json.Unmarshal(body, &errBody); err == nil {
+		if errObject, ok := errBody["error"].(map[string]interface{}); ok {
+			errObject["request_preview"] = preview
+			errObject["request_preview_truncated"] = truncated
+			if raw, err := json.Marshal(errBody); err == nil {
+				body = raw
+			}
+		}
+	}
+	pw.Header().Del("Content-Length")
+	pw.ResponseWriter.WriteHeader(pw.code)
+	pw.ResponseWriter.Write(body)
+}
@@ -0,0 +1,62 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/util"
+)
+
+// categories whose requests are rejected unless they are explicitly enabled,
+// whatever the credential, as they can reshape cluster-wide behavior
+var privilegedCategories = map[category.Category]bool{
+	category.IndexTemplates: true,
+}
+
+func (es *elasticsearch) initPrivilegedCategories() error {
+	es.enabledCategories = make(map[category.Category]bool)
+	for _, name := range envList(envPrivilegedCategories) {
+		c, err := parseCategory(name)
+		if err != nil {
+			return err
+		}
+		if !privilegedCategories[c] {
+			return fmt.Errorf("%s: category %q isn't a privileged one", envPrivilegedCategories, name)
+		}
+		es.enabledCategories[c] = true
+	}
+	return nil
+}
+
+// enabledPrivilegedCategories returns the names of the enabled privileged categories.
+func (es *elasticsearch) enabledPrivilegedCategories() []string {
+	names := []string{}
+	for c := range es.enabledCategories {
+		names = append(names, c.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// blockPrivileged rejects the requests of the privileged categories that
+// haven't been enabled.
+func (es *elasticsearch) blockPrivileged(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqCategory, err := category.FromContext(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating request category", http.StatusInternalServerError)
+			return
+		}
+		if privilegedCategories[*reqCategory] && !es.enabledCategories[*reqCategory] {
+			msg := fmt.Sprintf(`category "%s" is disabled, add it to %s to enable it`, reqCategory, envPrivilegedCategories)
+			util.WriteBackDenied(w, req, msg, http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,256 @@
+package elasticsearch
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/util"
+)
+
+const defaultRequestQueueSize = 100
+
+var errQueueFull = errors.New("too many requests queued for elasticsearch, try again later")
+
+// requestQueue limits the number of requests forwarded to es at once, the
+// requests over the limit wait for a slot and are let through by priority
+// of their category, then in their order of arrival.
+type requestQueue struct {
+	limit      int
+	size       int
+	priorities map[category.Category]int
+
+	mu       sync.Mutex
+	active   int
+	waiting  waiters
+	seq      int64
+	rejected int64
+}
+
+// waiter is a request waiting for a slot, ready is closed once it has been
+// given one or has been rejected.
+type waiter struct {
+	priority int
+	seq      int64
+	ready    chan struct{}
+	rejected bool
+	// position in the heap, -1 once out of it
+	index int
+}
+
+// waiters is a heap of the waiting requests, highest priority first.
+type waiters []*waiter
+
+func (w waiters) Len() int { return len(w) }
+
+func (w waiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w waiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *waiters) Push(x interface{}) {
+	item := x.(*waiter)
+	item.index = len(*w)
+	*w = append(*w, item)
+}
+
+func (w *waiters) Pop() interface{} {
+	old := *w
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	item.index = -1
+	*w = old[:len(old)-1]
+	return item
+}
+
+func (es *elasticsearch) initRequestQueue() error {
+	value := os.Getenv(envRequestConcurrency)
+	if value == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	// no request would ever get a slot otherwise
+	if limit <= 0 {
+		return fmt.Errorf("%s must be a positive number, got %d", envRequestConcurrency, limit)
+	}
+	queue := &requestQueue{limit: limit, size: defaultRequestQueueSize}
+	if value := os.Getenv(envRequestQueueSize); value != "" {
+		if queue.size, err = strconv.Atoi(value); err != nil {
+			return err
+		}
+		if queue.size < 0 {
+			return fmt.Errorf("%s can't be negative, got %d", envRequestQueueSize, queue.size)
+		}
+	}
+	if queue.priorities, err = categoryPriorities(os.Getenv(envRequestPriorities)); err != nil {
+		return err
+	}
+	es.requestQueue = queue
+	return nil
+}
+
+// categoryPriorities parses a comma separated list of category:priority
+// pairs, e.g. "search:10,docs:1".
+func categoryPriorities(value string) (map[category.Category]int, error) {
+	priorities := make(map[category.Category]int)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid category priority %q, expected category:priority", pair)
+		}
+		c, err := parseCategory(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		priorities[c] = priority
+	}
+	return priorities, nil
+}
+
+// acquire waits for a slot to forward the request of the category. It fails
+// if the queue is full of requests of the same or a higher priority, if the
+// request gets rejected in favor of a higher priority one while it waits or
+// if ctx is done first. The returned func releases the slot.
+func (q *requestQueue) acquire(ctx context.Context, c category.Category) (func(), error) {
+	q.mu.Lock()
+	if q.active < q.limit && len(q.waiting) == 0 {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	w := &waiter{priority: q.priorities[c], seq: q.seq, ready: make(chan struct{})}
+	q.seq++
+	if len(q.waiting) >= q.size {
+		lowest := q.lowest()
+		if lowest == nil || lowest.priority >= w.priority {
+			q.rejected++
+			q.mu.Unlock()
+			return nil, errQueueFull
+		}
+		heap.Remove(&q.waiting, lowest.index)
+		lowest.rejected = true
+		q.rejected++
+		close(lowest.ready)
+	}
+	heap.Push(&q.waiting, w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		if w.rejected {
+			return nil, errQueueFull
+		}
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		queued := w.index >= 0
+		if queued {
+			heap.Remove(&q.waiting, w.index)
+		}
+		q.mu.Unlock()
+		// the slot may have been handed over as ctx got done
+		if !queued && !w.rejected {
+			q.release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// lowest returns the waiting request to be rejected first, the most recent
+// one of the lowest priority.
+func (q *requestQueue) lowest() *waiter {
+	var lowest *waiter
+	for _, w := range q.waiting {
+		if lowest == nil || w.priority < lowest.priority ||
+			(w.priority == lowest.priority && w.seq > lowest.seq) {
+			lowest = w
+		}
+	}
+	return lowest
+}
+
+// release hands the slot over to the next waiting request, if any.
+func (q *requestQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) == 0 {
+		q.active--
+		return
+	}
+	next := heap.Pop(&q.waiting).(*waiter)
+	close(next.ready)
+}
+
+// deadline returns the time by which the request of the category must have
+// been answered, zero if it has no timeout.
+func (es *elasticsearch) deadline(c category.Category) time.Time {
+	if timeout := es.timeout(c); timeout > 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
+// queued waits for a slot of the request queue, if enabled, until the
+// deadline of the request, if any, which the wait counts against. The error
+// is written back if the request doesn't get one, the returned func releases
+// the slot otherwise.
+func (es *elasticsearch) queued(ctx context.Context, w http.ResponseWriter, c category.Category, deadline time.Time) (func(), bool) {
+	if es.requestQueue == nil {
+		return func() {}, true
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	release, err := es.requestQueue.acquire(ctx, c)
+	switch {
+	case err == errQueueFull:
+		log.Warnln(logTag, ": rejecting a", c, "request, the request queue is full")
+		util.WriteBackError(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	case err != nil:
+		util.WriteBackError(w, "timed out waiting for elasticsearch", http.StatusGatewayTimeout)
+		return nil, false
+	}
+	return release, true
+}
+
+func (q *requestQueue) stats() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return map[string]interface{}{
+		"active":   q.active,
+		"waiting":  len(q.waiting),
+		"rejected": q.rejected,
+	}
+}
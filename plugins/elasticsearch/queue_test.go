@@ -0,0 +1,162 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestQueue(t *testing.T) {
+	Convey("Request queue", t, func() {
+		queue := &requestQueue{
+			limit:      1,
+			size:       10,
+			priorities: map[category.Category]int{category.Search: 10, category.Docs: 1},
+		}
+		// waitFor waits for the number of waiting requests to reach n
+		waitFor := func(n int) {
+			deadline := time.Now().Add(time.Second)
+			for queue.stats()["waiting"] != n && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		type result struct {
+			category category.Category
+			release  func()
+			err      error
+		}
+		results := make(chan result, 3)
+		enqueue := func(ctx context.Context, c category.Category) {
+			go func() {
+				release, err := queue.acquire(ctx, c)
+				results <- result{c, release, err}
+			}()
+		}
+
+		release, err := queue.acquire(context.Background(), category.Docs)
+		So(err, ShouldBeNil)
+
+		Convey("serves a high priority request before a queued low priority one", func() {
+			enqueue(context.Background(), category.Docs)
+			waitFor(1)
+			enqueue(context.Background(), category.Search)
+			waitFor(2)
+			So(queue.stats()["active"], ShouldEqual, 1)
+
+			release()
+			first := <-results
+			So(first.err, ShouldBeNil)
+			So(first.category, ShouldEqual, category.Search)
+			So(queue.stats()["waiting"], ShouldEqual, 1)
+
+			first.release()
+			second := <-results
+			So(second.err, ShouldBeNil)
+			So(second.category, ShouldEqual, category.Docs)
+			second.release()
+			So(queue.stats()["active"], ShouldEqual, 0)
+		})
+		Convey("rejects the low priority requests first once full", func() {
+			queue.size = 1
+			enqueue(context.Background(), category.Docs)
+			waitFor(1)
+			enqueue(context.Background(), category.Search)
+			rejected := <-results
+			So(rejected.category, ShouldEqual, category.Docs)
+			So(rejected.err, ShouldEqual, errQueueFull)
+
+			_, err := queue.acquire(context.Background(), category.Docs)
+			So(err, ShouldEqual, errQueueFull)
+			So(queue.stats()["rejected"], ShouldEqual, int64(2))
+
+			release()
+			served := <-results
+			So(served.category, ShouldEqual, category.Search)
+			served.release()
+		})
+		Convey("drops the requests done waiting", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			enqueue(ctx, category.Search)
+			waitFor(1)
+			cancel()
+			So((<-results).err, ShouldEqual, context.Canceled)
+			So(queue.stats()["waiting"], ShouldEqual, 0)
+
+			release()
+			So(queue.stats()["active"], ShouldEqual, 0)
+		})
+	})
+
+	Convey("Queued requests", t, func() {
+		os.Setenv(envRequestConcurrency, "1")
+		os.Setenv(envRequestQueueSize, "0")
+		os.Setenv(envRequestPriorities, "search:10,docs:1")
+		defer os.Unsetenv(envRequestConcurrency)
+		defer os.Unsetenv(envRequestQueueSize)
+		defer os.Unsetenv(envRequestPriorities)
+		es := &elasticsearch{}
+		So(es.initRequestQueue(), ShouldBeNil)
+		So(es.requestQueue.priorities, ShouldResemble, map[category.Category]int{category.Search: 10, category.Docs: 1})
+
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"took":1}`))
+		})
+		defer upstream.Close()
+		search := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+			resp := httptest.NewRecorder()
+			es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+			return resp
+		}
+		So(search().Code, ShouldEqual, http.StatusOK)
+		So(es.requestQueue.stats()["active"], ShouldEqual, 0)
+
+		release, err := es.requestQueue.acquire(context.Background(), category.Docs)
+		So(err, ShouldBeNil)
+		So(search().Code, ShouldEqual, http.StatusServiceUnavailable)
+		release()
+		So(search().Code, ShouldEqual, http.StatusOK)
+
+		os.Setenv(envRequestPriorities, "search")
+		So((&elasticsearch{}).initRequestQueue(), ShouldNotBeNil)
+		os.Setenv(envRequestPriorities, "")
+		for _, limit := range []string{"0", "-1"} {
+			os.Setenv(envRequestConcurrency, limit)
+			So((&elasticsearch{}).initRequestQueue(), ShouldNotBeNil)
+		}
+	})
+
+	Convey("The wait for a slot counts against the timeout", t, func() {
+		es := &elasticsearch{
+			requestQueue: &requestQueue{limit: 1, size: 10},
+			timeouts:     map[category.Category]time.Duration{category.Search: 200 * time.Millisecond},
+		}
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(150 * time.Millisecond)
+			w.Write([]byte(`{"took":1}`))
+		})
+		defer upstream.Close()
+
+		release, err := es.requestQueue.acquire(context.Background(), category.Docs)
+		So(err, ShouldBeNil)
+		codes := make(chan int)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+			resp := httptest.NewRecorder()
+			es.handler()(resp, classified(req, category.Search, acl.Search, op.Read))
+			codes <- resp.Code
+		}()
+		time.Sleep(100 * time.Millisecond)
+		release()
+		So(<-codes, ShouldEqual, http.StatusGatewayTimeout)
+	})
+}
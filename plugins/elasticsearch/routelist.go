@@ -0,0 +1,164 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/plugins"
+	"github.com/appbaseio/arc/util"
+)
+
+const (
+	defaultRouteListSize = 100
+	maxRouteListSize     = 1000
+)
+
+// routeEntry is a route of the route listing, one per method. The routes
+// of the es apis are documented by their spec.
+type routeEntry struct {
+	Method        string     `json:"method"`
+	Path          string     `json:"path"`
+	Name          string     `json:"name"`
+	Category      string     `json:"category"`
+	ACL           string     `json:"acl,omitempty"`
+	Op            string     `json:"op,omitempty"`
+	Documentation string     `json:"documentation,omitempty"`
+	Body          *routeBody `json:"body,omitempty"`
+}
+
+// routeBody describes the body of the requests of a route, if they take one.
+type routeBody struct {
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// routeListParams are the pagination, filtering and sorting of the route listing.
+type routeListParams struct {
+	From     int
+	Size     int
+	Method   string
+	Category string
+	SortBy   string
+}
+
+// parseRouteListParams reads the params of the route listing, the size
+// defaults to 100 when it is invalid or exceeds 1000, like for the logs.
+func parseRouteListParams(values url.Values) (routeListParams, error) {
+	params := routeListParams{
+		Size:     defaultRouteListSize,
+		Method:   strings.ToUpper(values.Get("method")),
+		Category: strings.ToLower(values.Get("category")),
+		SortBy:   values.Get("sort"),
+	}
+	if from := values.Get("from"); from != "" {
+		value, err := strconv.Atoi(from)
+		if err != nil || value < 0 {
+			return params, fmt.Errorf(`invalid value "%v" for query param "from"`, from)
+		}
+		params.From = value
+	}
+	if size := values.Get("size"); size != "" {
+		value, err := strconv.Atoi(size)
+		if err != nil || value < 0 {
+			log.Errorln(logTag, `: invalid "size" value provided, defaulting to`, defaultRouteListSize)
+		} else if value > maxRouteListSize {
+			log.Println(logTag, `: "size" limit exceeded (>`, maxRouteListSize, `), defaulting to`, defaultRouteListSize)
+		} else {
+			params.Size = value
+		}
+	}
+	switch params.SortBy {
+	case "":
+		params.SortBy = "path"
+	case "path", "name":
+	default:
+		return params, fmt.Errorf(`invalid value "%v" for query param "sort", expected "path" or "name"`, params.SortBy)
+	}
+	return params, nil
+}
+
+// listRoutes returns the routes matching the filters, sorted, along with
+// the number of matching routes before the pagination.
+func listRoutes(routes []plugins.Route, specs map[string]api, params routeListParams) ([]routeEntry, int) {
+	seen := make(map[string]bool)
+	var entries []routeEntry
+	for _, r := range routes {
+		for _, method := range r.Methods {
+			key := method + ":" + r.Path
+			// only the first of the duplicate routes is ever matched
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			entry := routeEntry{Method: method, Path: r.Path, Name: r.Name, Category: arcRoutesCategory}
+			if spec, ok := specs[key]; ok {
+				entry.Category = spec.category.String()
+				entry.ACL = spec.acl.String()
+				entry.Op = spec.op.String()
+				if spec.spec != nil {
+					entry.Documentation = spec.spec.Documentation
+					if spec.spec.Body.Description != "" || spec.spec.Body.Required {
+						entry.Body = &routeBody{
+							Description: spec.spec.Body.Description,
+							Required:    spec.spec.Body.Required,
+						}
+					}
+				}
+			}
+			if params.Method != "" && entry.Method != params.Method {
+				continue
+			}
+			if params.Category != "" && entry.Category != params.Category {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if params.SortBy == "name" && a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	total := len(entries)
+	from := util.Min(params.From, total)
+	to := util.Min(from+params.Size, total)
+	return entries[from:to], total
+}
+
+func (es *elasticsearch) routeListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := parseRouteListParams(r.URL.Query())
+		if err != nil {
+			util.WriteBackError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, total := listRoutes(es.routes(), routeSpecs, params)
+		if entries == nil {
+			entries = []routeEntry{}
+		}
+		raw, err := json.Marshal(map[string]interface{}{
+			"total":  total,
+			"from":   params.From,
+			"size":   params.Size,
+			"routes": entries,
+		})
+		if err != nil {
+			log.Errorln(logTag, ": error marshalling routes:", err)
+			util.WriteBackError(w, "error listing routes", http.StatusInternalServerError)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusOK)
+	}
+}
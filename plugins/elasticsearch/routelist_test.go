@@ -0,0 +1,144 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/plugins"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRouteList(t *testing.T) {
+	Convey("Listing the routes", t, func() {
+		routes := []plugins.Route{
+			{Name: "search", Methods: []string{http.MethodGet, http.MethodPost}, Path: "/{index}/_search"},
+			{Name: "cat.indices", Methods: []string{http.MethodGet}, Path: "/_cat/indices"},
+			{Name: "Get arc health", Methods: []string{http.MethodGet}, Path: "/_arc/health"},
+			{Name: "duplicate", Methods: []string{http.MethodGet}, Path: "/_cat/indices"},
+		}
+		specs := map[string]api{
+			"GET:/{index}/_search":  {name: "search", category: category.Search, acl: acl.Search, op: op.Read},
+			"POST:/{index}/_search": {name: "search", category: category.Search, acl: acl.Search, op: op.Read},
+			"GET:/_cat/indices":     {name: "cat.indices", category: category.Cat, acl: acl.Cat, op: op.Read},
+		}
+		list := func(query string) ([]routeEntry, int) {
+			values, err := url.ParseQuery(query)
+			So(err, ShouldBeNil)
+			params, err := parseRouteListParams(values)
+			So(err, ShouldBeNil)
+			return listRoutes(routes, specs, params)
+		}
+		paths := func(entries []routeEntry) []string {
+			var paths []string
+			for _, e := range entries {
+				paths = append(paths, e.Method+" "+e.Path)
+			}
+			return paths
+		}
+
+		Convey("sorted by path by default, one entry per method", func() {
+			entries, total := list("")
+			So(total, ShouldEqual, 4)
+			So(paths(entries), ShouldResemble, []string{
+				"GET /_arc/health", "GET /_cat/indices", "GET /{index}/_search", "POST /{index}/_search",
+			})
+			So(entries[0].Category, ShouldEqual, arcRoutesCategory)
+			So(entries[1], ShouldResemble, routeEntry{
+				Method: http.MethodGet, Path: "/_cat/indices", Name: "cat.indices",
+				Category: "cat", ACL: acl.Cat.String(), Op: op.Read.String(),
+			})
+		})
+		Convey("sorted by name", func() {
+			entries, _ := list("sort=name")
+			So(paths(entries), ShouldResemble, []string{
+				"GET /_arc/health", "GET /_cat/indices", "GET /{index}/_search", "POST /{index}/_search",
+			})
+			So(entries[0].Name, ShouldEqual, "Get arc health")
+			So(entries[1].Name, ShouldEqual, "cat.indices")
+		})
+		Convey("filtered by method and category", func() {
+			entries, total := list("method=post")
+			So(total, ShouldEqual, 1)
+			So(paths(entries), ShouldResemble, []string{"POST /{index}/_search"})
+
+			entries, total = list("category=search&method=GET")
+			So(total, ShouldEqual, 1)
+			So(paths(entries), ShouldResemble, []string{"GET /{index}/_search"})
+
+			entries, total = list("category=arc")
+			So(total, ShouldEqual, 1)
+			So(paths(entries), ShouldResemble, []string{"GET /_arc/health"})
+		})
+		Convey("documented by the specs", func() {
+			// loads the specs
+			specFor(http.MethodGet, "/")
+			values, _ := url.ParseQuery("size=1000")
+			params, err := parseRouteListParams(values)
+			So(err, ShouldBeNil)
+			entries, _ := listRoutes(Instance().routes(), routeSpecs, params)
+			documented := make(map[string]routeEntry)
+			for _, e := range entries {
+				documented[e.Method+" "+e.Path] = e
+			}
+
+			search := documented["POST /{index}/_search"]
+			So(search.Documentation, ShouldEqual, "http://www.elastic.co/guide/en/elasticsearch/reference/master/search-search.html")
+			So(search.Body, ShouldResemble, &routeBody{Description: "The search definition using the Query DSL"})
+			So(documented["PUT /{index}/_doc/{id}"].Body, ShouldResemble, &routeBody{Description: "The document", Required: true})
+			So(documented["GET /_cat/indices"].Documentation, ShouldNotBeEmpty)
+			So(documented["GET /_cat/indices"].Body, ShouldBeNil)
+			// arc's own routes have no spec
+			So(documented["GET /_arc/health"].Documentation, ShouldBeEmpty)
+		})
+		Convey("paginated", func() {
+			entries, total := list("from=1&size=2")
+			So(total, ShouldEqual, 4)
+			So(paths(entries), ShouldResemble, []string{"GET /_cat/indices", "GET /{index}/_search"})
+
+			entries, total = list("from=10")
+			So(total, ShouldEqual, 4)
+			So(entries, ShouldBeEmpty)
+		})
+		Convey("invalid params", func() {
+			_, err := parseRouteListParams(url.Values{"from": {"-1"}})
+			So(err, ShouldNotBeNil)
+			_, err = parseRouteListParams(url.Values{"sort": {"category"}})
+			So(err, ShouldNotBeNil)
+			params, err := parseRouteListParams(url.Values{"size": {"5000"}})
+			So(err, ShouldBeNil)
+			So(params.Size, ShouldEqual, defaultRouteListSize)
+			params, err = parseRouteListParams(url.Values{"size": {"ten"}})
+			So(err, ShouldBeNil)
+			So(params.Size, ShouldEqual, defaultRouteListSize)
+		})
+		Convey("through the handler", func() {
+			specFor(http.MethodGet, "/_search")
+			get := func(query string) (int, map[string]interface{}) {
+				w := httptest.NewRecorder()
+				Instance().routeListHandler()(w, httptest.NewRequest(http.MethodGet, "/_arc/routes?"+query, nil))
+				var body map[string]interface{}
+				So(json.Unmarshal(w.Body.Bytes(), &body), ShouldBeNil)
+				return w.Code, body
+			}
+			code, body := get("method=HEAD&category=indextemplates")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body["total"], ShouldEqual, 3)
+			So(body["routes"], ShouldHaveLength, 3)
+
+			code, body = get("size=1&from=1&category=indextemplates")
+			So(code, ShouldEqual, http.StatusOK)
+			So(body["size"], ShouldEqual, 1)
+			So(body["routes"], ShouldHaveLength, 1)
+
+			code, _ = get("from=abc")
+			So(code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/appbaseio/arc/middleware"
@@ -51,17 +57,130 @@ type spec struct {
 }
 
 func (es *elasticsearch) preprocess(mw []middleware.Middleware) error {
-	files := make(chan string)
-	apis := make(chan api)
+	fallback, err := readSpecFallback()
+	if err != nil {
+		log.Errorln(logTag, ": unable to read", envSpecFallback, ":", err)
+		return err
+	}
+
+	versions, err := readSpecVersions()
+	if err != nil {
+		log.Errorln(logTag, ": unable to read", envSpecVersions, ":", err)
+		return err
+	}
+
+	middlewareFunction := (&chain{}).Wrap
 
 	box := packr.NewBox("./api")
+	limit := &routeLimit{max: es.maxRoutes}
+	timings := newSpecTimings(es.slowSpecDecode)
+	routes = append(routes, es.registerSpecs(&box, "", mw, fallback, limit, timings)...)
+	if es.defaultIndex != "" {
+		routes = append(routes, es.defaultIndexRoutes(routes, mw)...)
+	}
+	var versionRoutes []plugins.Route
+	for _, version := range versions {
+		versionRoutes = append(versionRoutes, es.registerSpecs(specDir(version.dir), version.prefix, mw, fallback, limit, timings)...)
+	}
+	timings.log()
+	if limit.dropped > 0 {
+		log.Errorln(logTag, ": route limit of", es.maxRoutes, "reached,", limit.dropped,
+			"routes from the specs were not registered, check the spec directory or raise", envMaxRoutes)
+	}
 
-	go fetchSpecFiles(&box, files)
-	go decodeSpecFiles(&box, files, apis)
+	if path := os.Getenv(envRouteOverridesFile); path != "" {
+		overrides, err := readRouteOverrides(path)
+		if err != nil {
+			log.Errorln(logTag, ": unable to read route overrides from", path, ":", err)
+			return err
+		}
+		applyRouteOverrides(routeSpecs, overrides)
+	}
+
+	// sort the routes
+	criteria := func(r1, r2 plugins.Route) bool {
+		f1, c1 := util.CountComponents(r1.Path)
+		f2, c2 := util.CountComponents(r2.Path)
+		if f1 == f2 {
+			return c1 < c2
+		}
+		return f1 > f2
+	}
+	plugins.RouteBy(criteria).RouteSort(routes)
+	plugins.RouteBy(criteria).RouteSort(versionRoutes)
+	if len(es.disabledRoutes) > 0 {
+		es.disabledMatcher = newDisabledMatcher(versionRoutes, routes)
+		versionRoutes, routes = enabledRoutes(versionRoutes), enabledRoutes(routes)
+	}
+
+	// append index route last in order to avoid early matches for other specific routes
+	indexRoute := plugins.Route{
+		Name:        "ping",
+		Methods:     []string{http.MethodGet, http.MethodHead},
+		Path:        "/",
+		HandlerFunc: middlewareFunction(mw, es.handler()),
+		Description: "You know, for search",
+	}
+	routes = append(routes, indexRoute)
+	for _, version := range versions {
+		versionRoutes = append(versionRoutes, plugins.Route{
+			Name:        "ping",
+			Methods:     []string{http.MethodGet, http.MethodHead},
+			Path:        version.prefix,
+			HandlerFunc: stripPrefix(version.prefix, middlewareFunction(mw, es.handler())),
+			Description: "You know, for search",
+		})
+	}
+
+	// the routes of the additional spec versions are registered ahead of the
+	// default ones, whose index templates would otherwise match the prefix
+	routes = append(versionRoutes, routes...)
+
+	// arc's own routes are registered ahead of the spec routes so that
+	// they are never proxied to elasticsearch
+	routes = append(es.arcRoutes(), routes...)
+
+	logRouteTable(routes, routeSpecs)
+
+	if os.Getenv(envSpecSelfCheck) != "false" {
+		expected := envList(envExpectedEndpoints)
+		if len(expected) == 0 {
+			expected = defaultExpectedEndpoints
+		}
+		checkSpecs(routeSpecs, expected)
+	}
+	return nil
+}
+
+// routeLimit counts the spec routes registered against the configured maximum.
+type routeLimit struct {
+	max, registered, dropped int
+}
+
+// registerSpecs decodes the specs of the source and returns their routes, with
+// the paths under the given prefix. The classification of each route is
+// recorded in routeSpecs, keyed by its prefixed path. The specs are
+// registered in the order of their names, so that the same routes are
+// dropped on every run once the limit is reached.
+func (es *elasticsearch) registerSpecs(source specSource, prefix string, mw []middleware.Middleware, fallback specFallback, limit *routeLimit, timings *specTimings) []plugins.Route {
+	files := make(chan string)
+	apis := make(chan api)
+
+	go fetchSpecFiles(source, files)
+	go decodeSpecFiles(source, files, apis, fallback, es.specDecoders, timings)
 
 	middlewareFunction := (&chain{}).Wrap
 
+	// the specs are decoded concurrently, in no particular order
+	var decoded []api
 	for api := range apis {
+		decoded = append(decoded, api)
+	}
+	sort.Slice(decoded, func(i, j int) bool { return decoded[i].name < decoded[j].name })
+
+	var specRoutes []plugins.Route
+	for _, api := range decoded {
+		var apiRegistered bool
 		for _, path := range api.spec.URL.Paths {
 			if !strings.HasPrefix(path, "/") {
 				path = "/" + path
@@ -69,19 +188,42 @@ func (es *elasticsearch) preprocess(mw []middleware.Middleware) error {
 			if path == "/" {
 				continue
 			}
+			// the disabled routes are only kept to be matched, see disabledMatcher
+			if es.routeDisabled(api.name, prefix+path) {
+				specRoutes = append(specRoutes, plugins.Route{
+					Name:    api.name,
+					Methods: api.spec.Methods,
+					Path:    prefix + path,
+				})
+				continue
+			}
+			if limit.max > 0 && limit.registered >= limit.max {
+				limit.dropped++
+				continue
+			}
+			limit.registered++
+			apiRegistered = true
+			path = prefix + path
+			h := middlewareFunction(mw, es.handler())
+			if prefix != "" {
+				h = stripPrefix(prefix, h)
+			}
 			r := plugins.Route{
 				Name:        api.name,
 				Methods:     api.spec.Methods,
 				Path:        path,
-				HandlerFunc: middlewareFunction(mw, es.handler()),
+				HandlerFunc: es.rejectDisabled(h),
 				Description: api.spec.Documentation,
 			}
-			routes = append(routes, r)
+			specRoutes = append(specRoutes, r)
 			for _, method := range api.spec.Methods {
 				key := fmt.Sprintf("%s:%s", method, path)
 				routeSpecs[key] = api
 			}
 		}
+		if !apiRegistered {
+			continue
+		}
 		if _, ok := acls[api.category]; !ok {
 			acls[api.category] = make(map[acl.ACL]bool)
 		}
@@ -89,35 +231,296 @@ func (es *elasticsearch) preprocess(mw []middleware.Middleware) error {
 			acls[api.category][api.acl] = true
 		}
 	}
+	return specRoutes
+}
 
-	// sort the routes
-	criteria := func(r1, r2 plugins.Route) bool {
-		f1, c1 := util.CountComponents(r1.Path)
-		f2, c2 := util.CountComponents(r2.Path)
-		if f1 == f2 {
-			return c1 < c2
+// number of paths reported at either end of the route table
+const routeTableEnds = 5
+
+// category under which arc's own routes are counted
+const arcRoutesCategory = "arc"
+
+type routeSummary struct {
+	Total      int            `json:"total"`
+	Categories map[string]int `json:"categories"`
+	First      []string       `json:"first"`
+	Last       []string       `json:"last"`
+}
+
+// summarizeRoutes counts the routes per category, arc's own routes don't
+// have a spec, and lists the paths at both ends of the table, in the order
+// the routes are registered.
+func summarizeRoutes(routes []plugins.Route, specs map[string]api) routeSummary {
+	summary := routeSummary{
+		Total:      len(routes),
+		Categories: make(map[string]int),
+	}
+	for _, r := range routes {
+		name := arcRoutesCategory
+		for _, method := range r.Methods {
+			if spec, ok := specs[method+":"+r.Path]; ok {
+				name = spec.category.String()
+				break
+			}
 		}
-		return f1 > f2
+		summary.Categories[name]++
 	}
-	plugins.RouteBy(criteria).RouteSort(routes)
+	ends := util.Min(routeTableEnds, len(routes))
+	for _, r := range routes[:ends] {
+		summary.First = append(summary.First, r.Path)
+	}
+	for _, r := range routes[len(routes)-ends:] {
+		summary.Last = append(summary.Last, r.Path)
+	}
+	return summary
+}
 
-	// append index route last in order to avoid early matches for other specific routes
-	indexRoute := plugins.Route{
-		Name:        "ping",
-		Methods:     []string{http.MethodGet, http.MethodHead},
-		Path:        "/",
-		HandlerFunc: middlewareFunction(mw, es.handler()),
-		Description: "You know, for search",
+// logRouteTable logs the summary of the route table, every route is logged at debug level.
+func logRouteTable(routes []plugins.Route, specs map[string]api) {
+	summary := summarizeRoutes(routes, specs)
+	log.WithFields(log.Fields{
+		"total":      summary.Total,
+		"categories": summary.Categories,
+		"first":      summary.First,
+		"last":       summary.Last,
+	}).Infoln(logTag, ": route table")
+	if log.IsLevelEnabled(log.DebugLevel) {
+		for _, r := range routes {
+			log.Debugln(logTag, ": route", r.Name, r.Methods, r.Path)
+		}
+	}
+}
+
+// routeDisabled checks whether the spec route has been disabled by name or
+// template. Disabled routes aren't registered, their requests are answered
+// with a 404.
+func (es *elasticsearch) routeDisabled(name, template string) bool {
+	for _, pattern := range es.disabledRoutes {
+		nameMatch, _ := filepath.Match(pattern, name)
+		templateMatch, _ := filepath.Match(pattern, template)
+		if nameMatch || templateMatch {
+			log.Println(logTag, ": disabling route", name, template)
+			return true
+		}
+	}
+	return false
+}
+
+// disabledMatcher matches the spec routes, the disabled ones included, in
+// the order they are registered in, so that the requests to a disabled
+// route aren't served by a less specific one instead, e.g. a
+// POST /{index}/_delete_by_query by POST /{index}/{type}.
+type disabledMatcher struct {
+	router   *mux.Router
+	disabled map[*mux.Route]bool
+}
+
+// newDisabledMatcher returns the matcher of the route lists, in order, the
+// disabled routes being the ones without a handler.
+func newDisabledMatcher(lists ...[]plugins.Route) *disabledMatcher {
+	m := &disabledMatcher{router: mux.NewRouter(), disabled: make(map[*mux.Route]bool)}
+	for _, list := range lists {
+		for _, r := range list {
+			route := m.router.Methods(r.Methods...).Path(r.Path)
+			if r.HandlerFunc == nil {
+				m.disabled[route] = true
+			}
+		}
+	}
+	return m
+}
+
+// matches checks whether the request is one of a disabled route.
+func (m *disabledMatcher) matches(r *http.Request) bool {
+	var match mux.RouteMatch
+	return m.router.Match(r, &match) && match.MatchErr == nil && m.disabled[match.Route]
+}
+
+// enabledRoutes returns the routes that have a handler.
+func enabledRoutes(list []plugins.Route) []plugins.Route {
+	var enabled []plugins.Route
+	for _, r := range list {
+		if r.HandlerFunc != nil {
+			enabled = append(enabled, r)
+		}
+	}
+	return enabled
+}
+
+// rejectDisabled answers the requests to the disabled routes as if no route
+// matched them.
+func (es *elasticsearch) rejectDisabled(h http.HandlerFunc) http.HandlerFunc {
+	if len(es.disabledRoutes) == 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if es.disabledMatcher != nil && es.disabledMatcher.matches(r) {
+			plugins.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// endpoints that must be served by any sane spec set
+var defaultExpectedEndpoints = []string{"_search", "_bulk", "_doc"}
+
+// checkSpecs logs the number of registered routes and warns about the expected
+// endpoints that are missing from the decoded specs, which is usually a sign
+// of a spec that failed to load.
+func checkSpecs(specs map[string]api, expected []string) []string {
+	log.Infoln(logTag, ":", len(specs), "routes registered from specs")
+	found := make(map[string]bool)
+	for key := range specs {
+		for _, token := range strings.Split(key, "/") {
+			found[token] = true
+		}
+	}
+	var missing []string
+	for _, endpoint := range expected {
+		if !found[endpoint] {
+			missing = append(missing, endpoint)
+		}
+	}
+	if len(missing) > 0 {
+		log.Warnln(logTag, ": expected endpoints missing from the specs:", strings.Join(missing, ", "))
+	}
+	return missing
+}
+
+func (es *elasticsearch) arcRoutes() []plugins.Route {
+	return []plugins.Route{
+		{
+			Name:        "Get arc health",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/health",
+			HandlerFunc: es.healthHandler(),
+			Description: "Returns the health of the gateway",
+		},
+		{
+			Name:        "Get arc health details",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/health/details",
+			HandlerFunc: (&adminChain{}).Wrap(es.healthDetailsHandler()),
+			Description: "Returns the health of the gateway and the outcome of each health check, admin only",
+		},
+		{
+			Name:        "Get arc version",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/version",
+			HandlerFunc: es.versionHandler(),
+			Description: "Returns the arc, go and elasticsearch versions",
+		},
+		{
+			Name:        "Get queued bulk status",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/bulk/{id}",
+			HandlerFunc: (&bulkStatusChain{}).Wrap(es.bulkStatusHandler()),
+			Description: "Returns the status of a queued bulk request to the principal that queued it or an admin",
+		},
+		{
+			Name:        "Get arc config",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/config",
+			HandlerFunc: (&adminChain{}).Wrap(es.configHandler()),
+			Description: "Returns the effective runtime configuration, admin only",
+		},
+		{
+			Name:        "Get captured requests",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/captures",
+			HandlerFunc: (&adminChain{}).Wrap(es.capturesHandler()),
+			Description: "Returns the recently captured requests, admin only",
+		},
+		{
+			Name:        "List routes",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/routes",
+			HandlerFunc: (&adminChain{}).Wrap(es.routeListHandler()),
+			Description: "Returns the registered routes and their classification, paginated, admin only",
+		},
+		{
+			Name:        "Get index operation stats",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/stats/indices",
+			HandlerFunc: (&adminChain{}).Wrap(es.indexStatsHandler()),
+			Description: "Returns the read, write and delete counts by index, admin only",
+		},
+		{
+			Name:        "Get response cache stats",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/cache/stats",
+			HandlerFunc: (&adminChain{}).Wrap(es.cacheStatsHandler()),
+			Description: "Returns the hits, misses, hit ratio, evictions and size of the response cache, admin only",
+		},
+		{
+			Name:        "Get response cache entry",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_arc/cache/{requestID}",
+			HandlerFunc: (&adminChain{}).Wrap(es.cacheEntryHandler()),
+			Description: "Returns the response cached for a request key and its metadata, admin only",
+		},
+		{
+			Name:        "Reload es credentials",
+			Methods:     []string{http.MethodPost},
+			Path:        "/_arc/reload-credentials",
+			HandlerFunc: (&adminChain{}).Wrap(es.reloadCredentialsHandler()),
+			Description: "Swaps the es credentials without restarting arc, admin only",
+		},
+	}
+}
+
+// routeOverride explicitly sets the classification of a route, taking
+// precedence over the values decoded from its spec.
+type routeOverride struct {
+	Category *category.Category `json:"category,omitempty"`
+	ACL      *acl.ACL           `json:"acl,omitempty"`
+	Op       *op.Operation      `json:"op,omitempty"`
+}
+
+// readRouteOverrides reads the overrides keyed by "METHOD:path" from the given json file.
+func readRouteOverrides(path string) (map[string]routeOverride, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]routeOverride)
+	if err := json.Unmarshal(content, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func applyRouteOverrides(specs map[string]api, overrides map[string]routeOverride) {
+	for key, override := range overrides {
+		spec, ok := specs[key]
+		if !ok {
+			log.Warnln(logTag, ": ignoring override for unknown route", key)
+			continue
+		}
+		if override.Category != nil {
+			spec.category = *override.Category
+		}
+		if override.ACL != nil {
+			spec.acl = *override.ACL
+		}
+		if override.Op != nil {
+			spec.op = *override.Op
+		}
+		specs[key] = spec
+		if _, ok := acls[spec.category]; !ok {
+			acls[spec.category] = make(map[acl.ACL]bool)
+		}
+		acls[spec.category][spec.acl] = true
+		log.Println(logTag, ": route", key, "classified as category=", spec.category, ", acl=", spec.acl, ", op=", spec.op)
 	}
-	routes = append(routes, indexRoute)
-	return nil
 }
 
 func (es *elasticsearch) routes() []plugins.Route {
 	return routes
 }
 
-func fetchSpecFiles(box *packr.Box, files chan<- string) {
+func fetchSpecFiles(box specSource, files chan<- string) {
 	defer close(files)
 	for _, file := range box.List() {
 		if filepath.Ext(file) == ".json" && !strings.HasPrefix(file, "_") {
@@ -126,11 +529,22 @@ func fetchSpecFiles(box *packr.Box, files chan<- string) {
 	}
 }
 
-func decodeSpecFiles(box *packr.Box, files <-chan string, apis chan<- api) {
+// decodeSpecFiles decodes the spec files with a pool of decoders, so that
+// the startup resource usage doesn't grow with the number of spec files. The
+// time each file takes to decode is recorded in timings, if not nil.
+func decodeSpecFiles(box specSource, files <-chan string, apis chan<- api, fallback specFallback, decoders int, timings *specTimings) {
+	if decoders <= 0 {
+		decoders = runtime.GOMAXPROCS(0)
+	}
 	var wg sync.WaitGroup
-	for file := range files {
+	for i := 0; i < decoders; i++ {
 		wg.Add(1)
-		go decodeSpecFile(box, file, &wg, apis)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				decodeSpecFile(box, file, apis, fallback, timings)
+			}
+		}()
 	}
 
 	go func() {
@@ -139,9 +553,8 @@ func decodeSpecFiles(box *packr.Box, files <-chan string, apis chan<- api) {
 	}()
 }
 
-func decodeSpecFile(box *packr.Box, file string, wg *sync.WaitGroup, apis chan<- api) {
-	defer wg.Done()
-
+func decodeSpecFile(box specSource, file string, apis chan<- api, fallback specFallback, timings *specTimings) {
+	started := time.Now()
 	content, err := box.Find(file)
 	if err != nil {
 		log.Errorln("can't read file:", err)
@@ -168,56 +581,155 @@ func decodeSpecFile(box *packr.Box, file string, wg *sync.WaitGroup, apis chan<-
 	}
 
 	specName := strings.TrimSuffix(filepath.Base(file), ".json")
-	specCategory := decodeCategory(&s)
-	specOp := decodeOp(&s)
-	specACL, err := decodeACL(specName, &s)
-	if err != nil {
-		log.Errorln(logTag, ": unable to categorize spec", specName, ":", err)
+	classified := classifySpec(specName, &s, fallback)
+	// the time spent waiting for the routes to be registered isn't counted
+	timings.record(file, time.Since(started))
+	apis <- classified
+}
+
+// specFallback is the classification applied to the specs that can't be decoded.
+type specFallback struct {
+	Category category.Category `json:"category"`
+	ACL      acl.ACL           `json:"acl"`
+	Op       op.Operation      `json:"op"`
+}
+
+var defaultSpecFallback = specFallback{
+	Category: category.Misc,
+	ACL:      acl.Get,
+	Op:       op.Read,
+}
+
+// readSpecFallback returns the configured fallback classification, the
+// values that aren't configured are taken from defaultSpecFallback.
+func readSpecFallback() (specFallback, error) {
+	fallback := defaultSpecFallback
+	if value := os.Getenv(envSpecFallback); value != "" {
+		if err := json.Unmarshal([]byte(value), &fallback); err != nil {
+			return defaultSpecFallback, err
+		}
 	}
+	return fallback, nil
+}
 
-	apis <- api{
+// classifySpec decodes the category, acl and op of the spec, the fallback
+// is applied to the ones that fail to decode.
+func classifySpec(specName string, s *spec, fallback specFallback) api {
+	specCategory, err := decodeCategory(s)
+	if err != nil {
+		log.Warnln(logTag, ": spec", specName, "classified with fallback category", fallback.Category, ":", err)
+		specCategory = fallback.Category
+	}
+	specACL, err := decodeACL(specName, s)
+	if err != nil {
+		log.Warnln(logTag, ": spec", specName, "classified with fallback acl", fallback.ACL, ":", err)
+		specACL = fallback.ACL
+	}
+	specOp, err := decodeOp(specName, s)
+	if err != nil {
+		log.Warnln(logTag, ": spec", specName, "classified with fallback op", fallback.Op, ":", err)
+		specOp = fallback.Op
+	}
+	return api{
 		name:     specName,
 		category: specCategory,
 		op:       specOp,
-		acl:      *specACL,
-		spec:     &s,
+		acl:      specACL,
+		spec:     s,
+	}
+}
+
+// documentation tags that map to a category
+var categoryTags = map[string]category.Category{
+	"docs":    category.Docs,
+	"search":  category.Search,
+	"indices": category.Indices,
+	"cat":     category.Cat,
+	"tasks":   category.Clusters,
+	"cluster": category.Clusters,
+	// sql queries are searches
+	"sql": category.Search,
+	// the scripts, snapshots, ingest pipelines and the root endpoints, i.e.
+	// ping and info, whose documentation is the guide itself
+	"modules":  category.Misc,
+	"ingest":   category.Misc,
+	"painless": category.Misc,
+	"":         category.Misc,
+}
+
+// acls of the specs whose acl can't be decoded from their path or name
+var specACLs = map[string]acl.ACL{
+	"sql.query":     acl.Search,
+	"sql.translate": acl.Search,
+}
+
+// ops of the specs that take a body but only read, whose op can't be decoded
+// from their methods
+var specOps = map[string]op.Operation{
+	"indices.analyze": op.Read,
+}
+
+// path components of the template endpoints, which reshape the indices
+// created afterwards cluster-wide
+var templateEndpoints = map[string]bool{
+	"_template":           true,
+	"_index_template":     true,
+	"_component_template": true,
+}
+
+// isTemplateSpec checks whether the spec is one of a template endpoint.
+func isTemplateSpec(spec *spec) bool {
+	for _, pathToken := range strings.Split(spec.URL.Path, "/") {
+		if templateEndpoints[pathToken] {
+			return true
+		}
 	}
+	return false
 }
 
-func decodeCategory(spec *spec) category.Category {
+func decodeCategory(spec *spec) (category.Category, error) {
+	if isTemplateSpec(spec) {
+		return category.IndexTemplates, nil
+	}
 	docTokens := strings.Split(spec.Documentation, "/")
 	tag := strings.TrimSuffix(docTokens[len(docTokens)-1], ".html")
 	tagTokens := strings.Split(tag, "-")
 	tagName := tagTokens[0]
-	return category.FromString(tagName)
+	specCategory, ok := categoryTags[tagName]
+	if !ok {
+		return category.Misc, fmt.Errorf("documentation tag %q does not belong to a category", tagName)
+	}
+	return specCategory, nil
 }
 
-func decodeACL(specName string, spec *spec) (*acl.ACL, error) {
+func decodeACL(specName string, spec *spec) (acl.ACL, error) {
+	if specACL, ok := specACLs[specName]; ok {
+		return specACL, nil
+	}
+	if isTemplateSpec(spec) {
+		return acl.Template, nil
+	}
 	pathTokens := strings.Split(spec.URL.Path, "/")
 	for _, pathToken := range pathTokens {
 		if strings.HasPrefix(pathToken, "_") {
 			pathToken = strings.TrimPrefix(pathToken, "_")
-			c, err := acl.FromString(pathToken)
-			if err != nil {
-				return nil, err
-			}
-			return &c, nil
+			return acl.FromString(pathToken)
 		}
 	}
 
 	aclString := strings.Split(specName, ".")[0]
-	a, err := acl.FromString(aclString)
-	if err != nil {
-		defaultACL := acl.Get
-		return &defaultACL, err
-	}
-
-	return &a, nil
+	return acl.FromString(aclString)
 }
 
-func decodeOp(spec *spec) op.Operation {
+func decodeOp(specName string, spec *spec) (op.Operation, error) {
+	if specOp, ok := specOps[specName]; ok {
+		return specOp, nil
+	}
 	var specOp op.Operation
 	methods := spec.Methods
+	if len(methods) == 0 {
+		return specOp, fmt.Errorf("spec has no methods")
+	}
 
 out:
 	for _, method := range methods {
@@ -240,12 +752,11 @@ out:
 		case http.MethodPost:
 			specOp = op.Write
 		default:
-			specOp = op.Read
-			break out
+			return specOp, fmt.Errorf("unknown method %q", method)
 		}
 	}
 
-	return specOp
+	return specOp, nil
 }
 
 func printCategoryACLMDTable() {
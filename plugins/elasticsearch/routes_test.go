@@ -0,0 +1,504 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/plugins"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var loadSpecs sync.Once
+
+// specFor returns the decoded spec registered against the method and route template.
+func specFor(method, path string) api {
+	loadSpecs.Do(func() {
+		if err := Instance().preprocess(nil); err != nil {
+			panic(err)
+		}
+	})
+	return routeSpecs[method+":"+path]
+}
+
+// concurrencySource records the maximum number of spec files read at once.
+type concurrencySource struct {
+	specDir
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (s *concurrencySource) Find(name string) ([]byte, error) {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.max {
+		s.max = s.running
+	}
+	s.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+	return s.specDir.Find(name)
+}
+
+func TestRoutes(t *testing.T) {
+	Convey("Routes", t, func() {
+		Convey("Async reindex flow", func() {
+			reindex := specFor(http.MethodPost, "/_reindex")
+			So(reindex.category, ShouldEqual, category.Docs)
+			So(reindex.acl, ShouldEqual, acl.Reindex)
+			So(reindex.op, ShouldEqual, op.Write)
+
+			task := specFor(http.MethodGet, "/_tasks/{task_id}")
+			So(task.category, ShouldEqual, category.Clusters)
+			So(task.acl, ShouldEqual, acl.Tasks)
+			So(task.op, ShouldEqual, op.Read)
+
+			cancel := specFor(http.MethodPost, "/_tasks/{task_id}/_cancel")
+			So(cancel.category, ShouldEqual, category.Clusters)
+			So(cancel.acl, ShouldEqual, acl.Tasks)
+			So(cancel.op, ShouldEqual, op.Write)
+		})
+		Convey("Search templates", func() {
+			for _, path := range []string{"/_search/template", "/{index}/_search/template"} {
+				search := specFor(http.MethodPost, path)
+				So(search.category, ShouldEqual, category.Search)
+				So(search.acl, ShouldEqual, acl.Search)
+				So(search.op, ShouldEqual, op.Read)
+			}
+
+			render := specFor(http.MethodPost, "/_render/template/{id}")
+			So(render.category, ShouldEqual, category.Search)
+			So(render.acl, ShouldEqual, acl.Render)
+			So(render.op, ShouldEqual, op.Read)
+
+			msearch := specFor(http.MethodPost, "/{index}/_msearch/template")
+			So(msearch.category, ShouldEqual, category.Search)
+			So(msearch.acl, ShouldEqual, acl.Msearch)
+		})
+		Convey("Index templates", func() {
+			for _, path := range []string{"/_template/{name}", "/_index_template/{name}", "/_component_template/{name}"} {
+				put := specFor(http.MethodPut, path)
+				So(put.category, ShouldEqual, category.IndexTemplates)
+				So(put.acl, ShouldEqual, acl.Template)
+				So(put.op, ShouldEqual, op.Write)
+				So(specFor(http.MethodGet, path).category, ShouldEqual, category.IndexTemplates)
+				So(specFor(http.MethodDelete, path).category, ShouldEqual, category.IndexTemplates)
+			}
+			// the search templates aren't privileged
+			So(specFor(http.MethodPost, "/_render/template/{id}").category, ShouldEqual, category.Search)
+
+			put := specFor(http.MethodPut, "/_index_template/{name}")
+			serve := func(es *elasticsearch) int {
+				resp := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPut, "/_index_template/logs", strings.NewReader(`{"index_patterns":["logs-*"]}`))
+				es.blockPrivileged(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				})(resp, classified(req, put.category, put.acl, put.op))
+				return resp.Code
+			}
+
+			os.Unsetenv(envPrivilegedCategories)
+			blocked := &elasticsearch{}
+			So(blocked.initPrivilegedCategories(), ShouldBeNil)
+			So(serve(blocked), ShouldEqual, http.StatusForbidden)
+
+			os.Setenv(envPrivilegedCategories, "indextemplates")
+			defer os.Unsetenv(envPrivilegedCategories)
+			enabled := &elasticsearch{}
+			So(enabled.initPrivilegedCategories(), ShouldBeNil)
+			So(serve(enabled), ShouldEqual, http.StatusOK)
+
+			// only the privileged categories can be listed
+			os.Setenv(envPrivilegedCategories, "search")
+			So((&elasticsearch{}).initPrivilegedCategories(), ShouldNotBeNil)
+		})
+		Convey("Spec self-check", func() {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+			specs := map[string]api{
+				"POST:/_bulk":                  {},
+				"PUT:/{index}/_doc/{id}":       {},
+				"GET:/{index}/_count":          {},
+				"GET:/_cluster/health/{index}": {},
+			}
+
+			Convey("warns about a missing _search", func() {
+				missing := checkSpecs(specs, defaultExpectedEndpoints)
+				So(missing, ShouldResemble, []string{"_search"})
+				So(hook.LastEntry().Level, ShouldEqual, log.WarnLevel)
+				So(hook.LastEntry().Message, ShouldContainSubstring, "_search")
+			})
+			Convey("passes on the complete set of specs", func() {
+				specFor(http.MethodGet, "/_search")
+				So(checkSpecs(routeSpecs, defaultExpectedEndpoints), ShouldBeEmpty)
+			})
+		})
+		Convey("SQL", func() {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				query := specFor(method, "/_sql")
+				So(query.category, ShouldEqual, category.Search)
+				So(query.acl, ShouldEqual, acl.Search)
+				So(query.op, ShouldEqual, op.Read)
+			}
+			translate := specFor(http.MethodPost, "/_sql/translate")
+			So(translate.category, ShouldEqual, category.Search)
+			So(translate.acl, ShouldEqual, acl.Search)
+		})
+		Convey("Analyze", func() {
+			for _, path := range []string{"/_analyze", "/{index}/_analyze"} {
+				for _, method := range []string{http.MethodGet, http.MethodPost} {
+					analyze := specFor(method, path)
+					So(analyze.category, ShouldEqual, category.Indices)
+					So(analyze.acl, ShouldEqual, acl.Analyze)
+					So(analyze.op, ShouldEqual, op.Read)
+				}
+			}
+			// whatever the order of its methods
+			s := &spec{Methods: []string{http.MethodPost, http.MethodGet}}
+			specOp, err := decodeOp("indices.analyze", s)
+			So(err, ShouldBeNil)
+			So(specOp, ShouldEqual, op.Read)
+		})
+		Convey("Spec decode timing", func() {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			Convey("logs the summary after preprocess", func() {
+				saved := routes
+				routes = nil
+				defer func() { routes = saved }()
+				So((&elasticsearch{}).preprocess(nil), ShouldBeNil)
+
+				var summary *log.Entry
+				for _, entry := range hook.AllEntries() {
+					if strings.Contains(entry.Message, "loaded the spec files") {
+						summary = entry
+					}
+				}
+				So(summary, ShouldNotBeNil)
+				So(summary.Level, ShouldEqual, log.InfoLevel)
+				So(summary.Data["files"], ShouldBeGreaterThan, 100)
+				So(summary.Data["slowest"], ShouldEndWith, ".json")
+				So(summary.Data, ShouldContainKey, "took")
+				So(summary.Data, ShouldContainKey, "decoding")
+			})
+			Convey("flags the slow spec files", func() {
+				timings := newSpecTimings(10 * time.Millisecond)
+				timings.record("search.json", time.Millisecond)
+				So(hook.Entries, ShouldBeEmpty)
+				timings.record("custom.json", 20*time.Millisecond)
+				So(hook.LastEntry().Level, ShouldEqual, log.WarnLevel)
+				So(hook.LastEntry().Message, ShouldContainSubstring, "custom.json")
+
+				timings.log()
+				So(hook.LastEntry().Data["files"], ShouldEqual, 2)
+				So(hook.LastEntry().Data["slowest"], ShouldEqual, "custom.json")
+				So(hook.LastEntry().Data["slow"], ShouldResemble, []string{"custom.json"})
+			})
+		})
+		Convey("Route table summary", func() {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+			specs := map[string]api{
+				"POST:/{index}/_search":  {category: category.Search},
+				"GET:/{index}/_search":   {category: category.Search},
+				"GET:/_search":           {category: category.Search},
+				"PUT:/{index}/_doc/{id}": {category: category.Docs},
+				"GET:/_cat/indices":      {category: category.Cat},
+			}
+			table := []plugins.Route{
+				{Methods: []string{http.MethodGet}, Path: "/_arc/health"},
+				{Methods: []string{http.MethodPut}, Path: "/{index}/_doc/{id}"},
+				{Methods: []string{http.MethodGet}, Path: "/_cat/indices"},
+				{Methods: []string{http.MethodGet, http.MethodPost}, Path: "/{index}/_search"},
+				{Methods: []string{http.MethodGet}, Path: "/_search"},
+				{Methods: []string{http.MethodGet}, Path: "/_arc/version"},
+				{Methods: []string{http.MethodGet, http.MethodHead}, Path: "/"},
+			}
+			logRouteTable(table, specs)
+
+			entry := hook.LastEntry()
+			So(entry.Level, ShouldEqual, log.InfoLevel)
+			So(entry.Data["total"], ShouldEqual, 7)
+			So(entry.Data["categories"], ShouldResemble, map[string]int{
+				"search": 2,
+				"docs":   1,
+				"cat":    1,
+				"arc":    3,
+			})
+			So(entry.Data["first"], ShouldResemble, []string{"/_arc/health", "/{index}/_doc/{id}", "/_cat/indices", "/{index}/_search", "/_search"})
+			So(entry.Data["last"], ShouldResemble, []string{"/_cat/indices", "/{index}/_search", "/_search", "/_arc/version", "/"})
+		})
+		Convey("Route limit", func() {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+			savedRoutes, savedSpecs, savedACLs := routes, routeSpecs, acls
+			routes, routeSpecs, acls = nil, make(map[string]api), make(map[category.Category]map[acl.ACL]bool)
+			defer func() { routes, routeSpecs, acls = savedRoutes, savedSpecs, savedACLs }()
+
+			es := &elasticsearch{maxRoutes: 5}
+			So(es.preprocess(nil), ShouldBeNil)
+			// the spec routes plus the ping route and arc's own routes
+			So(len(routes), ShouldEqual, 5+1+len(es.arcRoutes()))
+
+			// the routes of the first specs by name are kept, whatever the
+			// order the specs are decoded in
+			kept := func() []string {
+				var names []string
+				for _, r := range routes[len(es.arcRoutes()) : len(routes)-1] {
+					names = append(names, r.Name)
+				}
+				sort.Strings(names)
+				return names
+			}
+			limited := kept()
+			routes, routeSpecs = nil, make(map[string]api)
+			es.maxRoutes = 0
+			So(es.preprocess(nil), ShouldBeNil)
+			So(limited, ShouldResemble, kept()[:5])
+
+			var logged bool
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == log.ErrorLevel && strings.Contains(entry.Message, "route limit of 5 reached") {
+					logged = true
+				}
+			}
+			So(logged, ShouldBeTrue)
+		})
+		Convey("Spec versions", func() {
+			dir, err := ioutil.TempDir("", "specs")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			knn := `{"knn_search": {
+				"documentation": "https://www.elastic.co/guide/en/elasticsearch/reference/master/search-search.html",
+				"methods": ["GET", "POST"],
+				"url": {"path": "/{index}/_knn_search", "paths": ["/{index}/_knn_search"]}
+			}}`
+			So(ioutil.WriteFile(filepath.Join(dir, "knn_search.json"), []byte(knn), 0644), ShouldBeNil)
+
+			os.Setenv(envSpecVersions, "/v8="+dir)
+			defer os.Unsetenv(envSpecVersions)
+			savedRoutes, savedSpecs, savedACLs := routes, routeSpecs, acls
+			routes, routeSpecs, acls = nil, make(map[string]api), make(map[category.Category]map[acl.ACL]bool)
+			defer func() { routes, routeSpecs, acls = savedRoutes, savedSpecs, savedACLs }()
+
+			es := &elasticsearch{}
+			So(es.preprocess(nil), ShouldBeNil)
+			So(routeSpecs["POST:/v8/{index}/_knn_search"].category, ShouldEqual, category.Search)
+			So(routeSpecs, ShouldNotContainKey, "POST:/{index}/_knn_search")
+			So(routeSpecs, ShouldNotContainKey, "POST:/v8/{index}/_search")
+
+			router := mux.NewRouter()
+			for _, r := range routes {
+				template := r.Path
+				router.Methods(r.Methods...).Path(r.Path).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(template))
+				})
+			}
+			serve := func(method, url string) string {
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, httptest.NewRequest(method, url, nil))
+				return resp.Body.String()
+			}
+			So(serve(http.MethodPost, "/v8/foo/_knn_search"), ShouldEqual, "/v8/{index}/_knn_search")
+			So(serve(http.MethodPost, "/foo/_search"), ShouldEqual, "/{index}/_search")
+			So(serve(http.MethodPost, "/foo/_knn_search"), ShouldNotEqual, "/v8/{index}/_knn_search")
+			So(serve(http.MethodGet, "/v8"), ShouldEqual, "/v8")
+
+			Convey("should forward the requests without the prefix", func() {
+				var path string
+				h := stripPrefix("/v8", func(w http.ResponseWriter, r *http.Request) { path = r.URL.Path })
+				h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v8/foo/_knn_search", nil))
+				So(path, ShouldEqual, "/foo/_knn_search")
+				h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v8", nil))
+				So(path, ShouldEqual, "/")
+			})
+		})
+		Convey("Invalid spec versions", func() {
+			for _, value := range []string{"v8=/tmp", "/_v8=/tmp", "/{v}=/tmp", "/v8", "/v8=/does/not/exist", "/v8=/tmp,/v8/=/tmp"} {
+				os.Setenv(envSpecVersions, value)
+				_, err := readSpecVersions()
+				So(err, ShouldNotBeNil)
+			}
+			os.Unsetenv(envSpecVersions)
+		})
+		Convey("Default index", func() {
+			savedRoutes, savedSpecs, savedACLs := routes, routeSpecs, acls
+			routes, routeSpecs, acls = nil, make(map[string]api), make(map[category.Category]map[acl.ACL]bool)
+			defer func() { routes, routeSpecs, acls = savedRoutes, savedSpecs, savedACLs }()
+
+			es := &elasticsearch{defaultIndex: "products"}
+			So(es.preprocess(nil), ShouldBeNil)
+			So(routeSpecs["PUT:/_doc/{id}"].category, ShouldEqual, category.Docs)
+			So(routeSpecs["PUT:/_doc/{id}"].op, ShouldEqual, op.Write)
+			So(routeSpecs["GET:/_doc/{id}"].op, ShouldEqual, op.Read)
+			So(routeSpecs, ShouldContainKey, "POST:/_doc")
+			// only the single document routes get an index-less variant
+			So(routeSpecs, ShouldNotContainKey, "POST:/_delete_by_query")
+			So(routeSpecs, ShouldNotContainKey, "PUT:/{type}/{id}")
+			So(routeSpecs["POST:/_bulk"].acl, ShouldEqual, acl.Bulk)
+
+			var path string
+			upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"result":"created"}`))
+			})
+			defer upstream.Close()
+			var indices []string
+			h := withDefaultIndex(es.defaultIndex, func(w http.ResponseWriter, r *http.Request) {
+				r = classified(r, category.Docs, acl.Index, op.Write)
+				indices, _ = index.FromContext(r.Context())
+				es.handler()(w, r)
+			})
+			resp := route(http.MethodPut, "/_doc/{id}", h, httptest.NewRequest(http.MethodPut, "/_doc/1", strings.NewReader(`{"title":"arc"}`)))
+			So(resp.Code, ShouldEqual, http.StatusCreated)
+			So(path, ShouldEqual, "/products/_doc/1")
+			So(indices, ShouldResemble, []string{"products"})
+		})
+		Convey("Spec decoding concurrency", func() {
+			dir, err := ioutil.TempDir("", "specs")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			for i := 0; i < 20; i++ {
+				spec := fmt.Sprintf(`{"custom_%d": {
+					"documentation": "https://www.elastic.co/guide/en/elasticsearch/reference/master/search-search.html",
+					"methods": ["GET"],
+					"url": {"path": "/_custom_%d", "paths": ["/_custom_%d"]}
+				}}`, i, i, i)
+				So(ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("custom_%d.json", i)), []byte(spec), 0644), ShouldBeNil)
+			}
+			source := &concurrencySource{specDir: specDir(dir)}
+
+			files := make(chan string)
+			apis := make(chan api)
+			go fetchSpecFiles(source, files)
+			go decodeSpecFiles(source, files, apis, defaultSpecFallback, 3, nil)
+			var decoded int
+			for range apis {
+				decoded++
+			}
+			So(decoded, ShouldEqual, 20)
+			So(source.max, ShouldEqual, 3)
+		})
+		Convey("Disabled routes", func() {
+			savedRoutes, savedSpecs, savedACLs := routes, routeSpecs, acls
+			routes, routeSpecs, acls = nil, make(map[string]api), make(map[category.Category]map[acl.ACL]bool)
+			defer func() { routes, routeSpecs, acls = savedRoutes, savedSpecs, savedACLs }()
+
+			es := &elasticsearch{disabledRoutes: []string{"delete_by_query", "/_snapshot/*"}}
+			So(es.preprocess(nil), ShouldBeNil)
+			registered := make(map[string]bool)
+			for _, r := range routes {
+				registered[r.Name] = true
+				registered[r.Path] = true
+			}
+			So(registered, ShouldNotContainKey, "delete_by_query")
+			So(registered, ShouldNotContainKey, "/_snapshot/{repository}")
+			So(routeSpecs, ShouldNotContainKey, "POST:/{index}/_delete_by_query")
+			So(registered, ShouldContainKey, "search")
+			So(registered, ShouldContainKey, "/_snapshot")
+
+			router := mux.NewRouter()
+			for _, r := range routes {
+				router.Methods(r.Methods...).Path(r.Path).HandlerFunc(r.HandlerFunc)
+			}
+			serve := func(method, path string) int {
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, httptest.NewRequest(method, path, nil))
+				return resp.Code
+			}
+			// the less specific routes don't serve the disabled ones'
+			// requests, e.g. POST /{index}/{type}
+			So(serve(http.MethodPost, "/foo/_delete_by_query"), ShouldEqual, http.StatusNotFound)
+			// or only match the routes of other methods
+			So(serve(http.MethodGet, "/_snapshot/backups"), ShouldEqual, http.StatusMethodNotAllowed)
+			// while the other routes are served, i.e. authenticated
+			So(serve(http.MethodPost, "/foo/_search"), ShouldEqual, http.StatusUnauthorized)
+
+			// and the disabled routes get the route suggestions when they are on
+			plugins.SetNotFoundHandler(plugins.NotFoundHandler(router))
+			defer plugins.SetNotFoundHandler(nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/foo/_delete_by_query", nil))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			var body map[string]interface{}
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			So(body, ShouldContainKey, "suggestions")
+			So(body["suggestions"], ShouldNotContain, "/{index}/_delete_by_query")
+		})
+		Convey("Specs that fail to decode get the fallback classification", func() {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+			fallback := specFallback{Category: category.Clusters, ACL: acl.Cluster, Op: op.Write}
+			decoded := func(name, documentation, path string, methods ...string) api {
+				s := &spec{Documentation: documentation, Methods: methods}
+				s.URL.Path = path
+				return classifySpec(name, s, fallback)
+			}
+
+			Convey("category", func() {
+				a := decoded("search", "https://www.elastic.co/guide/odd.html", "/_search", http.MethodGet)
+				So(a.category, ShouldEqual, category.Clusters)
+				So(a.acl, ShouldEqual, acl.Search)
+				So(a.op, ShouldEqual, op.Read)
+				So(hook.LastEntry().Level, ShouldEqual, log.WarnLevel)
+				So(hook.LastEntry().Message, ShouldContainSubstring, "search")
+			})
+			Convey("misc categories aren't decode failures", func() {
+				for _, documentation := range []string{
+					"http://www.elastic.co/guide/en/elasticsearch/reference/master/modules-snapshots.html",
+					"https://www.elastic.co/guide/en/elasticsearch/plugins/master/ingest.html",
+					"http://www.elastic.co/guide/",
+				} {
+					a := decoded("ping", documentation, "/", http.MethodGet)
+					So(a.category, ShouldEqual, category.Misc)
+				}
+				for _, entry := range hook.AllEntries() {
+					So(entry.Message, ShouldNotContainSubstring, "fallback category")
+				}
+			})
+			Convey("acl", func() {
+				a := decoded("oddity", "https://www.elastic.co/guide/search-search.html", "/_oddity", http.MethodGet)
+				So(a.category, ShouldEqual, category.Search)
+				So(a.acl, ShouldEqual, acl.Cluster)
+				So(hook.LastEntry().Level, ShouldEqual, log.WarnLevel)
+				So(hook.LastEntry().Message, ShouldContainSubstring, "oddity")
+			})
+			Convey("op", func() {
+				a := decoded("search", "https://www.elastic.co/guide/search-search.html", "/_search")
+				So(a.category, ShouldEqual, category.Search)
+				So(a.op, ShouldEqual, op.Write)
+				So(hook.LastEntry().Level, ShouldEqual, log.WarnLevel)
+			})
+			Convey("defaults and partial configuration", func() {
+				So(defaultSpecFallback, ShouldResemble, specFallback{Category: category.Misc, ACL: acl.Get, Op: op.Read})
+				os.Setenv(envSpecFallback, `{"acl": "cluster"}`)
+				defer os.Unsetenv(envSpecFallback)
+				configured, err := readSpecFallback()
+				So(err, ShouldBeNil)
+				So(configured, ShouldResemble, specFallback{Category: category.Misc, ACL: acl.Cluster, Op: op.Read})
+			})
+		})
+	})
+}
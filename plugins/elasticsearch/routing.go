@@ -0,0 +1,65 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+)
+
+// initRoutedIndices reads the patterns of the custom-routed indices, whose
+// documents are written with a routing value.
+func (es *elasticsearch) initRoutedIndices() error {
+	patterns := envList(envRoutedIndices)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %v", envRoutedIndices, pattern, err)
+		}
+	}
+	es.routedIndices = patterns
+	return nil
+}
+
+// documentWrite checks whether the request writes or deletes a single
+// document, by id or with an id generated by es.
+func documentWrite(req *http.Request, reqOp op.Operation) bool {
+	if reqOp != op.Write && reqOp != op.Delete {
+		return false
+	}
+	return mux.Vars(req)["id"] != "" || strings.HasSuffix(routeTemplate(req), "/_doc")
+}
+
+// requireRouting rejects the document writes to the custom-routed indices
+// that don't set the routing query param, which would otherwise land on the
+// shard of the document's id rather than the one of its routing value. The
+// writes of several documents, e.g. the bulk requests, aren't checked.
+func (es *elasticsearch) requireRouting(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(es.routedIndices) == 0 {
+			h(w, req)
+			return
+		}
+		reqOp, err := op.FromContext(req.Context())
+		if err != nil {
+			log.Errorln(logTag, ":", err)
+			util.WriteBackError(w, "an error occurred while validating request op", http.StatusInternalServerError)
+			return
+		}
+		if !documentWrite(req, *reqOp) || req.URL.Query().Get("routing") != "" {
+			h(w, req)
+			return
+		}
+		if index, ok := matchIndex(es.routedIndices, util.IndicesFromRequest(req)); ok {
+			msg := fmt.Sprintf(`the documents of index "%s" are custom-routed, set the "routing" query param`, index)
+			util.WriteBackError(w, msg, http.StatusBadRequest)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,100 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// time given to es to clear the scroll contexts left by the disconnected clients
+const scrollClearTimeout = 10 * time.Second
+
+// scrollCleanup clears the scroll contexts opened, or kept alive, by the
+// requests whose client disconnected before receiving the scroll id, which
+// would otherwise hold their resources on es until the keep alive expires.
+type scrollCleanup struct {
+	// scroll requests in flight and scroll contexts cleared
+	open    int64
+	cleared int64
+	// clears the scroll contexts, es unless replaced
+	clear func(ctx context.Context, ids []string) error
+}
+
+func (es *elasticsearch) initScrollCleanup() {
+	if os.Getenv(envScrollCleanup) == "true" {
+		es.scrollCleanup = &scrollCleanup{clear: clearScrolls}
+	}
+}
+
+// isScroll checks whether the request opens or continues a scroll.
+func isScroll(r *http.Request) bool {
+	if r.Method == http.MethodDelete {
+		return false
+	}
+	return r.URL.Query().Get("scroll") != "" || strings.HasSuffix(r.URL.Path, "/_search/scroll") ||
+		strings.HasPrefix(r.URL.Path, "/_search/scroll/")
+}
+
+// detachedContext carries the values of its parent but not its
+// cancellation, so that the scroll requests complete with their scroll id
+// even if the client goes away.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// track returns the context, detached from ctx, the scroll request is to be
+// performed with, and a func to call with the es response body once it has
+// been received, which clears the scroll if the client has disconnected.
+func (s *scrollCleanup) track(ctx context.Context, r *http.Request) (context.Context, func(body []byte)) {
+	atomic.AddInt64(&s.open, 1)
+	return detachedContext{ctx}, func(body []byte) {
+		defer atomic.AddInt64(&s.open, -1)
+		if r.Context().Err() == nil {
+			return
+		}
+		var res struct {
+			ScrollID string `json:"_scroll_id"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil || res.ScrollID == "" {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), scrollClearTimeout)
+		defer cancel()
+		if err := s.clear(ctx, []string{res.ScrollID}); err != nil {
+			log.Errorln(logTag, ": error clearing the scroll of the disconnected client:", err)
+			return
+		}
+		atomic.AddInt64(&s.cleared, 1)
+		log.Debugln(logTag, ": cleared the scroll of the disconnected client", r.URL.Path)
+	}
+}
+
+func (s *scrollCleanup) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"open":    atomic.LoadInt64(&s.open),
+		"cleared": atomic.LoadInt64(&s.cleared),
+	}
+}
+
+// clearScrolls deletes the scroll contexts from es.
+func clearScrolls(ctx context.Context, ids []string) error {
+	_, err := util.GetReadClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodDelete,
+		Path:   "/_search/scroll",
+		Body:   map[string][]string{"scroll_id": ids},
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package elasticsearch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScrollCleanup(t *testing.T) {
+	Convey("Scroll cleanup", t, func() {
+		es := &elasticsearch{scrollCleanup: &scrollCleanup{clear: clearScrolls}}
+
+		var (
+			mu      sync.Mutex
+			cleared []string
+		)
+		received := make(chan struct{}, 1)
+		release := make(chan struct{})
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				body, _ := ioutil.ReadAll(r.Body)
+				mu.Lock()
+				cleared = append(cleared, r.URL.Path+" "+string(body))
+				mu.Unlock()
+				w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+				return
+			}
+			received <- struct{}{}
+			<-release
+			w.Write([]byte(`{"_scroll_id":"c2Nyb2xs","hits":{"hits":[]}}`))
+		})
+		defer upstream.Close()
+
+		// serve performs the request, canceling it once es received it
+		serve := func(template, url string, cancel bool) {
+			ctx, cancelRequest := context.WithCancel(context.Background())
+			defer cancelRequest()
+			req := httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				route(http.MethodGet, template, func(w http.ResponseWriter, r *http.Request) {
+					es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+				}, req)
+			}()
+			<-received
+			if cancel {
+				cancelRequest()
+			}
+			release <- struct{}{}
+			<-done
+		}
+		clearedScrolls := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), cleared...)
+		}
+
+		Convey("should clear the scroll opened for a disconnected client", func() {
+			serve("/{index}/_search", "/books/_search?scroll=1m", true)
+			So(clearedScrolls(), ShouldResemble, []string{`/_search/scroll {"scroll_id":["c2Nyb2xs"]}`})
+			So(es.scrollCleanup.stats(), ShouldResemble, map[string]interface{}{"open": int64(0), "cleared": int64(1)})
+		})
+		Convey("should clear the scroll continued for a disconnected client", func() {
+			serve("/_search/scroll", "/_search/scroll?scroll=1m&scroll_id=c2Nyb2xs", true)
+			So(clearedScrolls(), ShouldHaveLength, 1)
+		})
+		Convey("should keep the scrolls of the connected clients", func() {
+			serve("/{index}/_search", "/books/_search?scroll=1m", false)
+			So(clearedScrolls(), ShouldBeEmpty)
+			So(es.scrollCleanup.stats()["cleared"], ShouldEqual, int64(0))
+		})
+	})
+
+	Convey("Scroll requests", t, func() {
+		So(isScroll(httptest.NewRequest(http.MethodPost, "/books/_search?scroll=1m", nil)), ShouldBeTrue)
+		So(isScroll(httptest.NewRequest(http.MethodPost, "/_search/scroll", nil)), ShouldBeTrue)
+		So(isScroll(httptest.NewRequest(http.MethodGet, "/_search/scroll/c2Nyb2xs", nil)), ShouldBeTrue)
+		So(isScroll(httptest.NewRequest(http.MethodDelete, "/_search/scroll", nil)), ShouldBeFalse)
+		So(isScroll(httptest.NewRequest(http.MethodPost, "/books/_search", nil)), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,38 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// headerShardFailures reports the number of shards that failed to execute a
+// search whose partial results es still responded with.
+const headerShardFailures = "X-Arc-Shard-Failures"
+
+type shardsSummary struct {
+	Shards *struct {
+		Failed int `json:"failed"`
+	} `json:"_shards"`
+}
+
+// shardFailures returns the number of failed shards of the search response,
+// summed over the responses of a _msearch.
+func shardFailures(body []byte) int {
+	if !bytes.Contains(body, []byte(`"_shards"`)) {
+		return 0
+	}
+	var res struct {
+		shardsSummary
+		Responses []shardsSummary `json:"responses"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return 0
+	}
+	var failed int
+	for _, summary := range append(res.Responses, res.shardsSummary) {
+		if summary.Shards != nil {
+			failed += summary.Shards.Failed
+		}
+	}
+	return failed
+}
@@ -0,0 +1,68 @@
+package elasticsearch
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultSlowSpecDecode = 100 * time.Millisecond
+
+// specTimings records how long the spec files took to be loaded, to
+// pinpoint the custom specs slowing down the startup.
+type specTimings struct {
+	// decode time from which a spec file is flagged as slow
+	slow    time.Duration
+	started time.Time
+
+	mu       sync.Mutex
+	files    int
+	decoding time.Duration
+	slowest  string
+	longest  time.Duration
+	flagged  []string
+}
+
+func newSpecTimings(slow time.Duration) *specTimings {
+	if slow <= 0 {
+		slow = defaultSlowSpecDecode
+	}
+	return &specTimings{slow: slow, started: time.Now()}
+}
+
+// record records the time the spec file took to be read and decoded.
+func (t *specTimings) record(file string, took time.Duration) {
+	if t == nil {
+		return
+	}
+	log.Debugln(logTag, ": decoded spec", file, "in", took)
+	if took >= t.slow {
+		log.Warnln(logTag, ": spec", file, "took", took, "to decode, over", t.slow)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files++
+	t.decoding += took
+	if took > t.longest {
+		t.slowest, t.longest = file, took
+	}
+	if took >= t.slow {
+		t.flagged = append(t.flagged, file)
+	}
+}
+
+// log logs how long the spec files took to be loaded overall, and the
+// cumulative time spent decoding them across the decoders.
+func (t *specTimings) log() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	log.WithFields(log.Fields{
+		"files":    t.files,
+		"took":     time.Since(t.started).String(),
+		"decoding": t.decoding.String(),
+		"slowest":  t.slowest,
+		"longest":  t.longest.String(),
+		"slow":     t.flagged,
+	}).Infoln(logTag, ": loaded the spec files")
+}
@@ -0,0 +1,90 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// specSource lists and reads the spec files, either the ones embedded in
+// the binary or the ones of a spec directory.
+type specSource interface {
+	List() []string
+	Find(name string) ([]byte, error)
+}
+
+// specDir is a directory of spec files.
+type specDir string
+
+func (d specDir) List() []string {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			files = append(files, info.Name())
+		}
+	}
+	return files
+}
+
+func (d specDir) Find(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+// specVersion is an additional spec set served under a path prefix, e.g.
+// the specs of the next major es version during a migration.
+type specVersion struct {
+	prefix string
+	dir    string
+}
+
+// readSpecVersions parses the comma separated "prefix=dir" pairs of the
+// additional spec sets. The prefixes keep the routes of the spec sets apart,
+// so they must not overlap with each other or with the es endpoints.
+func readSpecVersions() ([]specVersion, error) {
+	var versions []specVersion
+	prefixes := make(map[string]bool)
+	for _, pair := range envList(envSpecVersions) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(`invalid spec version %q, expected "prefix=dir"`, pair)
+		}
+		prefix, dir := strings.TrimSuffix(strings.TrimSpace(parts[0]), "/"), strings.TrimSpace(parts[1])
+		if !strings.HasPrefix(prefix, "/") || len(prefix) < 2 ||
+			strings.ContainsAny(prefix, "{}") || strings.HasPrefix(prefix, "/_") {
+			return nil, fmt.Errorf("invalid spec version prefix %q, expected e.g. /v8", prefix)
+		}
+		if prefixes[prefix] {
+			return nil, fmt.Errorf("duplicate spec version prefix %q", prefix)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("spec directory %q of prefix %q doesn't exist", dir, prefix)
+		}
+		prefixes[prefix] = true
+		versions = append(versions, specVersion{prefix: prefix, dir: dir})
+	}
+	return versions, nil
+}
+
+// stripPrefix removes the spec version prefix from the request path before
+// the request is classified and forwarded to es.
+func stripPrefix(prefix string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stripped := new(http.Request)
+		*stripped = *r
+		u := *r.URL
+		u.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.RawPath = ""
+		stripped.URL = &u
+		h(w, stripped)
+	}
+}
@@ -0,0 +1,235 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/feature"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// size of the chunks in which the streamed responses are flushed
+const streamChunkSize = 32 * 1024
+
+// routeTemplate returns the path template of the route matched by the request.
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return template
+}
+
+// streams checks whether the request's route has opted in for streaming, or
+// it is a _bulk and the bulk responses are streamed, unless streaming has
+// been flagged on or off for the request.
+func (es *elasticsearch) streams(r *http.Request) bool {
+	streamed := es.streamedRoutes[routeTemplate(r)]
+	if !streamed && (es.streamBulk || es.streamBulkThreshold > 0) {
+		reqACL, err := acl.FromContext(r.Context())
+		streamed = err == nil && *reqACL == acl.Bulk && es.largeBulk(r)
+	}
+	return feature.Enabled(r.Context(), feature.Stream, streamed)
+}
+
+// largeBulk checks whether the bulk request is large enough for its response
+// to be streamed rather than buffered. The small bulks are answered faster
+// once buffered, the large ones would hold their per-item results in memory.
+// The bulks sent without a content length are considered large.
+func (es *elasticsearch) largeBulk(r *http.Request) bool {
+	if es.streamBulkThreshold <= 0 {
+		return true
+	}
+	return r.ContentLength < 0 || r.ContentLength >= es.streamBulkThreshold
+}
+
+// buffersStream checks whether the streamed response must be read in full
+// before it is written back, i.e. whether its documents may have encrypted
+// fields to decrypt or transforms to run. The documents of a bulk response
+// are the ones of the indices the bulk writes to, those of its path and
+// the ones named by its actions, so a bulk is only buffered if any of them
+// has encrypted fields or transforms, or if they can't be told, e.g. when
+// an action names no index. The other responses, e.g. of the searches, may
+// return the documents of any index and are always buffered.
+func (es *elasticsearch) buffersStream(r *http.Request, body []byte) bool {
+	if es.encryption == nil && es.transforms == nil {
+		return false
+	}
+	reqACL, err := acl.FromContext(r.Context())
+	if err != nil || *reqACL != acl.Bulk {
+		return true
+	}
+	var index string
+	if indices := util.IndicesFromRequest(r); len(indices) == 1 {
+		index = indices[0]
+	}
+	indices, ok := bulkIndices(index, body)
+	if !ok || len(indices) == 0 {
+		return true
+	}
+	aliases := classify.GetAliasIndexCache()
+	for _, index := range indices {
+		if index == "" || index == "_all" || strings.ContainsAny(index, "*?[,") {
+			return true
+		}
+		names := indexNames(index, aliases)
+		if aliased, ok := aliases[index]; ok {
+			names = append(names, aliased)
+		}
+		for _, name := range names {
+			if es.encryption != nil && len(es.encryption.fieldsOf(name)) > 0 {
+				return true
+			}
+		}
+		if es.transforms != nil && len(es.transforms.pipelineFor(names)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkIndices returns the indices of the actions of the bulk body, the index
+// of each action defaulting to the one in the path. It returns false if an
+// action can't be decoded.
+func bulkIndices(index string, body []byte) ([]string, bool) {
+	var indices []string
+	lines := bytes.Split(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		if len(bytes.TrimSpace(lines[i])) == 0 {
+			continue
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(lines[i], &action); err != nil {
+			return nil, false
+		}
+		for name, meta := range action {
+			actionIndex := meta.Index
+			if actionIndex == "" {
+				actionIndex = index
+			}
+			indices = append(indices, actionIndex)
+			// the documents follow every action but the deletes
+			if name != "delete" {
+				i++
+			}
+		}
+	}
+	return indices, true
+}
+
+// stream forwards the request to elasticsearch and writes the response back
+// as it is received, flushing each chunk, rather than once it has been read
+// in full. The es client buffers the responses, so the request is made with
+// the shared http client instead. It returns the status code es responded
+// with, zero if it didn't respond.
+func (es *elasticsearch) stream(ctx context.Context, w http.ResponseWriter, r *http.Request, o op.Operation, options es7.PerformRequestOptions) int {
+	esURL := util.GetWriteESURL()
+	if o == op.Read {
+		esURL = util.GetReadESURL()
+	}
+	// the credentials of the url are sent as basic auth, like the es
+	// client does, rather than as the url's userinfo
+	parsed, err := url.Parse(esURL)
+	if err != nil {
+		log.Errorln(logTag, ": error parsing the es url:", err)
+		util.WriteBackError(w, "invalid elasticsearch url", http.StatusInternalServerError)
+		return 0
+	}
+	userinfo := parsed.User
+	parsed.User = nil
+	target := strings.TrimSuffix(parsed.String(), "/") + options.Path
+	if len(options.Params) > 0 {
+		target += "?" + options.Params.Encode()
+	}
+	var body io.Reader
+	if s, ok := options.Body.(string); ok {
+		body = strings.NewReader(s)
+	}
+	req, err := http.NewRequest(options.Method, target, body)
+	if err != nil {
+		log.Errorln(logTag, ": error building the streamed request:", err)
+		util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+		return 0
+	}
+	req = req.WithContext(ctx)
+	for k, v := range options.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// es gets the configured credentials, not the ones the client
+	// authenticated with to arc
+	if userinfo != nil {
+		password, _ := userinfo.Password()
+		req.SetBasicAuth(userinfo.Username(), password)
+	}
+
+	res, err := util.HTTPClient().Do(req)
+	if err != nil {
+		log.Errorln(logTag, ": error fetching the streamed response for", options.Path, err)
+		util.WriteBackError(w, err.Error(), http.StatusInternalServerError)
+		return 0
+	}
+	defer res.Body.Close()
+
+	// the documents of the response can only be decrypted and transformed
+	// once it has been read in full
+	sent, _ := options.Body.(string)
+	if es.buffersStream(r, []byte(sent)) {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			log.Errorln(logTag, ": error reading the streamed response:", err)
+			util.WriteBackError(w, "error reading the elasticsearch response", http.StatusBadGateway)
+			return res.StatusCode
+		}
+		es.writeResponse(w, r, res.StatusCode, res.Header, body)
+		return res.StatusCode
+	}
+
+	for k, v := range res.Header {
+		if k != "Content-Length" && !es.responseHeaderDenylist[k] {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("X-Origin", "ES")
+	w.WriteHeader(res.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				log.Errorln(logTag, ": error writing the streamed response:", err)
+				return res.StatusCode
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return res.StatusCode
+		}
+		if err != nil {
+			log.Errorln(logTag, ": error reading the streamed response:", err)
+			return res.StatusCode
+		}
+	}
+}
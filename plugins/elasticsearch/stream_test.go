@@ -0,0 +1,277 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+	"github.com/appbaseio/arc/model/response"
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// chunkWriter reports every flushed chunk of the response.
+type chunkWriter struct {
+	*httptest.ResponseRecorder
+	flushed chan string
+	offset  int
+}
+
+func (c *chunkWriter) Flush() {
+	body := c.Body.String()
+	c.flushed <- body[c.offset:]
+	c.offset = len(body)
+}
+
+func TestStream(t *testing.T) {
+	Convey("Streamed routes", t, func() {
+		release := make(chan struct{})
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`[{"index":"foo"},`))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte(`{"index":"bar"}]`))
+		})
+		defer upstream.Close()
+		os.Setenv("ES_CLUSTER_URL", upstream.URL)
+		defer os.Unsetenv("ES_CLUSTER_URL")
+
+		es := &elasticsearch{streamedRoutes: map[string]bool{"/_cat/indices": true}}
+		router := mux.NewRouter()
+		router.Methods(http.MethodGet).Path("/_cat/indices").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			es.handler()(w, classified(r, category.Cat, acl.Cat, op.Read))
+		})
+
+		resp := &chunkWriter{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 2)}
+		done := make(chan struct{})
+		go func() {
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/_cat/indices?format=json", nil))
+			close(done)
+		}()
+
+		// the first chunk reaches the client before elasticsearch is done
+		select {
+		case chunk := <-resp.flushed:
+			So(chunk, ShouldEqual, `[{"index":"foo"},`)
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("the first chunk wasn't flushed before the response was complete")
+		}
+		close(release)
+		<-done
+		So(<-resp.flushed, ShouldEqual, `{"index":"bar"}]`)
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+		So(resp.Body.String(), ShouldEqual, `[{"index":"foo"},{"index":"bar"}]`)
+	})
+}
+
+func TestStreamCredentials(t *testing.T) {
+	Convey("Streamed requests authenticate with the configured credentials", t, func() {
+		var username, password, authorization string
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			username, password, _ = r.BasicAuth()
+			authorization = strings.Join(r.Header["Authorization"], ",")
+			w.Write([]byte(`[]`))
+		})
+		defer upstream.Close()
+		es := &elasticsearch{streamedRoutes: map[string]bool{"/_cat/indices": true}}
+		router := mux.NewRouter()
+		router.Methods(http.MethodGet).Path("/_cat/indices").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			es.handler()(w, classified(r, category.Cat, acl.Cat, op.Read))
+		})
+		stream := func() {
+			req := httptest.NewRequest(http.MethodGet, "/_cat/indices?format=json", nil)
+			req.SetBasicAuth("arc-user", "arc-secret")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		os.Setenv("ES_CLUSTER_URL", strings.Replace(upstream.URL, "http://", "http://elastic:changeme@", 1))
+		defer os.Unsetenv("ES_CLUSTER_URL")
+		stream()
+		So(username, ShouldEqual, "elastic")
+		So(password, ShouldEqual, "changeme")
+		// the client's credentials aren't sent along
+		So(authorization, ShouldNotContainSubstring, ",")
+
+		// without configured credentials the request is sent as the es
+		// client sends it
+		os.Setenv("ES_CLUSTER_URL", upstream.URL)
+		stream()
+		So(username, ShouldEqual, "arc-user")
+	})
+}
+
+func TestStreamBulk(t *testing.T) {
+	Convey("Streamed bulk responses", t, func() {
+		// a bulk response with a few thousand items, sent in two halves
+		item := `{"index":{"_index":"foo","_id":"%d","status":201,"result":"created"}},`
+		var first, second strings.Builder
+		first.WriteString(`{"took":30,"errors":false,"items":[`)
+		for i := 0; i < 5000; i++ {
+			builder := &first
+			if i >= 2500 {
+				builder = &second
+			}
+			builder.WriteString(fmt.Sprintf(item, i))
+		}
+		second.WriteString(`{"index":{"_index":"foo","_id":"last","status":201,"result":"created"}}]}`)
+
+		release := make(chan struct{})
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(first.String()))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte(second.String()))
+		})
+		defer upstream.Close()
+		os.Setenv("ES_CLUSTER_URL", upstream.URL)
+		defer os.Unsetenv("ES_CLUSTER_URL")
+
+		es := withCache()
+		es.cache.countTTL = time.Minute
+		es.streamBulk = true
+		response.SaveResponse("count", &response.CachedResponse{
+			Code:    http.StatusOK,
+			Body:    []byte(`{"count":1}`),
+			Indices: append(countIndices([]string{"foo"}), countMarker),
+		}, time.Minute)
+
+		router := mux.NewRouter()
+		router.Methods(http.MethodPost).Path("/{index}/_bulk").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			es.handler()(w, classified(r, category.Docs, acl.Bulk, op.Write))
+		})
+
+		resp := &chunkWriter{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 64)}
+		done := make(chan struct{})
+		go func() {
+			req := httptest.NewRequest(http.MethodPost, "/foo/_bulk", strings.NewReader(`{"index":{}}`+"\n"+`{"title":"arc"}`+"\n"))
+			router.ServeHTTP(resp, req)
+			close(done)
+		}()
+
+		// the first half reaches the client before elasticsearch is done
+		var received strings.Builder
+		for received.Len() < first.Len() {
+			select {
+			case chunk := <-resp.flushed:
+				received.WriteString(chunk)
+			case <-time.After(5 * time.Second):
+				close(release)
+				t.Fatal("the first half wasn't flushed before the response was complete")
+			}
+		}
+		So(received.String(), ShouldEqual, first.String())
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		close(release)
+		<-done
+
+		So(resp.Body.String(), ShouldEqual, first.String()+second.String())
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+		// the streamed write still invalidates the counts of its index
+		_, ok := response.GetResponse("count")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestStreamBulkThreshold(t *testing.T) {
+	Convey("Bulk streaming by body size", t, func() {
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`{"took":3,"errors":false,"items":[]}`))
+		})
+		defer upstream.Close()
+		os.Setenv("ES_CLUSTER_URL", upstream.URL)
+		defer os.Unsetenv("ES_CLUSTER_URL")
+
+		es := &elasticsearch{streamBulkThreshold: 100}
+		router := mux.NewRouter()
+		router.Methods(http.MethodPost).Path("/{index}/_bulk").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			es.handler()(w, classified(r, category.Docs, acl.Bulk, op.Write))
+		})
+		bulk := func(docs int) *chunkWriter {
+			body := strings.Repeat(`{"index":{}}`+"\n"+`{"title":"arc"}`+"\n", docs)
+			resp := &chunkWriter{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 64)}
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/foo/_bulk", strings.NewReader(body)))
+			return resp
+		}
+
+		Convey("small bulks stay buffered", func() {
+			resp := bulk(1)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, `{"took":3,"errors":false,"items":[]}`)
+			So(len(resp.flushed), ShouldEqual, 0)
+		})
+		Convey("large bulks are streamed", func() {
+			resp := bulk(10)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, `{"took":3,"errors":false,"items":[]}`)
+			So(len(resp.flushed), ShouldBeGreaterThan, 0)
+		})
+		Convey("bulks of unknown size are streamed", func() {
+			req := httptest.NewRequest(http.MethodPost, "/foo/_bulk", strings.NewReader(`{"index":{}}`+"\n"))
+			req.ContentLength = -1
+			So(es.streams(classified(req, category.Docs, acl.Bulk, op.Write)), ShouldBeTrue)
+
+			// the size only matters for the bulks
+			search := httptest.NewRequest(http.MethodPost, "/foo/_search", strings.NewReader(strings.Repeat(" ", 200)))
+			So(es.streams(classified(search, category.Search, acl.Search, op.Read)), ShouldBeFalse)
+		})
+	})
+}
+
+func TestStreamEncryptedIndices(t *testing.T) {
+	Convey("Streamed bulks of encrypted indices", t, func() {
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`{"took":3,"errors":false,"items":[]}`))
+		})
+		defer upstream.Close()
+		os.Setenv("ES_CLUSTER_URL", upstream.URL)
+		defer os.Unsetenv("ES_CLUSTER_URL")
+		os.Setenv(envEncryptedFields, "patients:ssn")
+		os.Setenv(envEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer os.Unsetenv(envEncryptedFields)
+		defer os.Unsetenv(envEncryptionKey)
+
+		es := &elasticsearch{streamBulk: true}
+		So(es.initEncryption(), ShouldBeNil)
+		router := mux.NewRouter()
+		for _, template := range []string{"/_bulk", "/{index}/_bulk"} {
+			router.Methods(http.MethodPost).Path(template).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				es.handler()(w, classified(r, category.Docs, acl.Bulk, op.Write))
+			})
+		}
+		bulk := func(path, action string) *chunkWriter {
+			resp := &chunkWriter{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 64)}
+			body := action + "\n" + `{"title":"arc"}` + "\n"
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, `{"took":3,"errors":false,"items":[]}`)
+			return resp
+		}
+
+		Convey("the bulks of the unrelated indices are still streamed", func() {
+			So(len(bulk("/foo/_bulk", `{"index":{}}`).flushed), ShouldBeGreaterThan, 0)
+			So(len(bulk("/_bulk", `{"index":{"_index":"foo"}}`).flushed), ShouldBeGreaterThan, 0)
+		})
+		Convey("the bulks of the encrypted indices are buffered", func() {
+			So(len(bulk("/patients/_bulk", `{"index":{}}`).flushed), ShouldEqual, 0)
+			So(len(bulk("/foo/_bulk", `{"index":{"_index":"patients"}}`).flushed), ShouldEqual, 0)
+		})
+		Convey("the bulks of unknown indices are buffered", func() {
+			So(len(bulk("/_bulk", `{"index":{}}`).flushed), ShouldEqual, 0)
+			So(len(bulk("/pat*/_bulk", `{"index":{}}`).flushed), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,47 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// headers reporting where the latency of a search comes from
+const (
+	headerESTook  = "X-Arc-ES-Took-Ms"
+	headerTotalMs = "X-Arc-Total-Ms"
+)
+
+type contextKey string
+
+// startedAtKey is a key against which the time arc started serving the request is stored in the context.
+const startedAtKey = contextKey("startedAt")
+
+// timeRequest records the time arc started serving the request.
+func timeRequest(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), startedAtKey, time.Now())
+		h(w, req.WithContext(ctx))
+	}
+}
+
+// setTimingHeaders reports the time es took to execute the search, as per
+// the "took" of the json response, and the total time arc took to serve it.
+func setTimingHeaders(header http.Header, ctx context.Context, body []byte) {
+	if !bytes.Contains(body, []byte(`"took"`)) {
+		return
+	}
+	var res struct {
+		Took *int64 `json:"took"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.Took == nil {
+		return
+	}
+	header.Set(headerESTook, strconv.FormatInt(*res.Took, 10))
+	if startedAt, ok := ctx.Value(startedAtKey).(time.Time); ok {
+		header.Set(headerTotalMs, strconv.FormatInt(int64(time.Since(startedAt)/time.Millisecond), 10))
+	}
+}
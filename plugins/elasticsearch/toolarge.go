@@ -0,0 +1,54 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/appbaseio/arc/util"
+)
+
+// default http.max_content_length of the elasticsearch nodes
+const defaultMaxContentLength = "100mb"
+
+// tooLargeHint explains the 413 responses of es, which usually come with no
+// body, so that the clients know how to get their requests through.
+type tooLargeHint struct {
+	// http.max_content_length configured on the es nodes, if known
+	maxContentLength string
+}
+
+func (es *elasticsearch) initTooLargeHint() {
+	if os.Getenv(envExplainTooLarge) != "true" {
+		return
+	}
+	es.tooLargeHint = &tooLargeHint{maxContentLength: os.Getenv(envMaxContentLength)}
+}
+
+// explain returns arc's error for the 413 response of es to a request with a
+// body of the given size, the original body is kept under "upstream_body",
+// as is if it is json and as a string otherwise.
+func (h *tooLargeHint) explain(size int, contentType string, body []byte) []byte {
+	limit := h.maxContentLength + " as configured"
+	if h.maxContentLength == "" {
+		limit = defaultMaxContentLength + " by default"
+	}
+	msg := fmt.Sprintf("elasticsearch rejected the request body of %d bytes as too large, the http.max_content_length "+
+		"setting of its nodes limits the request bodies to %s: split the request, e.g. send the bulk requests "+
+		"in smaller batches, or raise the limit", size, limit)
+	errBody := util.ErrorBody(msg, http.StatusRequestEntityTooLarge)
+	if errObject, ok := errBody["error"].(map[string]interface{}); ok && len(body) > 0 {
+		if json.Valid(body) {
+			errObject["upstream_body"] = json.RawMessage(body)
+		} else {
+			errObject["upstream_content_type"] = contentType
+			errObject["upstream_body"] = string(body)
+		}
+	}
+	raw, err := json.Marshal(errBody)
+	if err != nil {
+		return body
+	}
+	return raw
+}
@@ -0,0 +1,246 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/appbaseio/arc/middleware/classify"
+)
+
+// responseTransformer rewrites a document of a successful response in
+// place, i.e. its _source along with its highlight and fields.
+type responseTransformer func(doc map[string]interface{}) error
+
+// transformPipelines are the response transformers run for the documents of
+// the matching indices, in the configured order.
+type transformPipelines struct {
+	patterns []string
+	// transformers by index pattern
+	pipelines map[string][]responseTransformer
+}
+
+func (es *elasticsearch) initResponseTransforms() error {
+	entries := envList(envResponseTransforms)
+	if len(entries) == 0 {
+		return nil
+	}
+	pipelines := &transformPipelines{pipelines: make(map[string][]responseTransformer)}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return fmt.Errorf(`invalid response transform %q, expected "index:transform:field"`, entry)
+		}
+		transformer, err := newResponseTransformer(parts[1], parts[2])
+		if err != nil {
+			return fmt.Errorf("invalid response transform %q: %v", entry, err)
+		}
+		if _, ok := pipelines.pipelines[parts[0]]; !ok {
+			pipelines.patterns = append(pipelines.patterns, parts[0])
+		}
+		pipelines.pipelines[parts[0]] = append(pipelines.pipelines[parts[0]], transformer)
+	}
+	es.transforms = pipelines
+	return nil
+}
+
+// newResponseTransformer returns the named transformer of the documents,
+// applied to the given dotted field path.
+func newResponseTransformer(name, field string) (responseTransformer, error) {
+	switch name {
+	case "redact":
+		return func(doc map[string]interface{}) error {
+			if source, ok := doc["_source"].(map[string]interface{}); ok {
+				err := transformFields(source, []string{field}, func(interface{}) (interface{}, error) {
+					return redacted, nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			// the highlighted fragments and the fetched fields hold the
+			// values of the field too
+			for _, key := range []string{"highlight", "fields"} {
+				if values, ok := doc[key].(map[string]interface{}); ok {
+					redactFlatField(values, field)
+				}
+			}
+			return nil
+		}, nil
+	case "rename":
+		names := strings.SplitN(field, "=", 2)
+		if len(names) != 2 || names[0] == "" || names[1] == "" {
+			return nil, fmt.Errorf(`expected "from=to" fields`)
+		}
+		return func(doc map[string]interface{}) error {
+			if source, ok := doc["_source"].(map[string]interface{}); ok {
+				renameField(source, names[0], names[1])
+			}
+			for _, key := range []string{"highlight", "fields"} {
+				if values, ok := doc[key].(map[string]interface{}); ok {
+					renameFlatField(values, names[0], names[1])
+				}
+			}
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf(`unknown transform %q, expected "redact" or "rename"`, name)
+}
+
+// flatFieldOf checks whether the key of the highlight or fields of a
+// document, a full dotted path, is the field or one of its sub-fields, e.g.
+// email.keyword for email.
+func flatFieldOf(key, field string) bool {
+	return key == field || strings.HasPrefix(key, field+".")
+}
+
+// redactFlatField redacts the values of the field, and of its sub-fields,
+// keyed on their full dotted path. The nested fields are returned as arrays
+// of objects keyed on the paths relative to the nested object.
+func redactFlatField(values map[string]interface{}, field string) {
+	for key, value := range values {
+		if flatFieldOf(key, field) {
+			values[key] = []interface{}{redacted}
+			continue
+		}
+		if !strings.HasPrefix(field, key+".") {
+			continue
+		}
+		if nested, ok := value.([]interface{}); ok {
+			for _, object := range nested {
+				if object, ok := object.(map[string]interface{}); ok {
+					redactFlatField(object, strings.TrimPrefix(field, key+"."))
+				}
+			}
+		}
+	}
+}
+
+// renameFlatField renames the field, and its sub-fields, keyed on their full
+// dotted path.
+func renameFlatField(values map[string]interface{}, from, to string) {
+	renamed := to
+	if i := strings.LastIndex(from, "."); i >= 0 {
+		renamed = from[:i+1] + to
+	}
+	for key, value := range values {
+		if flatFieldOf(key, from) {
+			delete(values, key)
+			values[renamed+strings.TrimPrefix(key, from)] = value
+		}
+	}
+}
+
+// renameField moves the value at the dotted path from under the key to of
+// the same parent object.
+func renameField(source map[string]interface{}, from, to string) {
+	parent := source
+	keys := strings.Split(from, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		parent = child
+	}
+	leaf := keys[len(keys)-1]
+	value, ok := parent[leaf]
+	if !ok {
+		return
+	}
+	delete(parent, leaf)
+	parent[to] = value
+}
+
+// pipelineFor returns the transformers of the pipelines matching the
+// indices, nil if none does.
+func (p *transformPipelines) pipelineFor(indices []string) []responseTransformer {
+	var pipeline []responseTransformer
+	for _, pattern := range p.patterns {
+		if _, ok := matchIndex([]string{pattern}, indices); ok {
+			pipeline = append(pipeline, p.pipelines[pattern]...)
+		}
+	}
+	return pipeline
+}
+
+// indexNames returns the index along with the aliases pointing at it, which
+// the patterns may name rather than the index.
+func indexNames(index string, aliases map[string]string) []string {
+	names := []string{index}
+	for alias, aliased := range aliases {
+		if aliased == index {
+			names = append(names, alias)
+		}
+	}
+	return names
+}
+
+// transform runs the pipelines over the documents of the response body, the
+// ones of each document's _index, so that the searches of several indices,
+// aliases or patterns are transformed too. The documents without an _index,
+// e.g. the get of an update, are transformed as the enclosing document, or
+// else as the request's indices. Bodies that aren't valid json are returned
+// as is.
+func (p *transformPipelines) transform(indices []string, body []byte) ([]byte, error) {
+	if !bytes.Contains(body, []byte(`"_source"`)) && !bytes.Contains(body, []byte(`"highlight"`)) &&
+		!bytes.Contains(body, []byte(`"fields"`)) {
+		return body, nil
+	}
+	decoded, err := decodeJSON(body)
+	if err != nil {
+		return body, nil
+	}
+	aliases := classify.GetAliasIndexCache()
+	pipelines := make(map[string][]responseTransformer)
+	pipelineOf := func(index string) []responseTransformer {
+		pipeline, ok := pipelines[index]
+		if !ok {
+			pipeline = p.pipelineFor(indexNames(index, aliases))
+			pipelines[index] = pipeline
+		}
+		return pipeline
+	}
+	var walk func(value interface{}, pipeline []responseTransformer) error
+	walk = func(value interface{}, pipeline []responseTransformer) error {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if index, ok := v["_index"].(string); ok {
+				pipeline = pipelineOf(index)
+			}
+			// the hits fetched without their _source still have an _id,
+			// unlike e.g. the fields of a _field_caps response
+			_, hasSource := v["_source"]
+			_, hasID := v["_id"]
+			_, hasHighlight := v["highlight"]
+			_, hasFields := v["fields"]
+			if hasSource || (hasID && (hasHighlight || hasFields)) {
+				for _, transformer := range pipeline {
+					if err := transformer(v); err != nil {
+						return err
+					}
+				}
+			}
+			for key, child := range v {
+				if key == "_source" || key == "highlight" || key == "fields" {
+					continue
+				}
+				if err := walk(child, pipeline); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				if err := walk(child, pipeline); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(decoded, p.pipelineFor(indices)); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
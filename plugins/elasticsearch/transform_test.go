@@ -0,0 +1,131 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/model/acl"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/op"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResponseTransforms(t *testing.T) {
+	Convey("Response transforms by index", t, func() {
+		os.Setenv(envResponseTransforms, "customers:redact:email,customers:rename:name=full_name,cust*:redact:address.zip")
+		defer os.Unsetenv(envResponseTransforms)
+		es := &elasticsearch{}
+		So(es.initResponseTransforms(), ShouldBeNil)
+
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			index := strings.Split(strings.Trim(r.URL.Path, "/"), "/")[0]
+			if index == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"_index":"missing","found":false,"_source":{"email":"jane@example.com"}}`))
+				return
+			}
+			w.Write([]byte(`{"hits":{"hits":[{"_index":"` + index + `","_id":"1","_source":{"name":"Jane","email":"jane@example.com","address":{"city":"Berlin","zip":"10115"}}}]}}`))
+		})
+		defer upstream.Close()
+
+		search := func(index string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/"+index+"/_search", nil)
+			return route(http.MethodGet, "/{index}/_search", func(w http.ResponseWriter, r *http.Request) {
+				es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+			}, req)
+		}
+
+		Convey("should run the pipeline of the request's index", func() {
+			body := search("customers").Body.String()
+			So(body, ShouldNotContainSubstring, "jane@example.com")
+			So(body, ShouldContainSubstring, `"email":"`+redacted+`"`)
+			So(body, ShouldContainSubstring, `"full_name":"Jane"`)
+			So(body, ShouldNotContainSubstring, `"name":"Jane"`)
+			So(body, ShouldContainSubstring, `"zip":"`+redacted+`"`)
+			So(body, ShouldContainSubstring, `"city":"Berlin"`)
+		})
+		Convey("should leave the responses of the other indices as is", func() {
+			body := search("orders").Body.String()
+			So(body, ShouldContainSubstring, `"email":"jane@example.com"`)
+			So(body, ShouldContainSubstring, `"name":"Jane"`)
+			So(body, ShouldContainSubstring, `"zip":"10115"`)
+		})
+		Convey("should only run the matching patterns", func() {
+			body := search("custom").Body.String()
+			So(body, ShouldContainSubstring, `"email":"jane@example.com"`)
+			So(body, ShouldContainSubstring, `"zip":"`+redacted+`"`)
+		})
+		Convey("should pass the error responses through", func() {
+			es.transforms.pipelines["missing"] = es.transforms.pipelines["customers"]
+			es.transforms.patterns = append(es.transforms.patterns, "missing")
+			res := search("missing")
+			So(res.Code, ShouldEqual, http.StatusNotFound)
+			So(res.Body.String(), ShouldContainSubstring, "jane@example.com")
+		})
+	})
+
+	Convey("Response transforms by document index", t, func() {
+		os.Setenv(envResponseTransforms, "customers:redact:email,people:redact:phone")
+		defer os.Unsetenv(envResponseTransforms)
+		es := &elasticsearch{streamedRoutes: map[string]bool{}}
+		So(es.initResponseTransforms(), ShouldBeNil)
+		saved := classify.GetAliasIndexCache()
+		classify.SetAliasIndexCache(map[string]string{"people": "persons-v1"})
+		defer classify.SetAliasIndexCache(saved)
+
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"hits":{"hits":[` +
+				`{"_index":"customers","_id":"1","_source":{"email":"jane@example.com"},` +
+				`"highlight":{"email":["<em>jane</em>@example.com"],"email.keyword":["<em>jane@example.com</em>"]},` +
+				`"fields":{"email":["jane@example.com"]}},` +
+				`{"_index":"persons-v1","_id":"2","_source":{"phone":"555-0100","email":"joe@example.com"}},` +
+				`{"_index":"orders","_id":"3","_source":{"email":"ann@example.com"}}]}}`))
+		})
+		defer upstream.Close()
+
+		search := func(template, path string) string {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			return route(http.MethodGet, template, func(w http.ResponseWriter, r *http.Request) {
+				es.handler()(w, classified(r, category.Search, acl.Search, op.Read))
+			}, req).Body.String()
+		}
+
+		Convey("should transform the hits of the searches without an index", func() {
+			body := search("/_search", "/_search")
+			So(body, ShouldNotContainSubstring, "jane")
+			So(body, ShouldContainSubstring, `"email":["`+redacted+`"]`)
+			So(body, ShouldContainSubstring, `"email.keyword":["`+redacted+`"]`)
+			// the patterns may name an alias of the hit's index
+			So(body, ShouldNotContainSubstring, "555-0100")
+			So(body, ShouldContainSubstring, `"email":"joe@example.com"`)
+			So(body, ShouldContainSubstring, `"email":"ann@example.com"`)
+		})
+		Convey("should transform the hits of the other indices searched", func() {
+			body := search("/{index}/_search", "/orders,customers/_search")
+			So(body, ShouldNotContainSubstring, "jane")
+			So(body, ShouldContainSubstring, `"email":"ann@example.com"`)
+		})
+		Convey("should transform the streamed responses", func() {
+			os.Setenv("ES_CLUSTER_URL", upstream.URL)
+			defer os.Unsetenv("ES_CLUSTER_URL")
+			es.streamedRoutes["/{index}/_search"] = true
+			body := search("/{index}/_search", "/orders/_search")
+			So(body, ShouldNotContainSubstring, "jane")
+			So(body, ShouldNotContainSubstring, "555-0100")
+		})
+	})
+
+	Convey("Invalid response transforms", t, func() {
+		for _, value := range []string{"customers:email", "customers:mask:email", "customers:rename:name", ":redact:email"} {
+			os.Setenv(envResponseTransforms, value)
+			So((&elasticsearch{}).initResponseTransforms(), ShouldNotBeNil)
+		}
+		os.Unsetenv(envResponseTransforms)
+	})
+}
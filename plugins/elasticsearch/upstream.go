@@ -0,0 +1,33 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// headerUpstream routes the request of an admin user to one of the clusters
+// of ES_NAMED_CLUSTER_URLS, e.g. to test a canary through the gateway.
+const headerUpstream = "X-Arc-Upstream"
+
+// namedUpstream returns the client of the cluster the request is routed to
+// with the X-Arc-Upstream header, nil if it has none. The error is the one
+// to respond with, along with its status code.
+func namedUpstream(r *http.Request) (*es7.Client, int, error) {
+	name := r.Header.Get(headerUpstream)
+	if name == "" {
+		return nil, 0, nil
+	}
+	if !isAdminRequest(r) {
+		return nil, http.StatusForbidden, fmt.Errorf("only admin users can set the %s header", headerUpstream)
+	}
+	client, ok := util.GetNamedClient7(name)
+	if !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf(`unknown upstream "%s", expected one of [%s]`,
+			name, strings.Join(util.NamedClusters(), ", "))
+	}
+	return client, 0, nil
+}
@@ -0,0 +1,193 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+)
+
+// Query params consumed by arc itself, these are never forwarded to elasticsearch.
+const (
+	gatewayFormatParam = "gateway_format"
+	gatewayMaxAgeParam = "max_age"
+	// confirms the deletes of _all or of wildcard indices
+	gatewayConfirmParam = "i_am_sure"
+	// path of the value the successful json responses are reduced to
+	gatewayExtractParam = "gateway_extract"
+)
+
+var gatewayParams = []string{
+	gatewayFormatParam,
+	gatewayMaxAgeParam,
+	gatewayConfirmParam,
+	gatewayExtractParam,
+}
+
+// Supported values of the gateway_format query param.
+const (
+	formatPretty = "pretty"
+	formatMinify = "minify"
+)
+
+func isValidFormat(format string) bool {
+	return format == "" || format == formatPretty || format == formatMinify
+}
+
+// formatJSON pretty-prints or minifies the json body as per the requested format.
+// The body is returned as is if it can't be parsed as json.
+func formatJSON(body []byte, format string) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case formatPretty:
+		err = json.Indent(&buf, body, "", "  ")
+	case formatMinify:
+		err = json.Compact(&buf, body)
+	default:
+		return body
+	}
+	if err != nil {
+		return body
+	}
+	return buf.Bytes()
+}
+
+// media types of the sql results formats
+var sqlFormats = map[string]string{
+	"application/json":          "json",
+	"text/csv":                  "csv",
+	"text/plain":                "txt",
+	"text/tab-separated-values": "tsv",
+	"application/yaml":          "yaml",
+	"application/cbor":          "cbor",
+	"application/smile":         "smile",
+}
+
+func isSQLPath(path string) bool {
+	return path == "/_sql" || strings.HasPrefix(path, "/_sql/")
+}
+
+// sqlFormat returns the sql results format matching the Accept header, if any.
+func sqlFormat(accept string) string {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		if format, ok := sqlFormats[mediaType]; ok {
+			return format
+		}
+	}
+	return ""
+}
+
+// envList returns the comma separated values of the given env var.
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// headerSet returns a set of the given header names in their canonical form.
+func headerSet(headers []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, header := range headers {
+		set[http.CanonicalHeaderKey(header)] = true
+	}
+	return set
+}
+
+// parseCategory returns the category of the given name, e.g. "search".
+func parseCategory(name string) (category.Category, error) {
+	var c category.Category
+	err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToLower(name))))
+	return c, err
+}
+
+// categoryDurations parses a comma separated list of category:duration
+// pairs, e.g. "search:10s,docs:2m".
+func categoryDurations(value string) (map[category.Category]time.Duration, error) {
+	durations := make(map[category.Category]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid category duration %q, expected category:duration", pair)
+		}
+		c, err := parseCategory(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		durations[c] = d
+	}
+	return durations, nil
+}
+
+// categoryHeaders parses a comma separated list of category:header pairs,
+// e.g. "search:Accept-Language,search:X-Tenant", a category may be listed
+// with several headers.
+func categoryHeaders(value string) (map[category.Category][]string, error) {
+	headers := make(map[category.Category][]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid category header %q, expected category:header", pair)
+		}
+		c, err := parseCategory(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		headers[c] = append(headers[c], http.CanonicalHeaderKey(strings.TrimSpace(parts[1])))
+	}
+	return headers, nil
+}
+
+// errorStatusCodes lists the non 2xx status codes elasticsearch may respond with.
+var errorStatusCodes = func() []int {
+	var codes []int
+	for code := 300; code < 600; code++ {
+		codes = append(codes, code)
+	}
+	return codes
+}()
+
+// defaultRetryAfter is the delay, in seconds, suggested to the clients when
+// elasticsearch rejects a request with 429 without saying when to retry.
+const defaultRetryAfter = "1"
+
+// retryAfter makes sure the header carries a valid Retry-After value, either
+// a number of seconds or an http date, falling back to defaultRetryAfter.
+func retryAfter(header http.Header) {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return
+	}
+	if _, err := http.ParseTime(value); err == nil {
+		return
+	}
+	header.Set("Retry-After", defaultRetryAfter)
+}
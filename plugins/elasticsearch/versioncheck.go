@@ -0,0 +1,148 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+)
+
+// major versions arc has a client for
+var supportedMajorVersions = map[string]bool{"6": true, "7": true}
+
+// versionCheck periodically detects the version of the cluster, to catch it
+// being upgraded underneath arc, whose clients may start failing in subtle
+// ways against another major version.
+type versionCheck struct {
+	interval time.Duration
+	// whether the plugins switch to the client of the new major version,
+	// if arc has one
+	switchClients bool
+	// source of the cluster version, es unless replaced
+	source func(ctx context.Context) (string, error)
+
+	mu        sync.RWMutex
+	expected  string
+	detected  string
+	mismatch  bool
+	checkedAt time.Time
+}
+
+func (es *elasticsearch) initVersionCheck() error {
+	value := os.Getenv(envVersionCheckInterval)
+	if value == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	es.versionCheck = &versionCheck{
+		interval:      interval,
+		switchClients: os.Getenv(envVersionCheckSwitch) == "true",
+		source:        clusterVersion,
+		expected:      util.CachedSemanticVersion(),
+	}
+	go es.versionCheck.run()
+	return nil
+}
+
+func (v *versionCheck) run() {
+	ticker := time.NewTicker(v.interval)
+	for range ticker.C {
+		v.check(context.Background())
+	}
+}
+
+// check detects the version of the cluster and flags the change of major
+// version. The plugins are switched to the client of the new version if
+// enabled and possible, the mismatch is resolved then.
+func (v *versionCheck) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, v.interval)
+	defer cancel()
+	detected, err := v.source(ctx)
+	if err != nil {
+		log.Errorln(logTag, ": unable to detect the elasticsearch version:", err)
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.detected = detected
+	v.checkedAt = time.Now()
+	if v.expected == "" {
+		v.expected = detected
+	}
+	if majorVersion(detected) == majorVersion(v.expected) {
+		v.mismatch = false
+		return
+	}
+	if v.switchClients && supportedMajorVersions[majorVersion(detected)] {
+		log.Warnln(logTag, ": elasticsearch version changed from", v.expected, "to", detected,
+			", switching to the clients of version", majorVersion(detected))
+		util.SetSemanticVersion(detected)
+		v.expected = detected
+		v.mismatch = false
+		return
+	}
+	if !v.mismatch {
+		log.Errorln(logTag, ": ELASTICSEARCH VERSION MISMATCH: arc started against version", v.expected,
+			"but the cluster now runs version", detected, ", the requests may fail, restart arc")
+	}
+	v.mismatch = true
+}
+
+func (v *versionCheck) mismatched() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.mismatch
+}
+
+func (v *versionCheck) stats() map[string]interface{} {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	stats := map[string]interface{}{
+		"expected": v.expected,
+		"detected": v.detected,
+		"mismatch": v.mismatch,
+	}
+	if !v.checkedAt.IsZero() {
+		stats["checked_at"] = v.checkedAt.Format(time.RFC3339)
+	}
+	return stats
+}
+
+func majorVersion(version string) string {
+	return strings.SplitN(version, ".", 2)[0]
+}
+
+// clusterVersion returns the version of the cluster.
+func clusterVersion(ctx context.Context) (string, error) {
+	res, err := util.GetClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodGet,
+		Path:   "/",
+	})
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(res.Body, &info); err != nil {
+		return "", fmt.Errorf("error parsing the cluster info: %v", err)
+	}
+	if info.Version.Number == "" {
+		return "", fmt.Errorf("the cluster info has no version")
+	}
+	return info.Version.Number, nil
+}
@@ -0,0 +1,110 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/appbaseio/arc/util"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVersionCheck(t *testing.T) {
+	Convey("Version check", t, func() {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		detected := "7.10.2"
+		var sourceErr error
+		check := &versionCheck{
+			interval: time.Second,
+			source: func(ctx context.Context) (string, error) {
+				return detected, sourceErr
+			},
+			expected: "7.10.2",
+		}
+		es := &elasticsearch{versionCheck: check}
+		health := func() (int, map[string]interface{}) {
+			resp := httptest.NewRecorder()
+			es.healthDetailsHandler()(resp, httptest.NewRequest(http.MethodGet, "/_arc/health", nil))
+			var body map[string]interface{}
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			return resp.Code, body
+		}
+
+		check.check(context.Background())
+		code, body := health()
+		So(code, ShouldEqual, http.StatusOK)
+		So(body["status"], ShouldEqual, "ok")
+		So(body["version_check"].(map[string]interface{})["detected"], ShouldEqual, "7.10.2")
+
+		Convey("a new minor version is no mismatch", func() {
+			detected = "7.17.0"
+			check.check(context.Background())
+			So(check.mismatched(), ShouldBeFalse)
+		})
+		Convey("a new major version degrades the health", func() {
+			detected = "8.1.0"
+			check.check(context.Background())
+			So(check.mismatched(), ShouldBeTrue)
+			So(hook.LastEntry().Level, ShouldEqual, log.ErrorLevel)
+			So(hook.LastEntry().Message, ShouldContainSubstring, "VERSION MISMATCH")
+			So(hook.LastEntry().Message, ShouldContainSubstring, "8.1.0")
+
+			code, body := health()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(body["status"], ShouldEqual, "degraded")
+			So(body["failing"], ShouldResemble, []interface{}{"elasticsearch_version"})
+
+			// the mismatch is logged once, and kept while the version
+			// can't be detected
+			entries := len(hook.AllEntries())
+			check.check(context.Background())
+			So(hook.AllEntries(), ShouldHaveLength, entries)
+			sourceErr = errors.New("unreachable")
+			check.check(context.Background())
+			So(check.mismatched(), ShouldBeTrue)
+
+			// back to the expected version
+			detected, sourceErr = "7.10.2", nil
+			check.check(context.Background())
+			So(check.mismatched(), ShouldBeFalse)
+		})
+		Convey("the clients of a supported version are switched to", func() {
+			previous := util.CachedSemanticVersion()
+			defer util.SetSemanticVersion(previous)
+			check.switchClients = true
+
+			detected = "8.1.0"
+			check.check(context.Background())
+			So(check.mismatched(), ShouldBeTrue)
+
+			detected = "6.8.23"
+			check.check(context.Background())
+			So(check.mismatched(), ShouldBeFalse)
+			So(hook.LastEntry().Level, ShouldEqual, log.WarnLevel)
+			So(util.GetVersion(), ShouldEqual, 6)
+			So(util.CachedSemanticVersion(), ShouldEqual, "6.8.23")
+			So(check.stats()["expected"], ShouldEqual, "6.8.23")
+		})
+	})
+
+	Convey("Cluster version", t, func() {
+		upstream := newUpstream(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`{"name":"node-1","version":{"number":"8.1.0"}}`))
+		})
+		defer upstream.Close()
+		version, err := clusterVersion(context.Background())
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, "8.1.0")
+	})
+}
@@ -0,0 +1,80 @@
+package logs
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultFlushInterval = 5 * time.Second
+
+// bulkIndexer buffers the log records and hands them over to flush in batches,
+// whenever size records are buffered or every interval, whichever comes first.
+type bulkIndexer struct {
+	mu       sync.Mutex
+	size     int
+	interval time.Duration
+	records  []record
+	flush    func([]record)
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newBulkIndexer(size int, interval time.Duration, flush func([]record)) *bulkIndexer {
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	return &bulkIndexer{
+		size:     size,
+		interval: interval,
+		flush:    flush,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// add buffers the record, flushing the buffer once it is full.
+func (b *bulkIndexer) add(rec record) {
+	b.mu.Lock()
+	b.records = append(b.records, rec)
+	if len(b.records) < b.size {
+		b.mu.Unlock()
+		return
+	}
+	records := b.records
+	b.records = nil
+	b.mu.Unlock()
+	b.flush(records)
+}
+
+// flushBuffered flushes the buffered records, if any.
+func (b *bulkIndexer) flushBuffered() {
+	b.mu.Lock()
+	records := b.records
+	b.records = nil
+	b.mu.Unlock()
+	if len(records) > 0 {
+		b.flush(records)
+	}
+}
+
+// run flushes the buffered records every interval until the indexer is closed.
+func (b *bulkIndexer) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.flushBuffered()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// close stops the periodic flushes and flushes the records left in the buffer.
+func (b *bulkIndexer) close() {
+	close(b.stop)
+	<-b.done
+	b.flushBuffered()
+}
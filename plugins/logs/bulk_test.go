@@ -0,0 +1,75 @@
+package logs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/model/category"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// batches collects the flushed batches of records.
+type batches struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (b *batches) flush(recs []record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sizes = append(b.sizes, len(recs))
+}
+
+func (b *batches) flushed() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int(nil), b.sizes...)
+}
+
+func TestBulkIndexer(t *testing.T) {
+	Convey("Bulk indexer", t, func() {
+		rec := record{Category: category.Search}
+
+		Convey("Flushes once the buffer is full", func() {
+			var b batches
+			indexer := newBulkIndexer(3, time.Hour, b.flush)
+			for i := 0; i < 7; i++ {
+				indexer.add(rec)
+			}
+			So(b.flushed(), ShouldResemble, []int{3, 3})
+		})
+		Convey("Flushes the partial buffer every interval", func() {
+			var b batches
+			indexer := newBulkIndexer(100, 20*time.Millisecond, b.flush)
+			go indexer.run()
+			defer indexer.close()
+			indexer.add(rec)
+			indexer.add(rec)
+			time.Sleep(100 * time.Millisecond)
+			So(b.flushed(), ShouldResemble, []int{2})
+		})
+		Convey("Flushes the buffer on close", func() {
+			var b batches
+			indexer := newBulkIndexer(100, time.Hour, b.flush)
+			go indexer.run()
+			indexer.add(rec)
+			So(b.flushed(), ShouldBeEmpty)
+			indexer.close()
+			So(b.flushed(), ShouldResemble, []int{1})
+		})
+		Convey("Recorded requests are buffered", func() {
+			var b batches
+			l, records := newTestLogs()
+			l.bulk = newBulkIndexer(2, time.Hour, b.flush)
+			l.record(httptest.NewRequest(http.MethodGet, "/foo/_search", nil), category.Search, http.StatusOK, `{"took":1}`)
+			l.record(httptest.NewRequest(http.MethodGet, "/foo/_search", nil), category.Search, http.StatusOK, `{"took":1}`)
+			So(b.flushed(), ShouldResemble, []int{2})
+			// nor written to the log file
+			So(records(), ShouldBeEmpty)
+		})
+	})
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -88,6 +91,59 @@ func (es *elasticsearch) indexRecord(ctx context.Context, rec record) {
 	}
 }
 
+// indexRecords indexes the records with a single bulk request.
+func (es *elasticsearch) indexRecords(ctx context.Context, recs []record) {
+	bulk := util.GetClient7().Bulk()
+	for _, rec := range recs {
+		bulk.Add(es7.NewBulkIndexRequest().
+			Index(es.indexName).
+			Type("_doc").
+			Doc(rec))
+	}
+	res, err := bulk.Do(ctx)
+	if err != nil {
+		log.Errorln(logTag, ": error indexing", len(recs), "log records :", err)
+		return
+	}
+	if failed := res.Failed(); len(failed) > 0 {
+		log.Errorln(logTag, ":", len(failed), "of", len(recs), "log records failed to index")
+	}
+}
+
+// index blocks that prevent the log records from being indexed
+var writeBlocks = []string{
+	"index.blocks.write",
+	"index.blocks.read_only",
+	"index.blocks.read_only_allow_delete",
+}
+
+// checkWrite checks that the log records can be indexed, i.e. that the logs
+// alias exists and none of its indices blocks the writes.
+func (es *elasticsearch) checkWrite(ctx context.Context) error {
+	res, err := util.GetClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodGet,
+		Path:   "/" + url.PathEscape(es.indexName) + "/_settings",
+		Params: url.Values{"flat_settings": {"true"}, "filter_path": {"*.settings.index.blocks*"}},
+	})
+	if err != nil {
+		return fmt.Errorf("logs index %s isn't reachable: %v", es.indexName, err)
+	}
+	var indices map[string]struct {
+		Settings map[string]string `json:"settings"`
+	}
+	if err := json.Unmarshal(res.Body, &indices); err != nil {
+		return fmt.Errorf("error parsing the settings of logs index %s: %v", es.indexName, err)
+	}
+	for index, settings := range indices {
+		for _, block := range writeBlocks {
+			if settings.Settings[block] == "true" {
+				return fmt.Errorf("logs index %s has the %s block set", index, block)
+			}
+		}
+	}
+	return nil
+}
+
 type logsFilter struct {
 	Offset         int
 	StartDate      string
@@ -98,6 +154,82 @@ type logsFilter struct {
 	Size           int
 	Filter         string
 	Indices        []string
+	TraceID        string
+}
+
+// logsTail selects the log records timestamped at or after since, in pages
+// of size records sorted after the last one read.
+type logsTail struct {
+	Since   time.Time
+	After   []interface{}
+	Size    int
+	Indices []string
+}
+
+// tailedLog is a log record read from the logs index.
+type tailedLog struct {
+	ID        string
+	Timestamp time.Time
+	Source    json.RawMessage
+}
+
+// tailLogs returns the log records of the tail in the order they were
+// logged, along with the sort values of the last one to read the next
+// records after. The raw search request works with es 6 as well.
+func (es *elasticsearch) tailLogs(ctx context.Context, tail logsTail) ([]tailedLog, []interface{}, error) {
+	query := es7.NewBoolQuery().Filter(es7.NewRangeQuery("timestamp").Gte(tail.Since.Format(time.RFC3339Nano)))
+	util.GetIndexFilterQueryEs7(query, tail.Indices...)
+	source, err := query.Source()
+	if err != nil {
+		return nil, nil, err
+	}
+	body := map[string]interface{}{
+		"query": source,
+		"size":  tail.Size,
+		// the logs index has a single shard, the records of a timestamp are
+		// sorted in their indexing order then
+		"sort": []interface{}{
+			map[string]interface{}{"timestamp": map[string]interface{}{"order": "asc", "unmapped_type": "date"}},
+			"_doc",
+		},
+	}
+	if tail.After != nil {
+		body["search_after"] = tail.After
+	}
+	res, err := util.GetClient7().PerformRequest(ctx, es7.PerformRequestOptions{
+		Method: http.MethodPost,
+		Path:   "/" + url.PathEscape(es.indexName) + "/_search",
+		Body:   body,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				ID     string          `json:"_id"`
+				Source json.RawMessage `json:"_source"`
+				Sort   []interface{}   `json:"sort"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(res.Body, &response); err != nil {
+		return nil, nil, fmt.Errorf("error parsing the logs: %v", err)
+	}
+	after := tail.After
+	logs := make([]tailedLog, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		rec := tailedLog{ID: hit.ID, Source: hit.Source}
+		// the timestamp is sorted on as epoch millis
+		if len(hit.Sort) > 0 {
+			if millis, ok := hit.Sort[0].(float64); ok {
+				rec.Timestamp = time.Unix(0, int64(millis)*int64(time.Millisecond))
+			}
+		}
+		logs = append(logs, rec)
+		after = hit.Sort
+	}
+	return logs, after, nil
 }
 
 func (es *elasticsearch) getRawLogs(ctx context.Context, logsFilter logsFilter) ([]byte, error) {
@@ -135,7 +267,7 @@ func (es *elasticsearch) rolloverIndexJob(alias string) {
 		Mappings(mappings).
 		Do(ctx)
 	if err != nil {
-		log.Printf(logTag, "error while creating a rollover service %s %v", alias, err)
+		log.Errorln(logTag, ": error while creating a rollover service", alias, err)
 	}
 	log.Println(logTag, ": rollover res oldIndex", rolloverService.OldIndex)
 	log.Println(logTag, ": rollover res newIndex", rolloverService.NewIndex)
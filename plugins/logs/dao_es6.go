@@ -36,6 +36,10 @@ func (es *elasticsearch) getRawLogsES6(ctx context.Context, logsFilter logsFilte
 	// apply index filtering logic
 	util.GetIndexFilterQueryEs6(query, logsFilter.Filter)
 
+	if logsFilter.TraceID != "" {
+		query.Filter(es6.NewTermQuery("trace_id", logsFilter.TraceID))
+	}
+
 	// only apply latency filter when start or end range is available
 	if logsFilter.StartLatency != nil || logsFilter.EndLatency != nil {
 		latencyRangeQuery := es6.NewRangeQuery("response.took")
@@ -35,6 +35,10 @@ func (es *elasticsearch) getRawLogsES7(ctx context.Context, logsFilter logsFilte
 	// apply index filtering logic
 	util.GetIndexFilterQueryEs7(query, logsFilter.Indices...)
 
+	if logsFilter.TraceID != "" {
+		query.Filter(es7.NewTermQuery("trace_id", logsFilter.TraceID))
+	}
+
 	// only apply latency filter when start or end range is available
 	if logsFilter.StartLatency != nil || logsFilter.EndLatency != nil {
 		latencyRangeQuery := es7.NewRangeQuery("response.took")
@@ -0,0 +1,52 @@
+package logs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appbaseio/arc/util"
+	es7 "github.com/olivere/elastic/v7"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckWrite(t *testing.T) {
+	Convey("Logs index write check", t, func() {
+		code, settings := http.StatusOK, `{}`
+		var path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			w.Write([]byte(settings))
+		}))
+		defer server.Close()
+		client, err := es7.NewClient(es7.SetURL(server.URL), es7.SetSniff(false), es7.SetHealthcheck(false))
+		So(err, ShouldBeNil)
+		util.SetClient7(client)
+		defer util.SetClient7(nil)
+		es := &elasticsearch{indexName: ".logs"}
+
+		Convey("passes when the indices accept writes", func() {
+			So(es.checkWrite(context.Background()), ShouldBeNil)
+			So(path, ShouldEqual, "/.logs/_settings")
+			settings = `{".logs-000001":{"settings":{"index.blocks.write":"false"}}}`
+			So(es.checkWrite(context.Background()), ShouldBeNil)
+		})
+		Convey("fails when an index blocks the writes", func() {
+			settings = `{".logs-000001":{"settings":{"index.blocks.read_only_allow_delete":"true"}}}`
+			err := es.checkWrite(context.Background())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "index.blocks.read_only_allow_delete")
+		})
+		Convey("fails when the alias is missing", func() {
+			code, settings = http.StatusNotFound, `{"error":{"type":"index_not_found_exception"},"status":404}`
+			So(es.checkWrite(context.Background()), ShouldNotBeNil)
+		})
+		Convey("fails when the plugin isn't initialized", func() {
+			So((&Logs{}).CheckWrite(context.Background()), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// prefix of the fields selecting a single header, e.g. "headers.X-Opaque-Id"
+const headerFieldPrefix = "headers."
+
+// logFields are the fields of the records that get logged, the indices,
+// category and timestamp of the records are always logged since the logs
+// are filtered and sorted on them.
+type logFields struct {
+	method  bool
+	path    bool
+	status  bool
+	latency bool
+	body    bool
+	trace   bool
+	// whether all the request and response headers are logged, or only
+	// the listed ones
+	allHeaders bool
+	headers    map[string]bool
+}
+
+// parseLogFields parses the comma separated list of fields to log, nil if
+// every field is.
+func parseLogFields(value string) (*logFields, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	fields := &logFields{headers: make(map[string]bool)}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case field == "method":
+			fields.method = true
+		case field == "path":
+			fields.path = true
+		case field == "status":
+			fields.status = true
+		case field == "latency":
+			fields.latency = true
+		case field == "body":
+			fields.body = true
+		case field == "trace":
+			fields.trace = true
+		case field == "headers":
+			fields.allHeaders = true
+		case strings.HasPrefix(field, headerFieldPrefix) && len(field) > len(headerFieldPrefix):
+			fields.headers[http.CanonicalHeaderKey(strings.TrimPrefix(field, headerFieldPrefix))] = true
+		default:
+			return nil, fmt.Errorf(`unknown log field %q, expected one of "method", "path", "status", "latency", "headers", "headers.<name>", "body" or "trace"`, field)
+		}
+	}
+	return fields, nil
+}
+
+// apply clears the fields of the record that aren't logged, which are
+// omitted once it is marshalled.
+func (f *logFields) apply(rec *record) {
+	if f == nil {
+		return
+	}
+	rec.fields = f
+	if !f.method {
+		rec.Request.Method = ""
+	}
+	if !f.path {
+		rec.Request.URI = ""
+	}
+	if !f.status {
+		rec.Response.Code = 0
+		rec.Response.Status = ""
+	}
+	if !f.latency {
+		rec.Response.Took = nil
+	}
+	if !f.body {
+		rec.Request.Body = ""
+		rec.Response.Body = ""
+	}
+	if !f.trace {
+		rec.TraceID = ""
+		rec.SpanID = ""
+	}
+	if !f.allHeaders {
+		rec.Request.Headers = f.selectHeaders(rec.Request.Headers)
+		rec.Response.Headers = f.selectHeaders(rec.Response.Headers)
+	}
+}
+
+// selectHeaders returns the listed headers, nil if there is none.
+func (f *logFields) selectHeaders(headers map[string][]string) map[string][]string {
+	var selected map[string][]string
+	for k, v := range headers {
+		if f.headers[http.CanonicalHeaderKey(k)] {
+			if selected == nil {
+				selected = make(map[string][]string)
+			}
+			selected[k] = v
+		}
+	}
+	return selected
+}
+
+// omit removes the fields that aren't logged from the marshalled record.
+func (f *logFields) omit(raw []byte) ([]byte, error) {
+	var doc, request, response map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(doc["request"], &request); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(doc["response"], &response); err != nil {
+		return nil, err
+	}
+	drop := func(fields map[string]json.RawMessage, logged bool, keys ...string) {
+		for _, key := range keys {
+			// the headers are null if none of the listed ones is set
+			if !logged || string(fields[key]) == "null" {
+				delete(fields, key)
+			}
+		}
+	}
+	drop(request, f.method, "method")
+	drop(request, f.path, "uri")
+	drop(request, f.body, "body")
+	drop(request, f.allHeaders || len(f.headers) > 0, "header")
+	drop(response, f.status, "code", "status")
+	drop(response, f.latency, "took")
+	drop(response, f.body, "body")
+	drop(response, f.allHeaders || len(f.headers) > 0, "Headers")
+	drop(doc, f.trace, "trace_id", "span_id")
+
+	var err error
+	if doc["request"], err = json.Marshal(request); err != nil {
+		return nil, err
+	}
+	if doc["response"], err = json.Marshal(response); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
@@ -1,10 +1,12 @@
 package logs
 
 import (
+	"compress/gzip"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -110,6 +112,7 @@ func (l *Logs) logsHandler(w http.ResponseWriter, req *http.Request, isSearchLog
 		Size:      rangeParams.Size,
 		Filter:    filter,
 		Indices:   indices,
+		TraceID:   req.URL.Query().Get("trace_id"),
 	}
 
 	// Apply Search request filters
@@ -160,7 +163,53 @@ func (l *Logs) logsHandler(w http.ResponseWriter, req *http.Request, isSearchLog
 		return
 	}
 
-	util.WriteBackRaw(w, raw, http.StatusOK)
+	writeLogs(w, req, raw)
+}
+
+// writeLogs writes back the logs, gzipped if the client accepts it as the
+// exports of the logs can take several MBs.
+func writeLogs(w http.ResponseWriter, req *http.Request, raw []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) {
+		util.WriteBackRaw(w, raw, http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(http.StatusOK)
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(raw); err != nil {
+		log.Errorln(logTag, ": error writing the gzipped logs :", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		log.Errorln(logTag, ": error writing the gzipped logs :", err)
+	}
+}
+
+// acceptsGzip checks whether the Accept-Encoding header of the request
+// accepts the gzip encoding, i.e. lists gzip or * with a non zero weight.
+func acceptsGzip(req *http.Request) bool {
+	for _, value := range req.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			params := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			accepted := true
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					weight, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+					accepted = err == nil && weight > 0
+				}
+			}
+			return accepted
+		}
+	}
+	return false
 }
 
 func (l *Logs) getLogs() http.HandlerFunc {
@@ -0,0 +1,75 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// storedLogs serves the given raw logs.
+type storedLogs struct {
+	logsService
+	raw []byte
+}
+
+func (s *storedLogs) getRawLogs(ctx context.Context, logsFilter logsFilter) ([]byte, error) {
+	return s.raw, nil
+}
+
+func TestGetLogs(t *testing.T) {
+	Convey("Getting the logs", t, func() {
+		raw, err := json.Marshal(map[string]interface{}{
+			"logs": []record{
+				{Indices: []string{"foo"}, Request: Request{URI: "/foo/_search", Method: http.MethodPost}},
+				{Indices: []string{"bar"}, Request: Request{URI: "/bar/_doc/1", Method: http.MethodGet}},
+			},
+			"total": 2,
+		})
+		So(err, ShouldBeNil)
+		l := &Logs{es: &storedLogs{raw: raw}}
+		get := func(acceptEncoding string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/_logs", nil)
+			if acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			resp := httptest.NewRecorder()
+			l.getLogs()(resp, req)
+			return resp
+		}
+
+		Convey("gzipped when the client accepts it", func() {
+			resp := get("deflate, gzip;q=0.8")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(resp.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+			So(resp.Body.Len(), ShouldBeLessThan, len(raw))
+
+			gz, err := gzip.NewReader(resp.Body)
+			So(err, ShouldBeNil)
+			decompressed, err := ioutil.ReadAll(gz)
+			So(err, ShouldBeNil)
+			var logs struct {
+				Logs  []record `json:"logs"`
+				Total int      `json:"total"`
+			}
+			So(json.Unmarshal(decompressed, &logs), ShouldBeNil)
+			So(logs.Total, ShouldEqual, 2)
+			So(logs.Logs, ShouldHaveLength, 2)
+			So(logs.Logs[0].Request.URI, ShouldEqual, "/foo/_search")
+			So(logs.Logs[1].Indices, ShouldResemble, []string{"bar"})
+		})
+		Convey("as is otherwise", func() {
+			for _, acceptEncoding := range []string{"", "deflate", "gzip;q=0"} {
+				resp := get(acceptEncoding)
+				So(resp.Header().Get("Content-Encoding"), ShouldBeEmpty)
+				So(resp.Body.Bytes(), ShouldResemble, raw)
+			}
+		})
+	})
+}
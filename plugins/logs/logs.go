@@ -1,11 +1,18 @@
 package logs
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/appbaseio/arc/middleware"
 	"github.com/appbaseio/arc/plugins"
+	"github.com/appbaseio/arc/util"
 	"github.com/natefinch/lumberjack"
 	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
@@ -18,6 +25,17 @@ const (
 	envLogsEsIndex     = "LOGS_ES_INDEX"
 	defaultLogFilePath = "/var/log/arc/es.json"
 	envLogFilePath     = "LOG_FILE_PATH"
+	envLogsSampleRate  = "LOGS_SAMPLE_RATE"
+	envLogsMaskFields  = "LOGS_MASKED_FIELDS"
+	envLogsBodies      = "LOGS_BODIES"
+	envLogsBulkSize    = "LOGS_BULK_SIZE"
+	envLogsFlushPeriod = "LOGS_FLUSH_INTERVAL"
+	envLogsFields      = "LOGS_FIELDS"
+	envLogsStreamEvery = "LOGS_STREAM_INTERVAL"
+	envLogsLookback    = "LOGS_STREAM_LOOKBACK"
+	envLogsExcluded    = "LOGS_EXCLUDED_ROUTES"
+	envEncryptedFields = "ES_ENCRYPTED_FIELDS"
+	defaultSampleRate  = 1.0
 	config             = `
 	{
 	  "aliases": {
@@ -48,6 +66,28 @@ var (
 type Logs struct {
 	es         logsService
 	lumberjack lumberjack.Logger
+	sampleRate float64
+	// json field paths whose values are masked in the logged bodies
+	maskedFields [][]string
+	// dotted paths of the fields encrypted by the elasticsearch plugin,
+	// masked wherever they are found in the logged bodies
+	encryptedFields []string
+	// whether the bodies get logged, keyed on route name or category
+	bodyToggles map[string]bodyToggle
+	// buffer of the records indexed in elasticsearch rather than written to
+	// the log file, nil if disabled
+	bulk *bulkIndexer
+	// records being written, waited for on shutdown
+	recording sync.WaitGroup
+	// fields of the records that get logged, nil if all of them do
+	fields *logFields
+	// interval at which the streamed logs are polled, and how far back the
+	// records indexed late are looked for
+	streamInterval time.Duration
+	streamLookback time.Duration
+	// route names or templates, glob patterns allowed, whose requests
+	// aren't logged
+	excludedRoutes []string
 }
 
 // Instance returns the singleton instance of Logs plugin.
@@ -91,6 +131,55 @@ func (l *Logs) InitFunc() error {
 		MaxAge:     30, //days
 	}
 
+	l.sampleRate = defaultSampleRate
+	if rate := os.Getenv(envLogsSampleRate); rate != "" {
+		value, err := strconv.ParseFloat(rate, 64)
+		if err != nil || value < 0 || value > 1 {
+			log.Warnln(logTag, envLogsSampleRate+" must be a number between 0.0 and 1.0, defaulting to", defaultSampleRate)
+		} else {
+			l.sampleRate = value
+		}
+	}
+
+	l.maskedFields = fieldPaths(os.Getenv(envLogsMaskFields))
+	l.encryptedFields = encryptedFields(os.Getenv(envEncryptedFields))
+	for _, pattern := range strings.Split(os.Getenv(envLogsExcluded), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			l.excludedRoutes = append(l.excludedRoutes, pattern)
+		}
+	}
+
+	if l.fields, err = parseLogFields(os.Getenv(envLogsFields)); err != nil {
+		log.Errorln(logTag, ": unable to parse", envLogsFields, ":", err)
+		return err
+	}
+
+	if value := os.Getenv(envLogsBodies); value != "" {
+		if err := json.Unmarshal([]byte(value), &l.bodyToggles); err != nil {
+			log.Errorln(logTag, ": unable to parse", envLogsBodies, ":", err)
+			return err
+		}
+	}
+
+	if err := l.initBulkIndexer(); err != nil {
+		return err
+	}
+
+	l.streamInterval = defaultStreamInterval
+	if value := os.Getenv(envLogsStreamEvery); value != "" {
+		if l.streamInterval, err = time.ParseDuration(value); err != nil || l.streamInterval <= 0 {
+			log.Errorln(logTag, ":", envLogsStreamEvery, "must be a positive duration")
+			return fmt.Errorf("invalid %s: %q", envLogsStreamEvery, value)
+		}
+	}
+	l.streamLookback = defaultStreamLookback
+	if value := os.Getenv(envLogsLookback); value != "" {
+		if l.streamLookback, err = time.ParseDuration(value); err != nil || l.streamLookback <= 0 {
+			log.Errorln(logTag, ":", envLogsLookback, "must be a positive duration")
+			return fmt.Errorf("invalid %s: %q", envLogsLookback, value)
+		}
+	}
+
 	// init cron job
 	cronjob := cron.New()
 	cronjob.AddFunc("@midnight", func() { l.es.rolloverIndexJob(indexName) })
@@ -99,6 +188,50 @@ func (l *Logs) InitFunc() error {
 	return nil
 }
 
+// initBulkIndexer sets up the indexing of the records in elasticsearch, in
+// batches of LOGS_BULK_SIZE records, when the batch size is configured.
+func (l *Logs) initBulkIndexer() error {
+	value := os.Getenv(envLogsBulkSize)
+	if value == "" {
+		return nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		log.Errorln(logTag, ":", envLogsBulkSize, "must be a positive number")
+		return fmt.Errorf("invalid %s: %q", envLogsBulkSize, value)
+	}
+	interval := defaultFlushInterval
+	if value := os.Getenv(envLogsFlushPeriod); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Errorln(logTag, ": unable to parse", envLogsFlushPeriod, ":", err)
+			return err
+		}
+	}
+	l.bulk = newBulkIndexer(size, interval, func(recs []record) {
+		l.es.indexRecords(context.Background(), recs)
+	})
+	go l.bulk.run()
+	util.AddShutdownHook(func() {
+		l.recording.Wait()
+		l.bulk.close()
+	})
+	return nil
+}
+
+// CheckWrite checks whether the log records can be indexed in elasticsearch.
+func (l *Logs) CheckWrite(ctx context.Context) error {
+	if l.es == nil {
+		return fmt.Errorf("%s plugin isn't initialized", logTag)
+	}
+	return l.es.checkWrite(ctx)
+}
+
+// SampleRate returns the effective fraction of successful requests that get logged.
+func (l *Logs) SampleRate() float64 {
+	return l.sampleRate
+}
+
 // Routes returns an empty slice of routes, since Logs is solely a middleware.
 func (l *Logs) Routes() []plugins.Route {
 	return l.routes()
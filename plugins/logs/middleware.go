@@ -3,6 +3,7 @@ package logs
 import (
 	"encoding/json"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
@@ -18,6 +19,7 @@ import (
 	"github.com/appbaseio/arc/model/index"
 	"github.com/appbaseio/arc/model/request"
 	"github.com/appbaseio/arc/model/response"
+	"github.com/appbaseio/arc/model/trace"
 	"github.com/appbaseio/arc/plugins/auth"
 	"github.com/appbaseio/arc/util"
 )
@@ -72,7 +74,7 @@ type Request struct {
 	URI     string              `json:"uri"`
 	Method  string              `json:"method"`
 	Headers map[string][]string `json:"header"`
-	Body    string              `json:"body"`
+	Body    string              `json:"body,omitempty"`
 }
 
 type Response struct {
@@ -86,9 +88,24 @@ type Response struct {
 type record struct {
 	Indices   []string          `json:"indices"`
 	Category  category.Category `json:"category"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	SpanID    string            `json:"span_id,omitempty"`
 	Request   Request           `json:"request"`
 	Response  Response          `json:"response"`
 	Timestamp time.Time         `json:"timestamp"`
+	// fields that get logged, nil if all of them do
+	fields *logFields
+}
+
+// MarshalJSON omits the fields of the record that aren't logged, see
+// LOGS_FIELDS, the records of all the fields keep their shape.
+func (rec record) MarshalJSON() ([]byte, error) {
+	type plain record
+	raw, err := json.Marshal(plain(rec))
+	if err != nil || rec.fields == nil {
+		return raw, err
+	}
+	return rec.fields.omit(raw)
 }
 
 // Recorder records a log "record" for every request.
@@ -98,8 +115,8 @@ func Recorder() middleware.Middleware {
 
 func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// skip logs from streams
-		if r.Header.Get("X-Request-Category") == "streams" {
+		// skip logs from streams and from the excluded routes, e.g. the pings
+		if r.Header.Get("X-Request-Category") == "streams" || l.excluded(r) {
 			h(w, r)
 			return
 		}
@@ -113,7 +130,8 @@ func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 
 		var dumpRequest []byte
 		if *reqCategory != category.ReactiveSearch {
-			dumpRequest, err = httputil.DumpRequest(r, true)
+			// bodyless requests are dumped without reading their body
+			dumpRequest, err = httputil.DumpRequest(r, !util.IsBodyless(r))
 			if err != nil {
 				log.Errorln(logTag, ":", err.Error())
 				return
@@ -121,9 +139,9 @@ func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 		}
 		// Serve using response recorder
 		respRecorder := httptest.NewRecorder()
-		h(respRecorder, r)
 		var rsResponseBody *response.Response
 		if *reqCategory == category.ReactiveSearch {
+			h(respRecorder, r)
 			rsResponse, err := response.FromContext(ctx)
 			if err != nil {
 				log.Errorln(logTag, ":", err)
@@ -131,17 +149,78 @@ func (l *Logs) recorder(h http.HandlerFunc) http.HandlerFunc {
 				return
 			}
 			rsResponseBody = rsResponse
+			// Copy the response to writer
+			for k, v := range respRecorder.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(respRecorder.Code)
+			w.Write(respRecorder.Body.Bytes())
+		} else {
+			// the response is written back as it is served, e.g. streamed
+			h(&teeWriter{ResponseWriter: w, recorder: respRecorder}, r)
 		}
-		// Copy the response to writer
-		for k, v := range respRecorder.Header() {
-			w.Header()[k] = v
+		if !l.sampled(respRecorder.Code) {
+			return
 		}
-		w.WriteHeader(respRecorder.Code)
-		w.Write(respRecorder.Body.Bytes())
 		// Record the document
+		l.recording.Add(1)
+		go func() {
+			defer l.recording.Done()
+			l.recordResponse(respRecorder, r, dumpRequest, rsResponseBody)
+		}()
+	}
+}
 
-		go l.recordResponse(respRecorder, r, dumpRequest, rsResponseBody)
+// teeWriter writes the response to the client while recording it. The
+// bodies larger than what gets logged aren't recorded, so that the streamed
+// responses aren't held in memory, nor logged partially since their
+// masked fields couldn't be told apart.
+type teeWriter struct {
+	http.ResponseWriter
+	recorder    *httptest.ResponseRecorder
+	wroteHeader bool
+	// whether the body outgrew maxLoggedBodySize and is no longer recorded
+	overflowed bool
+}
+
+func (t *teeWriter) WriteHeader(code int) {
+	if t.wroteHeader {
+		return
+	}
+	t.wroteHeader = true
+	for k, v := range t.ResponseWriter.Header() {
+		t.recorder.Header()[k] = v
 	}
+	t.recorder.WriteHeader(code)
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	t.WriteHeader(http.StatusOK)
+	if !t.overflowed {
+		if t.recorder.Body.Len()+len(b) > maxLoggedBodySize {
+			t.overflowed = true
+			t.recorder.Body = nil
+		} else {
+			t.recorder.Write(b)
+		}
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *teeWriter) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// sampled decides whether a request with the given response code gets logged.
+// Error responses are always logged regardless of the sample rate.
+func (l *Logs) sampled(code int) bool {
+	if code >= http.StatusBadRequest {
+		return true
+	}
+	return rand.Float64() < l.sampleRate
 }
 
 func (l *Logs) recordResponse(w *httptest.ResponseRecorder, r *http.Request, reqBody []byte, rsResponseBody *response.Response) {
@@ -165,10 +244,16 @@ func (l *Logs) recordResponse(w *httptest.ResponseRecorder, r *http.Request, req
 		return
 	}
 
+	logRequestBody, logResponseBody := l.logsBodies(r, *reqCategory)
+
 	var rec record
 	rec.Indices = reqIndices
 	rec.Category = *reqCategory
 	rec.Timestamp = time.Now()
+	if reqTrace, err := trace.FromContext(ctx); err == nil {
+		rec.TraceID = reqTrace.TraceID
+		rec.SpanID = reqTrace.SpanID
+	}
 
 	// record response
 	response := w.Result()
@@ -207,12 +292,12 @@ func (l *Logs) recordResponse(w *httptest.ResponseRecorder, r *http.Request, req
 		rec.Request = Request{
 			URI:     r.URL.Path,
 			Headers: headers,
-			Body:    string(marshalled[:util.Min(len(marshalled), 1000000)]),
+			Body:    l.loggedBody(marshalled),
 			Method:  r.Method,
 		}
 		if rec.Response.Code > http.StatusOK {
 			// read error response from response recorder body
-			rec.Response.Body = string(responseBody[:util.Min(len(responseBody), 1000000)])
+			rec.Response.Body = l.loggedBody(responseBody)
 		} else {
 			// read success response from context
 			rsResponseBody.L.Lock()
@@ -234,7 +319,7 @@ func (l *Logs) recordResponse(w *httptest.ResponseRecorder, r *http.Request, req
 				log.Errorln(logTag, "error encountered while marshalling response body:", err)
 				return
 			}
-			rec.Response.Body = string(marshalledRes[:util.Min(len(marshalledRes), 1000000)])
+			rec.Response.Body = l.loggedBody(marshalledRes)
 		}
 	} else {
 		requestBody := strings.Split(string(reqBody), "\r\n\r\n")
@@ -246,16 +331,29 @@ func (l *Logs) recordResponse(w *httptest.ResponseRecorder, r *http.Request, req
 		rec.Request = Request{
 			URI:     r.URL.Path,
 			Headers: headers,
-			Body:    string(parsedBody[:util.Min(len(parsedBody), 1000000)]),
+			Body:    l.loggedBody(parsedBody),
 			Method:  r.Method,
 		}
-		rec.Response.Body = string(responseBody[:util.Min(len(responseBody), 1000000)])
+		rec.Response.Body = l.loggedBody(responseBody)
+	}
+	if !logRequestBody {
+		rec.Request.Body = ""
 	}
+	if !logResponseBody {
+		rec.Response.Body = ""
+	}
+	l.fields.apply(&rec)
 	marshalledLog, err := json.Marshal(rec)
 	if err != nil {
 		log.Errorln(logTag, "error encountered while marshalling record :", err)
 		return
 	}
+	// the records indexed by arc aren't written to the file as well, which
+	// filebeat would index a second time
+	if l.bulk != nil {
+		l.bulk.add(rec)
+		return
+	}
 	n, err := l.lumberjack.Write(marshalledLog)
 	if err != nil {
 		log.Errorln(logTag, "error encountered while writing logs :", err)
@@ -0,0 +1,308 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/middleware/classify"
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/model/index"
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+	"github.com/natefinch/lumberjack"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestLogs returns a Logs instance that writes the records to a temporary file.
+func newTestLogs() (*Logs, func() []record) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		panic(err)
+	}
+	l := &Logs{
+		sampleRate: 1,
+		lumberjack: lumberjack.Logger{Filename: filepath.Join(dir, "es.json")},
+	}
+	records := func() []record {
+		defer os.RemoveAll(dir)
+		file, err := os.Open(l.lumberjack.Filename)
+		if err != nil {
+			return nil
+		}
+		defer file.Close()
+		var recs []record
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var rec record
+			json.Unmarshal(scanner.Bytes(), &rec)
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+	return l, records
+}
+
+// record logs the request with the given response synchronously.
+func (l *Logs) record(req *http.Request, c category.Category, code int, respBody string) {
+	ctx := category.NewContext(req.Context(), &c)
+	ctx = index.NewContext(ctx, []string{"foo"})
+	req = req.WithContext(ctx)
+	dump, _ := httputil.DumpRequest(req, !util.IsBodyless(req))
+	resp := httptest.NewRecorder()
+	resp.WriteHeader(code)
+	resp.WriteString(respBody)
+	l.recordResponse(resp, req, dump, nil)
+}
+
+func TestRecorder(t *testing.T) {
+	Convey("Recorder", t, func() {
+		Convey("Sampling: errors bypass the sample rate", func() {
+			l := &Logs{sampleRate: 0}
+			So(l.sampled(http.StatusOK), ShouldBeFalse)
+			So(l.sampled(http.StatusNotFound), ShouldBeTrue)
+			So(l.sampled(http.StatusInternalServerError), ShouldBeTrue)
+		})
+		Convey("Sampling: full sample rate logs everything", func() {
+			l := &Logs{sampleRate: 1}
+			So(l.sampled(http.StatusOK), ShouldBeTrue)
+		})
+		Convey("Masking: configured fields are redacted", func() {
+			l, records := newTestLogs()
+			l.maskedFields = fieldPaths("query.match.email, hits.hits._source.email")
+			req := httptest.NewRequest(http.MethodPost, "/foo/_search",
+				strings.NewReader(`{"query":{"match":{"email":"jane@example.com"}}}`))
+			l.record(req, category.Search, http.StatusOK,
+				`{"took":1,"hits":{"hits":[{"_source":{"email":"jane@example.com","name":"jane"}}]}}`)
+
+			recs := records()
+			So(recs, ShouldHaveLength, 1)
+			So(recs[0].Request.Body, ShouldEqual, `{"query":{"match":{"email":"********"}}}`)
+			So(recs[0].Response.Body, ShouldNotContainSubstring, "jane@example.com")
+			So(recs[0].Response.Body, ShouldContainSubstring, `"name":"jane"`)
+		})
+		Convey("Masking: the encrypted fields are redacted wherever they are", func() {
+			l, records := newTestLogs()
+			l.encryptedFields = encryptedFields("patients:ssn, patients:address.zip")
+			req := httptest.NewRequest(http.MethodPost, "/patients/_bulk",
+				strings.NewReader("{\"index\":{}}\n{\"ssn\":\"123-45-6789\",\"address\":{\"zip\":\"75001\"}}\n"))
+			l.record(req, category.Docs, http.StatusOK, `{"errors":false}`)
+			req = httptest.NewRequest(http.MethodPost, "/patients/_search", strings.NewReader(`{"size":1}`))
+			l.record(req, category.Search, http.StatusOK,
+				`{"hits":{"hits":[{"_index":"patients","_source":{"ssn":"123-45-6789","name":"jane"}}]}}`)
+
+			recs := records()
+			So(recs, ShouldHaveLength, 2)
+			So(recs[0].Request.Body, ShouldNotContainSubstring, "123-45-6789")
+			So(recs[0].Request.Body, ShouldNotContainSubstring, "75001")
+			So(recs[0].Request.Body, ShouldContainSubstring, `"ssn":"********"`)
+			So(recs[1].Response.Body, ShouldNotContainSubstring, "123-45-6789")
+			So(recs[1].Response.Body, ShouldContainSubstring, `"name":"jane"`)
+		})
+		Convey("Masking: non-json bodies are logged unchanged", func() {
+			body := []byte("health status index\ngreen open foo\n")
+			So(string(maskFields(body, fieldPaths("email"))), ShouldEqual, string(body))
+		})
+		Convey("Bodies: toggles by route name and category", func() {
+			l, records := newTestLogs()
+			So(json.Unmarshal([]byte(`{
+				"bulk": {"request": false, "response": false},
+				"docs": {"response": false},
+				"search": {"request": true}
+			}`), &l.bodyToggles), ShouldBeNil)
+
+			router := mux.NewRouter()
+			router.Methods(http.MethodPost).Name("bulk").Path("/_bulk").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				l.record(r, category.Docs, http.StatusOK, `{"errors":false}`)
+			})
+			router.Methods(http.MethodPost).Name("index").Path("/{index}/_doc").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				l.record(r, category.Docs, http.StatusCreated, `{"result":"created"}`)
+			})
+			router.Methods(http.MethodPost).Name("search").Path("/{index}/_search").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				l.record(r, category.Search, http.StatusOK, `{"took":1}`)
+			})
+			serve := func(path, body string) {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			}
+			serve("/_bulk", "{\"index\":{}}\n{\"a\":1}\n")
+			serve("/foo/_doc", `{"a":1}`)
+			serve("/foo/_search", `{"query":{"match_all":{}}}`)
+
+			recs := records()
+			So(recs, ShouldHaveLength, 3)
+			So(recs[0].Request.URI, ShouldEqual, "/_bulk")
+			So(recs[0].Request.Body, ShouldBeEmpty)
+			So(recs[0].Response.Body, ShouldBeEmpty)
+			So(recs[1].Request.Body, ShouldEqual, `{"a":1}`)
+			So(recs[1].Response.Body, ShouldBeEmpty)
+			So(recs[2].Request.Body, ShouldEqual, `{"query":{"match_all":{}}}`)
+			So(recs[2].Response.Body, ShouldEqual, `{"took":1}`)
+			So(*recs[2].Response.Took, ShouldEqual, 1)
+		})
+		Convey("Exclusions: the excluded routes aren't logged", func() {
+			l, records := newTestLogs()
+			l.excludedRoutes = []string{"ping", "/_cat/*"}
+			classified := func(c category.Category) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					ctx := category.NewContext(r.Context(), &c)
+					ctx = index.NewContext(ctx, []string{"foo"})
+					l.recorder(func(w http.ResponseWriter, r *http.Request) {
+						w.Write([]byte(`{}`))
+					})(w, r.WithContext(ctx))
+				}
+			}
+			router := mux.NewRouter()
+			router.Methods(http.MethodGet).Name("ping").Path("/").HandlerFunc(classified(category.Misc))
+			router.Methods(http.MethodGet).Name("cat.health").Path("/_cat/health").HandlerFunc(classified(category.Cat))
+			router.Methods(http.MethodPost).Name("search").Path("/{index}/_search").HandlerFunc(classified(category.Search))
+			for _, path := range []string{"/", "/_cat/health", "/foo/_search"} {
+				method := http.MethodGet
+				if path == "/foo/_search" {
+					method = http.MethodPost
+				}
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, httptest.NewRequest(method, path, nil))
+				So(resp.Body.String(), ShouldEqual, `{}`)
+			}
+
+			// the records are written in the background
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if raw, _ := ioutil.ReadFile(l.lumberjack.Filename); len(raw) > 0 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			recs := records()
+			So(recs, ShouldHaveLength, 1)
+			So(recs[0].Request.URI, ShouldEqual, "/foo/_search")
+		})
+		Convey("Tracing: trace context is recorded", func() {
+			l, records := newTestLogs()
+			h := classify.Trace()(func(w http.ResponseWriter, r *http.Request) {
+				l.record(r, category.Search, http.StatusOK, `{"took":1}`)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/foo/_search", nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			h(httptest.NewRecorder(), req)
+			req = httptest.NewRequest(http.MethodPost, "/foo/_search", nil)
+			req.Header.Set("uber-trace-id", "a1b2c3d4e5f60718:1a2b3c4d5e6f7081:0:1")
+			h(httptest.NewRecorder(), req)
+			h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/foo/_search", nil))
+
+			recs := records()
+			So(recs, ShouldHaveLength, 3)
+			So(recs[0].TraceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(recs[0].SpanID, ShouldEqual, "00f067aa0ba902b7")
+			So(recs[1].TraceID, ShouldEqual, "a1b2c3d4e5f60718")
+			So(recs[1].SpanID, ShouldEqual, "1a2b3c4d5e6f7081")
+			So(recs[2].TraceID, ShouldBeEmpty)
+		})
+		Convey("Bodies: bodyless GET and HEAD requests have no body field", func() {
+			l, records := newTestLogs()
+			l.record(httptest.NewRequest(http.MethodGet, "/foo/_doc/1", nil), category.Docs, http.StatusOK, `{"found":true}`)
+			l.record(httptest.NewRequest(http.MethodHead, "/foo", nil), category.Indices, http.StatusOK, "")
+			l.record(httptest.NewRequest(http.MethodGet, "/foo/_search", strings.NewReader(`{"size":1}`)), category.Search, http.StatusOK, `{"took":1}`)
+
+			raw, err := ioutil.ReadFile(l.lumberjack.Filename)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+			So(lines, ShouldHaveLength, 3)
+			for _, line := range lines[:2] {
+				var rec map[string]interface{}
+				So(json.Unmarshal([]byte(line), &rec), ShouldBeNil)
+				So(rec["request"], ShouldNotContainKey, "body")
+			}
+			recs := records()
+			So(recs[2].Request.Body, ShouldEqual, `{"size":1}`)
+		})
+		Convey("Fields: only the configured fields are logged", func() {
+			l, records := newTestLogs()
+			fields, err := parseLogFields("method, status, headers.x-opaque-id")
+			So(err, ShouldBeNil)
+			l.fields = fields
+			req := httptest.NewRequest(http.MethodPost, "/foo/_search", strings.NewReader(`{"size":1}`))
+			req.Header.Set("X-Opaque-Id", "dashboard")
+			req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+			l.record(req, category.Search, http.StatusOK, `{"took":1}`)
+
+			raw, err := ioutil.ReadFile(l.lumberjack.Filename)
+			So(err, ShouldBeNil)
+			var rec map[string]interface{}
+			So(json.Unmarshal(raw, &rec), ShouldBeNil)
+			So(rec, ShouldContainKey, "indices")
+			So(rec, ShouldContainKey, "category")
+			So(rec, ShouldContainKey, "timestamp")
+			So(rec, ShouldNotContainKey, "trace_id")
+			So(rec["request"], ShouldResemble, map[string]interface{}{
+				"method": http.MethodPost,
+				"header": map[string]interface{}{"X-Opaque-Id": []interface{}{"dashboard"}},
+			})
+			So(rec["response"], ShouldResemble, map[string]interface{}{
+				"code":   float64(http.StatusOK),
+				"status": http.StatusText(http.StatusOK),
+			})
+
+			recs := records()
+			So(recs, ShouldHaveLength, 1)
+			So(recs[0].Indices, ShouldResemble, []string{"foo"})
+			So(recs[0].Request.URI, ShouldBeEmpty)
+
+			// the records indexed in bulk are marshalled the same
+			l.fields.apply(&recs[0])
+			indexed, err := json.Marshal(recs[0])
+			So(err, ShouldBeNil)
+			var doc map[string]interface{}
+			So(json.Unmarshal(indexed, &doc), ShouldBeNil)
+			So(doc["request"], ShouldResemble, rec["request"])
+			So(doc["response"], ShouldResemble, rec["response"])
+
+			_, err = parseLogFields("method,cookies")
+			So(err, ShouldNotBeNil)
+			fields, err = parseLogFields(" ")
+			So(err, ShouldBeNil)
+			So(fields, ShouldBeNil)
+		})
+		Convey("Fields: the records of all the fields keep their shape", func() {
+			l, _ := newTestLogs()
+			req := httptest.NewRequest(http.MethodGet, "/foo/_search", nil)
+			l.record(req, category.Search, http.StatusOK, "")
+
+			raw, err := ioutil.ReadFile(l.lumberjack.Filename)
+			So(err, ShouldBeNil)
+			var rec map[string]map[string]interface{}
+			json.Unmarshal(raw, &rec)
+			So(rec["request"], ShouldContainKey, "uri")
+			So(rec["request"], ShouldContainKey, "method")
+			So(rec["request"], ShouldContainKey, "header")
+			So(rec["response"], ShouldContainKey, "code")
+			So(rec["response"], ShouldContainKey, "Headers")
+			So(rec["response"], ShouldContainKey, "body")
+		})
+		Convey("Streaming: the bodies too large to be logged aren't recorded", func() {
+			resp := httptest.NewRecorder()
+			recorder := httptest.NewRecorder()
+			tee := &teeWriter{ResponseWriter: resp, recorder: recorder}
+			chunk := strings.Repeat("a", maxLoggedBodySize/2)
+			tee.Write([]byte(chunk))
+			So(recorder.Body.Len(), ShouldEqual, len(chunk))
+			tee.Write([]byte(chunk))
+			tee.Write([]byte(chunk))
+			So(tee.overflowed, ShouldBeTrue)
+			body, _ := ioutil.ReadAll(recorder.Result().Body)
+			So(body, ShouldBeEmpty)
+			// the client still gets the whole response
+			So(resp.Body.Len(), ShouldEqual, 3*len(chunk))
+		})
+	})
+}
@@ -37,5 +37,19 @@ func (l *Logs) routes() []plugins.Route {
 			HandlerFunc: middleware(l.getSearchLogs()),
 			Description: "Returns the search request logs for the cluster",
 		},
+		{
+			Name:        "Stream index logs",
+			Methods:     []string{http.MethodGet},
+			Path:        "/{index}/_logs/stream",
+			HandlerFunc: middleware(l.streamLogs()),
+			Description: "Streams the new logs for an index as server-sent events",
+		},
+		{
+			Name:        "Stream logs",
+			Methods:     []string{http.MethodGet},
+			Path:        "/_logs/stream",
+			HandlerFunc: middleware(l.streamLogs()),
+			Description: "Streams the new logs for the cluster as server-sent events",
+		},
 	}
 }
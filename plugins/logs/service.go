@@ -5,5 +5,8 @@ import "context"
 type logsService interface {
 	getRawLogs(ctx context.Context, logsFilter logsFilter) ([]byte, error)
 	indexRecord(ctx context.Context, r record)
+	indexRecords(ctx context.Context, recs []record)
 	rolloverIndexJob(alias string)
+	checkWrite(ctx context.Context) error
+	tailLogs(ctx context.Context, tail logsTail) ([]tailedLog, []interface{}, error)
 }
@@ -0,0 +1,112 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/appbaseio/arc/util"
+)
+
+const (
+	defaultStreamInterval = time.Second
+	defaultStreamLookback = 30 * time.Second
+	// max number of records read from es at once while streaming
+	streamBatchSize = 100
+)
+
+// streamLogs pushes the log records logged after the client connected as
+// server-sent events, one "log" event per record. The logs index is polled
+// every LOGS_STREAM_INTERVAL until the client disconnects. The records are
+// indexed some time after they are logged, e.g. once the bulk they are part
+// of is flushed, so every poll reads the records logged within the lookback
+// window again and skips the ones already pushed.
+func (l *Logs) streamLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			util.WriteBackError(w, "streaming isn't supported", http.StatusInternalServerError)
+			return
+		}
+		interval := l.streamInterval
+		if interval <= 0 {
+			interval = defaultStreamInterval
+		}
+		lookback := l.streamLookback
+		if lookback <= 0 {
+			lookback = defaultStreamLookback
+		}
+		// the records are timestamped to the millisecond
+		connected := time.Now().Truncate(time.Millisecond)
+		// timestamps of the records pushed, by id, within the window
+		pushed := make(map[string]time.Time)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ctx := req.Context()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Debugln(logTag, ": logs stream client disconnected")
+				return
+			case <-ticker.C:
+			}
+			since := time.Now().Add(-lookback).Truncate(time.Millisecond)
+			if since.Before(connected) {
+				since = connected
+			}
+			for id, timestamp := range pushed {
+				if timestamp.Before(since) {
+					delete(pushed, id)
+				}
+			}
+			tail := logsTail{
+				Since:   since,
+				Size:    streamBatchSize,
+				Indices: util.IndicesFromRequest(req),
+			}
+			// read the records of the window until caught up
+			for {
+				logs, after, err := l.es.tailLogs(ctx, tail)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Errorln(logTag, ": error streaming logs :", err)
+					}
+					break
+				}
+				tail.After = after
+				var written bool
+				for _, rec := range logs {
+					if _, ok := pushed[rec.ID]; ok {
+						continue
+					}
+					pushed[rec.ID] = rec.Timestamp
+					// the data of an event can't span several lines
+					var data bytes.Buffer
+					if err := json.Compact(&data, rec.Source); err != nil {
+						log.Errorln(logTag, ": error streaming a log record :", err)
+						continue
+					}
+					fmt.Fprintf(w, "event: log\ndata: %s\n\n", data.Bytes())
+					written = true
+				}
+				if written {
+					flusher.Flush()
+				}
+				if len(logs) < tail.Size {
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+	es7 "github.com/olivere/elastic/v7"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// indexedLogs fakes the search of the logs index, the records are sorted by
+// timestamp, then in their indexing order.
+type indexedLogs struct {
+	mu      sync.Mutex
+	records []indexedLog
+	queries []string
+}
+
+type indexedLog struct {
+	timestamp int64
+	source    string
+}
+
+// add indexes the record, logged at the given time.
+func (i *indexedLogs) add(rec string, logged time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.records = append(i.records, indexedLog{timestamp: logged.UnixNano() / int64(time.Millisecond), source: rec})
+}
+
+func (i *indexedLogs) search(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query       json.RawMessage `json:"query"`
+		SearchAfter []int64         `json:"search_after"`
+	}
+	raw, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(raw, &body)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.queries = append(i.queries, string(body.Query))
+	seqs := make([]int, len(i.records))
+	for seq := range seqs {
+		seqs[seq] = seq
+	}
+	sort.Slice(seqs, func(a, b int) bool {
+		if i.records[seqs[a]].timestamp != i.records[seqs[b]].timestamp {
+			return i.records[seqs[a]].timestamp < i.records[seqs[b]].timestamp
+		}
+		return seqs[a] < seqs[b]
+	})
+	hits := []map[string]interface{}{}
+	for _, seq := range seqs {
+		rec := i.records[seq]
+		if body.SearchAfter != nil && (rec.timestamp < body.SearchAfter[0] ||
+			(rec.timestamp == body.SearchAfter[0] && int64(seq) <= body.SearchAfter[1])) {
+			continue
+		}
+		hits = append(hits, map[string]interface{}{
+			"_id":     strconv.Itoa(seq),
+			"_source": json.RawMessage(rec.source),
+			"sort":    []int64{rec.timestamp, int64(seq)},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+}
+
+func (i *indexedLogs) lastQuery() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.queries) == 0 {
+		return ""
+	}
+	return i.queries[len(i.queries)-1]
+}
+
+func TestStreamLogs(t *testing.T) {
+	Convey("Logs stream", t, func() {
+		logs := &indexedLogs{}
+		upstream := httptest.NewServer(http.HandlerFunc(logs.search))
+		defer upstream.Close()
+		client, err := es7.NewClient(es7.SetURL(upstream.URL), es7.SetSniff(false), es7.SetHealthcheck(false))
+		So(err, ShouldBeNil)
+		util.SetClient7(client)
+		defer util.SetClient7(nil)
+
+		l := &Logs{es: &elasticsearch{indexName: ".logs"}, streamInterval: 10 * time.Millisecond}
+		done := make(chan struct{})
+		router := mux.NewRouter()
+		router.Path("/{index}/_logs/stream").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(done)
+			l.streamLogs()(w, r)
+		})
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		res, err := http.Get(server.URL + "/foo/_logs/stream")
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(res.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+		logs.add(`{"indices":["foo"],"category":"search","request":{"uri":"/foo/_search"}}`, time.Now())
+		reader := bufio.NewReader(res.Body)
+		event, err := reader.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(event, ShouldEqual, "event: log\n")
+		data, err := reader.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(data, ShouldEqual, `data: {"indices":["foo"],"category":"search","request":{"uri":"/foo/_search"}}`+"\n")
+		So(logs.lastQuery(), ShouldContainSubstring, `"indices.keyword":"foo"`)
+
+		// the records already pushed aren't pushed again
+		logs.add(`{"indices":["foo"],"category":"docs"}`, time.Now())
+		reader.ReadString('\n')
+		reader.ReadString('\n')
+		data, err = reader.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(data), ShouldEqual, `data: {"indices":["foo"],"category":"docs"}`)
+
+		// nor are the records indexed after the ones logged later
+		time.Sleep(20 * time.Millisecond)
+		logs.add(`{"indices":["foo"],"category":"cat"}`, time.Now().Add(-10*time.Millisecond))
+		reader.ReadString('\n')
+		reader.ReadString('\n')
+		data, err = reader.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(data), ShouldEqual, `data: {"indices":["foo"],"category":"cat"}`)
+
+		res.Body.Close()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("the stream didn't end once the client disconnected")
+		}
+	})
+}
@@ -1,5 +1,145 @@
 package logs
 
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/appbaseio/arc/model/category"
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxLoggedBodySize is the number of bytes of a body that get logged
+	maxLoggedBodySize = 1000000
+	maskPlaceholder   = "********"
+)
+
+// loggedBody returns the body as it must be logged, i.e. with the configured
+// and the encrypted fields masked and truncated to maxLoggedBodySize.
+func (l *Logs) loggedBody(body []byte) string {
+	body = maskFields(body, l.maskedFields)
+	body = util.MaskNestedFields(body, l.encryptedFields, maskPlaceholder)
+	return string(body[:util.Min(len(body), maxLoggedBodySize)])
+}
+
+// bodyToggle tells whether the request and response bodies get logged, both
+// are logged unless turned off.
+type bodyToggle struct {
+	Request  *bool `json:"request,omitempty"`
+	Response *bool `json:"response,omitempty"`
+}
+
+// logsBodies returns whether the request and response bodies of the request get
+// logged. A toggle for the route name takes precedence over one for the category.
+func (l *Logs) logsBodies(r *http.Request, c category.Category) (request, response bool) {
+	request, response = true, true
+	toggle, ok := l.bodyToggles[c.String()]
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		if routeToggle, found := l.bodyToggles[route.GetName()]; found {
+			toggle, ok = routeToggle, true
+		}
+	}
+	if !ok {
+		return
+	}
+	if toggle.Request != nil {
+		request = *toggle.Request
+	}
+	if toggle.Response != nil {
+		response = *toggle.Response
+	}
+	return
+}
+
+// excluded checks whether the request is left out of the logs, i.e. whether
+// the name or the template of its route matches one of the excluded routes.
+func (l *Logs) excluded(r *http.Request) bool {
+	if len(l.excludedRoutes) == 0 {
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, _ := route.GetPathTemplate()
+	for _, pattern := range l.excludedRoutes {
+		nameMatch, _ := filepath.Match(pattern, route.GetName())
+		templateMatch, _ := filepath.Match(pattern, template)
+		if nameMatch || templateMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldPaths parses the comma separated list of dotted json field paths, e.g. "query.match.email".
+func fieldPaths(value string) [][]string {
+	var paths [][]string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			paths = append(paths, strings.Split(field, "."))
+		}
+	}
+	return paths
+}
+
+// encryptedFields returns the field paths of the "index:field" pairs, the
+// fields of all the indices are masked alike.
+func encryptedFields(value string) []string {
+	var fields []string
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) == 2 && parts[1] != "" && !util.Contains(fields, parts[1]) {
+			fields = append(fields, parts[1])
+		}
+	}
+	return fields
+}
+
+// maskFields replaces the values of the given field paths in the json body with a
+// placeholder. Arrays along the path are traversed element-wise. Bodies that are
+// not json are returned unchanged.
+func maskFields(body []byte, paths [][]string) []byte {
+	if len(paths) == 0 || len(body) == 0 {
+		return body
+	}
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return body
+	}
+	for _, path := range paths {
+		maskField(parsed, path)
+	}
+	masked, err := json.Marshal(parsed)
+	if err != nil {
+		return body
+	}
+	return masked
+}
+
+func maskField(node interface{}, path []string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		value, ok := n[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			n[path[0]] = maskPlaceholder
+			return
+		}
+		maskField(value, path[1:])
+	case []interface{}:
+		for _, element := range n {
+			maskField(element, path)
+		}
+	}
+}
+
 // LogsMappings mappings for .logs indices
 const LogsMappings = `{
    "dynamic":false,
@@ -463,6 +603,12 @@ const LogsMappings = `{
       },
       "timestamp":{
          "type":"date"
+      },
+      "trace_id":{
+         "type":"keyword"
+      },
+      "span_id":{
+         "type":"keyword"
       }
    }
 }`
@@ -0,0 +1,117 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/appbaseio/arc/util"
+	"github.com/gorilla/mux"
+)
+
+// maximum number of route templates suggested for an unmatched path
+const maxRouteSuggestions = 5
+
+// handler answering the unmatched paths, nil if they get a plain 404
+var notFoundHandler http.Handler
+
+// SetNotFoundHandler makes NotFound answer with the given handler, nil
+// restores the plain 404.
+func SetNotFoundHandler(h http.Handler) {
+	notFoundHandler = h
+}
+
+// NotFound answers the request like the unmatched paths are answered, i.e.
+// with the handler set by SetNotFoundHandler, or a plain 404 if none is set.
+// The plugins use it for the paths they turn away themselves, e.g. the
+// requests of the disabled routes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	if notFoundHandler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	notFoundHandler.ServeHTTP(w, r)
+}
+
+// NotFoundHandler answers the requests whose path matches none of the
+// router's routes with a 404 listing the closest route templates, so that
+// the clients can find out the endpoint they were after. The routes must
+// have been registered beforehand.
+func NotFoundHandler(router *mux.Router) http.HandlerFunc {
+	var templates []string
+	seen := make(map[string]bool)
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err == nil && !seen[template] {
+			seen[template] = true
+			templates = append(templates, template)
+		}
+		return nil
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := util.ErrorBody(fmt.Sprintf("no route matches %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+		body["suggestions"] = SuggestRoutes(r.URL.Path, templates)
+		raw, err := json.Marshal(body)
+		if err != nil {
+			util.WriteBackError(w, "page not found", http.StatusNotFound)
+			return
+		}
+		util.WriteBackRaw(w, raw, http.StatusNotFound)
+	}
+}
+
+// SuggestRoutes returns the route templates closest to the path, closest first.
+func SuggestRoutes(path string, templates []string) []string {
+	maxDistance := len(path) / 4
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	distances := make(map[string]int)
+	suggestions := []string{}
+	for _, template := range templates {
+		if d := templateDistance(path, template); d <= maxDistance {
+			distances[template] = d
+			suggestions = append(suggestions, template)
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if distances[suggestions[i]] == distances[suggestions[j]] {
+			return suggestions[i] < suggestions[j]
+		}
+		return distances[suggestions[i]] < distances[suggestions[j]]
+	})
+	if len(suggestions) > maxRouteSuggestions {
+		suggestions = suggestions[:maxRouteSuggestions]
+	}
+	return suggestions
+}
+
+// templateDistance sums the edit distances between the path segments and the
+// template segments, the template variables match any segment. A segment
+// missing from either side costs its length.
+func templateDistance(path, template string) int {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	templateSegments := strings.Split(strings.Trim(template, "/"), "/")
+	var d int
+	for i := 0; i < len(segments) || i < len(templateSegments); i++ {
+		switch {
+		case i >= len(templateSegments):
+			d += len(segments[i])
+		case isTemplateVar(templateSegments[i]):
+			if i >= len(segments) {
+				d++
+			}
+		case i >= len(segments):
+			d += len(templateSegments[i])
+		default:
+			d += util.Levenshtein(segments[i], templateSegments[i])
+		}
+	}
+	return d
+}
+
+func isTemplateVar(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
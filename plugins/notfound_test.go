@@ -0,0 +1,62 @@
+package plugins
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotFoundHandler(t *testing.T) {
+	Convey("Not found handler", t, func() {
+		router := mux.NewRouter()
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		for _, template := range []string{"/{index}/_search", "/_search", "/{index}/_doc/{id}", "/_cluster/health", "/_arc/health"} {
+			router.Methods(http.MethodGet, http.MethodPost).Path(template).HandlerFunc(ok)
+		}
+		router.NotFoundHandler = NotFoundHandler(router)
+
+		serve := func(path string) (int, []string) {
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+			var body struct {
+				Suggestions []string `json:"suggestions"`
+			}
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			return resp.Code, body.Suggestions
+		}
+
+		Convey("should suggest the closest route templates", func() {
+			code, suggestions := serve("/products/_serach")
+			So(code, ShouldEqual, http.StatusNotFound)
+			So(suggestions, ShouldResemble, []string{"/{index}/_search"})
+
+			_, suggestions = serve("/_cluster/helth")
+			So(suggestions, ShouldResemble, []string{"/_cluster/health"})
+
+			_, suggestions = serve("/products/doc/1")
+			So(suggestions, ShouldResemble, []string{"/{index}/_doc/{id}"})
+		})
+		Convey("should list no suggestions for the unrelated paths", func() {
+			code, suggestions := serve("/completely/unrelated/path/here")
+			So(code, ShouldEqual, http.StatusNotFound)
+			So(suggestions, ShouldBeEmpty)
+		})
+		Convey("should answer the paths turned away by the plugins the same way", func() {
+			resp := httptest.NewRecorder()
+			NotFound(resp, httptest.NewRequest(http.MethodGet, "/_search/disabled", nil))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldNotContainSubstring, "suggestions")
+
+			SetNotFoundHandler(router.NotFoundHandler)
+			defer SetNotFoundHandler(nil)
+			resp = httptest.NewRecorder()
+			NotFound(resp, httptest.NewRequest(http.MethodGet, "/_cluster/helth", nil))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldContainSubstring, "/_cluster/health")
+		})
+	})
+}
@@ -104,6 +104,9 @@ func (p *permissions) postPermission(opts ...permission.Options) http.HandlerFun
 		if permissionBody.TTL != 0 {
 			permissionOptions = append(permissionOptions, permission.SetTTL(permissionBody.TTL))
 		}
+		if permissionBody.BypassCache != nil {
+			permissionOptions = append(permissionOptions, permission.SetBypassCache(*permissionBody.BypassCache))
+		}
 
 		var newPermission *permission.Permission
 		if *reqUser.IsAdmin {
@@ -100,6 +100,9 @@ func (u *Users) postUser() http.HandlerFunc {
 		if userBody.Indices != nil {
 			opts = append(opts, user.SetIndices(userBody.Indices))
 		}
+		if userBody.BypassCache != nil {
+			opts = append(opts, user.SetBypassCache(*userBody.BypassCache))
+		}
 		if userBody.Username == "" {
 			util.WriteBackError(w, `can't create a user without a "username"`, http.StatusBadRequest)
 			return
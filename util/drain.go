@@ -0,0 +1,163 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const envClientDrainTimeout = "ES_CLIENT_DRAIN_TIMEOUT"
+
+const defaultClientDrainTimeout = 30 * time.Second
+
+// the transports counting the requests in flight of the es clients, by client
+var (
+	clientTransports   = make(map[interface{}]*clientTransport)
+	clientTransportsMu sync.Mutex
+)
+
+// clientTransport counts the requests an es client has in flight, so that the
+// client can be drained before being closed once another one replaces it.
+type clientTransport struct {
+	*http.Transport
+	mu     sync.Mutex
+	active int
+	// closed once no request is in flight, nil until someone waits for it
+	idle chan struct{}
+}
+
+// newClientTransport returns a transport configured as the shared http
+// client's one but with its own connections, which can be closed along with
+// the client.
+func newClientTransport() *clientTransport {
+	return &clientTransport{Transport: HTTPClient().Transport.(*http.Transport).Clone()}
+}
+
+// httpClient returns the http client the es client is to be created with.
+func (t *clientTransport) httpClient() *http.Client {
+	return &http.Client{Timeout: HTTPClient().Timeout, Transport: t}
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.active++
+	t.mu.Unlock()
+	res, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		t.done()
+		return nil, err
+	}
+	// the request is in flight until its response has been read, the bodies
+	// of the responses to the HEAD requests aren't always closed
+	if req.Method == http.MethodHead || res.ContentLength == 0 {
+		t.done()
+		return res, nil
+	}
+	res.Body = &trackedBody{ReadCloser: res.Body, transport: t}
+	return res, nil
+}
+
+func (t *clientTransport) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.active == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// wait waits for the requests in flight to complete, up to the timeout. It
+// returns whether they have.
+func (t *clientTransport) wait(timeout time.Duration) bool {
+	t.mu.Lock()
+	if t.active == 0 {
+		t.mu.Unlock()
+		return true
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// trackedBody marks the request done once its response body has been read
+// or closed.
+type trackedBody struct {
+	io.ReadCloser
+	transport *clientTransport
+	once      sync.Once
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.transport.done)
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.transport.done)
+	return err
+}
+
+// trackClient records the transport counting the requests of the client.
+func trackClient(client interface{}, t *clientTransport) {
+	clientTransportsMu.Lock()
+	defer clientTransportsMu.Unlock()
+	clientTransports[client] = t
+}
+
+// clientDrainTimeout returns how long the replaced clients are given to
+// complete their requests in flight before being closed.
+func clientDrainTimeout() time.Duration {
+	value := os.Getenv(envClientDrainTimeout)
+	if value == "" {
+		return defaultClientDrainTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		log.Errorln("invalid", envClientDrainTimeout, value, ", using the default:", err)
+		return defaultClientDrainTimeout
+	}
+	return timeout
+}
+
+// drainClient closes the replaced client once its requests in flight have
+// completed, or the drain timeout has elapsed, without blocking the caller.
+// The returned channel is closed once the client has been closed.
+func drainClient(client interface{ Stop() }) <-chan struct{} {
+	closed := make(chan struct{})
+	clientTransportsMu.Lock()
+	t := clientTransports[client]
+	delete(clientTransports, client)
+	clientTransportsMu.Unlock()
+
+	go func() {
+		defer close(closed)
+		if t != nil {
+			if !t.wait(clientDrainTimeout()) {
+				log.Warnln("closing the replaced es client with requests still in flight")
+			}
+			defer t.CloseIdleConnections()
+		}
+		client.Stop()
+	}()
+	return closed
+}
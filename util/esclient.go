@@ -1,9 +1,12 @@
 package util
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,38 +19,310 @@ import (
 var version int
 var semanticVersion string
 
+// guards the detected version, which may change at runtime
+var versionMu sync.RWMutex
+
+const (
+	envESReadClusterURL   = "ES_READ_CLUSTER_URL"
+	envESWriteClusterURL  = "ES_WRITE_CLUSTER_URL"
+	envESMirrorClusterURL = "ES_MIRROR_CLUSTER_URL"
+	envESNamedClusterURLs = "ES_NAMED_CLUSTER_URLS"
+)
+
 var (
-	clientInit sync.Once
-	client7    *es7.Client
-	client6    *es6.Client
+	clientInit    sync.Once
+	client7       *es7.Client
+	client6       *es6.Client
+	readClient7   *es7.Client
+	writeClient7  *es7.Client
+	mirrorClient7 *es7.Client
+	// clients of the clusters the admin users can route requests to by name
+	namedClients7 = make(map[string]*es7.Client)
+	clientsMu     sync.RWMutex
+)
+
+// credentials replacing the ones present in the es urls, set when the
+// credentials are reloaded at runtime
+var (
+	credentials   *url.Userinfo
+	credentialsMu sync.RWMutex
 )
 
 // GetClient7 returns the es7 client
 func GetClient7() *es7.Client {
+	clientsMu.RLock()
+	client := client7
+	clientsMu.RUnlock()
+	if client != nil {
+		return client
+	}
 	// initialize the client if not present
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
 	if client7 == nil {
 		initClient7()
 	}
 	return client7
 }
 
+// SetClient7 replaces the es7 client, the replaced one is closed once its
+// requests in flight have completed.
+func SetClient7(client *es7.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	replaceClient7(&client7, client)
+}
+
+// replaceClient7 swaps the client and drains the replaced one.
+func replaceClient7(current **es7.Client, client *es7.Client) {
+	if old := *current; old != nil && old != client {
+		drainClient(old)
+	}
+	*current = client
+}
+
+// GetReadClient7 returns the es7 client that serves the read operations. It is
+// the same as the es7 client unless ES_READ_CLUSTER_URL is set.
+func GetReadClient7() *es7.Client {
+	clientsMu.RLock()
+	client := readClient7
+	clientsMu.RUnlock()
+	if client != nil {
+		return client
+	}
+	return GetClient7()
+}
+
+// GetWriteClient7 returns the es7 client that serves the write and delete operations.
+// It is the same as the es7 client unless ES_WRITE_CLUSTER_URL is set.
+func GetWriteClient7() *es7.Client {
+	clientsMu.RLock()
+	client := writeClient7
+	clientsMu.RUnlock()
+	if client != nil {
+		return client
+	}
+	return GetClient7()
+}
+
+// SetReadClient7 replaces the es7 client that serves the read operations.
+func SetReadClient7(client *es7.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	replaceClient7(&readClient7, client)
+}
+
+// SetWriteClient7 replaces the es7 client that serves the write and delete operations.
+func SetWriteClient7(client *es7.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	replaceClient7(&writeClient7, client)
+}
+
+// GetMirrorClient7 returns the es7 client of the secondary cluster the read
+// requests are mirrored to, nil unless ES_MIRROR_CLUSTER_URL is set.
+func GetMirrorClient7() *es7.Client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	return mirrorClient7
+}
+
+// SetMirrorClient7 replaces the es7 client of the secondary cluster.
+func SetMirrorClient7(client *es7.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	replaceClient7(&mirrorClient7, client)
+}
+
+// GetNamedClient7 returns the es7 client of the cluster configured under the
+// name in ES_NAMED_CLUSTER_URLS, and false if there is none.
+func GetNamedClient7(name string) (*es7.Client, bool) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	client, ok := namedClients7[name]
+	return client, ok
+}
+
+// NamedClusters returns the sorted names of the clusters of ES_NAMED_CLUSTER_URLS.
+func NamedClusters() []string {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	names := make([]string, 0, len(namedClients7))
+	for name := range namedClients7 {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetNamedClient7 replaces the es7 client of the named cluster, nil removes it.
+func SetNamedClient7(name string, client *es7.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	current := namedClients7[name]
+	replaceClient7(&current, client)
+	if client == nil {
+		delete(namedClients7, name)
+		return
+	}
+	namedClients7[name] = client
+}
+
 // GetClient6 returns the es6 client
 func GetClient6() *es6.Client {
+	clientsMu.RLock()
+	client := client6
+	clientsMu.RUnlock()
+	if client != nil {
+		return client
+	}
 	// initialize the client if not present
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
 	if client6 == nil {
 		initClient6()
 	}
 	return client6
 }
 
+// ReloadCredentials swaps the es clients for ones authenticating with the
+// given credentials, once they have been validated against the cluster. The
+// requests in flight complete with the clients they started with, which are
+// closed afterwards.
+func ReloadCredentials(ctx context.Context, username, password string) error {
+	userinfo := url.UserPassword(username, password)
+
+	esURL := withCredentials(rawESURL(), userinfo)
+	if err := checkCredentials(ctx, esURL); err != nil {
+		return err
+	}
+	newClient, err := newClient7(esURL)
+	if err != nil {
+		return fmt.Errorf("error while initializing elastic v7 client: %v", err)
+	}
+	var newReadClient, newWriteClient *es7.Client
+	if readURL := os.Getenv(envESReadClusterURL); readURL != "" {
+		readURL = withCredentials(escapeCredentials(readURL), userinfo)
+		if err := checkCredentials(ctx, readURL); err != nil {
+			return err
+		}
+		if newReadClient, err = newClient7(readURL); err != nil {
+			return fmt.Errorf("error while initializing elastic v7 read client: %v", err)
+		}
+	}
+	if writeURL := os.Getenv(envESWriteClusterURL); writeURL != "" {
+		writeURL = withCredentials(escapeCredentials(writeURL), userinfo)
+		if err := checkCredentials(ctx, writeURL); err != nil {
+			return err
+		}
+		if newWriteClient, err = newClient7(writeURL); err != nil {
+			return fmt.Errorf("error while initializing elastic v7 write client: %v", err)
+		}
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client6 != nil {
+		newClient6, err := newClient6(esURL)
+		if err != nil {
+			return fmt.Errorf("error while initializing elastic v6 client: %v", err)
+		}
+		drainClient(client6)
+		client6 = newClient6
+	}
+	replaceClient7(&client7, newClient)
+	replaceClient7(&readClient7, newReadClient)
+	replaceClient7(&writeClient7, newWriteClient)
+
+	credentialsMu.Lock()
+	credentials = userinfo
+	credentialsMu.Unlock()
+
+	log.Println("es credentials reloaded for user", username)
+	return nil
+}
+
+// checkCredentials pings the cluster to make sure it accepts the credentials
+// present in the url.
+func checkCredentials(ctx context.Context, esURL string) error {
+	req, err := http.NewRequest(http.MethodHead, esURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := HTTPClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error while validating the credentials: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("credentials rejected by %s with status %d", redactedHost(esURL), res.StatusCode)
+	}
+	return nil
+}
+
+// redactedHost returns the host of the url, leaving out its credentials.
+func redactedHost(esURL string) string {
+	u, err := url.Parse(esURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// withCredentials replaces the credentials present in the url.
+func withCredentials(esURL string, userinfo *url.Userinfo) string {
+	if userinfo == nil {
+		return esURL
+	}
+	u, err := url.Parse(esURL)
+	if err != nil {
+		return esURL
+	}
+	u.User = userinfo
+	return u.String()
+}
+
+func reloadedCredentials() *url.Userinfo {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	return credentials
+}
+
 // GetESURL returns elasticsearch url with escaped auth
 func GetESURL() string {
+	return withCredentials(rawESURL(), reloadedCredentials())
+}
+
+// rawESURL returns the configured elasticsearch url with escaped auth,
+// ignoring the reloaded credentials.
+func rawESURL() string {
 	esURL := os.Getenv("ES_CLUSTER_URL")
 
 	if esURL == "" {
 		log.Fatal("Error encountered: ", fmt.Errorf("ES_CLUSTER_URL must be set in the environment variables"))
 	}
 
+	return escapeCredentials(esURL)
+}
+
+// GetReadESURL returns the url of the es cluster that serves the read operations.
+func GetReadESURL() string {
+	if esURL := os.Getenv(envESReadClusterURL); esURL != "" {
+		return withCredentials(escapeCredentials(esURL), reloadedCredentials())
+	}
+	return GetESURL()
+}
+
+// GetWriteESURL returns the url of the es cluster that serves the write operations.
+func GetWriteESURL() string {
+	if esURL := os.Getenv(envESWriteClusterURL); esURL != "" {
+		return withCredentials(escapeCredentials(esURL), reloadedCredentials())
+	}
+	return GetESURL()
+}
+
+// escapeCredentials escapes the username and password present in the es url.
+func escapeCredentials(esURL string) string {
 	if strings.Contains(esURL, "@") {
 		splitIndex := strings.LastIndex(esURL, "@")
 		protocolWithCredentials := strings.Split(esURL[0:splitIndex], "://")
@@ -65,34 +340,58 @@ func GetESURL() string {
 
 // GetVersion returns the es version
 func GetVersion() int {
+	versionMu.RLock()
+	detected := version
+	versionMu.RUnlock()
 	// Get the version if not present
-	if version == 0 {
-		esVersion, err := client7.ElasticsearchVersion(GetESURL())
-		if err != nil {
-			log.Fatal("Error encountered: ", fmt.Errorf("error while retrieving the elastic version: %v", err))
-		}
-		var splitStr = strings.Split(esVersion, ".")
-		if len(splitStr) > 0 && splitStr[0] != "" {
-			version, _ = strconv.Atoi(splitStr[0])
-			if err != nil {
-				log.Errorln("Error encountered: error while calculating the elastic version", err)
-			}
-		}
+	if detected == 0 {
+		detectVersion()
+		versionMu.RLock()
+		detected = version
+		versionMu.RUnlock()
 	}
-	return version
+	return detected
 }
 
 // GetSemanticVersion returns the es version
 func GetSemanticVersion() string {
 	// Get the version if not present
-	if semanticVersion == "" {
-		esVersion, err := client7.ElasticsearchVersion(GetESURL())
+	if CachedSemanticVersion() == "" {
+		detectVersion()
+	}
+	return CachedSemanticVersion()
+}
+
+func detectVersion() {
+	esVersion, err := client7.ElasticsearchVersion(GetESURL())
+	if err != nil {
+		log.Fatal("Error encountered: ", fmt.Errorf("error while retrieving the elastic version: %v", err))
+	}
+	SetSemanticVersion(esVersion)
+}
+
+// SetSemanticVersion records the es version, e.g. once the cluster has been
+// upgraded, the plugins pick the client of its major version.
+func SetSemanticVersion(esVersion string) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+	semanticVersion = esVersion
+	version = 0
+	var splitStr = strings.Split(esVersion, ".")
+	if len(splitStr) > 0 && splitStr[0] != "" {
+		major, err := strconv.Atoi(splitStr[0])
 		if err != nil {
-			log.Fatal("Error encountered: ", fmt.Errorf("error while retrieving the elastic version: %v", err))
-		} else {
-			semanticVersion = esVersion
+			log.Errorln("Error encountered: error while calculating the elastic version", err)
 		}
+		version = major
 	}
+}
+
+// CachedSemanticVersion returns the es version detected while instantiating
+// the clients, without reaching out to es. It is empty if not detected yet.
+func CachedSemanticVersion() string {
+	versionMu.RLock()
+	defer versionMu.RUnlock()
 	return semanticVersion
 }
 
@@ -119,47 +418,106 @@ func isSniffingEnabled() bool {
 
 func initClient6() {
 	var err error
+	// Initialize the ES v6 client
+	client6, err = newClient6(GetESURL())
+	if err != nil {
+		log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v6 client: %v", err))
+	}
+}
+
+func initClient7() {
+	var err error
+	// Initialize the ES v7 client
+	client7, err = newClient7(GetESURL())
+	if err != nil {
+		log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v7 client: %v", err))
+	}
+}
 
+// initReadWriteClients7 initializes the dedicated read and write clients, and
+// the client of the mirror cluster, if configured.
+func initReadWriteClients7() {
+	var err error
+	if esURL := os.Getenv(envESReadClusterURL); esURL != "" {
+		readClient7, err = newClient7(escapeCredentials(esURL))
+		if err != nil {
+			log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v7 read client: %v", err))
+		}
+	}
+	if esURL := os.Getenv(envESWriteClusterURL); esURL != "" {
+		writeClient7, err = newClient7(escapeCredentials(esURL))
+		if err != nil {
+			log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v7 write client: %v", err))
+		}
+	}
+	// the secondary cluster has credentials of its own, left out of the
+	// credentials reloads
+	if esURL := os.Getenv(envESMirrorClusterURL); esURL != "" {
+		mirrorClient7, err = newClient7(escapeCredentials(esURL))
+		if err != nil {
+			log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v7 mirror client: %v", err))
+		}
+	}
+	// so do the named clusters, e.g. canaries
+	for _, pair := range strings.Split(os.Getenv(envESNamedClusterURLs), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			log.Fatal("Error encountered: ", fmt.Errorf("invalid named cluster in %s, expected name=url", envESNamedClusterURLs))
+		}
+		name := strings.TrimSpace(parts[0])
+		namedClients7[name], err = newClient7(escapeCredentials(strings.TrimSpace(parts[1])))
+		if err != nil {
+			log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v7 client of the %s cluster: %v", name, err))
+		}
+	}
+}
+
+func newClient6(esURL string) (*es6.Client, error) {
 	loggerT := log.New()
 	wrappedLoggerDebug := &WrapKitLoggerDebug{*loggerT}
 	wrappedLoggerError := &WrapKitLoggerError{*loggerT}
 
-	// Initialize the ES v6 client
-	client6, err = es6.NewClient(
-		es6.SetURL(GetESURL()),
+	transport := newClientTransport()
+	client, err := es6.NewClient(
+		es6.SetURL(esURL),
 		es6.SetRetrier(NewRetrier()),
 		es6.SetSniff(isSniffingEnabled()),
-		es6.SetHttpClient(HTTPClient()),
+		es6.SetHttpClient(transport.httpClient()),
 		es6.SetErrorLog(wrappedLoggerError),
 		es6.SetInfoLog(wrappedLoggerDebug),
 		es6.SetTraceLog(wrappedLoggerDebug),
 	)
-
 	if err != nil {
-		log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v6 client: %v", err))
+		return nil, err
 	}
+	trackClient(client, transport)
+	return client, nil
 }
 
-func initClient7() {
-	var err error
-	// Initialize the ES v7 client
-
+func newClient7(esURL string) (*es7.Client, error) {
 	loggerT := log.New()
 	wrappedLoggerDebug := &WrapKitLoggerDebug{*loggerT}
 	wrappedLoggerError := &WrapKitLoggerError{*loggerT}
 
-	client7, err = es7.NewClient(
-		es7.SetURL(GetESURL()),
+	transport := newClientTransport()
+	client, err := es7.NewClient(
+		es7.SetURL(esURL),
 		es7.SetRetrier(NewRetrier()),
 		es7.SetSniff(isSniffingEnabled()),
-		es7.SetHttpClient(HTTPClient()),
+		es7.SetHttpClient(transport.httpClient()),
 		es7.SetErrorLog(wrappedLoggerError),
 		es7.SetInfoLog(wrappedLoggerDebug),
 		es7.SetTraceLog(wrappedLoggerDebug),
 	)
 	if err != nil {
-		log.Fatal("Error encountered: ", fmt.Errorf("error while initializing elastic v7 client: %v", err))
+		return nil, err
 	}
+	trackClient(client, transport)
+	return client, nil
 }
 
 // NewClient instantiates the ES v6 and v7 clients
@@ -169,9 +527,9 @@ func NewClient() {
 		initClient7()
 		// Initialize the ES v6 client
 		initClient6()
+		// Initialize the dedicated read/write ES v7 clients
+		initReadWriteClients7()
 		// Get the ES version
-		GetVersion()
-
-		log.Println("clients instantiated, elastic search version is", version)
+		log.Println("clients instantiated, elastic search version is", GetVersion())
 	})
 }
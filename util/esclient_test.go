@@ -0,0 +1,138 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	es7 "github.com/olivere/elastic/v7"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReloadCredentials(t *testing.T) {
+	Convey("Reload credentials", t, func() {
+		var mu sync.Mutex
+		password := "old"
+		var used []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			username, pass, _ := r.BasicAuth()
+			if r.Method != http.MethodHead {
+				used = append(used, pass)
+			}
+			if username != "elastic" || pass != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}))
+		defer server.Close()
+
+		os.Setenv("ES_CLUSTER_URL", strings.Replace(server.URL, "://", "://elastic:old@", 1))
+		defer os.Unsetenv("ES_CLUSTER_URL")
+		defer func() {
+			credentials = nil
+			client7, readClient7, writeClient7 = nil, nil, nil
+		}()
+
+		search := func() {
+			_, err := GetClient7().PerformRequest(context.Background(), es7.PerformRequestOptions{
+				Method: http.MethodGet,
+				Path:   "/_search",
+			})
+			So(err, ShouldBeNil)
+		}
+		search()
+
+		mu.Lock()
+		password = "new"
+		mu.Unlock()
+
+		Convey("should keep the clients if the cluster rejects the credentials", func() {
+			before := GetClient7()
+			err := ReloadCredentials(context.Background(), "elastic", "wrong")
+			So(err, ShouldNotBeNil)
+			So(GetClient7(), ShouldEqual, before)
+			So(GetESURL(), ShouldContainSubstring, "elastic:old@")
+		})
+
+		Convey("should use the new credentials for the subsequent requests", func() {
+			So(ReloadCredentials(context.Background(), "elastic", "new"), ShouldBeNil)
+			search()
+			So(used, ShouldResemble, []string{"old", "new"})
+			So(GetESURL(), ShouldContainSubstring, "elastic:new@")
+		})
+	})
+}
+
+func TestClientDraining(t *testing.T) {
+	Convey("Client draining", t, func() {
+		received := make(chan struct{}, 1)
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				received <- struct{}{}
+				<-release
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}))
+		defer server.Close()
+		defer func() {
+			client7 = nil
+		}()
+
+		old, err := newClient7(server.URL)
+		So(err, ShouldBeNil)
+		SetClient7(old)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := old.PerformRequest(context.Background(), es7.PerformRequestOptions{
+				Method: http.MethodGet,
+				Path:   "/slow",
+			})
+			errs <- err
+		}()
+		<-received
+
+		Convey("should close the replaced client once its requests complete", func() {
+			replacement, err := newClient7(server.URL)
+			So(err, ShouldBeNil)
+			SetClient7(replacement)
+			So(GetClient7(), ShouldEqual, replacement)
+
+			// the request in flight keeps the replaced client open
+			time.Sleep(50 * time.Millisecond)
+			So(old.IsRunning(), ShouldBeTrue)
+
+			close(release)
+			So(<-errs, ShouldBeNil)
+			deadline := time.Now().Add(5 * time.Second)
+			for old.IsRunning() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(old.IsRunning(), ShouldBeFalse)
+			So(replacement.IsRunning(), ShouldBeTrue)
+		})
+
+		Convey("should close the replaced client once the drain timeout elapses", func() {
+			os.Setenv(envClientDrainTimeout, "50ms")
+			defer os.Unsetenv(envClientDrainTimeout)
+			defer close(release)
+
+			select {
+			case <-drainClient(old):
+			case <-time.After(5 * time.Second):
+			}
+			So(old.IsRunning(), ShouldBeFalse)
+		})
+	})
+}
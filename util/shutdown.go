@@ -0,0 +1,27 @@
+package util
+
+import "sync"
+
+var (
+	shutdownHooks   []func()
+	shutdownHooksMu sync.Mutex
+)
+
+// AddShutdownHook allows you to add a function that is executed when arc
+// is shutting down, e.g. to flush the buffered data
+func AddShutdownHook(hook func()) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// RunShutdownHooks executes the shutdown hooks in the order they were added
+func RunShutdownHooks() {
+	shutdownHooksMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// env vars configuring the tls of arc's own listener
+const (
+	envTLSMinVersion   = "HTTPS_MIN_TLS_VERSION"
+	envTLSCipherSuites = "HTTPS_CIPHER_SUITES"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLSConfig returns the tls config of arc's https listener as per the
+// HTTPS_MIN_TLS_VERSION and HTTPS_CIPHER_SUITES env vars, the handshakes
+// with older protocol versions or other cipher suites are refused. Go's
+// defaults apply to what isn't configured.
+func ServerTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if value := os.Getenv(envTLSMinVersion); value != "" {
+		version, ok := tlsVersions[strings.TrimPrefix(value, "TLS")]
+		if !ok {
+			return nil, fmt.Errorf(`invalid %s "%s", expected one of 1.0, 1.1, 1.2 or 1.3`, envTLSMinVersion, value)
+		}
+		config.MinVersion = version
+	}
+	if value := os.Getenv(envTLSCipherSuites); value != "" {
+		// only the secure suites can be picked, the tls 1.3 suites aren't
+		// configurable
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf(`invalid %s: unknown or insecure cipher suite "%s"`, envTLSCipherSuites, name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	return config, nil
+}
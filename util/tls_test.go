@@ -0,0 +1,85 @@
+package util
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServerTLSConfig(t *testing.T) {
+	Convey("Server tls config", t, func() {
+		serve := func() *httptest.Server {
+			config, err := ServerTLSConfig()
+			So(err, ShouldBeNil)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = config
+			server.StartTLS()
+			return server
+		}
+		get := func(server *httptest.Server, client *tls.Config) error {
+			client.InsecureSkipVerify = true
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: client}}
+			res, err := c.Get(server.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			return err
+		}
+
+		Convey("Older protocol versions are refused", func() {
+			os.Setenv(envTLSMinVersion, "1.2")
+			defer os.Unsetenv(envTLSMinVersion)
+			server := serve()
+			defer server.Close()
+
+			So(get(server, &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10}), ShouldNotBeNil)
+			So(get(server, &tls.Config{MinVersion: tls.VersionTLS11, MaxVersion: tls.VersionTLS11}), ShouldNotBeNil)
+			So(get(server, &tls.Config{MaxVersion: tls.VersionTLS12}), ShouldBeNil)
+			So(get(server, &tls.Config{}), ShouldBeNil)
+
+			os.Setenv(envTLSMinVersion, "1.3")
+			strict := serve()
+			defer strict.Close()
+			So(get(strict, &tls.Config{MaxVersion: tls.VersionTLS12}), ShouldNotBeNil)
+			So(get(strict, &tls.Config{}), ShouldBeNil)
+		})
+		Convey("Only the configured cipher suites are accepted", func() {
+			os.Setenv(envTLSMinVersion, "1.2")
+			os.Setenv(envTLSCipherSuites, "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+			defer os.Unsetenv(envTLSMinVersion)
+			defer os.Unsetenv(envTLSCipherSuites)
+			server := serve()
+			defer server.Close()
+
+			So(get(server, &tls.Config{
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			}), ShouldNotBeNil)
+			So(get(server, &tls.Config{
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			}), ShouldBeNil)
+		})
+		Convey("Invalid values are rejected", func() {
+			os.Setenv(envTLSMinVersion, "1.4")
+			_, err := ServerTLSConfig()
+			So(err, ShouldNotBeNil)
+			os.Unsetenv(envTLSMinVersion)
+
+			os.Setenv(envTLSCipherSuites, "TLS_RSA_WITH_RC4_128_SHA")
+			_, err = ServerTLSConfig()
+			So(err, ShouldNotBeNil)
+			os.Unsetenv(envTLSCipherSuites)
+		})
+		Convey("Go's defaults apply when nothing is configured", func() {
+			config, err := ServerTLSConfig()
+			So(err, ShouldBeNil)
+			So(config.MinVersion, ShouldEqual, 0)
+			So(config.CipherSuites, ShouldBeNil)
+		})
+	})
+}
@@ -10,8 +10,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
+	"mime"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
@@ -63,19 +65,67 @@ func WriteBackMessage(w http.ResponseWriter, message string, code int) {
 	}
 }
 
+// ERROR_RESPONSE_FORMAT env var and its supported values.
+const (
+	envErrorResponseFormat = "ERROR_RESPONSE_FORMAT"
+	// ErrorFormatPlain writes errors as {"error":{"code","status","message"}}.
+	ErrorFormatPlain = "plain"
+	// ErrorFormatES writes errors in the same shape as elasticsearch errors.
+	ErrorFormatES = "es"
+)
+
+// envMaskDeniedErrors, when "true", hides what was denied from the clients.
+const envMaskDeniedErrors = "MASK_DENIED_ERRORS"
+
+// WriteBackDenied writes back the error of a request denied access. If the
+// denials are masked, the client only gets the status text, the detailed
+// message, which may name the requested indices, is logged along with the
+// request path.
+func WriteBackDenied(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if os.Getenv(envMaskDeniedErrors) == "true" {
+		log.Warnln("denied", r.Method, r.URL.Path, ":", msg)
+		msg = strings.ToLower(http.StatusText(code))
+	}
+	WriteBackError(w, msg, code)
+}
+
 // WriteBackError writes the given error message as a json response to the response writer.
 func WriteBackError(w http.ResponseWriter, err string, code int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(code)
-	msg := map[string]interface{}{
+	json.NewEncoder(w).Encode(ErrorBody(err, code))
+}
+
+// ErrorBody returns the error envelope for gateway generated errors as per the
+// configured ERROR_RESPONSE_FORMAT. The "es" format mirrors the shape of
+// elasticsearch errors and marks the error with "origin": "arc" so that clients
+// can tell them apart from the upstream errors.
+func ErrorBody(err string, code int) map[string]interface{} {
+	if os.Getenv(envErrorResponseFormat) == ErrorFormatES {
+		errType := strings.ToLower(strings.Replace(http.StatusText(code), " ", "_", -1)) + "_exception"
+		return map[string]interface{}{
+			"error": map[string]interface{}{
+				"root_cause": []map[string]interface{}{
+					{
+						"type":   errType,
+						"reason": err,
+					},
+				},
+				"type":   errType,
+				"reason": err,
+				"origin": "arc",
+			},
+			"status": code,
+		}
+	}
+	return map[string]interface{}{
 		"error": map[string]interface{}{
 			"code":    code,
 			"status":  http.StatusText(code),
 			"message": err,
 		},
 	}
-	json.NewEncoder(w).Encode(msg)
 }
 
 // WriteBackRaw writes the given json encoded bytes to the response writer.
@@ -86,6 +136,28 @@ func WriteBackRaw(w http.ResponseWriter, raw []byte, code int) {
 	w.Write(raw)
 }
 
+// IsJSONContentType reports whether the given Content-Type header value denotes a json payload.
+func IsJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// IsBodyless reports whether the request is a HEAD request or a GET request
+// without a body, whose body is never worth reading.
+func IsBodyless(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodHead:
+		return true
+	case http.MethodGet:
+		return r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0
+	default:
+		return false
+	}
+}
+
 // Contains checks the presence of a string in the given string slice.
 func Contains(slice []string, val string) bool {
 	for _, v := range slice {
@@ -294,6 +366,27 @@ func CheckIfIndexExists(ctx context.Context, indexName string) bool {
 	return exists
 }
 
+// Levenshtein returns the edit distance between the strings.
+func Levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = Min(Min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
 // Min return min of two integers
 func Min(a, b int) int {
 	if a < b {
@@ -301,3 +394,71 @@ func Min(a, b int) int {
 	}
 	return b
 }
+
+// MaskNestedFields replaces the values of the dotted field paths with the
+// placeholder wherever they are found in the json body, e.g. in the _source of
+// each hit of a search response or in a search query, and in each line of the
+// ndjson bodies, e.g. of the _bulk requests. The lines that aren't json are
+// left unchanged.
+func MaskNestedFields(body []byte, fields []string, placeholder string) []byte {
+	if len(fields) == 0 || len(body) == 0 {
+		return body
+	}
+	var paths [][]string
+	for _, field := range fields {
+		paths = append(paths, strings.Split(field, "."))
+	}
+	mask := func(raw []byte) []byte {
+		var parsed interface{}
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			return raw
+		}
+		for _, path := range paths {
+			maskNestedField(parsed, path, placeholder)
+		}
+		masked, err := json.Marshal(parsed)
+		if err != nil {
+			return raw
+		}
+		return masked
+	}
+	if json.Valid(body) {
+		return mask(body)
+	}
+	lines := bytes.Split(body, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines[i] = mask(line)
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// maskNestedField masks the path starting from the node and from each of the
+// nodes below it.
+func maskNestedField(node interface{}, path []string, placeholder string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		parent := n
+		for _, key := range path[:len(path)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				parent = nil
+				break
+			}
+			parent = child
+		}
+		if parent != nil {
+			if _, ok := parent[path[len(path)-1]]; ok {
+				parent[path[len(path)-1]] = placeholder
+			}
+		}
+		for _, value := range n {
+			maskNestedField(value, path, placeholder)
+		}
+	case []interface{}:
+		for _, element := range n {
+			maskNestedField(element, path, placeholder)
+		}
+	}
+}
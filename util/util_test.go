@@ -0,0 +1,119 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteBackError(t *testing.T) {
+	Convey("WriteBackError", t, func() {
+		Convey("ES error format", func() {
+			os.Setenv(envErrorResponseFormat, ErrorFormatES)
+			defer os.Unsetenv(envErrorResponseFormat)
+			for _, code := range []int{
+				http.StatusUnauthorized,
+				http.StatusForbidden,
+				http.StatusRequestEntityTooLarge,
+				http.StatusTooManyRequests,
+				http.StatusServiceUnavailable,
+			} {
+				w := httptest.NewRecorder()
+				WriteBackError(w, "gateway error", code)
+				So(w.Code, ShouldEqual, code)
+
+				var body struct {
+					Error struct {
+						RootCause []map[string]string `json:"root_cause"`
+						Type      string              `json:"type"`
+						Reason    string              `json:"reason"`
+						Origin    string              `json:"origin"`
+					} `json:"error"`
+					Status int `json:"status"`
+				}
+				So(json.Unmarshal(w.Body.Bytes(), &body), ShouldBeNil)
+				So(body.Status, ShouldEqual, code)
+				So(body.Error.Reason, ShouldEqual, "gateway error")
+				So(body.Error.Origin, ShouldEqual, "arc")
+				So(body.Error.Type, ShouldEndWith, "_exception")
+				So(body.Error.RootCause, ShouldHaveLength, 1)
+				So(body.Error.RootCause[0]["type"], ShouldEqual, body.Error.Type)
+			}
+		})
+		Convey("Plain error format", func() {
+			w := httptest.NewRecorder()
+			WriteBackError(w, "gateway error", http.StatusForbidden)
+			var body map[string]map[string]interface{}
+			So(json.Unmarshal(w.Body.Bytes(), &body), ShouldBeNil)
+			So(body["error"]["message"], ShouldEqual, "gateway error")
+			So(body["error"]["code"], ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestWriteBackDenied(t *testing.T) {
+	Convey("WriteBackDenied", t, func() {
+		req := httptest.NewRequest(http.MethodGet, "/tenant-a/_search", nil)
+		msg := "credentials cannot access [tenant-a] index/indices"
+		message := func(w *httptest.ResponseRecorder) interface{} {
+			var body map[string]map[string]interface{}
+			So(json.Unmarshal(w.Body.Bytes(), &body), ShouldBeNil)
+			return body["error"]["message"]
+		}
+
+		Convey("passes the detailed message through by default", func() {
+			w := httptest.NewRecorder()
+			WriteBackDenied(w, req, msg, http.StatusForbidden)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			So(message(w), ShouldEqual, msg)
+		})
+		Convey("masks the message and logs it with the path", func() {
+			os.Setenv(envMaskDeniedErrors, "true")
+			defer os.Unsetenv(envMaskDeniedErrors)
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			w := httptest.NewRecorder()
+			WriteBackDenied(w, req, msg, http.StatusForbidden)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			So(message(w), ShouldEqual, "forbidden")
+			So(w.Body.String(), ShouldNotContainSubstring, "tenant-a")
+			So(hook.LastEntry().Message, ShouldContainSubstring, "/tenant-a/_search")
+			So(hook.LastEntry().Message, ShouldContainSubstring, msg)
+
+			w = httptest.NewRecorder()
+			WriteBackDenied(w, req, msg, http.StatusUnauthorized)
+			So(message(w), ShouldEqual, "unauthorized")
+		})
+	})
+}
+
+func TestMaskNestedFields(t *testing.T) {
+	Convey("Masking the nested fields", t, func() {
+		fields := []string{"ssn", "address.zip"}
+		Convey("masks the fields at any depth", func() {
+			body := `{"hits":{"hits":[{"_source":{"ssn":"123","name":"a","address":{"zip":"75001"}}}]},` +
+				`"query":{"term":{"ssn":"456"}}}`
+			masked := string(MaskNestedFields([]byte(body), fields, "***"))
+			So(masked, ShouldNotContainSubstring, "123")
+			So(masked, ShouldNotContainSubstring, "456")
+			So(masked, ShouldNotContainSubstring, "75001")
+			So(masked, ShouldContainSubstring, `"name":"a"`)
+		})
+		Convey("masks each line of the ndjson bodies", func() {
+			body := "{\"index\":{\"_index\":\"patients\"}}\n{\"ssn\":\"123\"}\n"
+			So(string(MaskNestedFields([]byte(body), fields, "***")), ShouldEqual,
+				"{\"index\":{\"_index\":\"patients\"}}\n{\"ssn\":\"***\"}\n")
+		})
+		Convey("leaves the other bodies unchanged", func() {
+			So(string(MaskNestedFields([]byte("ssn=123"), fields, "***")), ShouldEqual, "ssn=123")
+			So(string(MaskNestedFields([]byte(`{"ssn":"123"}`), nil, "***")), ShouldEqual, `{"ssn":"123"}`)
+		})
+	})
+}